@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strconv"
+)
+
+// AuditEntry is a change made through the API
+type AuditEntry struct {
+	ID         int             `json:"id"`
+	CreatedAt  string          `json:"created_at"`
+	UserID     *int            `json:"user_id"`
+	Action     string          `json:"action"`
+	EntityType string          `json:"entity_type"`
+	EntityID   int             `json:"entity_id"`
+	Details    json.RawMessage `json:"details,omitempty"`
+}
+
+// audit records a change in audit_log. The change has already happened, so a failure is only logged
+// instead of failing the request. details is stored as JSON and may be nil.
+func audit(ctx context.Context, db *sql.DB, action, entityType string, entityID int, details interface{}) {
+	var detailsJSON interface{}
+	if details != nil {
+		data, err := json.Marshal(details)
+		if err != nil {
+			slog.Error("encoding audit details failed", "action", action, "entity_type", entityType, "entity_id", entityID, "error", err)
+		} else {
+			detailsJSON = string(data)
+		}
+	}
+
+	// There are no user accounts yet, user_id stays NULL until requests carry one
+	_, err := db.ExecContext(ctx, `
+		INSERT INTO audit_log (created_at, user_id, action, entity_type, entity_id, details)
+		VALUES (NOW(), NULL, ?, ?, ?, ?)
+	`, action, entityType, entityID, detailsJSON)
+	if err != nil {
+		slog.Error("recording audit entry failed", "action", action, "entity_type", entityType, "entity_id", entityID, "error", err)
+	}
+}
+
+// GetAuditLog returns a handler that lists the audit entries, the most recent first. They can be filtered
+// with entity_type and entity_id and are paginated like the other lists.
+func GetAuditLog(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		limit, offset, err := ParsePagination(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if limit == 0 {
+			limit = defaultPageSize
+		}
+
+		where := "WHERE 1 = 1"
+		var filterArgs []interface{}
+		if entityType := r.URL.Query().Get("entity_type"); entityType != "" {
+			where += " AND entity_type = ?"
+			filterArgs = append(filterArgs, entityType)
+		}
+		if entityID := r.URL.Query().Get("entity_id"); entityID != "" {
+			id, err := strconv.Atoi(entityID)
+			if err != nil {
+				http.Error(w, "Invalid entity_id", http.StatusBadRequest)
+				return
+			}
+			where += " AND entity_id = ?"
+			filterArgs = append(filterArgs, id)
+		}
+
+		var total int
+		if err := db.QueryRowContext(r.Context(), "SELECT COUNT(*) FROM audit_log "+where, filterArgs...).Scan(&total); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		query, args := paginate(`
+			SELECT id, created_at, user_id, action, entity_type, entity_id, details
+			FROM audit_log
+			`+where+`
+			ORDER BY id DESC
+		`, filterArgs, limit, offset)
+		rows, err := db.QueryContext(r.Context(), query, args...)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer rows.Close()
+
+		entries := []AuditEntry{}
+		for rows.Next() {
+			var entry AuditEntry
+			var userID sql.NullInt64
+			var details sql.NullString
+			if err := rows.Scan(&entry.ID, &entry.CreatedAt, &userID, &entry.Action, &entry.EntityType, &entry.EntityID, &details); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			if userID.Valid {
+				id := int(userID.Int64)
+				entry.UserID = &id
+			}
+			if details.Valid {
+				entry.Details = json.RawMessage(details.String)
+			}
+			entries = append(entries, entry)
+		}
+		if err := rows.Err(); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		WriteListResponse(w, http.StatusOK, entries, total)
+	}
+}
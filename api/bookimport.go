@@ -0,0 +1,287 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+const (
+	// maxImportSize is the largest CSV file accepted by the bulk import
+	maxImportSize = 10 << 20
+	// importBatchSize is the number of books written by a single INSERT
+	importBatchSize = 100
+)
+
+// importColumns are the columns of an import file, title, author_firstname and author_lastname are required
+var importColumns = []string{"title", "author_firstname", "author_lastname", "details", "isbn"}
+
+// ImportLineError is a line of an import file that was not imported
+type ImportLineError struct {
+	Line   int    `json:"line"`
+	Reason string `json:"reason"`
+}
+
+// ImportSummary is the outcome of a bulk import
+type ImportSummary struct {
+	Created int               `json:"created"`
+	Skipped int               `json:"skipped"`
+	Errors  []ImportLineError `json:"errors"`
+}
+
+// importRow is a valid line of an import file
+type importRow struct {
+	Line            int
+	Title           string
+	AuthorFirstname string
+	AuthorLastname  string
+	Details         string
+	ISBN            string
+	AuthorID        int
+}
+
+// readImportFile parses an import file. Lines that fail validation are reported in the summary and left out of the rows,
+// an unreadable file or a missing column fails the whole import.
+func readImportFile(file io.Reader, summary *ImportSummary) ([]importRow, error) {
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, errors.New("the file is empty")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid CSV: %v", err)
+	}
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, name := range importColumns[:3] {
+		if _, ok := columns[name]; !ok {
+			return nil, fmt.Errorf("missing column %s", name)
+		}
+	}
+
+	var rows []importRow
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid CSV: %v", err)
+		}
+		line, _ := reader.FieldPos(0)
+
+		field := func(name string) string {
+			i, ok := columns[name]
+			if !ok || i >= len(record) {
+				return ""
+			}
+			return strings.TrimSpace(record[i])
+		}
+		row := importRow{
+			Line:            line,
+			Title:           field("title"),
+			AuthorFirstname: field("author_firstname"),
+			AuthorLastname:  field("author_lastname"),
+			Details:         field("details"),
+			ISBN:            field("isbn"),
+		}
+
+		if err := validateImportRow(&row); err != nil {
+			summary.Errors = append(summary.Errors, ImportLineError{Line: line, Reason: err.Error()})
+			continue
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// validateImportRow checks the fields of an import line and normalizes its ISBN
+func validateImportRow(row *importRow) error {
+	if err := validateRequiredField("title", row.Title, maxNameLength); err != nil {
+		return err
+	}
+	if row.AuthorFirstname == "" || row.AuthorLastname == "" {
+		return errors.New("author_firstname and author_lastname are required")
+	}
+	if row.ISBN != "" {
+		isbn, err := NormalizeISBN(row.ISBN)
+		if err != nil {
+			return err
+		}
+		row.ISBN = isbn
+	}
+	return nil
+}
+
+// skipExistingBooks drops the rows whose ISBN is already in the library or earlier in the file
+func skipExistingBooks(ctx context.Context, tx *sql.Tx, rows []importRow, summary *ImportSummary) ([]importRow, error) {
+	seen := make(map[string]bool)
+	kept := rows[:0]
+	for _, row := range rows {
+		if row.ISBN != "" {
+			if seen[row.ISBN] {
+				summary.Skipped++
+				continue
+			}
+			seen[row.ISBN] = true
+
+			var exists bool
+			err := tx.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM books WHERE isbn = ?)", row.ISBN).Scan(&exists)
+			if err != nil {
+				return nil, err
+			}
+			if exists {
+				summary.Skipped++
+				continue
+			}
+		}
+		kept = append(kept, row)
+	}
+	return kept, nil
+}
+
+// resolveImportAuthors sets the author of every row, creating the authors that are not in the library yet
+func resolveImportAuthors(ctx context.Context, tx *sql.Tx, rows []importRow) error {
+	authorIDs := make(map[[2]string]int)
+	for i := range rows {
+		name := [2]string{rows[i].AuthorFirstname, rows[i].AuthorLastname}
+		if id, ok := authorIDs[name]; ok {
+			rows[i].AuthorID = id
+			continue
+		}
+
+		id, err := findAuthorID(ctx, tx, name[0], name[1])
+		if err != nil {
+			return err
+		}
+		if id == 0 {
+			result, err := tx.ExecContext(ctx, "INSERT INTO authors (lastname, firstname, photo) VALUES (?, ?, '')", name[1], name[0])
+			if err != nil {
+				return fmt.Errorf("failed to insert author: %v", err)
+			}
+			lastID, err := result.LastInsertId()
+			if err != nil {
+				return err
+			}
+			id = int(lastID)
+		}
+		authorIDs[name] = id
+		rows[i].AuthorID = id
+	}
+	return nil
+}
+
+// insertImportBatch writes a batch of books with a single INSERT and links them to their authors.
+// InnoDB gives the rows of a multi-row INSERT consecutive ids starting at LastInsertId.
+func insertImportBatch(ctx context.Context, tx *sql.Tx, rows []importRow) error {
+	placeholders := make([]string, len(rows))
+	args := make([]interface{}, 0, len(rows)*4)
+	for i, row := range rows {
+		placeholders[i] = "(?, ?, '', FALSE, ?, ?)"
+		args = append(args, row.Title, row.AuthorID, row.Details, nullIfEmpty(row.ISBN))
+	}
+	result, err := tx.ExecContext(ctx, `
+		INSERT INTO books (title, author_id, photo, is_borrowed, details, isbn)
+		VALUES `+strings.Join(placeholders, ", "), args...)
+	if err != nil {
+		return fmt.Errorf("failed to insert books: %v", err)
+	}
+	firstID, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+
+	args = args[:0]
+	for i, row := range rows {
+		placeholders[i] = "(?, ?)"
+		args = append(args, row.AuthorID, firstID+int64(i))
+	}
+	_, err = tx.ExecContext(ctx, "INSERT INTO authors_books (author_id, book_id) VALUES "+strings.Join(placeholders, ", "), args...)
+	if err != nil {
+		return fmt.Errorf("failed to link authors: %v", err)
+	}
+	return nil
+}
+
+// ImportBooks returns a handler that creates books from an uploaded CSV file with the columns title,
+// author_firstname, author_lastname, details and isbn, creating the missing authors. Invalid lines are
+// reported and skipped, or reject the whole file with ?strict=true. Books whose ISBN is already in the
+// library are skipped. ?dry_run=true validates the file without writing anything.
+func ImportBooks(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		dryRun := query.Get("dry_run") == "true"
+		strict := query.Get("strict") == "true"
+
+		r.Body = http.MaxBytesReader(w, r.Body, maxImportSize)
+		file, _, err := r.FormFile("file")
+		if err != nil {
+			http.Error(w, "A CSV file is required in the file field", http.StatusBadRequest)
+			return
+		}
+		defer file.Close()
+		defer r.MultipartForm.RemoveAll()
+
+		summary := ImportSummary{Errors: []ImportLineError{}}
+		rows, err := readImportFile(file, &summary)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if strict && len(summary.Errors) > 0 {
+			RespondWithJSON(w, http.StatusUnprocessableEntity, summary)
+			return
+		}
+
+		tx, err := db.BeginTx(r.Context(), nil)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to start transaction: %v", err), http.StatusInternalServerError)
+			return
+		}
+		defer tx.Rollback()
+
+		rows, err = skipExistingBooks(r.Context(), tx, rows, &summary)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if dryRun {
+			summary.Created = len(rows)
+			RespondWithJSON(w, http.StatusOK, summary)
+			return
+		}
+
+		if err := resolveImportAuthors(r.Context(), tx, rows); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		for start := 0; start < len(rows); start += importBatchSize {
+			end := start + importBatchSize
+			if end > len(rows) {
+				end = len(rows)
+			}
+			if err := insertImportBatch(r.Context(), tx, rows[start:end]); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+
+		if err := tx.Commit(); err != nil {
+			http.Error(w, fmt.Sprintf("Failed to commit transaction: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		summary.Created = len(rows)
+		RespondWithJSON(w, http.StatusOK, summary)
+	}
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// cacheEntry is a cached value and the time it stops being valid
+type cacheEntry struct {
+	value     []byte
+	expiresAt time.Time
+}
+
+// Cache is an in-memory store of serialized query results with a time to live. Keys start with the
+// name of the list they belong to, like books:..., so a whole list can be invalidated at once.
+type Cache struct {
+	entries sync.Map
+}
+
+// NewCache creates a cache and starts removing its expired entries every interval, until ctx is done
+func NewCache(ctx context.Context, interval time.Duration) *Cache {
+	c := &Cache{}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				c.evictExpired()
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return c
+}
+
+// Get returns the value stored for key, unless it has expired
+func (c *Cache) Get(key string) ([]byte, bool) {
+	value, ok := c.entries.Load(key)
+	if !ok {
+		return nil, false
+	}
+	entry := value.(cacheEntry)
+	if time.Now().After(entry.expiresAt) {
+		c.entries.Delete(key)
+		return nil, false
+	}
+	return entry.value, true
+}
+
+// Set stores value for key during ttl
+func (c *Cache) Set(key string, value []byte, ttl time.Duration) {
+	c.entries.Store(key, cacheEntry{value: value, expiresAt: time.Now().Add(ttl)})
+}
+
+// Invalidate removes the entries of the list called name
+func (c *Cache) Invalidate(name string) {
+	c.entries.Range(func(key, _ interface{}) bool {
+		if strings.HasPrefix(key.(string), name+":") {
+			c.entries.Delete(key)
+		}
+		return true
+	})
+}
+
+// evictExpired removes the expired entries, a key that is never read again would stay forever otherwise
+func (c *Cache) evictExpired() {
+	now := time.Now()
+	c.entries.Range(func(key, value interface{}) bool {
+		if now.After(value.(cacheEntry).expiresAt) {
+			c.entries.Delete(key)
+		}
+		return true
+	})
+}
+
+// cachedResponse is a list response as it is stored in the cache. The body is kept byte for byte, its
+// ETag must be the one of the response that was cached.
+type cachedResponse struct {
+	TotalCount string `json:"total_count,omitempty"`
+	Body       []byte `json:"body"`
+}
+
+// CacheMiddleware serves the GET responses of a list from the cache for ttl. Responses are cached per
+// path and query string under the name of the list; only successful responses are stored. Cached responses
+// carry an ETag and honor If-None-Match like the handlers using RespondWithETag. A ttl of 0
+// disables the cache.
+func CacheMiddleware(cache *Cache, name string, ttl time.Duration) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if ttl <= 0 || r.Method != http.MethodGet {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			key := name + ":" + r.URL.Path + "?" + r.URL.RawQuery
+			if value, ok := cache.Get(key); ok {
+				var cached cachedResponse
+				if err := json.Unmarshal(value, &cached); err == nil {
+					if cached.TotalCount != "" {
+						w.Header().Set("X-Total-Count", cached.TotalCount)
+					}
+					writeJSONWithETag(w, r, http.StatusOK, cached.Body)
+					return
+				}
+			}
+
+			recorder := &recordingResponseWriter{ResponseWriter: w}
+			next.ServeHTTP(recorder, r)
+			if recorder.status != http.StatusOK {
+				return
+			}
+
+			value, err := json.Marshal(cachedResponse{
+				TotalCount: w.Header().Get("X-Total-Count"),
+				Body:       recorder.body.Bytes(),
+			})
+			if err == nil {
+				cache.Set(key, value, ttl)
+			}
+		})
+	}
+}
+
+// InvalidateCacheMiddleware empties the cached lists once a request that may change data has been handled.
+// Books and authors show up in each other's lists and a loan changes is_borrowed, so every write
+// invalidates both.
+func InvalidateCacheMiddleware(cache *Cache) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(w, r)
+			switch r.Method {
+			case http.MethodGet, http.MethodHead, http.MethodOptions:
+				return
+			}
+			cache.Invalidate("books")
+			cache.Invalidate("authors")
+		})
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestCache(t *testing.T) {
+	cache := &Cache{}
+
+	if _, ok := cache.Get("books:/books?"); ok {
+		t.Fatal("hit on an empty cache")
+	}
+
+	cache.Set("books:/books?", []byte("cached"), time.Minute)
+	value, ok := cache.Get("books:/books?")
+	if !ok || string(value) != "cached" {
+		t.Fatalf("got %q, %v, want a hit", value, ok)
+	}
+
+	cache.Set("books:/books?limit=1", []byte("expiring"), time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+	if _, ok := cache.Get("books:/books?limit=1"); ok {
+		t.Error("hit on an expired entry")
+	}
+	cache.evictExpired()
+	if _, ok := cache.entries.Load("books:/books?limit=1"); ok {
+		t.Error("the expired entry wasn't evicted")
+	}
+
+	cache.Set("authors:/authors?", []byte("authors"), time.Minute)
+	cache.Invalidate("books")
+	if _, ok := cache.Get("books:/books?"); ok {
+		t.Error("hit after the books were invalidated")
+	}
+	if _, ok := cache.Get("authors:/authors?"); !ok {
+		t.Error("invalidating the books removed the authors")
+	}
+}
+
+// listHandler answers a list of one book and counts its calls
+type listHandler struct {
+	calls int
+}
+
+func (h *listHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.calls++
+	WriteListResponse(w, http.StatusOK, []BookAuthorInfo{{BookID: h.calls, BookTitle: "Emma"}}, 1)
+}
+
+func TestCacheMiddleware(t *testing.T) {
+	cache := &Cache{}
+	handler := &listHandler{}
+	get := func(target string) *httptest.ResponseRecorder {
+		return serveWithMiddleware(CacheMiddleware(cache, "books", time.Minute), handler, "/books", httptest.NewRequest(http.MethodGet, target, nil))
+	}
+
+	miss := get("/books")
+	hit := get("/books")
+	if handler.calls != 1 {
+		t.Fatalf("the handler was called %d times, want 1", handler.calls)
+	}
+	if hit.Code != http.StatusOK || hit.Body.String() != miss.Body.String() {
+		t.Errorf("cached response %d %q, want %q", hit.Code, hit.Body, miss.Body)
+	}
+	if got := hit.Header().Get("X-Total-Count"); got != "1" {
+		t.Errorf("X-Total-Count %q, want 1", got)
+	}
+
+	get("/books?limit=5")
+	if handler.calls != 2 {
+		t.Errorf("another query string was served from the cache")
+	}
+
+	cache.Invalidate("books")
+	get("/books")
+	if handler.calls != 3 {
+		t.Errorf("the response was served from the cache after the invalidation")
+	}
+}
+
+func TestInvalidateCacheMiddleware(t *testing.T) {
+	cache := &Cache{}
+	noContent := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusNoContent) })
+
+	for _, method := range []string{http.MethodGet, http.MethodPut} {
+		cache.Set("books:/books?", []byte("cached"), time.Minute)
+		serveWithMiddleware(InvalidateCacheMiddleware(cache), noContent, "/books/{id}", httptest.NewRequest(method, "/books/1", nil))
+		_, ok := cache.Get("books:/books?")
+		if want := method == http.MethodGet; ok != want {
+			t.Errorf("%s: cached %v, want %v", method, ok, want)
+		}
+	}
+}
+
+// expectGoroutinesStopped waits for the number of goroutines to go back to before, failing after a second
+func expectGoroutinesStopped(t *testing.T, before int) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > before {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d goroutines are still running, want %d", runtime.NumGoroutine(), before)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestNewCacheEvictsUntilCanceled(t *testing.T) {
+	before := runtime.NumGoroutine()
+	ctx, cancel := context.WithCancel(context.Background())
+	cache := NewCache(ctx, time.Millisecond)
+	cache.Set("books:/books?", []byte("expiring"), time.Millisecond)
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		if _, ok := cache.entries.Load("books:/books?"); !ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the expired entry wasn't evicted")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	cancel()
+	expectGoroutinesStopped(t, before)
+}
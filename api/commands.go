@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+)
+
+// Exit codes of the commands
+const (
+	exitOK        = 0
+	exitFailure   = 1 // the database can't be reached, the server stopped, seeding or a reminder failed
+	exitConfig    = 2 // invalid command, flags or environment
+	exitMigration = 3 // a migration failed
+)
+
+const commandsUsage = `Usage: api [command] [flags]
+
+Commands:
+  serve    migrate the database and run the API server (default)
+  migrate  apply the pending migrations and exit
+  seed     migrate the database and insert the demo dataset, on an empty database
+  remind   migrate the database and email the subscribers whose loans are due soon or overdue
+
+Run "api <command> -h" for the flags, shared by every command.
+`
+
+func main() {
+	command, args := "serve", os.Args[1:]
+	// Without a command the flags are the ones of serve, as before the commands existed
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		command, args = args[0], args[1:]
+	}
+	os.Exit(runCommand(command, args))
+}
+
+// runCommand loads the configuration and opens the database for command, migrates it and runs the command.
+// It returns the exit code of the process.
+func runCommand(command string, args []string) int {
+	switch command {
+	case "serve", "migrate", "seed", "remind":
+	case "help":
+		fmt.Print(commandsUsage)
+		return exitOK
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s", command, commandsUsage)
+		return exitConfig
+	}
+
+	cfg, err := LoadConfig(args, os.LookupEnv)
+	if errors.Is(err, flag.ErrHelp) {
+		return exitOK
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitConfig
+	}
+	if err := setupLogging(cfg.LogLevel); err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid LOG_LEVEL: %v\n", err)
+		return exitConfig
+	}
+
+	db, err := initDB(cfg.DBUser, cfg.DBPassword, cfg.DBHost, cfg.DBPort, cfg.DBName)
+	if err != nil {
+		slog.Error("error initializing database", "error", err)
+		return exitFailure
+	}
+	defer db.Close()
+
+	if err := RunMigrations(db, cfg.MigrationsDir); err != nil {
+		slog.Error("error migrating the database", "error", err)
+		return exitMigration
+	}
+
+	switch command {
+	case "migrate":
+		slog.Info("the database is up to date")
+	case "seed":
+		err = Seed(context.Background(), db)
+		if err == nil {
+			slog.Info("inserted the demo dataset")
+		}
+	case "remind":
+		var sent int
+		within := time.Duration(cfg.ReminderDays) * 24 * time.Hour
+		sent, err = SendDueReminders(context.Background(), db, newMailerFromConfig(cfg), time.Now(), within)
+		slog.Info("sent the due date reminders", "sent", sent)
+	case "serve":
+		err = serve(cfg, db)
+	}
+	if err != nil {
+		slog.Error(command+" failed", "error", err)
+		return exitFailure
+	}
+	return exitOK
+}
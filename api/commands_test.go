@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestRunCommandExitCodes(t *testing.T) {
+	tests := []struct {
+		name    string
+		command string
+		args    []string
+		env     map[string]string
+		want    int
+	}{
+		{name: "help", command: "help", want: exitOK},
+		{name: "flags help", command: "migrate", args: []string{"-h"}, want: exitOK},
+		{name: "unknown command", command: "drop", want: exitConfig},
+		{name: "invalid flag", command: "serve", args: []string{"-request-timeout", "soon"}, want: exitConfig},
+		{name: "invalid environment", command: "seed", env: map[string]string{"RATE_LIMIT_BURST": "many"}, want: exitConfig},
+		{name: "invalid log level", command: "migrate", env: map[string]string{"LOG_LEVEL": "verbose"}, want: exitConfig},
+		{name: "unreachable database", command: "migrate", args: []string{"-db-hostname", "127.0.0.1", "-db-port", "1"}, want: exitFailure},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// runCommand sets up the default logger and the log level, restored when the test ends
+			captureLogs(t, slog.LevelInfo)
+			for key, value := range tt.env {
+				t.Setenv(key, value)
+			}
+
+			if got := runCommand(tt.command, tt.args); got != tt.want {
+				t.Errorf("exit code %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSeed(t *testing.T) {
+	t.Run("empty database", func(t *testing.T) {
+		db, mock := newMockDB(t)
+		mock.ExpectBegin()
+		for _, table := range []string{"authors", "books", "subscribers"} {
+			mock.ExpectQuery(sqlPattern("SELECT EXISTS (SELECT 1 FROM " + table + ")")).WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+		}
+		for _, statement := range seedStatements {
+			mock.ExpectExec("^" + sqlPattern(statement) + "$").WillReturnResult(sqlmock.NewResult(0, 3))
+		}
+		mock.ExpectCommit()
+
+		if err := Seed(context.Background(), db); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("database with data", func(t *testing.T) {
+		db, mock := newMockDB(t)
+		mock.ExpectBegin()
+		mock.ExpectQuery(sqlPattern("SELECT EXISTS (SELECT 1 FROM authors)")).WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+		mock.ExpectQuery(sqlPattern("SELECT EXISTS (SELECT 1 FROM books)")).WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+		mock.ExpectRollback()
+
+		if err := Seed(context.Background(), db); !errors.Is(err, errDatabaseNotEmpty) {
+			t.Errorf("got %v, want errDatabaseNotEmpty", err)
+		}
+	})
+
+	t.Run("insert failure", func(t *testing.T) {
+		db, mock := newMockDB(t)
+		mock.ExpectBegin()
+		for _, table := range []string{"authors", "books", "subscribers"} {
+			mock.ExpectQuery(sqlPattern("SELECT EXISTS (SELECT 1 FROM " + table + ")")).WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+		}
+		mock.ExpectExec(sqlPattern("INSERT INTO subscribers")).WillReturnError(errors.New("table subscribers doesn't exist"))
+		mock.ExpectRollback()
+
+		if err := Seed(context.Background(), db); err == nil {
+			t.Error("Seed succeeded after a failed insert")
+		}
+	})
+}
+
+// The tables are filled before the rows referencing them: the books reference their authors and the
+// subscriber borrowing them, the links and the loans reference both
+func TestSeedOrder(t *testing.T) {
+	insertInto := regexp.MustCompile(`^INSERT INTO (\w+)`)
+	var tables []string
+	for _, statement := range seedStatements {
+		tables = append(tables, insertInto.FindStringSubmatch(statement)[1])
+	}
+	want := []string{"subscribers", "authors", "books", "authors_books", "borrowed_books"}
+	if len(tables) != len(want) {
+		t.Fatalf("the seed fills %v, want %v", tables, want)
+	}
+	for i := range want {
+		if tables[i] != want[i] {
+			t.Fatalf("the seed fills %v, want %v", tables, want)
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestAcceptsGzip(t *testing.T) {
+	tests := []struct {
+		header string
+		want   bool
+	}{
+		{"gzip", true},
+		{"deflate, gzip;q=0.8", true},
+		{"*", true},
+		{"GZIP", true},
+		{"gzip;q=0", false},
+		{"deflate, br", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Accept-Encoding", tt.header)
+		if got := acceptsGzip(req); got != tt.want {
+			t.Errorf("%q: got %v, want %v", tt.header, got, tt.want)
+		}
+	}
+}
+
+// serveGzip serves handler behind GzipMiddleware to a client sending acceptEncoding
+func serveGzip(handler http.HandlerFunc, acceptEncoding string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/books", nil)
+	if acceptEncoding != "" {
+		req.Header.Set("Accept-Encoding", acceptEncoding)
+	}
+	return serveWithMiddleware(GzipMiddleware(), handler, "/books", req)
+}
+
+// gunzip decompresses the body of rec
+func gunzip(t *testing.T, rec *httptest.ResponseRecorder) []byte {
+	t.Helper()
+	gz, err := gzip.NewReader(rec.Body)
+	if err != nil {
+		t.Fatalf("the body isn't gzip: %v", err)
+	}
+	body, err := io.ReadAll(gz)
+	if err != nil {
+		t.Fatalf("decompressing the body: %v", err)
+	}
+	return body
+}
+
+func TestGzipMiddleware(t *testing.T) {
+	books := make([]BookAuthorInfo, 500)
+	for i := range books {
+		books[i] = BookAuthorInfo{BookID: i + 1, BookTitle: "Pride and Prejudice", AuthorID: 1,
+			Authors: []AuthorInfo{{ID: 1, Firstname: "Jane", Lastname: "Austen"}}}
+	}
+	list := func(w http.ResponseWriter, r *http.Request) {
+		RespondWithJSON(w, http.StatusCreated, books)
+	}
+
+	plain := serveGzip(list, "")
+	compressed := serveGzip(list, "gzip, deflate")
+	plainSize, compressedSize := plain.Body.Len(), compressed.Body.Len()
+
+	for name, rec := range map[string]*httptest.ResponseRecorder{"plain": plain, "compressed": compressed} {
+		if rec.Code != http.StatusCreated || rec.Header().Get("Content-Type") != "application/json" {
+			t.Errorf("%s: status %d with Content-Type %q, want 201 JSON", name, rec.Code, rec.Header().Get("Content-Type"))
+		}
+		if rec.Header().Get("Vary") != "Accept-Encoding" {
+			t.Errorf("%s: Vary %q, want Accept-Encoding", name, rec.Header().Get("Vary"))
+		}
+	}
+
+	if plain.Header().Get("Content-Encoding") != "" {
+		t.Errorf("a client without gzip got Content-Encoding %q", plain.Header().Get("Content-Encoding"))
+	}
+	var listed []BookAuthorInfo
+	decodeJSON(t, plain, &listed)
+	if !reflect.DeepEqual(listed, books) {
+		t.Errorf("a client without gzip didn't get the plain JSON list")
+	}
+
+	if compressed.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatalf("Content-Encoding %q, want gzip", compressed.Header().Get("Content-Encoding"))
+	}
+	if compressedSize*4 > plainSize {
+		t.Errorf("the compressed body is %d bytes for %d bytes of JSON", compressedSize, plainSize)
+	}
+	if body := gunzip(t, compressed); !strings.HasPrefix(string(body), "[") || len(body) != plainSize {
+		t.Errorf("the decompressed body is %d bytes, want the %d bytes of JSON", len(body), plainSize)
+	}
+}
+
+func TestGzipMiddlewareSkips(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+	}{
+		{"photo", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "image/jpeg")
+			w.Write([]byte{0xFF, 0xD8, 0xFF, 0xE0})
+		}},
+		{"not modified", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusNotModified)
+		}},
+		{"already encoded", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Content-Encoding", "br")
+			w.Write([]byte("brotli"))
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serveGzip(tt.handler, "gzip")
+			if rec.Header().Get("Content-Encoding") == "gzip" {
+				t.Errorf("the response was compressed")
+			}
+		})
+	}
+}
+
+func TestGzipMiddlewareStreams(t *testing.T) {
+	var flushedSize int
+	stream := func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/csv")
+		w.Write([]byte("id,title\n"))
+		w.(http.Flusher).Flush()
+		flushedSize = w.(interface{ Unwrap() http.ResponseWriter }).Unwrap().(*httptest.ResponseRecorder).Body.Len()
+		w.Write([]byte("1,Emma\n"))
+	}
+
+	rec := serveGzip(stream, "gzip")
+	if !rec.Flushed || flushedSize == 0 {
+		t.Errorf("nothing reached the client when the CSV header was flushed")
+	}
+	if body := gunzip(t, rec); string(body) != "id,title\n1,Emma\n" {
+		t.Errorf("got %q", body)
+	}
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config is the configuration of the server, read once at startup from the command line flags and the
+// environment. S3 and OpenTelemetry keep their own variables, see NewS3StorageFromEnv and setupTracing.
+type Config struct {
+	Port           string
+	DBUser         string
+	DBPassword     string
+	DBHost         string
+	DBPort         string
+	DBName         string
+	RequestTimeout time.Duration
+	CacheTTL       time.Duration
+	DebugEndpoints bool
+	TrustProxy     bool
+
+	LogLevel    string // LOG_LEVEL
+	TLSCertFile string // TLS_CERT_FILE
+	TLSKeyFile  string // TLS_KEY_FILE
+
+	StorageBackend   string // STORAGE_BACKEND, local or s3
+	UploadDir        string // UPLOAD_DIR
+	MaxPhotoSize     int64  // MAX_PHOTO_SIZE, in bytes
+	PhotoMemoryLimit int64  // PHOTO_MEMORY_LIMIT, in bytes
+	ConvertToWebP    bool   // CONVERT_TO_WEBP
+
+	RateLimitRPS   float64 // RATE_LIMIT_RPS, 0 disables rate limiting
+	RateLimitBurst int     // RATE_LIMIT_BURST
+
+	OpenLibraryURL string // OPENLIBRARY_URL
+	BaseURL        string // BASE_URL, the address the clients reach the server at
+
+	MigrationsDir string // MIGRATIONS_DIR
+
+	SMTPHost     string // SMTP_HOST, the emails are only logged without it
+	SMTPPort     string // SMTP_PORT
+	SMTPUsername string // SMTP_USERNAME
+	SMTPPassword string // SMTP_PASSWORD
+	MailFrom     string // MAIL_FROM
+	ReminderDays int    // REMINDER_DAYS, how many days before their due date the loans are reminded
+}
+
+// configEnv reads the environment variables of the configuration and collects what is wrong with them
+type configEnv struct {
+	lookup func(string) (string, bool)
+	errs   []error
+}
+
+func (e *configEnv) string(key, fallback string) string {
+	if value, ok := e.lookup(key); ok {
+		return value
+	}
+	return fallback
+}
+
+func (e *configEnv) bool(key string, fallback bool) bool {
+	value, ok := e.lookup(key)
+	if !ok {
+		return fallback
+	}
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		e.errs = append(e.errs, fmt.Errorf("%s must be true or false, got %q", key, value))
+		return fallback
+	}
+	return parsed
+}
+
+func (e *configEnv) int64(key string, fallback int64) int64 {
+	value, ok := e.lookup(key)
+	if !ok {
+		return fallback
+	}
+	parsed, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		e.errs = append(e.errs, fmt.Errorf("%s must be an integer, got %q", key, value))
+		return fallback
+	}
+	return parsed
+}
+
+func (e *configEnv) float(key string, fallback float64) float64 {
+	value, ok := e.lookup(key)
+	if !ok {
+		return fallback
+	}
+	parsed, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		e.errs = append(e.errs, fmt.Errorf("%s must be a number, got %q", key, value))
+		return fallback
+	}
+	return parsed
+}
+
+// LoadConfig parses the command line arguments and the environment read through lookup, usually
+// os.LookupEnv. Every invalid setting is reported in the returned error, not only the first one.
+func LoadConfig(args []string, lookup func(string) (string, bool)) (Config, error) {
+	env := &configEnv{lookup: lookup}
+	var cfg Config
+
+	flags := flag.NewFlagSet("api", flag.ContinueOnError)
+	flags.StringVar(&cfg.Port, "port", "8080", "Server Port")
+	flags.StringVar(&cfg.DBUser, "db-user", "root", "Database Username")
+	flags.StringVar(&cfg.DBPassword, "db-password", "password", "Database Password")
+	flags.StringVar(&cfg.DBHost, "db-hostname", "localhost", "Database hostname")
+	flags.StringVar(&cfg.DBPort, "db-port", "4450", "Database port")
+	flags.StringVar(&cfg.DBName, "db-name", "library", "Database name")
+	flags.DurationVar(&cfg.RequestTimeout, "request-timeout", 10*time.Second, "Maximum duration of a request, including its database queries")
+	flags.DurationVar(&cfg.CacheTTL, "cache-ttl", 30*time.Second, "How long the book and author lists are cached, 0 disables the cache")
+	flags.BoolVar(&cfg.DebugEndpoints, "debug", env.bool("DEBUG_ENDPOINTS", false), "Serve the pprof profiles and runtime statistics under /debug/ (also DEBUG_ENDPOINTS=true)")
+	flags.BoolVar(&cfg.TrustProxy, "trust-proxy", env.bool("TRUST_PROXY", false), "Identify clients by X-Forwarded-For for rate limiting, only behind a proxy that sets it (also TRUST_PROXY=true)")
+	if err := flags.Parse(args); err != nil {
+		return Config{}, err
+	}
+
+	cfg.LogLevel = env.string("LOG_LEVEL", "info")
+	cfg.TLSCertFile = env.string("TLS_CERT_FILE", "")
+	cfg.TLSKeyFile = env.string("TLS_KEY_FILE", "")
+	cfg.StorageBackend = env.string("STORAGE_BACKEND", "local")
+	cfg.UploadDir = env.string("UPLOAD_DIR", "./upload")
+	cfg.MaxPhotoSize = env.int64("MAX_PHOTO_SIZE", 5<<20)
+	cfg.PhotoMemoryLimit = env.int64("PHOTO_MEMORY_LIMIT", 1<<20)
+	cfg.ConvertToWebP = env.bool("CONVERT_TO_WEBP", false)
+	cfg.RateLimitRPS = env.float("RATE_LIMIT_RPS", 20)
+	cfg.RateLimitBurst = int(env.int64("RATE_LIMIT_BURST", 40))
+	cfg.OpenLibraryURL = strings.TrimSuffix(env.string("OPENLIBRARY_URL", "https://openlibrary.org"), "/")
+	cfg.MigrationsDir = env.string("MIGRATIONS_DIR", "./migrations")
+	cfg.BaseURL = strings.TrimSuffix(env.string("BASE_URL", "http://localhost:8080"), "/")
+	cfg.SMTPHost = env.string("SMTP_HOST", "")
+	cfg.SMTPPort = env.string("SMTP_PORT", "587")
+	cfg.SMTPUsername = env.string("SMTP_USERNAME", "")
+	cfg.SMTPPassword = env.string("SMTP_PASSWORD", "")
+	cfg.MailFrom = env.string("MAIL_FROM", "library@localhost")
+	cfg.ReminderDays = int(env.int64("REMINDER_DAYS", 2))
+
+	errs := append(env.errs, cfg.validate()...)
+	if len(errs) > 0 {
+		return Config{}, fmt.Errorf("invalid configuration:\n%w", errors.Join(errs...))
+	}
+	return cfg, nil
+}
+
+// validate returns every setting of cfg that is out of range or inconsistent
+func (cfg Config) validate() []error {
+	var errs []error
+	for _, port := range []struct{ name, value string }{{"-port", cfg.Port}, {"-db-port", cfg.DBPort}} {
+		if number, err := strconv.Atoi(port.value); err != nil || number < 1 || number > 65535 {
+			errs = append(errs, fmt.Errorf("%s must be a port number, got %q", port.name, port.value))
+		}
+	}
+	if cfg.DBHost == "" || cfg.DBName == "" {
+		errs = append(errs, errors.New("-db-hostname and -db-name are required"))
+	}
+	if cfg.RequestTimeout <= 0 {
+		errs = append(errs, errors.New("-request-timeout must be positive"))
+	}
+	if cfg.CacheTTL < 0 {
+		errs = append(errs, errors.New("-cache-ttl can't be negative"))
+	}
+	if _, err := ParseLogLevel(cfg.LogLevel); err != nil {
+		errs = append(errs, fmt.Errorf("LOG_LEVEL: %v", err))
+	}
+	if (cfg.TLSCertFile == "") != (cfg.TLSKeyFile == "") {
+		errs = append(errs, errors.New("TLS_CERT_FILE and TLS_KEY_FILE are both required to enable TLS"))
+	}
+	switch cfg.StorageBackend {
+	case "local":
+		if cfg.UploadDir == "" {
+			errs = append(errs, errors.New("UPLOAD_DIR can't be empty"))
+		}
+	case "s3":
+	default:
+		errs = append(errs, fmt.Errorf("STORAGE_BACKEND must be local or s3, got %q", cfg.StorageBackend))
+	}
+	if cfg.MaxPhotoSize <= 0 {
+		errs = append(errs, errors.New("MAX_PHOTO_SIZE must be positive"))
+	}
+	if cfg.PhotoMemoryLimit <= 0 {
+		errs = append(errs, errors.New("PHOTO_MEMORY_LIMIT must be positive"))
+	}
+	if cfg.RateLimitRPS < 0 {
+		errs = append(errs, errors.New("RATE_LIMIT_RPS can't be negative"))
+	}
+	if cfg.RateLimitBurst < 1 {
+		errs = append(errs, errors.New("RATE_LIMIT_BURST must be at least 1"))
+	}
+	if !strings.HasPrefix(cfg.OpenLibraryURL, "http://") && !strings.HasPrefix(cfg.OpenLibraryURL, "https://") {
+		errs = append(errs, fmt.Errorf("OPENLIBRARY_URL must be an http or https URL, got %q", cfg.OpenLibraryURL))
+	}
+	if !strings.HasPrefix(cfg.BaseURL, "http://") && !strings.HasPrefix(cfg.BaseURL, "https://") {
+		errs = append(errs, fmt.Errorf("BASE_URL must be an http or https URL, got %q", cfg.BaseURL))
+	}
+	if cfg.MigrationsDir == "" {
+		errs = append(errs, errors.New("MIGRATIONS_DIR can't be empty"))
+	}
+	if cfg.SMTPHost != "" {
+		if number, err := strconv.Atoi(cfg.SMTPPort); err != nil || number < 1 || number > 65535 {
+			errs = append(errs, fmt.Errorf("SMTP_PORT must be a port number, got %q", cfg.SMTPPort))
+		}
+		if cfg.MailFrom == "" {
+			errs = append(errs, errors.New("MAIL_FROM is required to send emails"))
+		}
+	}
+	if cfg.ReminderDays < 0 {
+		errs = append(errs, errors.New("REMINDER_DAYS can't be negative"))
+	}
+	return errs
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"strings"
+	"testing"
+	"time"
+)
+
+// envOf returns a lookup function reading env instead of the environment
+func envOf(env map[string]string) func(string) (string, bool) {
+	return func(key string) (string, bool) {
+		value, ok := env[key]
+		return value, ok
+	}
+}
+
+func TestLoadConfigDefaults(t *testing.T) {
+	cfg, err := LoadConfig(nil, envOf(nil))
+	if err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+	if cfg.Port != "8080" || cfg.DBPort != "4450" || cfg.DBName != "library" {
+		t.Errorf("server and database defaults: %+v", cfg)
+	}
+	if cfg.RequestTimeout != 10*time.Second || cfg.CacheTTL != 30*time.Second {
+		t.Errorf("timeouts %s and %s", cfg.RequestTimeout, cfg.CacheTTL)
+	}
+	if cfg.StorageBackend != "local" || cfg.UploadDir != "./upload" || cfg.MaxPhotoSize != 5<<20 {
+		t.Errorf("storage defaults: %+v", cfg)
+	}
+	if cfg.RateLimitRPS != 20 || cfg.RateLimitBurst != 40 || cfg.ReminderDays != 2 || cfg.LogLevel != "info" {
+		t.Errorf("limits defaults: %+v", cfg)
+	}
+}
+
+func TestLoadConfigOverrides(t *testing.T) {
+	args := []string{"-port", "9090", "-request-timeout", "3s", "-cache-ttl", "0", "-trust-proxy"}
+	env := map[string]string{
+		"STORAGE_BACKEND": "s3",
+		"RATE_LIMIT_RPS":  "0",
+		"BASE_URL":        "https://library.example.com/",
+		"DEBUG_ENDPOINTS": "true",
+	}
+	cfg, err := LoadConfig(args, envOf(env))
+	if err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+	if cfg.Port != "9090" || cfg.RequestTimeout != 3*time.Second || cfg.CacheTTL != 0 || !cfg.TrustProxy {
+		t.Errorf("flags not applied: %+v", cfg)
+	}
+	if cfg.StorageBackend != "s3" || cfg.RateLimitRPS != 0 || cfg.BaseURL != "https://library.example.com" || !cfg.DebugEndpoints {
+		t.Errorf("environment not applied: %+v", cfg)
+	}
+}
+
+func TestLoadConfigReportsEveryError(t *testing.T) {
+	args := []string{"-port", "http", "-request-timeout", "0s"}
+	env := map[string]string{
+		"MAX_PHOTO_SIZE":  "5MB",
+		"CONVERT_TO_WEBP": "maybe",
+		"STORAGE_BACKEND": "ftp",
+		"TLS_CERT_FILE":   "cert.pem",
+		"LOG_LEVEL":       "verbose",
+	}
+	_, err := LoadConfig(args, envOf(env))
+	if err == nil {
+		t.Fatal("LoadConfig accepted an invalid configuration")
+	}
+	for _, want := range []string{
+		`-port must be a port number, got "http"`,
+		"-request-timeout must be positive",
+		`MAX_PHOTO_SIZE must be an integer, got "5MB"`,
+		`CONVERT_TO_WEBP must be true or false, got "maybe"`,
+		`STORAGE_BACKEND must be local or s3, got "ftp"`,
+		"TLS_CERT_FILE and TLS_KEY_FILE are both required",
+		"LOG_LEVEL",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("the error doesn't mention %q:\n%v", want, err)
+		}
+	}
+}
+
+func TestLoadConfigInvalidFlags(t *testing.T) {
+	if _, err := LoadConfig([]string{"-request-timeout", "soon"}, envOf(nil)); err == nil {
+		t.Error("LoadConfig accepted an invalid duration")
+	}
+	if _, err := LoadConfig([]string{"-h"}, envOf(nil)); !errors.Is(err, flag.ErrHelp) {
+		t.Errorf("got %v for -h, want flag.ErrHelp", err)
+	}
+}
@@ -1,60 +1,66 @@
 package main
 
 import (
+    "bytes"
+    "context"
+    "errors"
     "fmt"
     "image"
     "image/jpeg"
-    "os"
+    _ "image/png"
+    "io"
+
     "github.com/nfnt/resize"
+    _ "golang.org/x/image/webp"
 )
 
+// Longest side in pixels of the generated photo variants
+const (
+    mediumSize    = 300
+    thumbnailSize = 100
+)
 
+// errInvalidImage is returned when an upload can't be decoded as an image
+var errInvalidImage = errors.New("file is not a valid image")
 
-func CropAndResize() {
-    inputFile, err := os.Open("input.jpg")
-    if err != nil {
-        fmt.Println("Error opening input file::", err)
-        return
-    }
-    defer inputFile.Close()
+// PhotoVariants holds the URLs of the stored versions of an uploaded photo
+type PhotoVariants struct {
+    Fullsize  string `json:"fullsize"`
+    Medium    string `json:"medium"`
+    Thumbnail string `json:"thumbnail"`
+}
 
-    img, _, err := image.Decode(inputFile)
+// GenerateSizes decodes the image read from src and stores its medium and thumbnail
+// variants as JPEG files under destDir, named with the version of the upload. Nothing is left behind when it fails.
+func GenerateSizes(ctx context.Context, storage Storage, src io.Reader, destDir, version string) (PhotoVariants, error) {
+    img, _, err := image.Decode(src)
     if err != nil {
-        fmt.Println("Error decoding image:", err)
-        return
+        return PhotoVariants{}, fmt.Errorf("%w: %v", errInvalidImage, err)
     }
 
-  
-    smallImg := resize.Thumbnail(100, 100, img, resize.Lanczos3)
-
-    mediumImg := resize.Thumbnail(300, 300, img, resize.Lanczos3)
-
-    largeImg := resize.Thumbnail(800, 800, img, resize.Lanczos3)
+    mediumKey := destDir + "/medium-" + version + ".jpg"
+    thumbnailKey := destDir + "/thumbnail-" + version + ".jpg"
+    var variants PhotoVariants
 
-    outputDir := "./output/"
-
-    if _, err := os.Stat(outputDir); os.IsNotExist(err) {
-        os.Mkdir(outputDir, 0755)
+    mediumImg := resize.Thumbnail(mediumSize, mediumSize, img, resize.Lanczos3)
+    if variants.Medium, err = saveImage(ctx, storage, mediumKey, mediumImg); err != nil {
+        return PhotoVariants{}, err
     }
 
-    saveImage(outputDir+"small.jpg", smallImg)
-    saveImage(outputDir+"medium.jpg", mediumImg)
-    saveImage(outputDir+"large.jpg", largeImg)
+    thumbnailImg := resize.Thumbnail(thumbnailSize, thumbnailSize, img, resize.Lanczos3)
+    if variants.Thumbnail, err = saveImage(ctx, storage, thumbnailKey, thumbnailImg); err != nil {
+        storage.Delete(ctx, mediumKey)
+        return PhotoVariants{}, err
+    }
 
-    fmt.Println("Images have been successfully cropped and resized!")
+    return variants, nil
 }
 
-func saveImage(filename string, img image.Image) {
-    outputFile, err := os.Create(filename)
-    if err != nil {
-        fmt.Println("Error creating output file::", err)
-        return
-    }
-    defer outputFile.Close()
-
-    err = jpeg.Encode(outputFile, img, nil)
-    if err != nil {
-        fmt.Println("Error saving image:", err)
-        return
+// saveImage encodes img as JPEG and stores it under key, returning its URL.
+func saveImage(ctx context.Context, storage Storage, key string, img image.Image) (string, error) {
+    var buf bytes.Buffer
+    if err := jpeg.Encode(&buf, img, nil); err != nil {
+        return "", fmt.Errorf("failed to encode %s: %w", key, err)
     }
+    return storage.Save(ctx, key, &buf)
 }
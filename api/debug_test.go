@@ -0,0 +1,58 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestDebugEndpoints(t *testing.T) {
+	discardLogs(t)
+	paths := []string{"/debug/pprof/", "/debug/pprof/cmdline", "/debug/vars"}
+
+	t.Run("disabled", func(t *testing.T) {
+		db, _ := newMockDB(t)
+		handler, err := setupHandler(testContext(t), Config{RequestTimeout: time.Minute}, db, newTestPhotoConfig(t))
+		if err != nil {
+			t.Fatalf("setupHandler: %v", err)
+		}
+		for _, path := range paths {
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+			if rec.Code != http.StatusNotFound {
+				t.Errorf("%s: status %d, want 404", path, rec.Code)
+			}
+		}
+	})
+
+	t.Run("enabled", func(t *testing.T) {
+		db, _ := newMockDB(t)
+		handler, err := setupHandler(testContext(t), Config{RequestTimeout: time.Minute, DebugEndpoints: true}, db, newTestPhotoConfig(t))
+		if err != nil {
+			t.Fatalf("setupHandler: %v", err)
+		}
+		for _, path := range paths {
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+			if rec.Code != http.StatusOK {
+				t.Errorf("%s: status %d, want 200", path, rec.Code)
+			}
+		}
+
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/debug/vars", nil))
+		var vars DebugVars
+		decodeJSON(t, rec, &vars)
+		if vars.Goroutines == 0 || vars.HeapAllocMB == 0 {
+			t.Errorf("got %+v", vars)
+		}
+
+		// The API is still served behind the debug endpoints
+		rec = httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/openapi.json", nil))
+		if rec.Code != http.StatusOK {
+			t.Errorf("/openapi.json: status %d, want 200", rec.Code)
+		}
+	})
+}
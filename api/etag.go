@@ -0,0 +1,65 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// etagFor computes a weak ETag from a response body and its X-Total-Count header, a page of a list
+// can stay the same while the total changes.
+func etagFor(header http.Header, body []byte) string {
+	hash := sha256.New()
+	hash.Write([]byte(header.Get("X-Total-Count") + "\n"))
+	hash.Write(body)
+	return `W/"` + hex.EncodeToString(hash.Sum(nil)[:16]) + `"`
+}
+
+// etagMatches reports whether the If-None-Match header of r lists etag. The comparison is weak, as
+// required for If-None-Match, so W/"x" and "x" match.
+func etagMatches(r *http.Request, etag string) bool {
+	ifNoneMatch := r.Header.Get("If-None-Match")
+	if ifNoneMatch == "" {
+		return false
+	}
+	if strings.TrimSpace(ifNoneMatch) == "*" {
+		return true
+	}
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		if strings.TrimPrefix(strings.TrimSpace(candidate), "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// writeJSONWithETag writes an encoded JSON body with its ETag. A successful response the client already
+// has, according to If-None-Match, is answered with 304 Not Modified and no body.
+func writeJSONWithETag(w http.ResponseWriter, r *http.Request, status int, body []byte) {
+	if status == http.StatusOK {
+		etag := etagFor(w.Header(), body)
+		w.Header().Set("ETag", etag)
+		if etagMatches(r, etag) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(body)
+}
+
+// RespondWithETag writes payload as JSON like RespondWithJSON, with an ETag so that clients can poll
+// the resource with If-None-Match and get a 304 while it is unchanged.
+func RespondWithETag(w http.ResponseWriter, r *http.Request, status int, payload interface{}) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	// Same output as the json.Encoder used by RespondWithJSON
+	writeJSONWithETag(w, r, status, append(body, '\n'))
+}
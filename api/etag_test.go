@@ -0,0 +1,108 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// expectAuthorList expects the queries of GetAuthors returning one author named lastname
+func expectAuthorList(mock sqlmock.Sqlmock, lastname string) {
+	FixtureAuthors(mock, []AuthorWithCount{{Author: Author{ID: 1, Lastname: lastname, Firstname: "Jane"}, BookCount: 2}})
+}
+
+func getWithETag(handler http.Handler, target, etag string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, target, nil)
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestGetAuthorsETag(t *testing.T) {
+	db, mock := newMockDB(t)
+	handler := GetAuthors(db)
+
+	expectAuthorList(mock, "Austen")
+	first := getWithETag(handler, "/authors", "")
+	etag := first.Header().Get("ETag")
+	if first.Code != http.StatusOK || !strings.HasPrefix(etag, `W/"`) {
+		t.Fatalf("got %d with ETag %q, want 200 with a weak ETag", first.Code, etag)
+	}
+
+	expectAuthorList(mock, "Austen")
+	unchanged := getWithETag(handler, "/authors", etag)
+	if unchanged.Code != http.StatusNotModified || unchanged.Body.Len() != 0 {
+		t.Errorf("got %d %q, want 304 without a body", unchanged.Code, unchanged.Body)
+	}
+	if unchanged.Header().Get("ETag") != etag {
+		t.Errorf("ETag %q, want %q", unchanged.Header().Get("ETag"), etag)
+	}
+
+	expectAuthorList(mock, "Austen-Leigh")
+	updated := getWithETag(handler, "/authors", etag)
+	if updated.Code != http.StatusOK || updated.Header().Get("ETag") == etag {
+		t.Errorf("got %d with ETag %q after an update, want 200 with a new ETag", updated.Code, updated.Header().Get("ETag"))
+	}
+}
+
+func TestCachedListETagInvalidatedByUpdate(t *testing.T) {
+	discardLogs(t)
+	db, mock := newMockDB(t)
+	photoConfig := newTestPhotoConfig(t)
+	r, err := setupRouter(testContext(t), Config{RequestTimeout: time.Minute, CacheTTL: time.Minute}, db, photoConfig)
+	if err != nil {
+		t.Fatalf("setupRouter: %v", err)
+	}
+	list := apiV1Prefix + "/authors"
+
+	expectAuthorList(mock, "Austen")
+	etag := getWithETag(r, list, "").Header().Get("ETag")
+
+	// Served from the cache, without any query
+	if rec := getWithETag(r, list, etag); rec.Code != http.StatusNotModified {
+		t.Fatalf("status %d, want 304", rec.Code)
+	}
+
+	mock.ExpectExec(sqlPattern("UPDATE authors")).WithArgs("Austen-Leigh", "Jane", "", 1).WillReturnResult(sqlmock.NewResult(0, 1))
+	expectAudit(mock, "update", "author", 1)
+	update := httptest.NewRequest(http.MethodPut, apiV1Prefix+"/authors/1", strings.NewReader(`{"firstname":"Jane","lastname":"Austen-Leigh"}`))
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, update)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("update status %d, want 200: %s", rec.Code, rec.Body)
+	}
+
+	expectAuthorList(mock, "Austen-Leigh")
+	rec = getWithETag(r, list, etag)
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "Austen-Leigh") {
+		t.Errorf("got %d %q after the update, want 200 with the new name", rec.Code, rec.Body)
+	}
+}
+
+func TestETagMatches(t *testing.T) {
+	tests := []struct {
+		ifNoneMatch string
+		want        bool
+	}{
+		{"", false},
+		{`W/"abc"`, true},
+		{`"abc"`, true},
+		{`"other", W/"abc"`, true},
+		{`"other"`, false},
+		{"*", true},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("If-None-Match", tt.ifNoneMatch)
+		if got := etagMatches(req, `W/"abc"`); got != tt.want {
+			t.Errorf("%q: got %v, want %v", tt.ifNoneMatch, got, tt.want)
+		}
+	}
+}
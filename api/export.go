@@ -0,0 +1,142 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// exportFlushRows is the number of CSV rows buffered before they are sent to the client
+const exportFlushRows = 500
+
+// startCSVExport sets the headers of a CSV download named <name>-<date>.csv and writes its header row
+func startCSVExport(w http.ResponseWriter, name string, header []string) *csv.Writer {
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s-%s.csv"`, name, time.Now().Format("2006-01-02")))
+	writer := csv.NewWriter(w)
+	writer.Write(header)
+	return writer
+}
+
+// ExportBooks returns a handler that streams the books with their main author as CSV.
+// It takes the same filters as the book list.
+func ExportBooks(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		where, args, err := bookListFilter(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		rows, err := db.QueryContext(r.Context(), `
+			SELECT books.id, books.title, books.author_id, authors.Firstname, authors.Lastname,
+				COALESCE(books.isbn, ''), books.is_borrowed, COALESCE(books.details, ''), COALESCE(books.photo, '')
+			FROM books
+			JOIN authors ON books.author_id = authors.id
+			`+where+`
+			ORDER BY books.id
+		`, args...)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer rows.Close()
+
+		writer := startCSVExport(w, "books", []string{"book_id", "title", "author_id", "author_firstname", "author_lastname", "isbn", "is_borrowed", "details", "photo"})
+		for count := 1; rows.Next(); count++ {
+			var bookID, authorID int
+			var title, firstname, lastname, isbn, details, photo string
+			var isBorrowed bool
+			if err := rows.Scan(&bookID, &title, &authorID, &firstname, &lastname, &isbn, &isBorrowed, &details, &photo); err != nil {
+				slog.Error("exporting books failed", "error", err)
+				return
+			}
+			writer.Write([]string{strconv.Itoa(bookID), title, strconv.Itoa(authorID), firstname, lastname, isbn, strconv.FormatBool(isBorrowed), details, photo})
+			if count%exportFlushRows == 0 {
+				writer.Flush()
+			}
+		}
+		// The status is already sent, a failure can only cut the file short
+		if err := rows.Err(); err != nil {
+			slog.Error("exporting books failed", "error", err)
+		}
+		writer.Flush()
+	}
+}
+
+// ExportSubscribers returns a handler that streams the subscribers as CSV, or renders them with
+// generatePDF for ?format=pdf.
+func ExportSubscribers(db *sql.DB, generatePDF pdfGenerator) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		format := r.URL.Query().Get("format")
+		if format != "" && format != "csv" && format != "pdf" {
+			http.Error(w, "format must be csv or pdf", http.StatusBadRequest)
+			return
+		}
+
+		rows, err := db.QueryContext(r.Context(), `
+			SELECT id, Lastname, Firstname, Email, COALESCE(phone, '')
+			FROM subscribers
+			ORDER BY id
+		`)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer rows.Close()
+
+		if format == "pdf" {
+			exportSubscribersPDF(w, rows, generatePDF)
+			return
+		}
+
+		writer := startCSVExport(w, "subscribers", []string{"id", "lastname", "firstname", "email", "phone"})
+		for count := 1; rows.Next(); count++ {
+			var subscriber Subscriber
+			if err := rows.Scan(&subscriber.ID, &subscriber.Lastname, &subscriber.Firstname, &subscriber.Email, &subscriber.Phone); err != nil {
+				slog.Error("exporting subscribers failed", "error", err)
+				return
+			}
+			writer.Write([]string{strconv.Itoa(subscriber.ID), subscriber.Lastname, subscriber.Firstname, subscriber.Email, subscriber.Phone})
+			if count%exportFlushRows == 0 {
+				writer.Flush()
+			}
+		}
+		if err := rows.Err(); err != nil {
+			slog.Error("exporting subscribers failed", "error", err)
+		}
+		writer.Flush()
+	}
+}
+
+// exportSubscribersPDF reads the subscribers of rows and sends the PDF generatePDF makes of them. Unlike the
+// CSV, the document is built in memory before anything is sent, so a failure still gets an error status.
+func exportSubscribersPDF(w http.ResponseWriter, rows *sql.Rows, generatePDF pdfGenerator) {
+	subscribers := []Subscriber{}
+	for rows.Next() {
+		var subscriber Subscriber
+		if err := rows.Scan(&subscriber.ID, &subscriber.Lastname, &subscriber.Firstname, &subscriber.Email, &subscriber.Phone); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		subscribers = append(subscribers, subscriber)
+	}
+	if err := rows.Err(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	document, err := generatePDF(subscribers)
+	if err != nil {
+		slog.Error("exporting subscribers as PDF failed", "error", err)
+		http.Error(w, "Failed to generate the PDF", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Disposition", `attachment; filename="subscribers.pdf"`)
+	w.Write(document)
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// expectSubscriberExport expects the query of the subscriber export
+func expectSubscriberExport(mock sqlmock.Sqlmock) {
+	mock.ExpectQuery(sqlPattern("SELECT id, Lastname, Firstname, Email, COALESCE(phone, '')")).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "lastname", "firstname", "email", "phone"}).
+			AddRow(1, "Johnson", "Emma", "emma@example.com", "+40700000001").
+			AddRow(3, "Williams", "Oliver", "oliver@example.com", ""))
+}
+
+func TestExportSubscribersPDF(t *testing.T) {
+	db, mock := newMockDB(t)
+	expectSubscriberExport(mock)
+	var rendered []Subscriber
+	generate := func(subscribers []Subscriber) ([]byte, error) {
+		rendered = subscribers
+		return []byte("%PDF-test"), nil
+	}
+
+	rec := serveRoute(ExportSubscribers(db, generate), http.MethodGet, "/subscribers/export", "/subscribers/export?format=pdf", nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d, want 200: %s", rec.Code, rec.Body)
+	}
+	if rec.Header().Get("Content-Type") != "application/pdf" || rec.Header().Get("Content-Disposition") != `attachment; filename="subscribers.pdf"` {
+		t.Errorf("headers %v", rec.Header())
+	}
+	if rec.Body.String() != "%PDF-test" {
+		t.Errorf("body %q, want the generated document", rec.Body)
+	}
+	want := []Subscriber{
+		{ID: 1, Lastname: "Johnson", Firstname: "Emma", Email: "emma@example.com", Phone: "+40700000001"},
+		{ID: 3, Lastname: "Williams", Firstname: "Oliver", Email: "oliver@example.com"},
+	}
+	if !reflect.DeepEqual(rendered, want) {
+		t.Errorf("rendered %+v, want %+v", rendered, want)
+	}
+}
+
+func TestExportSubscribersPDFFailure(t *testing.T) {
+	discardLogs(t)
+	db, mock := newMockDB(t)
+	expectSubscriberExport(mock)
+	generate := func([]Subscriber) ([]byte, error) { return nil, errors.New("font not found") }
+
+	rec := serveRoute(ExportSubscribers(db, generate), http.MethodGet, "/subscribers/export", "/subscribers/export?format=pdf", nil)
+	if rec.Code != http.StatusInternalServerError || rec.Header().Get("Content-Type") == "application/pdf" {
+		t.Errorf("got %d with %q, want a 500 without the PDF headers", rec.Code, rec.Header().Get("Content-Type"))
+	}
+}
+
+func TestExportSubscribersCSV(t *testing.T) {
+	db, mock := newMockDB(t)
+	expectSubscriberExport(mock)
+	generate := func([]Subscriber) ([]byte, error) {
+		t.Error("the PDF generator was called for a CSV export")
+		return nil, nil
+	}
+
+	rec := serveRoute(ExportSubscribers(db, generate), http.MethodGet, "/subscribers/export", "/subscribers/export", nil)
+	want := "id,lastname,firstname,email,phone\n1,Johnson,Emma,emma@example.com,+40700000001\n3,Williams,Oliver,oliver@example.com,\n"
+	if rec.Code != http.StatusOK || rec.Body.String() != want {
+		t.Errorf("got %d %q, want %q", rec.Code, rec.Body, want)
+	}
+}
+
+func TestExportSubscribersInvalidFormat(t *testing.T) {
+	rec := serveRoute(ExportSubscribers(nil, generateSubscribersPDF), http.MethodGet, "/subscribers/export", "/subscribers/export?format=xlsx", nil)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("status %d, want 400", rec.Code)
+	}
+}
+
+func TestGenerateSubscribersPDF(t *testing.T) {
+	subscribers := make([]Subscriber, 120)
+	for i := range subscribers {
+		subscribers[i] = Subscriber{ID: i + 1, Lastname: "Ștefănescu", Firstname: "Ana", Email: "ana@example.com"}
+	}
+	document, err := generateSubscribersPDF(subscribers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(document, []byte("%PDF-")) {
+		t.Errorf("the document starts with %q", document[:8])
+	}
+	// 120 rows don't fit on one page
+	if pages := strings.Count(string(document), "/Type /Page\n"); pages < 2 {
+		t.Errorf("got %d pages, want several", pages)
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"database/sql/driver"
+	"fmt"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// bookColumns are the columns ScanBooks reads
+var bookColumns = []string{"book_id", "book_title", "author_id", "book_photo", "is_borrowed", "book_details",
+	"author_lastname", "author_firstname", "isbn", "publisher", "format", "borrow_count"}
+
+// authorColumns are the columns of GET /authors
+var authorColumns = []string{"id", "lastname", "firstname", "photo", "book_count"}
+
+// bookRows returns the rows of the book list for the books ids, none of them borrowed
+func bookRows(ids ...int) *sqlmock.Rows {
+	rows := sqlmock.NewRows(bookColumns)
+	for _, id := range ids {
+		rows.AddRow(id, fmt.Sprintf("Book %d", id), 1, "", false, "", "Austen", "Jane", "", "", "", 0)
+	}
+	return rows
+}
+
+// Fixture describes the list query the fixtures expect: the fragment of its WHERE clause with the arguments
+// of the filter, and the LIMIT and OFFSET arguments when a page is requested. The zero Fixture is an
+// unfiltered list without pagination.
+type Fixture struct {
+	Where string
+	Args  []driver.Value
+	Page  []driver.Value
+	// Total is the X-Total-Count of the list, the number of records when it is 0
+	Total int
+}
+
+// total returns the count the COUNT(*) query of the list answers
+func (f Fixture) total(records int) int {
+	if f.Total != 0 {
+		return f.Total
+	}
+	return records
+}
+
+// expectList expects the COUNT(*) query of a list followed by the query selecting its records
+func (f Fixture) expectList(mock sqlmock.Sqlmock, countQuery, listQuery string, records int, rows *sqlmock.Rows) {
+	mock.ExpectQuery(sqlPattern(countQuery + f.Where)).WithArgs(f.Args...).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(f.total(records)))
+	mock.ExpectQuery(sqlPattern(listQuery)).WithArgs(append(append([]driver.Value{}, f.Args...), f.Page...)...).WillReturnRows(rows)
+}
+
+// Authors expects GET /authors to list authors
+func (f Fixture) Authors(mock sqlmock.Sqlmock, authors []AuthorWithCount) {
+	rows := sqlmock.NewRows(authorColumns)
+	for _, author := range authors {
+		rows.AddRow(author.ID, author.Lastname, author.Firstname, author.Photo, author.BookCount)
+	}
+	f.expectList(mock, "SELECT COUNT(*) FROM authors ", "COUNT(written.book_id) AS book_count", len(authors), rows)
+}
+
+// bookListRows returns the rows of the book list for books, with their main author
+func bookListRows(books []BookAuthorInfo) *sqlmock.Rows {
+	rows := sqlmock.NewRows(bookColumns)
+	for _, book := range books {
+		var author AuthorInfo
+		if len(book.Authors) > 0 {
+			author = book.Authors[0]
+		}
+		rows.AddRow(book.BookID, book.BookTitle, book.AuthorID, book.BookPhoto, book.IsBorrowed, book.BookDetails,
+			author.Lastname, author.Firstname, book.ISBN, book.Publisher, book.Format, book.BorrowCount)
+	}
+	return rows
+}
+
+// Books expects GET /books to list books, with their main author and without genres or co-authors
+func (f Fixture) Books(mock sqlmock.Sqlmock, books []BookAuthorInfo) {
+	f.SearchBooks(mock, books)
+	if len(books) > 0 {
+		mock.ExpectQuery(sqlPattern("FROM book_genres")).WillReturnRows(sqlmock.NewRows([]string{"book_id", "id", "name"}))
+		mock.ExpectQuery(sqlPattern("FROM authors_books")).WillReturnRows(sqlmock.NewRows([]string{"book_id", "id", "firstname", "lastname"}))
+	}
+}
+
+// SearchBooks expects GET /search_books to find books, which lists them without their genres and co-authors
+func (f Fixture) SearchBooks(mock sqlmock.Sqlmock, books []BookAuthorInfo) {
+	f.expectList(mock, "SELECT COUNT(*) FROM books JOIN authors ON books.author_id = authors.id ", "FROM books", len(books), bookListRows(books))
+}
+
+// Subscribers expects GET /subscribers to list subscribers
+func (f Fixture) Subscribers(mock sqlmock.Sqlmock, subscribers []Subscriber) {
+	rows := sqlmock.NewRows([]string{"id", "lastname", "firstname", "email", "phone", "membership_expiry"})
+	for _, subscriber := range subscribers {
+		var expiry driver.Value
+		if subscriber.MembershipExpiry != nil {
+			expiry = subscriber.MembershipExpiry.Time
+		}
+		rows.AddRow(subscriber.ID, subscriber.Lastname, subscriber.Firstname, subscriber.Email, subscriber.Phone, expiry)
+	}
+	f.expectList(mock, "SELECT COUNT(*) FROM subscribers", "FROM subscribers ORDER BY id", len(subscribers), rows)
+}
+
+// FixtureAuthors expects an unfiltered GET /authors to list authors
+func FixtureAuthors(mock sqlmock.Sqlmock, authors []AuthorWithCount) {
+	Fixture{}.Authors(mock, authors)
+}
+
+// FixtureBooks expects an unfiltered GET /books to list books
+func FixtureBooks(mock sqlmock.Sqlmock, books []BookAuthorInfo) {
+	Fixture{}.Books(mock, books)
+}
+
+// FixtureSubscribers expects GET /subscribers to list subscribers
+func FixtureSubscribers(mock sqlmock.Sqlmock, subscribers []Subscriber) {
+	Fixture{}.Subscribers(mock, subscribers)
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+)
+
+// GetBookFormats returns a handler that lists the distinct formats of the books in the catalogue
+func GetBookFormats(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rows, err := db.QueryContext(r.Context(), "SELECT DISTINCT format FROM books WHERE format != '' ORDER BY format")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer rows.Close()
+
+		formats := []string{}
+		for rows.Next() {
+			var format string
+			if err := rows.Scan(&format); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			formats = append(formats, format)
+		}
+		if err := rows.Err(); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		RespondWithJSON(w, http.StatusOK, formats)
+	}
+}
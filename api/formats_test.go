@@ -0,0 +1,92 @@
+package main
+
+import (
+	"database/sql/driver"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestValidateBookFormat(t *testing.T) {
+	for _, format := range []string{"", "hardcover", "paperback", "ebook", "audiobook"} {
+		if err := ValidateBookFormat(format); err != nil {
+			t.Errorf("%q: %v", format, err)
+		}
+	}
+	for _, format := range []string{"audio", "Ebook", "vinyl"} {
+		if err := ValidateBookFormat(format); err == nil {
+			t.Errorf("%q was accepted", format)
+		}
+	}
+}
+
+func TestInvalidBookFormat(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		method  string
+		pattern string
+		target  string
+		body    string
+	}{
+		{"AddBook", AddBook(nil, newTestPhotoConfig(t)), http.MethodPost, "/books/new", "/books/new", `{"title":"Emma","author_id":1,"format":"vinyl"}`},
+		{"UpdateBook", UpdateBook(nil), http.MethodPut, "/books/{id}", "/books/2", `{"title":"Emma","author_id":1,"format":"vinyl"}`},
+		{"book list filter", GetAllBooks(nil), http.MethodGet, "/books", "/books?format=vinyl", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serveRoute(tt.handler, tt.method, tt.pattern, tt.target, strings.NewReader(tt.body))
+			if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "format") {
+				t.Errorf("got %d %q, want 400 about the format", rec.Code, rec.Body)
+			}
+		})
+	}
+}
+
+func TestGetAllBooksByFormat(t *testing.T) {
+	db, mock := newMockDB(t)
+	Fixture{Where: "WHERE books.format = ?", Args: []driver.Value{"ebook"}}.Books(mock, []BookAuthorInfo{
+		{BookID: 3, BookTitle: "Nineteen Eighty-Four", AuthorID: 2, IsBorrowed: true, ISBN: "9780451524935", Publisher: "Signet Classics",
+			Format: "ebook", BorrowCount: 4, Authors: []AuthorInfo{{ID: 2, Firstname: "George", Lastname: "Orwell"}}},
+	})
+
+	rec := serveRoute(GetAllBooks(db), http.MethodGet, "/books", "/books?format=ebook", nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d, want 200: %s", rec.Code, rec.Body)
+	}
+	var books []BookAuthorInfo
+	decodeJSON(t, rec, &books)
+	if len(books) != 1 || books[0].Format != "ebook" {
+		t.Errorf("got %+v, want the ebook", books)
+	}
+}
+
+func TestGetBookFormats(t *testing.T) {
+	tests := []struct {
+		name string
+		rows *sqlmock.Rows
+		want []string
+	}{
+		{name: "empty catalogue", rows: sqlmock.NewRows([]string{"format"}), want: []string{}},
+		{name: "formats in use", rows: sqlmock.NewRows([]string{"format"}).AddRow("ebook").AddRow("paperback"), want: []string{"ebook", "paperback"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock := newMockDB(t)
+			mock.ExpectQuery(sqlPattern("SELECT DISTINCT format FROM books WHERE format != '' ORDER BY format")).WillReturnRows(tt.rows)
+
+			rec := serveRoute(GetBookFormats(db), http.MethodGet, "/books/formats", "/books/formats", nil)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status %d, want 200: %s", rec.Code, rec.Body)
+			}
+			var formats []string
+			decodeJSON(t, rec, &formats)
+			if !reflect.DeepEqual(formats, tt.want) {
+				t.Errorf("got %v, want %v", formats, tt.want)
+			}
+		})
+	}
+}
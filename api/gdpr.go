@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// SubscriberLoan is a loan of a book by a subscriber
+type SubscriberLoan struct {
+	BookID       int        `json:"book_id"`
+	BookTitle    string     `json:"book_title"`
+	DateOfBorrow *time.Time `json:"date_of_borrow"`
+	DueDate      *time.Time `json:"due_date"`
+	ReturnDate   *time.Time `json:"return_date"`
+}
+
+// SubscriberDataExport is everything the library stores about a subscriber
+type SubscriberDataExport struct {
+	Subscriber    Subscriber       `json:"subscriber"`
+	BorrowHistory []SubscriberLoan `json:"borrow_history"`
+	Reviews       []Review         `json:"reviews"`
+	ExportedAt    time.Time        `json:"exported_at"`
+}
+
+// subscriberLoans returns every loan of a subscriber, the most recent first
+func subscriberLoans(ctx context.Context, db *sql.DB, subscriberID int) ([]SubscriberLoan, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT borrowed_books.book_id, COALESCE(books.title, ''), borrowed_books.date_of_borrow,
+			borrowed_books.due_date, borrowed_books.return_date
+		FROM borrowed_books
+		LEFT JOIN books ON borrowed_books.book_id = books.id
+		WHERE borrowed_books.subscriber_id = ?
+		ORDER BY borrowed_books.date_of_borrow DESC
+	`, subscriberID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	loans := []SubscriberLoan{}
+	for rows.Next() {
+		var loan SubscriberLoan
+		if err := rows.Scan(&loan.BookID, &loan.BookTitle, &loan.DateOfBorrow, &loan.DueDate, &loan.ReturnDate); err != nil {
+			return nil, err
+		}
+		loans = append(loans, loan)
+	}
+	return loans, rows.Err()
+}
+
+// subscriberReviews returns the reviews written by a subscriber, the most recent first
+func subscriberReviews(ctx context.Context, db *sql.DB, subscriberID int) ([]Review, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT id, book_id, subscriber_id, rating, comment, created_at
+		FROM reviews
+		WHERE subscriber_id = ?
+		ORDER BY created_at DESC, id DESC
+	`, subscriberID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	reviews := []Review{}
+	for rows.Next() {
+		var review Review
+		if err := rows.Scan(&review.ID, &review.BookID, &review.SubscriberID, &review.Rating, &review.Comment, &review.CreatedAt); err != nil {
+			return nil, err
+		}
+		reviews = append(reviews, review)
+	}
+	return reviews, rows.Err()
+}
+
+// ExportSubscriberData returns a handler that gathers all the data of a subscriber in one JSON document,
+// to answer a data access request.
+func ExportSubscriberData(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		subscriberID, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			http.Error(w, "Invalid subscriber ID", http.StatusBadRequest)
+			return
+		}
+
+		export := SubscriberDataExport{ExportedAt: time.Now().UTC()}
+		var phone sql.NullString
+		err = db.QueryRowContext(r.Context(), "SELECT id, Lastname, Firstname, Email, phone FROM subscribers WHERE id = ?", subscriberID).
+			Scan(&export.Subscriber.ID, &export.Subscriber.Lastname, &export.Subscriber.Firstname, &export.Subscriber.Email, &phone)
+		if err == sql.ErrNoRows {
+			http.Error(w, "Subscriber not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		export.Subscriber.Phone = phone.String
+
+		export.BorrowHistory, err = subscriberLoans(r.Context(), db, subscriberID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		export.Reviews, err = subscriberReviews(r.Context(), db, subscriberID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="subscriber-%d.json"`, subscriberID))
+		RespondWithJSON(w, http.StatusOK, export)
+	}
+}
+
+// AnonymizeSubscriber returns a handler that erases the personal data of a subscriber. The name, email
+// and phone are replaced by placeholders, the review comments are cleared and so are the details of the
+// audit entries of the subscriber, which hold the data it had; the loans and ratings stay for the statistics.
+func AnonymizeSubscriber(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		subscriberID, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			http.Error(w, "Invalid subscriber ID", http.StatusBadRequest)
+			return
+		}
+
+		tx, err := db.BeginTx(r.Context(), nil)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to start transaction: %v", err), http.StatusInternalServerError)
+			return
+		}
+		defer tx.Rollback()
+
+		var id int
+		err = tx.QueryRowContext(r.Context(), "SELECT id FROM subscribers WHERE id = ? FOR UPDATE", subscriberID).Scan(&id)
+		if err == sql.ErrNoRows {
+			http.Error(w, "Subscriber not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		// The email stays unique per subscriber, the unique key would reject a shared placeholder
+		_, err = tx.ExecContext(r.Context(), `
+			UPDATE subscribers SET Lastname = 'Anonymized', Firstname = 'Subscriber', Email = ?, phone = NULL
+			WHERE id = ?
+		`, fmt.Sprintf("anonymized-%d@example.invalid", subscriberID), subscriberID)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to anonymize subscriber: %v", err), http.StatusInternalServerError)
+			return
+		}
+		_, err = tx.ExecContext(r.Context(), "UPDATE reviews SET comment = '' WHERE subscriber_id = ?", subscriberID)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to anonymize reviews: %v", err), http.StatusInternalServerError)
+			return
+		}
+		_, err = tx.ExecContext(r.Context(), "UPDATE audit_log SET details = NULL WHERE entity_type = 'subscriber' AND entity_id = ?", subscriberID)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to anonymize audit log: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		if err := tx.Commit(); err != nil {
+			http.Error(w, fmt.Sprintf("Failed to commit transaction: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		RespondWithJSON(w, http.StatusOK, map[string]string{"message": "Subscriber anonymized successfully"})
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestExportSubscriberData(t *testing.T) {
+	t.Run("exported", func(t *testing.T) {
+		db, mock := newMockDB(t)
+		borrowed := time.Date(2024, 4, 1, 10, 0, 0, 0, time.UTC)
+		mock.ExpectQuery(sqlPattern("SELECT id, Lastname, Firstname, Email, phone FROM subscribers WHERE id = ?")).WithArgs(1).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "lastname", "firstname", "email", "phone"}).
+				AddRow(1, "Johnson", "Emma", "emma@example.com", nil))
+		mock.ExpectQuery(sqlPattern("FROM borrowed_books")).WithArgs(1).
+			WillReturnRows(sqlmock.NewRows([]string{"book_id", "title", "date_of_borrow", "due_date", "return_date"}).
+				AddRow(2, "Emma", borrowed.AddDate(0, 1, 0), nil, nil).
+				AddRow(1, "Pride and Prejudice", borrowed, borrowed.AddDate(0, 0, loanPeriodDays), borrowed.AddDate(0, 0, 9)))
+		mock.ExpectQuery(sqlPattern("FROM reviews")).WithArgs(1).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "book_id", "subscriber_id", "rating", "comment", "created_at"}).
+				AddRow(4, 1, 1, 5, "Loved it", borrowed.AddDate(0, 0, 10)))
+
+		rec := serveRoute(ExportSubscriberData(db), http.MethodGet, "/subscribers/{id}/export", "/subscribers/1/export", nil)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status %d, want 200: %s", rec.Code, rec.Body)
+		}
+		if got := rec.Header().Get("Content-Disposition"); got != `attachment; filename="subscriber-1.json"` {
+			t.Errorf("Content-Disposition %q", got)
+		}
+		var export SubscriberDataExport
+		decodeJSON(t, rec, &export)
+		if export.Subscriber.Email != "emma@example.com" || len(export.BorrowHistory) != 2 || len(export.Reviews) != 1 {
+			t.Errorf("got %+v", export)
+		}
+		if export.BorrowHistory[1].BookTitle != "Pride and Prejudice" || export.BorrowHistory[1].ReturnDate == nil {
+			t.Errorf("got loan %+v", export.BorrowHistory[1])
+		}
+	})
+
+	t.Run("unknown subscriber", func(t *testing.T) {
+		db, mock := newMockDB(t)
+		mock.ExpectQuery(sqlPattern("FROM subscribers WHERE id = ?")).WithArgs(9).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "lastname", "firstname", "email", "phone"}))
+
+		rec := serveRoute(ExportSubscriberData(db), http.MethodGet, "/subscribers/{id}/export", "/subscribers/9/export", nil)
+		if rec.Code != http.StatusNotFound {
+			t.Errorf("status %d, want 404", rec.Code)
+		}
+	})
+}
+
+func TestAnonymizeSubscriber(t *testing.T) {
+	t.Run("anonymized", func(t *testing.T) {
+		db, mock := newMockDB(t)
+		mock.ExpectBegin()
+		mock.ExpectQuery(sqlPattern("SELECT id FROM subscribers WHERE id = ? FOR UPDATE")).WithArgs(1).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		mock.ExpectExec(sqlPattern("UPDATE subscribers SET Lastname = 'Anonymized'")).
+			WithArgs("anonymized-1@example.invalid", 1).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(sqlPattern("UPDATE reviews SET comment = ''")).WithArgs(1).WillReturnResult(sqlmock.NewResult(0, 2))
+		// GET /audit would otherwise still return the name, email and phone recorded by the updates
+		mock.ExpectExec(sqlPattern("UPDATE audit_log SET details = NULL WHERE entity_type = 'subscriber' AND entity_id = ?")).
+			WithArgs(1).WillReturnResult(sqlmock.NewResult(0, 3))
+		mock.ExpectCommit()
+
+		rec := serveRoute(AnonymizeSubscriber(db), http.MethodPost, "/subscribers/{id}/anonymize", "/subscribers/1/anonymize", nil)
+		if rec.Code != http.StatusOK {
+			t.Errorf("status %d, want 200: %s", rec.Code, rec.Body)
+		}
+	})
+
+	t.Run("unknown subscriber", func(t *testing.T) {
+		db, mock := newMockDB(t)
+		mock.ExpectBegin()
+		mock.ExpectQuery(sqlPattern("SELECT id FROM subscribers")).WithArgs(9).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+		mock.ExpectRollback()
+
+		rec := serveRoute(AnonymizeSubscriber(db), http.MethodPost, "/subscribers/{id}/anonymize", "/subscribers/9/anonymize", nil)
+		if rec.Code != http.StatusNotFound {
+			t.Errorf("status %d, want 404", rec.Code)
+		}
+	})
+
+	t.Run("failed update is rolled back", func(t *testing.T) {
+		db, mock := newMockDB(t)
+		mock.ExpectBegin()
+		mock.ExpectQuery(sqlPattern("SELECT id FROM subscribers")).WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		mock.ExpectExec(sqlPattern("UPDATE subscribers")).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(sqlPattern("UPDATE reviews")).WillReturnError(errors.New("deadlock found"))
+		mock.ExpectRollback()
+
+		rec := serveRoute(AnonymizeSubscriber(db), http.MethodPost, "/subscribers/{id}/anonymize", "/subscribers/1/anonymize", nil)
+		if rec.Code != http.StatusInternalServerError {
+			t.Errorf("status %d, want 500", rec.Code)
+		}
+	})
+
+	t.Run("failed audit redaction is rolled back", func(t *testing.T) {
+		db, mock := newMockDB(t)
+		mock.ExpectBegin()
+		mock.ExpectQuery(sqlPattern("SELECT id FROM subscribers")).WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		mock.ExpectExec(sqlPattern("UPDATE subscribers")).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(sqlPattern("UPDATE reviews")).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec(sqlPattern("UPDATE audit_log")).WillReturnError(errors.New("deadlock found"))
+		mock.ExpectRollback()
+
+		rec := serveRoute(AnonymizeSubscriber(db), http.MethodPost, "/subscribers/{id}/anonymize", "/subscribers/1/anonymize", nil)
+		if rec.Code != http.StatusInternalServerError {
+			t.Errorf("status %d, want 500", rec.Code)
+		}
+	})
+}
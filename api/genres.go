@@ -0,0 +1,245 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// maxGenreNameLength is the size of the genres.name column
+const maxGenreNameLength = 100
+
+// Genre is a category books can be grouped by
+type Genre struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+// errUnknownGenre is returned when a book is assigned a genre that doesn't exist
+var errUnknownGenre = errors.New("unknown genre")
+
+// GenreRef names a genre either by its id or by its name
+type GenreRef struct {
+	ID   int
+	Name string
+}
+
+// UnmarshalJSON accepts a genre id (a number) or a genre name (a string)
+func (g *GenreRef) UnmarshalJSON(data []byte) error {
+	if err := json.Unmarshal(data, &g.ID); err == nil {
+		return nil
+	}
+	if err := json.Unmarshal(data, &g.Name); err != nil {
+		return errors.New("a genre must be an id or a name")
+	}
+	return nil
+}
+
+// parseGenreRef reads a genre given as a form value, an id if it is numeric and a name otherwise
+func parseGenreRef(value string) GenreRef {
+	if id, err := strconv.Atoi(value); err == nil {
+		return GenreRef{ID: id}
+	}
+	return GenreRef{Name: value}
+}
+
+// setBookGenres replaces the genres of a book. Genres have to exist already, an unknown one fails with errUnknownGenre.
+func setBookGenres(ctx context.Context, tx *sql.Tx, bookID int64, genres []GenreRef) error {
+	if _, err := tx.ExecContext(ctx, "DELETE FROM book_genres WHERE book_id = ?", bookID); err != nil {
+		return err
+	}
+
+	for _, genre := range genres {
+		var genreID int
+		var err error
+		if genre.Name != "" {
+			err = tx.QueryRowContext(ctx, "SELECT id FROM genres WHERE name = ?", strings.TrimSpace(genre.Name)).Scan(&genreID)
+		} else {
+			err = tx.QueryRowContext(ctx, "SELECT id FROM genres WHERE id = ?", genre.ID).Scan(&genreID)
+		}
+		if err == sql.ErrNoRows {
+			if genre.Name != "" {
+				return fmt.Errorf("%w: %s", errUnknownGenre, genre.Name)
+			}
+			return fmt.Errorf("%w: %d", errUnknownGenre, genre.ID)
+		}
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, "INSERT IGNORE INTO book_genres (book_id, genre_id) VALUES (?, ?)", bookID, genreID)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadBookGenres fills in the genres of books with a single query
+func loadBookGenres(ctx context.Context, db *sql.DB, books []BookAuthorInfo) error {
+	if len(books) == 0 {
+		return nil
+	}
+
+	index := make(map[int]int, len(books))
+	placeholders := make([]string, len(books))
+	args := make([]interface{}, len(books))
+	for i, book := range books {
+		index[book.BookID] = i
+		placeholders[i] = "?"
+		args[i] = book.BookID
+		books[i].Genres = []Genre{}
+	}
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT book_genres.book_id, genres.id, genres.name
+		FROM book_genres
+		JOIN genres ON book_genres.genre_id = genres.id
+		WHERE book_genres.book_id IN (`+strings.Join(placeholders, ", ")+`)
+		ORDER BY genres.name
+	`, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var bookID int
+		var genre Genre
+		if err := rows.Scan(&bookID, &genre.ID, &genre.Name); err != nil {
+			return err
+		}
+		i := index[bookID]
+		books[i].Genres = append(books[i].Genres, genre)
+	}
+	return rows.Err()
+}
+
+// GetGenres returns a handler that lists all genres by name
+func GetGenres(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rows, err := db.QueryContext(r.Context(), "SELECT id, name FROM genres ORDER BY name")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer rows.Close()
+
+		genres := []Genre{}
+		for rows.Next() {
+			var genre Genre
+			if err := rows.Scan(&genre.ID, &genre.Name); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			genres = append(genres, genre)
+		}
+		if err := rows.Err(); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		RespondWithJSON(w, http.StatusOK, genres)
+	}
+}
+
+// AddGenre returns a handler that creates a genre
+func AddGenre(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var genre Genre
+		if err := json.NewDecoder(r.Body).Decode(&genre); err != nil {
+			http.Error(w, "Invalid JSON data", http.StatusBadRequest)
+			return
+		}
+		defer r.Body.Close()
+
+		genre.Name = strings.TrimSpace(genre.Name)
+		if err := validateRequiredField("name", genre.Name, maxGenreNameLength); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if _, err := strconv.Atoi(genre.Name); err == nil {
+			http.Error(w, "name can't be a number", http.StatusBadRequest)
+			return
+		}
+
+		result, err := db.ExecContext(r.Context(), "INSERT INTO genres (name) VALUES (?)", genre.Name)
+		if isDuplicateEntry(err) {
+			http.Error(w, "genre already exists", http.StatusConflict)
+			return
+		}
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to insert genre: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		id, err := result.LastInsertId()
+		if err != nil {
+			http.Error(w, "Failed to get last insert ID", http.StatusInternalServerError)
+			return
+		}
+
+		RespondWithJSON(w, http.StatusCreated, map[string]int{"id": int(id)})
+	}
+}
+
+// DeleteGenre returns a handler that deletes a genre. A genre still assigned to books is only
+// deleted with ?force=true, which detaches it from them.
+func DeleteGenre(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		genreID, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			http.Error(w, "Invalid genre ID", http.StatusBadRequest)
+			return
+		}
+
+		tx, err := db.BeginTx(r.Context(), nil)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to start transaction: %v", err), http.StatusInternalServerError)
+			return
+		}
+		defer tx.Rollback()
+
+		var bookCount int
+		err = tx.QueryRowContext(r.Context(), "SELECT COUNT(*) FROM book_genres WHERE genre_id = ?", genreID).Scan(&bookCount)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if bookCount > 0 && r.URL.Query().Get("force") != "true" {
+			RespondWithJSON(w, http.StatusConflict, map[string]interface{}{
+				"error":      "genre is assigned to books",
+				"book_count": bookCount,
+			})
+			return
+		}
+
+		if _, err := tx.ExecContext(r.Context(), "DELETE FROM book_genres WHERE genre_id = ?", genreID); err != nil {
+			http.Error(w, fmt.Sprintf("Failed to detach genre: %v", err), http.StatusInternalServerError)
+			return
+		}
+		result, err := tx.ExecContext(r.Context(), "DELETE FROM genres WHERE id = ?", genreID)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to delete genre: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+			http.Error(w, "Genre not found", http.StatusNotFound)
+			return
+		}
+
+		if err := tx.Commit(); err != nil {
+			http.Error(w, fmt.Sprintf("Failed to commit transaction: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		RespondWithJSON(w, http.StatusOK, map[string]string{"message": "Genre deleted successfully"})
+	}
+}
@@ -0,0 +1,240 @@
+//go:build integration
+
+package main
+
+// The integration tests run the API against a real MySQL, where the sqlmock tests only match the SQL with
+// regular expressions. They are built with the integration tag and need the DSN of a MySQL server whose
+// user may create databases, for example:
+//
+//	INTEGRATION_DSN='root:password@tcp(localhost:4450)/' go test -tags integration -run Integration ./...
+//
+// "make integration" starts a MySQL container and runs them. Without INTEGRATION_DSN they are skipped.
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/gorilla/mux"
+)
+
+// openIntegrationDB creates an empty database on the server of INTEGRATION_DSN for the test, migrated with
+// the migrations of the repository, and drops it when the test ends
+func openIntegrationDB(t *testing.T) *sql.DB {
+	t.Helper()
+	db := openEmptyIntegrationDB(t)
+	if err := RunMigrations(db, "./migrations"); err != nil {
+		t.Fatalf("RunMigrations: %v", err)
+	}
+	return db
+}
+
+// openEmptyIntegrationDB creates an empty database on the server of INTEGRATION_DSN for the test and drops it
+// when the test ends
+func openEmptyIntegrationDB(t *testing.T) *sql.DB {
+	t.Helper()
+	dsn := os.Getenv("INTEGRATION_DSN")
+	if dsn == "" {
+		t.Skip("INTEGRATION_DSN is not set")
+	}
+	cfg, err := mysql.ParseDSN(dsn)
+	if err != nil {
+		t.Fatalf("invalid INTEGRATION_DSN: %v", err)
+	}
+	cfg.ParseTime = true
+
+	cfg.DBName = ""
+	server, err := sql.Open("mysql", cfg.FormatDSN())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { server.Close() })
+	// A container that was just started takes a while to accept connections
+	deadline := time.Now().Add(time.Minute)
+	for err = server.Ping(); err != nil; err = server.Ping() {
+		if time.Now().After(deadline) {
+			t.Fatalf("MySQL at INTEGRATION_DSN is unreachable: %v", err)
+		}
+		time.Sleep(time.Second)
+	}
+
+	cfg.DBName = fmt.Sprintf("library_test_%d", time.Now().UnixNano())
+	if _, err := server.Exec("CREATE DATABASE " + cfg.DBName); err != nil {
+		t.Fatalf("creating the test database: %v", err)
+	}
+	t.Cleanup(func() {
+		if _, err := server.Exec("DROP DATABASE " + cfg.DBName); err != nil {
+			t.Errorf("dropping the test database: %v", err)
+		}
+	})
+
+	db, err := sql.Open("mysql", cfg.FormatDSN())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// TestIntegrationMigrateBaselineSchema migrates a database created from schema.sql, as the MySQL container
+// creates it, and uses the columns and tables added by the migrations
+func TestIntegrationMigrateBaselineSchema(t *testing.T) {
+	db := openEmptyIntegrationDB(t)
+	schema, err := os.ReadFile("schema.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, statement := range splitSQLStatements(string(schema)) {
+		if _, err := db.Exec(statement); err != nil {
+			t.Fatalf("schema.sql: %v\n%s", err, statement)
+		}
+	}
+	if err := RunMigrations(db, "./migrations"); err != nil {
+		t.Fatalf("RunMigrations: %v", err)
+	}
+	var versions int
+	if err := db.QueryRow("SELECT COUNT(*) FROM schema_migrations").Scan(&versions); err != nil {
+		t.Fatal(err)
+	}
+	files, _ := filepath.Glob("migrations/*.sql")
+	if versions != len(files) {
+		t.Errorf("%d migrations recorded, want %d", versions, len(files))
+	}
+
+	r := newTestRouter(t, db)
+	api := apiV1Prefix
+	bookID := createdID(t, integrationRequest(t, r, http.MethodPost, api+"/books/new",
+		`{"title":"Emma","author_id":1,"isbn":"9780141439587","publisher":"Penguin Classics","format":"hardcover","tag_names":["Classic"]}`,
+		http.StatusCreated))
+	subscriberID := createdID(t, integrationRequest(t, r, http.MethodPost, api+"/subscribers/new",
+		`{"firstname":"Jane","lastname":"Fairfax","email":"jane.fairfax@example.com","phone":"+40700000009"}`, http.StatusCreated))
+	integrationRequest(t, r, http.MethodPost, api+"/subscribers/new",
+		`{"firstname":"Emma","lastname":"Johnson","email":"EMMA.JOHNSON@example.com"}`, http.StatusConflict)
+	loan := fmt.Sprintf(`{"subscriber_id":%d,"book_id":%d}`, subscriberID, bookID)
+	integrationRequest(t, r, http.MethodPost, api+"/book/borrow", loan, http.StatusCreated)
+	integrationRequest(t, r, http.MethodPost, api+"/book/return", loan, http.StatusOK)
+	for _, target := range []string{"/books", "/subscribers", "/stats", "/audit", "/genres", "/webhooks"} {
+		integrationRequest(t, r, http.MethodGet, api+target, "", http.StatusOK)
+	}
+}
+
+// integrationRequest sends a request with a JSON body to the router and checks its status
+func integrationRequest(t *testing.T, r *mux.Router, method, target, body string, want int) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+	if rec.Code != want {
+		t.Fatalf("%s %s: status %d, want %d: %s", method, target, rec.Code, want, rec.Body)
+	}
+	return rec
+}
+
+// createdID returns the id of the record created by a request
+func createdID(t *testing.T, rec *httptest.ResponseRecorder) int {
+	t.Helper()
+	var response struct {
+		ID int `json:"id"`
+	}
+	decodeJSON(t, rec, &response)
+	if response.ID == 0 {
+		t.Fatal("no id in the response")
+	}
+	return response.ID
+}
+
+func TestIntegrationLibraryFlow(t *testing.T) {
+	db := openIntegrationDB(t)
+	r := newTestRouter(t, db)
+	api := apiV1Prefix
+
+	authorID := createdID(t, integrationRequest(t, r, http.MethodPost, api+"/authors/new",
+		`{"firstname":"George","lastname":"Orwell"}`, http.StatusCreated))
+	otherAuthorID := createdID(t, integrationRequest(t, r, http.MethodPost, api+"/authors/new",
+		`{"firstname":"Virginia","lastname":"Woolf"}`, http.StatusCreated))
+	bookID := createdID(t, integrationRequest(t, r, http.MethodPost, api+"/books/new",
+		fmt.Sprintf(`{"title":"Nineteen Eighty-Four","author_id":%d,"isbn":"9780451524935","publisher":"Signet Classics","format":"paperback","tag_names":["Dystopia"]}`, authorID),
+		http.StatusCreated))
+	subscriberID := createdID(t, integrationRequest(t, r, http.MethodPost, api+"/subscribers/new",
+		`{"firstname":"Emma","lastname":"Johnson","email":"Emma@Example.com"}`, http.StatusCreated))
+
+	var book BookAuthorInfo
+	decodeJSON(t, integrationRequest(t, r, http.MethodGet, fmt.Sprintf("%s/books/%d", api, bookID), "", http.StatusOK), &book)
+	if book.BookTitle != "Nineteen Eighty-Four" || len(book.Authors) != 1 || book.Authors[0].ID != authorID || book.Publisher != "Signet Classics" {
+		t.Errorf("got %+v", book)
+	}
+	// The same ISBN can't be added twice
+	integrationRequest(t, r, http.MethodPost, api+"/books/new",
+		fmt.Sprintf(`{"title":"1984","author_id":%d,"isbn":"978-0-451-52493-5"}`, authorID), http.StatusConflict)
+
+	loan := fmt.Sprintf(`{"subscriber_id":%d,"book_id":%d}`, subscriberID, bookID)
+	integrationRequest(t, r, http.MethodPost, api+"/book/borrow", loan, http.StatusCreated)
+	integrationRequest(t, r, http.MethodPost, api+"/book/borrow", loan, http.StatusConflict)
+	var availability map[string]interface{}
+	decodeJSON(t, integrationRequest(t, r, http.MethodGet, fmt.Sprintf("%s/books/%d/availability", api, bookID), "", http.StatusOK), &availability)
+	if availability["available"] != false {
+		t.Errorf("a borrowed book is %v", availability)
+	}
+	integrationRequest(t, r, http.MethodDelete, fmt.Sprintf("%s/books/%d", api, bookID), "", http.StatusConflict)
+
+	integrationRequest(t, r, http.MethodPost, api+"/book/return", loan, http.StatusOK)
+	integrationRequest(t, r, http.MethodPost, api+"/book/return", loan, http.StatusNotFound)
+	var history []map[string]interface{}
+	decodeJSON(t, integrationRequest(t, r, http.MethodGet, fmt.Sprintf("%s/books/%d/borrow-history", api, bookID), "", http.StatusOK), &history)
+	if len(history) != 1 || history[0]["return_date"] == nil {
+		t.Errorf("borrow history %v, want the returned loan", history)
+	}
+
+	// Deleting the last book of an author deletes the author as well
+	integrationRequest(t, r, http.MethodDelete, fmt.Sprintf("%s/books/%d", api, bookID), "", http.StatusOK)
+	integrationRequest(t, r, http.MethodGet, fmt.Sprintf("%s/books/%d", api, bookID), "", http.StatusNotFound)
+	integrationRequest(t, r, http.MethodGet, fmt.Sprintf("%s/authors/%d/profile", api, authorID), "", http.StatusNotFound)
+	integrationRequest(t, r, http.MethodDelete, fmt.Sprintf("%s/authors/%d", api, otherAuthorID), "", http.StatusOK)
+	integrationRequest(t, r, http.MethodDelete, fmt.Sprintf("%s/subscribers/%d", api, subscriberID), "", http.StatusOK)
+}
+
+// TestIntegrationReadEndpoints runs the queries of the read endpoints on the demo dataset, a query that
+// MySQL rejects makes its endpoint answer 500
+func TestIntegrationReadEndpoints(t *testing.T) {
+	db := openIntegrationDB(t)
+	if err := Seed(context.Background(), db); err != nil {
+		t.Fatalf("Seed: %v", err)
+	}
+	r := newTestRouter(t, db)
+
+	targets := []string{
+		"/books", "/books?genre=Classics&author_id=1&publisher=Penguin+Classics&format=paperback&is_borrowed=false&sort=title&order=desc&page=1&page_size=2",
+		"/books/available", "/books/popular", "/books/by-author?name=Austen", "/books/isbn/9780141439518", "/books/formats",
+		"/books/1", "/books/2/subscribers", "/books/2/borrow-history", "/books/1/availability", "/books/1/similar", "/books/1/reviews",
+		"/books/export", "/search_books?query=Emma&tags=classic&publisher=Penguin+Classics",
+		"/authors", "/authors?has_books=true&sort=lastname", "/authors?no_books=true", "/authorsbooks?author_id=1&sort=book&order=desc",
+		"/authors/1", "/authors/1/profile", "/authors/1/stats",
+		"/subscribers", "/subscribers/1", "/subscribers/1/active-borrows", "/subscribers/1/export", "/subscribers/search?query=Brown",
+		"/subscribers/expired-memberships", "/subscribers/export",
+		"/stats", "/stats/monthly-borrows", "/reports/top-books", "/audit", "/genres", "/publishers", "/webhooks",
+	}
+	for _, target := range targets {
+		t.Run(target, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, apiV1Prefix+target, nil))
+			if rec.Code != http.StatusOK {
+				t.Errorf("status %d, want 200: %s", rec.Code, rec.Body)
+			}
+			if strings.HasPrefix(rec.Header().Get("Content-Type"), "application/json") && !json.Valid(rec.Body.Bytes()) {
+				t.Errorf("invalid JSON: %s", rec.Body)
+			}
+		})
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// logLevel is the minimum level of the messages that are logged, it may be changed while the server runs
+var logLevel = new(slog.LevelVar)
+
+// logLevels are the level names accepted by LOG_LEVEL and POST /admin/loglevel
+var logLevels = map[string]slog.Level{
+	"debug": slog.LevelDebug,
+	"info":  slog.LevelInfo,
+	"warn":  slog.LevelWarn,
+	"error": slog.LevelError,
+}
+
+// ParseLogLevel reads a level name, one of debug, info, warn and error
+func ParseLogLevel(name string) (slog.Level, error) {
+	level, ok := logLevels[strings.ToLower(strings.TrimSpace(name))]
+	if !ok {
+		return 0, fmt.Errorf("level must be one of debug, info, warn or error")
+	}
+	return level, nil
+}
+
+// setupLogging makes the default logger write JSON lines to stderr from the level called levelName.
+// The standard log package goes through it as well.
+func setupLogging(levelName string) error {
+	level, err := ParseLogLevel(levelName)
+	if err != nil {
+		return err
+	}
+	logLevel.Set(level)
+	slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: logLevel})))
+	return nil
+}
+
+// SetLogLevelHandler returns a handler that changes the log level at runtime from a {"level": "debug"} body
+func SetLogLevelHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var requestBody struct {
+			Level string `json:"level"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&requestBody); err != nil {
+			http.Error(w, "Invalid JSON data", http.StatusBadRequest)
+			return
+		}
+		defer r.Body.Close()
+
+		level, err := ParseLogLevel(requestBody.Level)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		logLevel.Set(level)
+		slog.Info("log level changed", "level", level)
+
+		RespondWithJSON(w, http.StatusOK, map[string]string{"level": strings.ToLower(level.String())})
+	}
+}
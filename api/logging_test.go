@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// captureLogs makes the default logger write JSON lines to the returned buffer from logLevel, set to level
+// for the test, the way setupLogging does for stderr
+func captureLogs(t *testing.T, level slog.Level) *bytes.Buffer {
+	t.Helper()
+	var logs bytes.Buffer
+	defaultLogger, defaultLevel := slog.Default(), logLevel.Level()
+	logLevel.Set(level)
+	slog.SetDefault(slog.New(slog.NewJSONHandler(&logs, &slog.HandlerOptions{Level: logLevel})))
+	t.Cleanup(func() {
+		slog.SetDefault(defaultLogger)
+		logLevel.Set(defaultLevel)
+	})
+	return &logs
+}
+
+// discardLogs drops the messages of the default logger for the rest of the test, for the tests that build the
+// router or expect errors to be logged
+func discardLogs(t *testing.T) {
+	t.Helper()
+	defaultLogger := slog.Default()
+	slog.SetDefault(slog.New(slog.NewJSONHandler(io.Discard, nil)))
+	t.Cleanup(func() { slog.SetDefault(defaultLogger) })
+}
+
+func TestParseLogLevel(t *testing.T) {
+	tests := []struct {
+		name string
+		want slog.Level
+	}{
+		{"debug", slog.LevelDebug},
+		{"info", slog.LevelInfo},
+		{" WARN ", slog.LevelWarn},
+		{"Error", slog.LevelError},
+	}
+	for _, tt := range tests {
+		got, err := ParseLogLevel(tt.name)
+		if err != nil || got != tt.want {
+			t.Errorf("ParseLogLevel(%q) = %v, %v, want %v", tt.name, got, err, tt.want)
+		}
+	}
+
+	for _, name := range []string{"", "verbose", "trace"} {
+		if _, err := ParseLogLevel(name); err == nil {
+			t.Errorf("ParseLogLevel(%q) accepted an unknown level", name)
+		}
+	}
+}
+
+func TestLogLevelSuppressesDebug(t *testing.T) {
+	logs := captureLogs(t, slog.LevelInfo)
+
+	slog.Debug("debug message")
+	slog.Info("info message")
+	if strings.Contains(logs.String(), "debug message") {
+		t.Errorf("a debug message was logged at info level: %s", logs)
+	}
+	if !strings.Contains(logs.String(), "info message") {
+		t.Errorf("the info message wasn't logged: %s", logs)
+	}
+}
+
+func TestSetLogLevelHandler(t *testing.T) {
+	logs := captureLogs(t, slog.LevelInfo)
+
+	rec := serveRoute(SetLogLevelHandler(), http.MethodPost, "/admin/loglevel", "/admin/loglevel", strings.NewReader(`{"level": "debug"}`))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d, want 200: %s", rec.Code, rec.Body)
+	}
+	var response map[string]string
+	decodeJSON(t, rec, &response)
+	if response["level"] != "debug" {
+		t.Errorf("got %v", response)
+	}
+
+	slog.Debug("debug message")
+	if !strings.Contains(logs.String(), "debug message") {
+		t.Errorf("the debug message wasn't logged after switching to debug: %s", logs)
+	}
+
+	for _, body := range []string{`{"level": "verbose"}`, `{`} {
+		rec := serveRoute(SetLogLevelHandler(), http.MethodPost, "/admin/loglevel", "/admin/loglevel", strings.NewReader(body))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: status %d, want 400", body, rec.Code)
+		}
+	}
+	if logLevel.Level() != slog.LevelDebug {
+		t.Errorf("an invalid level changed the log level to %v", logLevel.Level())
+	}
+}
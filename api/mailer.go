@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"mime"
+	"net"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// Mailer sends a plain text email
+type Mailer interface {
+	Send(ctx context.Context, to, subject, body string) error
+}
+
+// SMTPMailer sends the emails through an SMTP server. Auth is nil when the server needs no login.
+type SMTPMailer struct {
+	Addr string
+	From string
+	Auth smtp.Auth
+}
+
+// Send delivers the email to the SMTP server. net/smtp takes no context, ctx is not used.
+func (m *SMTPMailer) Send(ctx context.Context, to, subject, body string) error {
+	var message strings.Builder
+	fmt.Fprintf(&message, "From: %s\r\n", m.From)
+	fmt.Fprintf(&message, "To: %s\r\n", to)
+	fmt.Fprintf(&message, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&message, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	message.WriteString("MIME-Version: 1.0\r\n")
+	message.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	message.WriteString("\r\n")
+	message.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+	return smtp.SendMail(m.Addr, m.Auth, m.From, []string{to}, []byte(message.String()))
+}
+
+// LogMailer only logs the emails, for development
+type LogMailer struct{}
+
+// Send logs the email instead of sending it
+func (LogMailer) Send(ctx context.Context, to, subject, body string) error {
+	slog.Info("email", "to", to, "subject", subject, "body", body)
+	return nil
+}
+
+// newMailerFromConfig returns an SMTPMailer when SMTP_HOST is set and a LogMailer otherwise
+func newMailerFromConfig(cfg Config) Mailer {
+	if cfg.SMTPHost == "" {
+		return LogMailer{}
+	}
+	mailer := &SMTPMailer{Addr: net.JoinHostPort(cfg.SMTPHost, cfg.SMTPPort), From: cfg.MailFrom}
+	if cfg.SMTPUsername != "" {
+		mailer.Auth = smtp.PlainAuth("", cfg.SMTPUsername, cfg.SMTPPassword, cfg.SMTPHost)
+	}
+	return mailer
+}
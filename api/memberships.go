@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// membershipDateLayout is the layout of the membership_expiry dates in the requests and responses
+const membershipDateLayout = "2006-01-02"
+
+// errInvalidMembershipExpiry is returned for a membership_expiry that isn't a date
+var errInvalidMembershipExpiry = errors.New("membership_expiry must be a date formatted as YYYY-MM-DD")
+
+// MembershipDate is the expiry date of a membership. It is read and written as YYYY-MM-DD, both in JSON
+// and in the database.
+type MembershipDate struct {
+	time.Time
+}
+
+// MarshalJSON encodes the date as YYYY-MM-DD
+func (d MembershipDate) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.Format(membershipDateLayout))
+}
+
+// UnmarshalJSON decodes a date formatted as YYYY-MM-DD
+func (d *MembershipDate) UnmarshalJSON(data []byte) error {
+	var value string
+	if err := json.Unmarshal(data, &value); err != nil {
+		return errInvalidMembershipExpiry
+	}
+	expiry, err := parseMembershipExpiry(value)
+	if err != nil || expiry == nil {
+		return errInvalidMembershipExpiry
+	}
+	*d = *expiry
+	return nil
+}
+
+// Scan reads a DATE column
+func (d *MembershipDate) Scan(src interface{}) error {
+	switch value := src.(type) {
+	case time.Time:
+		d.Time = value
+		return nil
+	case []byte:
+		return d.Scan(string(value))
+	case string:
+		expiry, err := time.Parse(membershipDateLayout, value)
+		if err != nil {
+			return fmt.Errorf("invalid membership_expiry %q in the database", value)
+		}
+		d.Time = expiry
+		return nil
+	}
+	return fmt.Errorf("cannot scan %T into a membership date", src)
+}
+
+// Value writes the date to a DATE column
+func (d MembershipDate) Value() (driver.Value, error) {
+	return d.Format(membershipDateLayout), nil
+}
+
+// parseMembershipExpiry parses the optional membership_expiry date of a subscriber, nil when it is empty
+func parseMembershipExpiry(value string) (*MembershipDate, error) {
+	if value == "" {
+		return nil, nil
+	}
+	expiry, err := time.Parse(membershipDateLayout, value)
+	if err != nil {
+		return nil, errInvalidMembershipExpiry
+	}
+	return &MembershipDate{expiry}, nil
+}
+
+// expiredMembership returns the expiry date of the membership of a subscriber when it is in the past, and
+// nil when it is still valid, has no expiry or the subscriber doesn't exist. A membership is valid up to
+// and including its expiry day, in the time zone of the database.
+func expiredMembership(ctx context.Context, db *sql.DB, subscriberID int) (*time.Time, error) {
+	var expiry *time.Time
+	var expired bool
+	err := db.QueryRowContext(ctx, `
+		SELECT membership_expiry, COALESCE(membership_expiry < CURDATE(), FALSE)
+		FROM subscribers WHERE id = ?
+	`, subscriberID).Scan(&expiry, &expired)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil || !expired {
+		return nil, err
+	}
+	return expiry, nil
+}
+
+// GetExpiredMemberships returns a handler that lists the subscribers whose membership has expired, the
+// longest expired first.
+func GetExpiredMemberships(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		limit, offset, err := ParsePagination(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		where := "WHERE membership_expiry < CURDATE()"
+		var total int
+		if err := db.QueryRowContext(r.Context(), "SELECT COUNT(*) FROM subscribers "+where).Scan(&total); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		query, args := paginate(`
+			SELECT id, lastname, firstname, email, COALESCE(phone, ''), membership_expiry
+			FROM subscribers `+where+`
+			ORDER BY membership_expiry, id
+		`, nil, limit, offset)
+		rows, err := db.QueryContext(r.Context(), query, args...)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer rows.Close()
+
+		subscribers := []Subscriber{}
+		for rows.Next() {
+			var subscriber Subscriber
+			if err := rows.Scan(&subscriber.ID, &subscriber.Lastname, &subscriber.Firstname, &subscriber.Email, &subscriber.Phone, &subscriber.MembershipExpiry); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			subscribers = append(subscribers, subscriber)
+		}
+		if err := rows.Err(); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		WriteListResponse(w, http.StatusOK, subscribers, total)
+	}
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestMembershipDateJSON(t *testing.T) {
+	subscriber := Subscriber{ID: 1, MembershipExpiry: &MembershipDate{time.Date(2025, 1, 31, 0, 0, 0, 0, time.UTC)}}
+	body, err := json.Marshal(subscriber)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(body), `"membership_expiry":"2025-01-31"`) {
+		t.Errorf("got %s, want the date without a time", body)
+	}
+
+	var decoded Subscriber
+	if err := json.Unmarshal(body, &decoded); err != nil || !decoded.MembershipExpiry.Equal(subscriber.MembershipExpiry.Time) {
+		t.Errorf("got %+v, %v", decoded.MembershipExpiry, err)
+	}
+	if body, _ := json.Marshal(Subscriber{ID: 1}); strings.Contains(string(body), "membership_expiry") {
+		t.Errorf("got %s, want no membership_expiry", body)
+	}
+	for _, invalid := range []string{`"31/01/2025"`, `"2025-01-31T00:00:00Z"`, `20250131`, `""`} {
+		var date MembershipDate
+		if err := json.Unmarshal([]byte(invalid), &date); err != errInvalidMembershipExpiry {
+			t.Errorf("%s: got %v, want errInvalidMembershipExpiry", invalid, err)
+		}
+	}
+}
+
+func TestMembershipDateScan(t *testing.T) {
+	want := time.Date(2025, 1, 31, 0, 0, 0, 0, time.UTC)
+	for _, src := range []interface{}{want, []byte("2025-01-31"), "2025-01-31"} {
+		var date MembershipDate
+		if err := date.Scan(src); err != nil || !date.Equal(want) {
+			t.Errorf("%#v: got %s, %v", src, date, err)
+		}
+	}
+	var date MembershipDate
+	if err := date.Scan(42); err == nil {
+		t.Error("scanned an integer")
+	}
+	if value, _ := (MembershipDate{want}).Value(); value != "2025-01-31" {
+		t.Errorf("Value %v, want 2025-01-31", value)
+	}
+}
+
+// expectMembership expects the membership check of subscriber 1 with its expiry
+func expectMembership(mock sqlmock.Sqlmock, expiry interface{}, expired bool) {
+	mock.ExpectQuery(sqlPattern("SELECT membership_expiry, COALESCE(membership_expiry < CURDATE(), FALSE)")).WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"membership_expiry", "expired"}).AddRow(expiry, expired))
+}
+
+func TestBorrowBookMembership(t *testing.T) {
+	body := `{"subscriber_id": 1, "book_id": 2}`
+
+	t.Run("expired", func(t *testing.T) {
+		db, mock := newMockDB(t)
+		expectMembership(mock, time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC), true)
+
+		rec := serveRoute(BorrowBook(db, newTestWebhooks(t)), http.MethodPost, "/book/borrow", "/book/borrow", strings.NewReader(body))
+		if rec.Code != http.StatusForbidden {
+			t.Fatalf("status %d, want 403", rec.Code)
+		}
+		var response map[string]string
+		decodeJSON(t, rec, &response)
+		if response["error"] != "membership expired" || response["expired_at"] != "2024-01-15" {
+			t.Errorf("got %v", response)
+		}
+	})
+
+	for name, expiry := range map[string]interface{}{"not yet expired": time.Now().AddDate(0, 1, 0), "no expiry": nil} {
+		t.Run(name, func(t *testing.T) {
+			db, mock := newMockDB(t)
+			expectMembership(mock, expiry, false)
+			mock.ExpectBegin()
+			mock.ExpectQuery(sqlPattern("FOR UPDATE")).WithArgs(2).WillReturnRows(sqlmock.NewRows([]string{"is_borrowed"}).AddRow(false))
+			mock.ExpectExec(sqlPattern("INSERT INTO borrowed_books")).WillReturnResult(sqlmock.NewResult(1, 1))
+			mock.ExpectExec(sqlPattern("UPDATE books SET is_borrowed = TRUE")).WillReturnResult(sqlmock.NewResult(0, 1))
+			mock.ExpectCommit()
+			expectAudit(mock, "borrow", "book", 2)
+
+			rec := serveRoute(BorrowBook(db, newTestWebhooks(t)), http.MethodPost, "/book/borrow", "/book/borrow", strings.NewReader(body))
+			if rec.Code != http.StatusCreated {
+				t.Fatalf("status %d, want 201: %s", rec.Code, rec.Body)
+			}
+			expectMessage(t, rec, "Book borrowed successfully")
+		})
+	}
+}
+
+func TestTransferBorrowMembership(t *testing.T) {
+	body := `{"from_subscriber_id": 2, "to_subscriber_id": 1, "book_id": 3}`
+
+	t.Run("expired", func(t *testing.T) {
+		db, mock := newMockDB(t)
+		expectMembership(mock, time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC), true)
+
+		rec := serveRoute(TransferBorrow(db), http.MethodPost, "/book/transfer", "/book/transfer", strings.NewReader(body))
+		if rec.Code != http.StatusForbidden {
+			t.Fatalf("status %d, want 403", rec.Code)
+		}
+		var response map[string]string
+		decodeJSON(t, rec, &response)
+		if response["error"] != "membership expired" || response["expired_at"] != "2024-01-15" {
+			t.Errorf("got %v", response)
+		}
+	})
+
+	t.Run("valid", func(t *testing.T) {
+		db, mock := newMockDB(t)
+		expectMembership(mock, time.Now().AddDate(0, 1, 0), false)
+		mock.ExpectBegin()
+		mock.ExpectQuery(sqlPattern("AND return_date IS NULL FOR UPDATE")).WithArgs(2, 3).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+		mock.ExpectQuery(sqlPattern("SELECT EXISTS(SELECT 1 FROM subscribers WHERE id = ?)")).WithArgs(1).
+			WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+		mock.ExpectQuery(sqlPattern("WHERE subscriber_id = ? AND return_date IS NULL")).WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+		mock.ExpectExec(sqlPattern("UPDATE borrowed_books SET subscriber_id = ?")).WithArgs(1, 2, 3).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		rec := serveRoute(TransferBorrow(db), http.MethodPost, "/book/transfer", "/book/transfer", strings.NewReader(body))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status %d, want 200: %s", rec.Code, rec.Body)
+		}
+		expectMessage(t, rec, "Book transferred successfully")
+	})
+}
+
+func TestSubscriberMembershipExpiry(t *testing.T) {
+	subscriber := `"firstname":"Emma","lastname":"Johnson","email":"emma@example.com"`
+
+	t.Run("add", func(t *testing.T) {
+		db, mock := newMockDB(t)
+		mock.ExpectExec(sqlPattern("INSERT INTO subscribers (lastname, firstname, email, phone, membership_expiry)")).
+			WithArgs("Johnson", "Emma", "emma@example.com", nil, "2025-01-31").WillReturnResult(sqlmock.NewResult(7, 1))
+		expectAudit(mock, "create", "subscriber", 7)
+
+		rec := serveRoute(AddSubscriber(db), http.MethodPost, "/subscribers/new", "/subscribers/new",
+			strings.NewReader(`{`+subscriber+`,"membership_expiry":"2025-01-31"}`))
+		if rec.Code != http.StatusCreated {
+			t.Errorf("status %d, want 201: %s", rec.Code, rec.Body)
+		}
+	})
+
+	t.Run("bulk", func(t *testing.T) {
+		db, mock := newMockDB(t)
+		mock.ExpectBegin()
+		mock.ExpectExec(sqlPattern("INSERT INTO subscribers (lastname, firstname, email, phone, membership_expiry)")).
+			WithArgs("Johnson", "Emma", "emma@example.com", nil, "2025-01-31").WillReturnResult(sqlmock.NewResult(7, 1))
+		mock.ExpectExec(sqlPattern("INSERT INTO subscribers (lastname, firstname, email, phone, membership_expiry)")).
+			WithArgs("Brown", "Liam", "liam@example.com", nil, nil).WillReturnResult(sqlmock.NewResult(8, 1))
+		mock.ExpectCommit()
+
+		rec := serveRoute(AddSubscribersBulk(db), http.MethodPost, "/subscribers/bulk", "/subscribers/bulk", strings.NewReader(`[
+			{`+subscriber+`,"membership_expiry":"2025-01-31"},
+			{"firstname":"Liam","lastname":"Brown","email":"liam@example.com"},
+			{"firstname":"Ava","lastname":"Smith","email":"ava@example.com","membership_expiry":"31/01/2025"}
+		]`))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status %d, want 200: %s", rec.Code, rec.Body)
+		}
+		var response struct {
+			Created int                    `json:"created"`
+			Results []BulkSubscriberResult `json:"results"`
+		}
+		decodeJSON(t, rec, &response)
+		if response.Created != 2 || response.Results[2].Error != errInvalidMembershipExpiry.Error() {
+			t.Errorf("got %+v, want the invalid date of the third subscriber reported", response)
+		}
+	})
+
+	t.Run("update", func(t *testing.T) {
+		db, mock := newMockDB(t)
+		mock.ExpectExec(sqlPattern("SET lastname = ?, firstname = ?, email = ?, phone = ?, membership_expiry = ?")).
+			WithArgs("Johnson", "Emma", "emma@example.com", nil, "2025-01-31", 1).WillReturnResult(sqlmock.NewResult(0, 1))
+		expectAudit(mock, "update", "subscriber", 1)
+
+		rec := serveRoute(UpdateSubscriber(db), http.MethodPut, "/subscribers/{id}", "/subscribers/1",
+			strings.NewReader(`{`+subscriber+`,"membership_expiry":"2025-01-31"}`))
+		if rec.Code != http.StatusOK {
+			t.Errorf("status %d, want 200: %s", rec.Code, rec.Body)
+		}
+	})
+
+	t.Run("update without expiry", func(t *testing.T) {
+		db, mock := newMockDB(t)
+		mock.ExpectExec(sqlPattern("UPDATE subscribers")).
+			WithArgs("Johnson", "Emma", "emma@example.com", nil, nil, 1).WillReturnResult(sqlmock.NewResult(0, 1))
+		expectAudit(mock, "update", "subscriber", 1)
+
+		rec := serveRoute(UpdateSubscriber(db), http.MethodPut, "/subscribers/{id}", "/subscribers/1", strings.NewReader(`{`+subscriber+`}`))
+		if rec.Code != http.StatusOK {
+			t.Errorf("status %d, want 200: %s", rec.Code, rec.Body)
+		}
+	})
+
+	for name, patch := range map[string]struct {
+		body string
+		want interface{}
+	}{
+		"patch":                     {`{"membership_expiry":"2025-01-31"}`, "2025-01-31"},
+		"patch clearing the expiry": {`{"membership_expiry":""}`, nil},
+	} {
+		t.Run(name, func(t *testing.T) {
+			db, mock := newMockDB(t)
+			mock.ExpectExec(sqlPattern("UPDATE subscribers SET membership_expiry = ? WHERE id = ?")).
+				WithArgs(patch.want, 1).WillReturnResult(sqlmock.NewResult(0, 1))
+			mock.ExpectQuery(sqlPattern("SELECT EXISTS(SELECT 1 FROM subscribers WHERE id = ?)")).WithArgs(1).
+				WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+			expectAudit(mock, "update", "subscriber", 1)
+
+			rec := serveRoute(PatchSubscriber(db), http.MethodPatch, "/subscribers/{id}", "/subscribers/1", strings.NewReader(patch.body))
+			if rec.Code != http.StatusOK {
+				t.Errorf("status %d, want 200: %s", rec.Code, rec.Body)
+			}
+		})
+	}
+
+	invalid := []struct {
+		name    string
+		handler http.HandlerFunc
+		method  string
+		body    string
+	}{
+		{"add", AddSubscriber(nil), http.MethodPost, `{` + subscriber + `,"membership_expiry":"31/01/2025"}`},
+		{"update", UpdateSubscriber(nil), http.MethodPut, `{` + subscriber + `,"membership_expiry":"31/01/2025"}`},
+		{"patch", PatchSubscriber(nil), http.MethodPatch, `{"membership_expiry":"31/01/2025"}`},
+	}
+	for _, tt := range invalid {
+		t.Run("invalid date on "+tt.name, func(t *testing.T) {
+			rec := serveRoute(tt.handler, tt.method, "/subscribers/{id}", "/subscribers/1", strings.NewReader(tt.body))
+			if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), errInvalidMembershipExpiry.Error()) {
+				t.Errorf("got %d %q, want 400 with the date format", rec.Code, rec.Body)
+			}
+		})
+	}
+}
+
+func TestGetExpiredMemberships(t *testing.T) {
+	db, mock := newMockDB(t)
+	mock.ExpectQuery(sqlPattern("SELECT COUNT(*) FROM subscribers WHERE membership_expiry < CURDATE()")).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	mock.ExpectQuery(sqlPattern("ORDER BY membership_expiry, id")).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "lastname", "firstname", "email", "phone", "membership_expiry"}).
+			AddRow(2, "Brown", "Sophia", "sophia@example.com", "", time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC)))
+
+	rec := serveRoute(GetExpiredMemberships(db), http.MethodGet, "/subscribers/expired-memberships", "/subscribers/expired-memberships", nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d, want 200: %s", rec.Code, rec.Body)
+	}
+	if !strings.Contains(rec.Body.String(), `"membership_expiry":"2024-01-15"`) || rec.Header().Get("X-Total-Count") != "1" {
+		t.Errorf("got %q", rec.Body)
+	}
+}
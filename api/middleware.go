@@ -0,0 +1,362 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// timeoutResponseWriter turns the 500 a handler writes after its request context expired into a 503.
+type timeoutResponseWriter struct {
+	http.ResponseWriter
+	ctx context.Context
+}
+
+func (tw *timeoutResponseWriter) WriteHeader(status int) {
+	if status == http.StatusInternalServerError && tw.ctx.Err() != nil {
+		status = http.StatusServiceUnavailable
+	}
+	tw.ResponseWriter.WriteHeader(status)
+}
+
+// TimeoutMiddleware bounds every request context by duration, so database calls made with
+// r.Context() are cancelled once it is exceeded and the client receives 503 Service Unavailable.
+func TimeoutMiddleware(duration time.Duration) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx, cancel := context.WithTimeout(r.Context(), duration)
+			defer cancel()
+
+			next.ServeHTTP(&timeoutResponseWriter{ResponseWriter: w, ctx: ctx}, r.WithContext(ctx))
+		})
+	}
+}
+
+// RecoveryMiddleware turns a panic in a handler into a logged stack trace and a 500 response
+// instead of a dropped connection.
+func RecoveryMiddleware() mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			defer func() {
+				if err := recover(); err != nil {
+					if err == http.ErrAbortHandler {
+						panic(err)
+					}
+					requestID := RequestIDFromContext(r.Context())
+					slog.Error("panic serving request", "request_id", requestID, "method", r.Method, "path", r.URL.Path,
+						"panic", fmt.Sprint(err), "stack", string(debug.Stack()))
+					RespondWithJSON(w, http.StatusInternalServerError, map[string]string{
+						"error":      "internal server error",
+						"request_id": requestID,
+					})
+				}
+			}()
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// requestIDKey is the context key of the request ID
+type requestIDKey struct{}
+
+// maxRequestIDLength bounds the X-Request-ID accepted from clients, longer ones are replaced
+const maxRequestIDLength = 128
+
+// RequestIDFromContext returns the ID RequestIDMiddleware gave the request, or "" outside of a request.
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// newRequestID generates a random UUID (version 4)
+func newRequestID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+// validRequestID reports whether a client supplied request ID can be reused: short and printable ASCII,
+// so it can't break a log line.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range id {
+		if c < 0x21 || c > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// RequestIDMiddleware gives every request an ID, the X-Request-ID it came with or a new UUID. The ID is
+// stored in the request context for the logs and sent back in the X-Request-ID response header.
+func RequestIDMiddleware() mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			id := r.Header.Get("X-Request-ID")
+			if !validRequestID(id) {
+				id = newRequestID()
+			}
+			w.Header().Set("X-Request-ID", id)
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id)))
+		})
+	}
+}
+
+// statusResponseWriter records the status and the number of bytes of a response for the access log.
+type statusResponseWriter struct {
+	http.ResponseWriter
+	status int
+	size   int
+}
+
+func (sw *statusResponseWriter) WriteHeader(status int) {
+	if sw.status == 0 {
+		sw.status = status
+	}
+	sw.ResponseWriter.WriteHeader(status)
+}
+
+func (sw *statusResponseWriter) Write(b []byte) (int, error) {
+	if sw.status == 0 {
+		sw.status = http.StatusOK
+	}
+	n, err := sw.ResponseWriter.Write(b)
+	sw.size += n
+	return n, err
+}
+
+// AccessLogMiddleware logs one line per request with its method, path, status, duration and response size,
+// and the time it was queued before reaching the server when the load balancer sets X-Request-Start.
+// It goes first so it also sees the 500 written by RecoveryMiddleware.
+func AccessLogMiddleware() mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			recorder := &statusResponseWriter{ResponseWriter: w}
+			next.ServeHTTP(recorder, r)
+
+			if recorder.status == 0 {
+				recorder.status = http.StatusOK
+			}
+			attrs := []interface{}{"request_id", RequestIDFromContext(r.Context()), "method", r.Method, "path", r.URL.Path,
+				"status", recorder.status, "duration", time.Since(start), "size", recorder.size}
+			if queued, ok := requestQueueTime(r.Header.Get("X-Request-Start"), start); ok {
+				attrs = append(attrs, "queue_ms", formatMilliseconds(queued))
+			}
+			slog.Info("request", attrs...)
+		})
+	}
+}
+
+// requestQueueTime parses an X-Request-Start header, a Unix time in milliseconds, optionally written t=<time>
+// and also accepted in seconds or microseconds as nginx and others send it, and returns how long before now it was.
+func requestQueueTime(header string, now time.Time) (time.Duration, bool) {
+	value, err := strconv.ParseFloat(strings.TrimPrefix(strings.TrimSpace(header), "t="), 64)
+	if err != nil || value <= 0 {
+		return 0, false
+	}
+	// Tell the units apart by magnitude: 1e12 milliseconds is in 2001, 1e12 seconds far in the future
+	switch {
+	case value >= 1e15:
+		value /= 1e6
+	case value >= 1e11:
+		value /= 1e3
+	}
+	queued := now.Sub(time.Unix(0, int64(value*float64(time.Second))))
+	if queued < 0 {
+		// Clocks of the load balancer and the server differ slightly
+		queued = 0
+	}
+	return queued, true
+}
+
+// formatMilliseconds writes d in milliseconds with two decimals, like 12.34
+func formatMilliseconds(d time.Duration) string {
+	return strconv.FormatFloat(float64(d)/float64(time.Millisecond), 'f', 2, 64)
+}
+
+// responseTimeWriter sets the X-Response-Time header when the status is written, the last moment headers can change.
+type responseTimeWriter struct {
+	http.ResponseWriter
+	start       time.Time
+	wroteHeader bool
+}
+
+func (rw *responseTimeWriter) WriteHeader(status int) {
+	if !rw.wroteHeader {
+		rw.wroteHeader = true
+		rw.Header().Set("X-Response-Time", formatMilliseconds(time.Since(rw.start)))
+	}
+	rw.ResponseWriter.WriteHeader(status)
+}
+
+func (rw *responseTimeWriter) Write(b []byte) (int, error) {
+	if !rw.wroteHeader {
+		rw.WriteHeader(http.StatusOK)
+	}
+	return rw.ResponseWriter.Write(b)
+}
+
+// ResponseTimeMiddleware sends the time the server spent on a request until it wrote the status in the
+// X-Response-Time header, in milliseconds. A streamed body may take longer than that to be sent.
+func ResponseTimeMiddleware() mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(&responseTimeWriter{ResponseWriter: w, start: time.Now()}, r)
+		})
+	}
+}
+
+// idempotencyKeyTTL is how long the response stored for an Idempotency-Key is replayed
+const idempotencyKeyTTL = 24 * time.Hour
+
+// recordingResponseWriter keeps a copy of the status and body written through it.
+type recordingResponseWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (rw *recordingResponseWriter) WriteHeader(status int) {
+	rw.status = status
+	rw.ResponseWriter.WriteHeader(status)
+}
+
+func (rw *recordingResponseWriter) Write(b []byte) (int, error) {
+	if rw.status == 0 {
+		rw.status = http.StatusOK
+	}
+	rw.body.Write(b)
+	return rw.ResponseWriter.Write(b)
+}
+
+// idempotencyPending is the response_status of a key whose first request is still being handled
+const idempotencyPending = 0
+
+// IdempotencyMiddleware makes retried requests carrying the same Idempotency-Key header safe: the first
+// successful response is stored in idempotency_keys and replayed for 24 hours instead of running the handler again.
+// The key is stored as pending before the handler runs, so a retry sent while the first request is still
+// handled is answered with 409 instead of running it twice. Failed responses are not stored, so the request
+// can be retried. Requests without the header pass through.
+func IdempotencyMiddleware(db *sql.DB) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := r.Header.Get("Idempotency-Key")
+			if key == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if len(key) > 255 {
+				http.Error(w, "Idempotency-Key must be at most 255 characters", http.StatusBadRequest)
+				return
+			}
+
+			claimed, err := claimIdempotencyKey(r.Context(), db, key, r.URL.Path)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			if !claimed {
+				replayIdempotentResponse(db, w, r, key)
+				return
+			}
+
+			// The key is released when the handler fails or panics. The request may have timed out by then.
+			ctx := context.WithoutCancel(r.Context())
+			stored := false
+			defer func() {
+				if stored {
+					return
+				}
+				_, err := db.ExecContext(ctx, "DELETE FROM idempotency_keys WHERE `key` = ? AND response_status = ?", key, idempotencyPending)
+				if err != nil {
+					slog.Error("releasing idempotency key failed", "key", key, "error", err)
+				}
+			}()
+
+			recorder := &recordingResponseWriter{ResponseWriter: w}
+			next.ServeHTTP(recorder, r)
+			if recorder.status < 200 || recorder.status > 299 {
+				return
+			}
+
+			_, err = db.ExecContext(ctx, `
+				UPDATE idempotency_keys SET response_status = ?, response_body = ? WHERE `+"`key`"+` = ?
+			`, recorder.status, recorder.body.String(), key)
+			if err != nil {
+				slog.Error("storing idempotency key failed", "key", key, "error", err)
+				return
+			}
+			stored = true
+		})
+	}
+}
+
+// claimIdempotencyKey stores key as pending for a request to path. It returns false when key is already
+// stored, unless its entry has expired: the expired entry is then taken over by a single request.
+func claimIdempotencyKey(ctx context.Context, db *sql.DB, key, path string) (bool, error) {
+	_, err := db.ExecContext(ctx, `
+		INSERT INTO idempotency_keys (`+"`key`"+`, user_id, request_path, response_status, response_body, created_at)
+		VALUES (?, NULL, ?, ?, '', NOW())
+	`, key, path, idempotencyPending)
+	if err == nil {
+		return true, nil
+	}
+	if !isDuplicateEntry(err) {
+		return false, err
+	}
+
+	result, err := db.ExecContext(ctx, `
+		UPDATE idempotency_keys SET request_path = ?, response_status = ?, response_body = '', created_at = NOW()
+		WHERE `+"`key`"+` = ? AND created_at <= NOW() - INTERVAL ? SECOND
+	`, path, idempotencyPending, key, int(idempotencyKeyTTL.Seconds()))
+	if err != nil {
+		return false, err
+	}
+	taken, err := result.RowsAffected()
+	return taken == 1, err
+}
+
+// replayIdempotentResponse answers a request whose key is already stored with the stored response, or
+// with 409 while the first request with the key is still handled
+func replayIdempotentResponse(db *sql.DB, w http.ResponseWriter, r *http.Request, key string) {
+	var path, body string
+	var status int
+	err := db.QueryRowContext(r.Context(), `
+		SELECT request_path, response_status, response_body FROM idempotency_keys WHERE `+"`key`"+` = ?
+	`, key).Scan(&path, &status, &body)
+	if err != nil && err != sql.ErrNoRows {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	switch {
+	case err == nil && path != r.URL.Path:
+		http.Error(w, "Idempotency-Key was already used for another request", http.StatusUnprocessableEntity)
+	case err == sql.ErrNoRows || status == idempotencyPending:
+		// The entry is gone when the first request failed in the meantime, the retry may be sent again
+		http.Error(w, "A request with this Idempotency-Key is still being processed", http.StatusConflict)
+	default:
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Idempotent-Replayed", "true")
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	}
+}
@@ -0,0 +1,519 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-sql-driver/mysql"
+	"github.com/gorilla/mux"
+)
+
+// serveWithMiddleware serves req with handler registered at pattern behind middleware
+func serveWithMiddleware(middleware mux.MiddlewareFunc, handler http.Handler, pattern string, req *http.Request) *httptest.ResponseRecorder {
+	r := mux.NewRouter()
+	r.Use(middleware)
+	r.Handle(pattern, handler)
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestTimeoutMiddlewareCancelledContext(t *testing.T) {
+	db, _ := newMockDB(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	req := httptest.NewRequest(http.MethodGet, "/subscribers/1", nil).WithContext(ctx)
+
+	rec := serveWithMiddleware(TimeoutMiddleware(time.Minute), GetSubscriberByID(db), "/subscribers/{id}", req)
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("status %d, want 503", rec.Code)
+	}
+}
+
+func TestTimeoutMiddlewareSlowQuery(t *testing.T) {
+	db, mock := newMockDB(t)
+	mock.ExpectQuery(sqlPattern("FROM subscribers WHERE id = ?")).WithArgs(1).WillDelayFor(time.Second).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	req := httptest.NewRequest(http.MethodGet, "/subscribers/1", nil)
+
+	start := time.Now()
+	rec := serveWithMiddleware(TimeoutMiddleware(20*time.Millisecond), GetSubscriberByID(db), "/subscribers/{id}", req)
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("status %d, want 503", rec.Code)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("the query wasn't cancelled, the request took %s", elapsed)
+	}
+}
+
+func TestTimeoutMiddlewareKeepsOtherErrors(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "failed", http.StatusInternalServerError)
+	})
+
+	rec := serveWithMiddleware(TimeoutMiddleware(time.Minute), handler, "/", httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("status %d, want 500", rec.Code)
+	}
+}
+
+// countingHandler answers 201 with a body and counts its calls
+type countingHandler struct {
+	calls int
+}
+
+func (h *countingHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.calls++
+	RespondWithJSON(w, http.StatusCreated, map[string]int{"id": 12})
+}
+
+func idempotentRequest(key string) *http.Request {
+	req := httptest.NewRequest(http.MethodPost, "/books/new", nil)
+	req.Header.Set("Idempotency-Key", key)
+	return req
+}
+
+// expectIdempotencyClaim expects the insert of the pending entry of key, failing when the key is stored
+func expectIdempotencyClaim(mock sqlmock.Sqlmock, key string, stored bool) {
+	insert := mock.ExpectExec(sqlPattern("INSERT INTO idempotency_keys")).WithArgs(key, "/books/new", idempotencyPending)
+	if stored {
+		insert.WillReturnError(&mysql.MySQLError{Number: mysqlErrDuplicateEntry, Message: "Duplicate entry '" + key + "' for key 'PRIMARY'"})
+	} else {
+		insert.WillReturnResult(sqlmock.NewResult(0, 1))
+	}
+}
+
+// expectExpiredTakeover expects the takeover of the entry of key when it has expired
+func expectExpiredTakeover(mock sqlmock.Sqlmock, key string, expired bool) {
+	taken := int64(0)
+	if expired {
+		taken = 1
+	}
+	mock.ExpectExec(sqlPattern("UPDATE idempotency_keys SET request_path = ?")).
+		WithArgs("/books/new", idempotencyPending, key, int(idempotencyKeyTTL.Seconds())).WillReturnResult(sqlmock.NewResult(0, taken))
+}
+
+func expectIdempotentResponse(mock sqlmock.Sqlmock, key string) {
+	mock.ExpectExec(sqlPattern("UPDATE idempotency_keys SET response_status = ?, response_body = ?")).
+		WithArgs(http.StatusCreated, "{\"id\":12}\n", key).WillReturnResult(sqlmock.NewResult(0, 1))
+}
+
+func TestIdempotencyMiddleware(t *testing.T) {
+	lookup := sqlPattern("SELECT request_path, response_status, response_body FROM idempotency_keys")
+	columns := []string{"request_path", "response_status", "response_body"}
+
+	t.Run("first call", func(t *testing.T) {
+		db, mock := newMockDB(t)
+		expectIdempotencyClaim(mock, "key-1", false)
+		expectIdempotentResponse(mock, "key-1")
+		handler := &countingHandler{}
+
+		rec := serveWithMiddleware(IdempotencyMiddleware(db), handler, "/books/new", idempotentRequest("key-1"))
+		if rec.Code != http.StatusCreated || handler.calls != 1 {
+			t.Errorf("status %d after %d calls, want 201 after 1", rec.Code, handler.calls)
+		}
+		if rec.Header().Get("Idempotent-Replayed") != "" {
+			t.Error("the first response is marked as replayed")
+		}
+	})
+
+	t.Run("replayed call", func(t *testing.T) {
+		db, mock := newMockDB(t)
+		expectIdempotencyClaim(mock, "key-1", true)
+		expectExpiredTakeover(mock, "key-1", false)
+		mock.ExpectQuery(lookup).WithArgs("key-1").
+			WillReturnRows(sqlmock.NewRows(columns).AddRow("/books/new", http.StatusCreated, `{"id":12}`))
+		handler := &countingHandler{}
+
+		rec := serveWithMiddleware(IdempotencyMiddleware(db), handler, "/books/new", idempotentRequest("key-1"))
+		if handler.calls != 0 {
+			t.Error("the handler ran again for a replayed key")
+		}
+		if rec.Code != http.StatusCreated || rec.Body.String() != `{"id":12}` || rec.Header().Get("Idempotent-Replayed") != "true" {
+			t.Errorf("got %d %q %v", rec.Code, rec.Body, rec.Header())
+		}
+	})
+
+	t.Run("expired key", func(t *testing.T) {
+		// An entry older than the TTL is taken over, so the request runs again and replaces it
+		db, mock := newMockDB(t)
+		expectIdempotencyClaim(mock, "key-old", true)
+		expectExpiredTakeover(mock, "key-old", true)
+		expectIdempotentResponse(mock, "key-old")
+		handler := &countingHandler{}
+
+		rec := serveWithMiddleware(IdempotencyMiddleware(db), handler, "/books/new", idempotentRequest("key-old"))
+		if rec.Code != http.StatusCreated || handler.calls != 1 {
+			t.Errorf("status %d after %d calls, want 201 after 1", rec.Code, handler.calls)
+		}
+	})
+
+	t.Run("key reused for another path", func(t *testing.T) {
+		db, mock := newMockDB(t)
+		expectIdempotencyClaim(mock, "key-1", true)
+		expectExpiredTakeover(mock, "key-1", false)
+		mock.ExpectQuery(lookup).WithArgs("key-1").
+			WillReturnRows(sqlmock.NewRows(columns).AddRow("/authors/new", http.StatusCreated, `{"id":3}`))
+		handler := &countingHandler{}
+
+		rec := serveWithMiddleware(IdempotencyMiddleware(db), handler, "/books/new", idempotentRequest("key-1"))
+		if rec.Code != http.StatusUnprocessableEntity || handler.calls != 0 {
+			t.Errorf("status %d after %d calls, want 422 after 0", rec.Code, handler.calls)
+		}
+	})
+
+	t.Run("failed call", func(t *testing.T) {
+		// The key is released so that the request can be retried
+		db, mock := newMockDB(t)
+		expectIdempotencyClaim(mock, "key-1", false)
+		mock.ExpectExec(sqlPattern("DELETE FROM idempotency_keys")).WithArgs("key-1", idempotencyPending).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		failing := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "failed", http.StatusInternalServerError)
+		})
+
+		rec := serveWithMiddleware(IdempotencyMiddleware(db), failing, "/books/new", idempotentRequest("key-1"))
+		if rec.Code != http.StatusInternalServerError {
+			t.Errorf("status %d, want 500", rec.Code)
+		}
+	})
+
+	t.Run("without key", func(t *testing.T) {
+		db, _ := newMockDB(t)
+		handler := &countingHandler{}
+
+		rec := serveWithMiddleware(IdempotencyMiddleware(db), handler, "/books/new", httptest.NewRequest(http.MethodPost, "/books/new", nil))
+		if rec.Code != http.StatusCreated || handler.calls != 1 {
+			t.Errorf("status %d after %d calls, want 201 after 1", rec.Code, handler.calls)
+		}
+	})
+}
+
+// TestIdempotencyMiddlewareConcurrentRetry sends a retry while the first request with the key is still
+// handled: the retry is answered with 409 and the handler runs once
+func TestIdempotencyMiddlewareConcurrentRetry(t *testing.T) {
+	db, mock := newMockDB(t)
+	expectIdempotencyClaim(mock, "key-1", false)
+	expectIdempotencyClaim(mock, "key-1", true)
+	expectExpiredTakeover(mock, "key-1", false)
+	mock.ExpectQuery(sqlPattern("SELECT request_path, response_status, response_body FROM idempotency_keys")).WithArgs("key-1").
+		WillReturnRows(sqlmock.NewRows([]string{"request_path", "response_status", "response_body"}).AddRow("/books/new", idempotencyPending, ""))
+	expectIdempotentResponse(mock, "key-1")
+
+	started, release := make(chan struct{}), make(chan struct{})
+	var calls atomic.Int32
+	slow := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		close(started)
+		<-release
+		RespondWithJSON(w, http.StatusCreated, map[string]int{"id": 12})
+	})
+	middleware := IdempotencyMiddleware(db)
+
+	first := make(chan *httptest.ResponseRecorder)
+	go func() {
+		first <- serveWithMiddleware(middleware, slow, "/books/new", idempotentRequest("key-1"))
+	}()
+	<-started
+	retry := serveWithMiddleware(middleware, slow, "/books/new", idempotentRequest("key-1"))
+	close(release)
+
+	if retry.Code != http.StatusConflict {
+		t.Errorf("retry status %d, want 409: %s", retry.Code, retry.Body)
+	}
+	if rec := <-first; rec.Code != http.StatusCreated {
+		t.Errorf("first status %d, want 201", rec.Code)
+	}
+	if calls.Load() != 1 {
+		t.Errorf("the handler ran %d times, want once", calls.Load())
+	}
+}
+
+func TestRecoveryMiddleware(t *testing.T) {
+	discardLogs(t)
+	panicking := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var subscriber *Subscriber
+		w.Write([]byte(subscriber.Email))
+	})
+
+	// Served for real, a dropped connection would show as a client error
+	r := mux.NewRouter()
+	r.Use(RequestIDMiddleware())
+	r.Use(RecoveryMiddleware())
+	r.Handle("/panic", panicking)
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/panic")
+	if err != nil {
+		t.Fatalf("the connection was dropped: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusInternalServerError {
+		t.Errorf("status %d, want 500", resp.StatusCode)
+	}
+	var body map[string]string
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	if body["error"] != "internal server error" || body["request_id"] != resp.Header.Get("X-Request-ID") {
+		t.Errorf("body %v", body)
+	}
+}
+
+func TestResponseTimeMiddleware(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+	}{
+		{"status written", func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusNoContent) }},
+		{"body only", func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("ok")) }},
+		{"slow handler", func(w http.ResponseWriter, r *http.Request) {
+			time.Sleep(2 * time.Millisecond)
+			RespondWithJSON(w, http.StatusOK, map[string]string{})
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serveWithMiddleware(ResponseTimeMiddleware(), tt.handler, "/", httptest.NewRequest(http.MethodGet, "/", nil))
+			header := rec.Header().Get("X-Response-Time")
+			if matched, _ := regexp.MatchString(`^[0-9]+\.[0-9]{2}$`, header); !matched {
+				t.Errorf("X-Response-Time %q, want milliseconds with two decimals", header)
+			}
+		})
+	}
+}
+
+func TestRequestQueueTime(t *testing.T) {
+	now := time.UnixMilli(1700000000500)
+	tests := []struct {
+		header string
+		want   time.Duration
+		ok     bool
+	}{
+		{"1700000000000", 500 * time.Millisecond, true},
+		{"t=1700000000000", 500 * time.Millisecond, true},
+		{"1700000000.25", 250 * time.Millisecond, true},
+		{"1700000000400000", 100 * time.Millisecond, true},
+		{"1700000001000", 0, true}, // the clock of the load balancer is ahead
+		{"", 0, false},
+		{"yesterday", 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := requestQueueTime(tt.header, now)
+		if ok != tt.ok || got.Round(time.Millisecond) != tt.want {
+			t.Errorf("%q: got %s, %v, want %s, %v", tt.header, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestAccessLogQueueTime(t *testing.T) {
+	logs := captureLogs(t, slog.LevelInfo)
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("X-Request-Start", "t="+strconv.FormatInt(time.Now().Add(-30*time.Millisecond).UnixMilli(), 10))
+	serveWithMiddleware(AccessLogMiddleware(), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), "/", req)
+
+	var line struct {
+		QueueMS string `json:"queue_ms"`
+	}
+	if err := json.Unmarshal(logs.Bytes(), &line); err != nil {
+		t.Fatalf("decoding the log line %q: %v", logs.String(), err)
+	}
+	queued, err := strconv.ParseFloat(line.QueueMS, 64)
+	if matched, _ := regexp.MatchString(`^[0-9]+\.[0-9]{2}$`, line.QueueMS); !matched || err != nil || queued < 30 {
+		t.Errorf("queue_ms %q, want at least 30.00", line.QueueMS)
+	}
+}
+
+// logLines decodes the JSON lines written to logs
+func logLines(t *testing.T, logs *bytes.Buffer) []map[string]interface{} {
+	t.Helper()
+	var lines []map[string]interface{}
+	decoder := json.NewDecoder(logs)
+	for decoder.More() {
+		var line map[string]interface{}
+		if err := decoder.Decode(&line); err != nil {
+			t.Fatalf("decoding the log lines: %v", err)
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+func TestAccessLogMiddleware(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		status  float64
+		size    float64
+	}{
+		{"status and body", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte("created"))
+		}, http.StatusCreated, 7},
+		{"body only", func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("ok")) }, http.StatusOK, 2},
+		{"nothing written", func(w http.ResponseWriter, r *http.Request) {}, http.StatusOK, 0},
+		{"error", func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "Book not found", http.StatusNotFound)
+		}, http.StatusNotFound, float64(len("Book not found\n"))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logs := captureLogs(t, slog.LevelInfo)
+
+			serveWithMiddleware(AccessLogMiddleware(), tt.handler, "/books/{id}", httptest.NewRequest(http.MethodPut, "/books/3", nil))
+			lines := logLines(t, logs)
+			if len(lines) != 1 {
+				t.Fatalf("%d log lines, want 1: %s", len(lines), logs)
+			}
+			line := lines[0]
+			if line["msg"] != "request" || line["method"] != http.MethodPut || line["path"] != "/books/3" ||
+				line["status"] != tt.status || line["size"] != tt.size {
+				t.Errorf("log line %v, want status %v and size %v", line, tt.status, tt.size)
+			}
+			if _, ok := line["duration"].(float64); !ok {
+				t.Errorf("no duration in %v", line)
+			}
+		})
+	}
+}
+
+func TestRecoveryMiddlewareLogs(t *testing.T) {
+	logs := captureLogs(t, slog.LevelInfo)
+	panicking := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("deliberate panic")
+	})
+
+	r := mux.NewRouter()
+	r.Use(AccessLogMiddleware())
+	r.Use(RecoveryMiddleware())
+	r.Handle("/panic", panicking)
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/panic", nil))
+
+	if rec.Code != http.StatusInternalServerError || rec.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("status %d with Content-Type %q, want a 500 JSON error", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	lines := logLines(t, logs)
+	if len(lines) != 2 {
+		t.Fatalf("%d log lines, want the panic and the request: %s", len(lines), logs)
+	}
+	if stack, _ := lines[0]["stack"].(string); lines[0]["panic"] != "deliberate panic" || !strings.Contains(stack, "TestRecoveryMiddlewareLogs") {
+		t.Errorf("panic log line %v, want the panic and its stack", lines[0])
+	}
+	if lines[1]["msg"] != "request" || lines[1]["status"] != float64(http.StatusInternalServerError) {
+		t.Errorf("access log line %v, want status 500", lines[1])
+	}
+}
+
+// The middlewares registered before RecoveryMiddleware see the 500 of a panic as any other response
+func TestRouterRecoversPanics(t *testing.T) {
+	r := newTestRouter(t, nil)
+	logs := captureLogs(t, slog.LevelInfo)
+	r.Handle("/panic", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("deliberate panic")
+	}))
+	req := httptest.NewRequest(http.MethodGet, "/panic", nil)
+	req.Header.Set("X-Request-ID", "support-42")
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusInternalServerError || rec.Header().Get("X-Response-Time") == "" {
+		t.Errorf("status %d with X-Response-Time %q, want a timed 500", rec.Code, rec.Header().Get("X-Response-Time"))
+	}
+	var body map[string]string
+	decodeJSON(t, rec, &body)
+	if body["request_id"] != "support-42" {
+		t.Errorf("error body %v, want the request ID", body)
+	}
+	lines := logLines(t, logs)
+	if len(lines) != 2 || lines[1]["msg"] != "request" || lines[1]["status"] != float64(http.StatusInternalServerError) {
+		t.Errorf("log lines %v, want the panic and the access log of the 500", lines)
+	}
+}
+
+func TestRequestIDMiddleware(t *testing.T) {
+	uuid := regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+	tests := []struct {
+		name     string
+		supplied string
+		reused   bool
+	}{
+		{name: "generated"},
+		{name: "supplied", supplied: "lb-7f3a9c", reused: true},
+		{name: "with spaces", supplied: "a b"},
+		{name: "too long", supplied: strings.Repeat("a", maxRequestIDLength+1)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var fromContext string
+			handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				fromContext = RequestIDFromContext(r.Context())
+			})
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.supplied != "" {
+				req.Header.Set("X-Request-ID", tt.supplied)
+			}
+
+			rec := serveWithMiddleware(RequestIDMiddleware(), handler, "/", req)
+			id := rec.Header().Get("X-Request-ID")
+			if id != fromContext {
+				t.Errorf("the response header %q isn't the ID of the context %q", id, fromContext)
+			}
+			if tt.reused && id != tt.supplied {
+				t.Errorf("ID %q, want the supplied %q", id, tt.supplied)
+			}
+			if !tt.reused && !uuid.MatchString(id) {
+				t.Errorf("ID %q, want a new UUID", id)
+			}
+		})
+	}
+
+	t.Run("unique", func(t *testing.T) {
+		if a, b := newRequestID(), newRequestID(); a == b {
+			t.Errorf("two requests got the ID %q", a)
+		}
+	})
+}
+
+func TestRequestIDInLogsAndErrors(t *testing.T) {
+	logs := captureLogs(t, slog.LevelInfo)
+	r := mux.NewRouter()
+	r.Use(RequestIDMiddleware())
+	r.Use(AccessLogMiddleware())
+	r.Use(RecoveryMiddleware())
+	r.Handle("/panic", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("deliberate panic")
+	}))
+	req := httptest.NewRequest(http.MethodGet, "/panic", nil)
+	req.Header.Set("X-Request-ID", "support-42")
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+
+	var body map[string]string
+	decodeJSON(t, rec, &body)
+	if body["request_id"] != "support-42" {
+		t.Errorf("error body %v, want the request ID", body)
+	}
+	lines := logLines(t, logs)
+	if len(lines) != 2 {
+		t.Fatalf("%d log lines, want 2: %s", len(lines), logs)
+	}
+	for _, line := range lines {
+		if line["request_id"] != "support-42" {
+			t.Errorf("log line %v doesn't carry the request ID", line)
+		}
+	}
+}
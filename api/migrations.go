@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"sort"
+	"strings"
+)
+
+// baselineMigration is the migration holding the schema of the databases created from schema.sql before
+// migrations existed. It is recorded without being run on such a database.
+const baselineMigration = "0001_initial_schema.sql"
+
+// RunMigrations applies the *.sql files of dir that haven't been applied yet, in filename order, and records
+// them in schema_migrations. See runMigrations.
+func RunMigrations(db *sql.DB, dir string) error {
+	return runMigrations(context.Background(), db, os.DirFS(dir))
+}
+
+// runMigrations applies the *.sql files of migrations that are not recorded in schema_migrations. Each file
+// runs in a transaction together with its record. MySQL commits DDL statements implicitly though, so a
+// migration that fails halfway may leave its earlier statements applied: keep one change per file.
+func runMigrations(ctx context.Context, db *sql.DB, migrations fs.FS) error {
+	_, err := db.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version VARCHAR(255) PRIMARY KEY,
+			applied_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create schema_migrations: %w", err)
+	}
+
+	files, err := fs.Glob(migrations, "*.sql")
+	if err != nil {
+		return err
+	}
+	sort.Strings(files)
+
+	applied, err := appliedMigrations(ctx, db)
+	if err != nil {
+		return err
+	}
+	if len(applied) == 0 {
+		if err := recordBaseline(ctx, db, applied); err != nil {
+			return err
+		}
+	}
+
+	for _, file := range files {
+		if applied[file] {
+			continue
+		}
+		script, err := fs.ReadFile(migrations, file)
+		if err != nil {
+			return fmt.Errorf("migration %s: %w", file, err)
+		}
+		if err := applyMigration(ctx, db, file, string(script)); err != nil {
+			return fmt.Errorf("migration %s: %w", file, err)
+		}
+		slog.Info("applied migration", "version", file)
+	}
+	return nil
+}
+
+// appliedMigrations returns the versions recorded in schema_migrations
+func appliedMigrations(ctx context.Context, db *sql.DB) (map[string]bool, error) {
+	rows, err := db.QueryContext(ctx, "SELECT version FROM schema_migrations")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read schema_migrations: %w", err)
+	}
+	defer rows.Close()
+
+	applied := make(map[string]bool)
+	for rows.Next() {
+		var version string
+		if err := rows.Scan(&version); err != nil {
+			return nil, err
+		}
+		applied[version] = true
+	}
+	return applied, rows.Err()
+}
+
+// recordBaseline marks the initial schema as applied on a database created from schema.sql, whose tables
+// already exist, instead of creating them a second time.
+func recordBaseline(ctx context.Context, db *sql.DB, applied map[string]bool) error {
+	var tables int
+	err := db.QueryRowContext(ctx, `
+		SELECT COUNT(*) FROM information_schema.tables WHERE table_schema = DATABASE() AND table_name = 'authors'
+	`).Scan(&tables)
+	if err != nil {
+		return err
+	}
+	if tables == 0 {
+		return nil
+	}
+
+	if _, err := db.ExecContext(ctx, "INSERT INTO schema_migrations (version) VALUES (?)", baselineMigration); err != nil {
+		return fmt.Errorf("failed to record the baseline migration: %w", err)
+	}
+	applied[baselineMigration] = true
+	slog.Info("recorded the existing schema as migrated", "version", baselineMigration)
+	return nil
+}
+
+// applyMigration runs the statements of script and records version in a transaction
+func applyMigration(ctx context.Context, db *sql.DB, version, script string) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, statement := range splitSQLStatements(script) {
+		if _, err := tx.ExecContext(ctx, statement); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.ExecContext(ctx, "INSERT INTO schema_migrations (version) VALUES (?)", version); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// splitSQLStatements splits a script on the semicolons that end its statements, those outside of quotes
+// and comments. The driver runs a single statement per call.
+func splitSQLStatements(script string) []string {
+	var statements []string
+	var current strings.Builder
+	var quote byte
+	for i := 0; i < len(script); i++ {
+		c := script[i]
+		switch {
+		case quote != 0:
+			if c == '\\' && quote != '`' && i+1 < len(script) {
+				current.WriteByte(c)
+				i++
+				c = script[i]
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"' || c == '`':
+			quote = c
+		case c == '-' && strings.HasPrefix(script[i:], "-- "), c == '#':
+			// Skip the comment up to the end of its line
+			end := strings.IndexByte(script[i:], '\n')
+			if end < 0 {
+				end = len(script) - i
+			}
+			i += end - 1
+			continue
+		case c == ';':
+			if statement := strings.TrimSpace(current.String()); statement != "" {
+				statements = append(statements, statement)
+			}
+			current.Reset()
+			continue
+		}
+		current.WriteByte(c)
+	}
+	if statement := strings.TrimSpace(current.String()); statement != "" {
+		statements = append(statements, statement)
+	}
+	return statements
+}
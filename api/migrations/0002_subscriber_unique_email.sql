@@ -0,0 +1,3 @@
+-- One subscriber per email, compared case-insensitively by the collation of the column
+
+ALTER TABLE `subscribers` ADD UNIQUE KEY `uq_subscribers_email` (`Email`);
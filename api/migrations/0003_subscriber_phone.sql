@@ -0,0 +1,4 @@
+-- Optional phone number of a subscriber, unique when set
+
+ALTER TABLE `subscribers` ADD COLUMN `phone` VARCHAR(20) AFTER `Email`;
+ALTER TABLE `subscribers` ADD UNIQUE KEY `uq_subscribers_phone` (`phone`);
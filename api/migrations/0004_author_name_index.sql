@@ -0,0 +1,3 @@
+-- Index for the lookup of the duplicate authors by name
+
+CREATE INDEX `idx_authors_name` ON `authors` (`Lastname`, `Firstname`);
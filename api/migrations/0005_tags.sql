@@ -0,0 +1,15 @@
+-- Tags of the books
+
+CREATE TABLE `tags` (
+  `id` INTEGER AUTO_INCREMENT PRIMARY KEY,
+  `name` VARCHAR(100) NOT NULL UNIQUE
+);
+
+CREATE TABLE `book_tags` (
+  `book_id` INTEGER NOT NULL,
+  `tag_id` INTEGER NOT NULL,
+  PRIMARY KEY (`book_id`, `tag_id`)
+);
+
+ALTER TABLE `book_tags` ADD FOREIGN KEY (`book_id`) REFERENCES `books` (`id`);
+ALTER TABLE `book_tags` ADD FOREIGN KEY (`tag_id`) REFERENCES `tags` (`id`);
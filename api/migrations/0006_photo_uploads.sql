@@ -0,0 +1,11 @@
+-- Log of the photos uploaded for the authors and the books
+
+CREATE TABLE `photo_uploads` (
+  `id` INTEGER AUTO_INCREMENT PRIMARY KEY,
+  `entity_type` VARCHAR(20) NOT NULL COMMENT 'authors or books',
+  `entity_id` INTEGER NOT NULL,
+  `file_path` VARCHAR(255) NOT NULL,
+  `uploaded_by_user_id` INTEGER NULL,
+  `uploaded_at` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  KEY `idx_photo_uploads_entity` (`entity_type`, `entity_id`)
+);
@@ -0,0 +1,11 @@
+-- Responses of the requests sent with an Idempotency-Key, replayed to their retries. A response_status of 0
+-- marks a key whose first request is still being handled.
+
+CREATE TABLE `idempotency_keys` (
+  `key` VARCHAR(255) PRIMARY KEY,
+  `user_id` INTEGER NULL,
+  `request_path` VARCHAR(255) NOT NULL,
+  `response_status` SMALLINT NOT NULL,
+  `response_body` MEDIUMTEXT NOT NULL,
+  `created_at` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
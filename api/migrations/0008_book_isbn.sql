@@ -0,0 +1,4 @@
+-- ISBN of a book, unique when set
+
+ALTER TABLE `books` ADD COLUMN `isbn` VARCHAR(13) NULL COMMENT 'ISBN-10 or ISBN-13 without hyphens';
+ALTER TABLE `books` ADD UNIQUE KEY `uq_books_isbn` (`isbn`);
@@ -0,0 +1,15 @@
+-- Genres grouping the books
+
+CREATE TABLE `genres` (
+  `id` INTEGER AUTO_INCREMENT PRIMARY KEY,
+  `name` VARCHAR(100) NOT NULL UNIQUE
+);
+
+CREATE TABLE `book_genres` (
+  `book_id` INTEGER NOT NULL,
+  `genre_id` INTEGER NOT NULL,
+  PRIMARY KEY (`book_id`, `genre_id`)
+);
+
+ALTER TABLE `book_genres` ADD FOREIGN KEY (`book_id`) REFERENCES `books` (`id`);
+ALTER TABLE `book_genres` ADD FOREIGN KEY (`genre_id`) REFERENCES `genres` (`id`);
@@ -0,0 +1,15 @@
+-- Reviews and ratings of the books by the subscribers, one per subscriber and book
+
+CREATE TABLE `reviews` (
+  `id` INTEGER AUTO_INCREMENT PRIMARY KEY,
+  `subscriber_id` INTEGER NOT NULL,
+  `book_id` INTEGER NOT NULL,
+  `rating` TINYINT NOT NULL,
+  `comment` VARCHAR(1000) NOT NULL DEFAULT '',
+  `created_at` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  UNIQUE KEY `uq_reviews_subscriber_book` (`subscriber_id`, `book_id`),
+  KEY `idx_reviews_book` (`book_id`)
+);
+
+ALTER TABLE `reviews` ADD FOREIGN KEY (`subscriber_id`) REFERENCES `subscribers` (`id`);
+ALTER TABLE `reviews` ADD FOREIGN KEY (`book_id`) REFERENCES `books` (`id`);
@@ -0,0 +1,3 @@
+-- Generated ids for the links between the books and their authors, AddBook inserts one per author
+
+ALTER TABLE `authors_books` MODIFY `id` INTEGER AUTO_INCREMENT;
@@ -0,0 +1,3 @@
+-- Date by which a borrowed book is to be returned, NULL for the loans made before due dates existed
+
+ALTER TABLE `borrowed_books` ADD COLUMN `due_date` TIMESTAMP NULL AFTER `date_of_borrow`;
@@ -0,0 +1,3 @@
+-- A book is linked to an author once at most
+
+ALTER TABLE `authors_books` ADD UNIQUE KEY `uq_authors_books` (`author_id`, `book_id`);
@@ -0,0 +1,12 @@
+-- Log of the write operations on the authors, books and subscribers
+
+CREATE TABLE `audit_log` (
+  `id` INTEGER AUTO_INCREMENT PRIMARY KEY,
+  `created_at` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  `user_id` INTEGER NULL,
+  `action` VARCHAR(20) NOT NULL COMMENT 'create, update, delete, borrow or return',
+  `entity_type` VARCHAR(20) NOT NULL COMMENT 'author, book or subscriber',
+  `entity_id` INTEGER NOT NULL,
+  `details` JSON NULL,
+  KEY `idx_audit_log_entity` (`entity_type`, `entity_id`)
+);
@@ -0,0 +1,4 @@
+-- Publisher of a book, empty when unknown
+
+ALTER TABLE `books` ADD COLUMN `publisher` VARCHAR(255) NOT NULL DEFAULT '';
+CREATE INDEX `idx_books_publisher` ON `books` (`publisher`);
@@ -0,0 +1,4 @@
+-- Format of a book: hardcover, paperback, ebook, audiobook or empty when unspecified
+
+ALTER TABLE `books` ADD COLUMN `format` VARCHAR(20) NOT NULL DEFAULT '' AFTER `publisher`;
+CREATE INDEX `idx_books_format` ON `books` (`format`);
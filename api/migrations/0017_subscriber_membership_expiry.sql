@@ -0,0 +1,3 @@
+-- Last day of the membership of a subscriber, NULL for a membership that doesn't expire
+
+ALTER TABLE `subscribers` ADD COLUMN `membership_expiry` DATE NULL;
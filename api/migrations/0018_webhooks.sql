@@ -0,0 +1,22 @@
+-- Webhooks notified of the borrow and return events, and the log of their delivery attempts
+
+CREATE TABLE `webhooks` (
+  `id` INTEGER AUTO_INCREMENT PRIMARY KEY,
+  `url` VARCHAR(2048) NOT NULL,
+  `secret` VARCHAR(255) NOT NULL,
+  `events` VARCHAR(255) NOT NULL COMMENT 'comma-separated, book.borrowed or book.returned',
+  `created_at` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE `webhook_deliveries` (
+  `id` INTEGER AUTO_INCREMENT PRIMARY KEY,
+  `webhook_id` INTEGER NOT NULL,
+  `event` VARCHAR(50) NOT NULL,
+  `attempt` TINYINT NOT NULL,
+  `status_code` SMALLINT NULL COMMENT 'NULL when no response was received',
+  `error` VARCHAR(1000) NULL,
+  `created_at` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  KEY `idx_webhook_deliveries_webhook` (`webhook_id`)
+);
+
+ALTER TABLE `webhook_deliveries` ADD FOREIGN KEY (`webhook_id`) REFERENCES `webhooks` (`id`);
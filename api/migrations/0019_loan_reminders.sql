@@ -0,0 +1,3 @@
+-- When the due date reminder of a loan was sent, NULL until it is
+
+ALTER TABLE `borrowed_books` ADD COLUMN `last_reminded_at` TIMESTAMP NULL;
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+var testMigrations = fstest.MapFS{
+	"0001_initial_schema.sql": {Data: []byte("CREATE TABLE authors (id INT);\nCREATE TABLE books (id INT);\n")},
+	"0002_book_format.sql":    {Data: []byte("ALTER TABLE books ADD COLUMN format VARCHAR(20);")},
+	"README.md":               {Data: []byte("not a migration")},
+}
+
+// expectMigrationsTable expects the creation of schema_migrations and the read of the versions applied
+func expectMigrationsTable(mock sqlmock.Sqlmock, applied ...string) {
+	mock.ExpectExec(sqlPattern("CREATE TABLE IF NOT EXISTS schema_migrations")).WillReturnResult(sqlmock.NewResult(0, 0))
+	rows := sqlmock.NewRows([]string{"version"})
+	for _, version := range applied {
+		rows.AddRow(version)
+	}
+	mock.ExpectQuery(sqlPattern("SELECT version FROM schema_migrations")).WillReturnRows(rows)
+}
+
+func expectAuthorsTable(mock sqlmock.Sqlmock, exists bool) {
+	count := 0
+	if exists {
+		count = 1
+	}
+	mock.ExpectQuery(sqlPattern("FROM information_schema.tables")).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(count))
+}
+
+func expectMigration(mock sqlmock.Sqlmock, version string, statements ...string) {
+	mock.ExpectBegin()
+	for _, statement := range statements {
+		mock.ExpectExec("^" + sqlPattern(statement) + "$").WillReturnResult(sqlmock.NewResult(0, 0))
+	}
+	mock.ExpectExec(sqlPattern("INSERT INTO schema_migrations (version) VALUES (?)")).WithArgs(version).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+}
+
+func TestRunMigrationsOnEmptyDatabase(t *testing.T) {
+	db, mock := newMockDB(t)
+	expectMigrationsTable(mock)
+	expectAuthorsTable(mock, false)
+	expectMigration(mock, "0001_initial_schema.sql", "CREATE TABLE authors (id INT)", "CREATE TABLE books (id INT)")
+	expectMigration(mock, "0002_book_format.sql", "ALTER TABLE books ADD COLUMN format VARCHAR(20)")
+
+	if err := runMigrations(context.Background(), db, testMigrations); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRunMigrationsSkipsApplied(t *testing.T) {
+	db, mock := newMockDB(t)
+	expectMigrationsTable(mock, "0001_initial_schema.sql")
+	expectMigration(mock, "0002_book_format.sql", "ALTER TABLE books ADD COLUMN format VARCHAR(20)")
+
+	if err := runMigrations(context.Background(), db, testMigrations); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRunMigrationsRecordsExistingSchema(t *testing.T) {
+	db, mock := newMockDB(t)
+	expectMigrationsTable(mock)
+	expectAuthorsTable(mock, true)
+	mock.ExpectExec(sqlPattern("INSERT INTO schema_migrations (version) VALUES (?)")).WithArgs(baselineMigration).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectMigration(mock, "0002_book_format.sql", "ALTER TABLE books ADD COLUMN format VARCHAR(20)")
+
+	if err := runMigrations(context.Background(), db, testMigrations); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRunMigrationsFailure(t *testing.T) {
+	db, mock := newMockDB(t)
+	expectMigrationsTable(mock, "0001_initial_schema.sql")
+	mock.ExpectBegin()
+	mock.ExpectExec(sqlPattern("ALTER TABLE books")).WillReturnError(errors.New("Duplicate column name 'format'"))
+	mock.ExpectRollback()
+
+	err := runMigrations(context.Background(), db, testMigrations)
+	if err == nil || !strings.Contains(err.Error(), "0002_book_format.sql") || !strings.Contains(err.Error(), "Duplicate column") {
+		t.Errorf("got %v, want the error of 0002_book_format.sql", err)
+	}
+}
+
+func TestSplitSQLStatements(t *testing.T) {
+	script := `-- the authors
+CREATE TABLE authors (id INT); # trailing comment; not a statement
+INSERT INTO authors VALUES ('a;b'), ("it\'s; fine");
+ALTER TABLE ` + "`semi;colon`" + ` ADD x INT
+`
+	want := []string{
+		"CREATE TABLE authors (id INT)",
+		`INSERT INTO authors VALUES ('a;b'), ("it\'s; fine")`,
+		"ALTER TABLE `semi;colon` ADD x INT",
+	}
+	if got := splitSQLStatements(script); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestMigrationFiles(t *testing.T) {
+	migrations := os.DirFS("migrations")
+	files, err := fs.Glob(migrations, "*.sql")
+	if err != nil || len(files) == 0 {
+		t.Fatalf("no migrations found: %v", err)
+	}
+	if files[0] != baselineMigration {
+		t.Errorf("the first migration is %s, want %s", files[0], baselineMigration)
+	}
+	for _, file := range files {
+		script, err := fs.ReadFile(migrations, file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(splitSQLStatements(string(script))) == 0 {
+			t.Errorf("%s has no statement", file)
+		}
+	}
+}
+
+// TestRunMigrationsOnBaselineDatabase runs the migrations of the repository on a database created from
+// schema.sql: the initial schema is recorded and every later migration runs, in order
+func TestRunMigrationsOnBaselineDatabase(t *testing.T) {
+	migrations := os.DirFS("migrations")
+	files, err := fs.Glob(migrations, "*.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db, mock := newMockDB(t)
+	expectMigrationsTable(mock)
+	expectAuthorsTable(mock, true)
+	mock.ExpectExec(sqlPattern("INSERT INTO schema_migrations (version) VALUES (?)")).WithArgs(baselineMigration).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	for _, file := range files[1:] {
+		script, err := fs.ReadFile(migrations, file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expectMigration(mock, file, splitSQLStatements(string(script))...)
+	}
+
+	if err := runMigrations(context.Background(), db, migrations); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestBaselineSchemaMatchesInitialMigration checks that the tables created by schema.sql are those of the
+// initial migration, which is recorded without running on such a database
+func TestBaselineSchemaMatchesInitialMigration(t *testing.T) {
+	schema, err := os.ReadFile("schema.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	initial, err := os.ReadFile("migrations/" + baselineMigration)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var tables []string
+	for _, statement := range splitSQLStatements(string(schema)) {
+		if !strings.HasPrefix(statement, "INSERT") {
+			tables = append(tables, statement)
+		}
+	}
+	if want := splitSQLStatements(string(initial)); !reflect.DeepEqual(tables, want) {
+		t.Errorf("the tables of schema.sql are\n%s\nwant those of %s\n%s", strings.Join(tables, ";\n"), baselineMigration, strings.Join(want, ";\n"))
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// routeMethods are the methods tried to find out which ones a path allows
+var routeMethods = []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete}
+
+// allowedMethods returns the methods router has routes for on the path of r
+func allowedMethods(router *mux.Router, r *http.Request) []string {
+	var allowed []string
+	for _, method := range routeMethods {
+		probe := r.Clone(r.Context())
+		probe.Method = method
+		var match mux.RouteMatch
+		if router.Match(probe, &match) && match.MatchErr == nil {
+			allowed = append(allowed, method)
+		}
+	}
+	return allowed
+}
+
+// methodNotAllowed writes the JSON 405 with the Allow header
+func methodNotAllowed(w http.ResponseWriter, allowed []string) {
+	w.Header().Set("Allow", strings.Join(allowed, ", "))
+	RespondWithJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+}
+
+// NotFoundHandler answers the requests that match no route of router with a JSON 404. mux reports some
+// method mismatches in a subrouter as not found, when a later route clears the mismatch, so a path router
+// has routes for with other methods is still answered with a 405.
+func NotFoundHandler(router *mux.Router) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if allowed := allowedMethods(router, r); len(allowed) > 0 {
+			methodNotAllowed(w, allowed)
+			return
+		}
+		RespondWithJSON(w, http.StatusNotFound, map[string]string{"error": "not found"})
+	})
+}
+
+// MethodNotAllowedHandler answers the requests whose path has routes but none for their method with a
+// JSON 405. The Allow header lists the methods router has routes for on that path.
+func MethodNotAllowedHandler(router *mux.Router) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		methodNotAllowed(w, allowedMethods(router, r))
+	})
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestJSONErrorsOfTheRouter(t *testing.T) {
+	r := newTestRouter(t, nil)
+	tests := []struct {
+		name   string
+		method string
+		target string
+		want   int
+		allow  string
+		error  string
+	}{
+		{name: "unknown path", method: http.MethodGet, target: "/nothing/here", want: http.StatusNotFound, error: "not found"},
+		{name: "unknown versioned path", method: http.MethodGet, target: apiV1Prefix + "/nothing", want: http.StatusNotFound, error: "not found"},
+		{name: "GET on /books/new", method: http.MethodGet, target: apiV1Prefix + "/books/new", want: http.StatusMethodNotAllowed, allow: "POST", error: "method not allowed"},
+		{name: "GET on the legacy /books/new", method: http.MethodGet, target: "/books/new", want: http.StatusMethodNotAllowed, allow: "POST", error: "method not allowed"},
+		{name: "PATCH on a book", method: http.MethodPatch, target: apiV1Prefix + "/books/1", want: http.StatusMethodNotAllowed, allow: "GET, POST, PUT, DELETE", error: "method not allowed"},
+		{name: "PATCH on a legacy book", method: http.MethodPatch, target: "/books/1", want: http.StatusMethodNotAllowed, allow: "GET, POST, PUT, DELETE", error: "method not allowed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			r.ServeHTTP(rec, httptest.NewRequest(tt.method, tt.target, nil))
+			if rec.Code != tt.want {
+				t.Fatalf("status %d, want %d: %s", rec.Code, tt.want, rec.Body)
+			}
+			if got := rec.Header().Get("Content-Type"); got != "application/json" {
+				t.Errorf("Content-Type %q, want application/json", got)
+			}
+			if got := rec.Header().Get("Allow"); got != tt.allow {
+				t.Errorf("Allow %q, want %q", got, tt.allow)
+			}
+			var body map[string]string
+			decodeJSON(t, rec, &body)
+			if body["error"] != tt.error {
+				t.Errorf("got %v, want the error %q", body, tt.error)
+			}
+		})
+	}
+}
@@ -0,0 +1,337 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// openAPIOperation documents a route for the OpenAPI document. Request and Response are example values,
+// such as Author{} or map[string]interface{}{"book_id": 0}, their schema is derived from them.
+type openAPIOperation struct {
+	Summary  string
+	Query    []string          // names of the query parameters
+	Request  interface{}       // JSON body
+	Form     map[string]string // multipart/form-data fields, "file" or "string"
+	Status   int               // status of a successful response, 200 when unset
+	Response interface{}       // JSON body of a successful response
+}
+
+// messageResponse is the body of the responses that only confirm a change
+var messageResponse = map[string]interface{}{"message": ""}
+
+// openAPIOperations documents the routes of version 1 of the API by "METHOD path". A route served for
+// several methods is documented by its first one. The tests fail on a route missing here.
+var openAPIOperations = map[string]openAPIOperation{
+	"GET /info": {Summary: "Info page"},
+
+	"GET /books": {Summary: "List the books", Query: []string{"genre", "author_id", "publisher", "format", "is_borrowed", "sort", "order", "page", "page_size"},
+		Response: []BookAuthorInfo{}},
+	"GET /books/available": {Summary: "List the books that are not borrowed", Query: []string{"genre", "author_id", "publisher", "format", "sort", "order", "page", "page_size"},
+		Response: []BookAuthorInfo{}},
+	"GET /books/popular":     {Summary: "List the most borrowed books", Query: []string{"limit"}, Response: []BookAuthorInfo{}},
+	"GET /books/by-author":   {Summary: "List the books of an author found by name", Query: []string{"firstname", "lastname"}, Response: []BookAuthorInfo{}},
+	"GET /books/isbn/{isbn}": {Summary: "Get a book by its ISBN", Response: BookAuthorInfo{}},
+	"GET /books/lookup":      {Summary: "Look up a book on OpenLibrary by its ISBN", Query: []string{"isbn"}, Response: BookLookup{}},
+	"GET /books/export":      {Summary: "Export the books as CSV", Query: []string{"genre", "author_id", "publisher", "format", "is_borrowed"}},
+	"GET /search_books": {Summary: "Search the books by title, author or tags", Query: []string{"query", "tags", "publisher", "page", "page_size"},
+		Response: []BookAuthorInfo{}},
+	"GET /books/{id}":                       {Summary: "Get a book with its authors, genres, tags and rating", Response: BookAuthorInfo{}},
+	"GET /books/{id}/subscribers":           {Summary: "List the subscribers who borrowed a book", Response: []Subscriber{}},
+	"GET /books/{id}/borrow-history":        {Summary: "List the loans of a book", Response: []BorrowHistoryEntry{}},
+	"GET /books/{id}/availability":          {Summary: "Tell whether a book can be borrowed", Response: map[string]interface{}{"available": false}},
+	"GET /books/{id}/similar":               {Summary: "List the books similar to a book", Query: []string{"limit"}, Response: []BookAuthorInfo{}},
+	"GET /books/{id}/reviews":               {Summary: "List the reviews of a book", Response: []Review{}},
+	"POST /books/{id}/reviews":              {Summary: "Review a book", Request: Review{}, Status: http.StatusCreated, Response: map[string]interface{}{"id": 0}},
+	"DELETE /books/{id}/reviews/{reviewID}": {Summary: "Delete a review", Query: []string{"subscriber_id"}, Response: messageResponse},
+	"DELETE /books/{id}/tags/{tag_name}":    {Summary: "Remove a tag from a book", Response: messageResponse},
+	"POST /books/new": {Summary: "Add a book, optionally with its photo as multipart/form-data", Request: NewBook{},
+		Form:   map[string]string{"title": "string", "author_id": "string", "details": "string", "isbn": "string", "publisher": "string", "format": "string", "photo": "file"},
+		Status: http.StatusCreated, Response: map[string]interface{}{"id": 0}},
+	"POST /books/import": {Summary: "Import books from a CSV file, or a single book from OpenLibrary by its ISBN",
+		Query: []string{"isbn", "dry_run", "strict"}, Form: map[string]string{"file": "file"}, Response: ImportSummary{}},
+	"PUT /books/{id}": {Summary: "Update a book", Request: NewBook{}, Response: messageResponse},
+	"PATCH /books/{id}/borrow-status": {Summary: "Mark a book borrowed or available", Request: map[string]interface{}{"is_borrowed": false},
+		Response: map[string]interface{}{"id": 0, "is_borrowed": false}},
+	"DELETE /books/{id}": {Summary: "Delete a book", Query: []string{"force"}, Response: messageResponse},
+
+	"GET /authors": {Summary: "List the authors with their number of books", Query: []string{"has_books", "no_books", "sort", "order", "page", "page_size"},
+		Response: []AuthorWithCount{}},
+	"GET /authorsbooks":         {Summary: "List the authors with their books", Query: []string{"author_id", "book_id", "sort", "order"}, Response: []AuthorBook{}},
+	"GET /authors/{id}":         {Summary: "Get an author with their books"},
+	"GET /authors/{id}/profile": {Summary: "Get an author", Response: AuthorProfile{}},
+	"GET /authors/{id}/stats":   {Summary: "Get the loan statistics of an author", Response: AuthorStats{}},
+	"POST /authors/new": {Summary: "Add an author, optionally with their photo as multipart/form-data", Query: []string{"allow_duplicate"}, Request: Author{},
+		Form: map[string]string{"firstname": "string", "lastname": "string", "photo": "file"}, Status: http.StatusCreated, Response: map[string]interface{}{"id": 0}},
+	"PUT /authors/{id}":        {Summary: "Update an author", Request: Author{}, Response: messageResponse},
+	"DELETE /authors/{id}":     {Summary: "Delete an author", Query: []string{"cascade", "force"}, Response: messageResponse},
+	"POST /authors/{id}/merge": {Summary: "Merge another author into an author", Request: map[string]interface{}{"source_id": 0}},
+	"POST /authors/{id}/books": {Summary: "Link a book to an author", Request: map[string]interface{}{"book_id": 0}, Status: http.StatusCreated,
+		Response: messageResponse},
+	"DELETE /authors/{id}/books/{book_id}": {Summary: "Unlink a book from an author", Response: messageResponse},
+
+	"GET /author/photo/{id}":       {Summary: "Get the photo of an author", Query: []string{"size"}},
+	"POST /author/photo/{id}":      {Summary: "Upload the photo of an author", Form: map[string]string{"file": "file"}},
+	"DELETE /author/photo/{id}":    {Summary: "Delete the photo of an author", Response: messageResponse},
+	"PATCH /author/photo-url/{id}": {Summary: "Use a remote photo for an author", Request: map[string]interface{}{"photo_url": ""}, Response: messageResponse},
+	"GET /books/photo/{id}":        {Summary: "Get the photo of a book", Query: []string{"size"}},
+	"POST /books/photo/{id}":       {Summary: "Upload the photo of a book", Form: map[string]string{"file": "file"}},
+	"DELETE /books/photo/{id}":     {Summary: "Delete the photo of a book", Response: messageResponse},
+	"PATCH /books/photo-url/{id}":  {Summary: "Use a remote photo for a book", Request: map[string]interface{}{"photo_url": ""}, Response: messageResponse},
+
+	"GET /subscribers":        {Summary: "List the subscribers", Query: []string{"page", "page_size"}, Response: []Subscriber{}},
+	"GET /subscribers/search": {Summary: "Search the subscribers by name or email", Query: []string{"query", "page", "page_size"}, Response: []Subscriber{}},
+	"GET /subscribers/expired-memberships": {Summary: "List the subscribers whose membership has expired", Query: []string{"page", "page_size"},
+		Response: []Subscriber{}},
+	"GET /subscribers/export": {Summary: "Export the subscribers as CSV or PDF", Query: []string{"format"}},
+	"POST /subscribers/new":   {Summary: "Add a subscriber", Request: Subscriber{}, Status: http.StatusCreated, Response: map[string]interface{}{"id": 0}},
+	"POST /subscribers/bulk":  {Summary: "Add up to 1000 subscribers", Request: []Subscriber{}, Response: map[string]interface{}{"results": []BulkSubscriberResult{}}},
+	"GET /subscribers/{id}":   {Summary: "Get a subscriber", Response: Subscriber{}},
+	"PUT /subscribers/{id}":   {Summary: "Update a subscriber", Request: Subscriber{}, Response: messageResponse},
+	"PATCH /subscribers/{id}": {Summary: "Update some fields of a subscriber",
+		Request: map[string]interface{}{"firstname": "", "lastname": "", "email": "", "phone": "", "membership_expiry": ""}, Response: messageResponse},
+	"DELETE /subscribers/{id}":             {Summary: "Delete a subscriber", Query: []string{"force"}, Response: messageResponse},
+	"GET /subscribers/{id}/active-borrows": {Summary: "List the books a subscriber has not returned", Response: []ActiveBorrowInfo{}},
+	"GET /subscribers/{id}/export":         {Summary: "Export the personal data of a subscriber", Response: SubscriberDataExport{}},
+	"POST /subscribers/{id}/anonymize":     {Summary: "Anonymize a subscriber", Response: messageResponse},
+
+	"POST /book/borrow": {Summary: "Borrow a book", Request: map[string]interface{}{"subscriber_id": 0, "book_id": 0}, Status: http.StatusCreated,
+		Response: messageResponse},
+	"POST /book/return": {Summary: "Return a borrowed book", Request: map[string]interface{}{"subscriber_id": 0, "book_id": 0}, Response: messageResponse},
+	"POST /book/transfer": {Summary: "Transfer a loan to another subscriber",
+		Request: map[string]interface{}{"from_subscriber_id": 0, "to_subscriber_id": 0, "book_id": 0}, Response: messageResponse},
+
+	"GET /genres":                {Summary: "List the genres", Response: []Genre{}},
+	"POST /genres/new":           {Summary: "Add a genre", Request: Genre{}, Status: http.StatusCreated, Response: map[string]interface{}{"id": 0}},
+	"DELETE /genres/{id}":        {Summary: "Delete a genre", Query: []string{"force"}, Response: messageResponse},
+	"GET /publishers":            {Summary: "List the publishers", Response: []string{}},
+	"GET /books/formats":         {Summary: "List the formats of the books in the catalogue", Response: []string{}},
+	"GET /stats":                 {Summary: "Get the library statistics"},
+	"GET /stats/monthly-borrows": {Summary: "Count the loans per month", Query: []string{"from", "to"}, Response: []MonthlyBorrow{}},
+	"GET /reports/top-books": {Summary: "Report the most borrowed books of a period, as JSON or CSV", Query: []string{"from", "to", "limit", "format"},
+		Response: []TopBookReportRow{}},
+	"GET /audit":    {Summary: "List the audit log", Query: []string{"entity_type", "entity_id", "page", "page_size"}, Response: []AuditEntry{}},
+	"GET /webhooks": {Summary: "List the webhooks", Response: []Webhook{}},
+	"POST /webhooks": {Summary: "Register a webhook for book.borrowed and book.returned events", Request: Webhook{}, Status: http.StatusCreated,
+		Response: map[string]interface{}{"id": 0, "secret": ""}},
+	"DELETE /webhooks/{id}":         {Summary: "Delete a webhook", Response: messageResponse},
+	"GET /webhooks/{id}/deliveries": {Summary: "List the delivery attempts of a webhook", Query: []string{"page", "page_size"}, Response: []WebhookDelivery{}},
+	"POST /admin/loglevel":          {Summary: "Change the log level", Request: map[string]interface{}{"level": ""}, Response: map[string]interface{}{"level": ""}},
+}
+
+// pathVariablePattern matches a route variable with its optional pattern, like {id:[0-9]+}
+var pathVariablePattern = regexp.MustCompile(`\{([^}:]+)(:[^}]*)?\}`)
+
+// openAPISchemas collects the component schemas of the named types used by the operations
+type openAPISchemas map[string]interface{}
+
+// schemaOf returns the JSON schema of the JSON encoding of a value of type t
+func (s openAPISchemas) schemaOf(t reflect.Type) map[string]interface{} {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch t {
+	case reflect.TypeOf(time.Time{}):
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	case reflect.TypeOf(MembershipDate{}):
+		return map[string]interface{}{"type": "string", "format": "date"}
+	case reflect.TypeOf(json.RawMessage{}):
+		return map[string]interface{}{}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": s.schemaOf(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": s.schemaOf(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return s.structSchema(t)
+		}
+		if _, ok := s[t.Name()]; !ok {
+			s[t.Name()] = map[string]interface{}{} // placeholder for recursive types
+			s[t.Name()] = s.structSchema(t)
+		}
+		return map[string]interface{}{"$ref": "#/components/schemas/" + t.Name()}
+	}
+	return map[string]interface{}{}
+}
+
+// structSchema returns the object schema of a struct, with the fields of its embedded structs
+func (s openAPISchemas) structSchema(t reflect.Type) map[string]interface{} {
+	properties := map[string]interface{}{}
+	var collect func(t reflect.Type)
+	collect = func(t reflect.Type) {
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			tag := field.Tag.Get("json")
+			if !field.IsExported() || tag == "-" {
+				continue
+			}
+			name, _, _ := strings.Cut(tag, ",")
+			if field.Anonymous && name == "" && field.Type.Kind() == reflect.Struct {
+				collect(field.Type)
+				continue
+			}
+			if name == "" {
+				name = field.Name
+			}
+			properties[name] = s.schemaOf(field.Type)
+		}
+	}
+	collect(t)
+	return map[string]interface{}{"type": "object", "properties": properties}
+}
+
+// schemaOfExample returns the schema of an example value, maps are described by their keys and values
+func (s openAPISchemas) schemaOfExample(example interface{}) map[string]interface{} {
+	if object, ok := example.(map[string]interface{}); ok {
+		properties := map[string]interface{}{}
+		for name, value := range object {
+			properties[name] = s.schemaOfExample(value)
+		}
+		return map[string]interface{}{"type": "object", "properties": properties}
+	}
+	return s.schemaOf(reflect.TypeOf(example))
+}
+
+// operationSpec builds the OpenAPI operation of a route with the path variables of path
+func (s openAPISchemas) operationSpec(doc openAPIOperation, path string) map[string]interface{} {
+	parameters := []interface{}{}
+	for _, match := range pathVariablePattern.FindAllStringSubmatch(path, -1) {
+		schema := map[string]interface{}{"type": "integer"}
+		if name := match[1]; name != "id" && !strings.HasSuffix(name, "_id") && !strings.HasSuffix(name, "ID") {
+			schema = map[string]interface{}{"type": "string"}
+		}
+		parameters = append(parameters, map[string]interface{}{"name": match[1], "in": "path", "required": true, "schema": schema})
+	}
+	for _, name := range doc.Query {
+		parameters = append(parameters, map[string]interface{}{"name": name, "in": "query", "schema": map[string]interface{}{"type": "string"}})
+	}
+
+	operation := map[string]interface{}{"summary": doc.Summary, "parameters": parameters}
+
+	content := map[string]interface{}{}
+	if doc.Request != nil {
+		content["application/json"] = map[string]interface{}{"schema": s.schemaOfExample(doc.Request)}
+	}
+	if doc.Form != nil {
+		properties := map[string]interface{}{}
+		for name, kind := range doc.Form {
+			properties[name] = map[string]interface{}{"type": "string"}
+			if kind == "file" {
+				properties[name] = map[string]interface{}{"type": "string", "format": "binary"}
+			}
+		}
+		content["multipart/form-data"] = map[string]interface{}{"schema": map[string]interface{}{"type": "object", "properties": properties}}
+	}
+	if len(content) > 0 {
+		operation["requestBody"] = map[string]interface{}{"content": content}
+	}
+
+	status := doc.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	success := map[string]interface{}{"description": http.StatusText(status)}
+	if doc.Response != nil {
+		success["content"] = map[string]interface{}{"application/json": map[string]interface{}{"schema": s.schemaOfExample(doc.Response)}}
+	}
+	operation["responses"] = map[string]interface{}{
+		strconv.Itoa(status): success,
+		"default":            map[string]interface{}{"description": "Error, as plain text or a JSON object with an error field"},
+	}
+	return operation
+}
+
+// BuildOpenAPISpec builds the OpenAPI 3 document of the routes of api, which are served under prefix. A route
+// without an entry in openAPIOperations is in the document without a summary, which the tests don't allow.
+func BuildOpenAPISpec(api *mux.Router, prefix string) map[string]interface{} {
+	schemas := openAPISchemas{}
+	paths := map[string]map[string]interface{}{}
+
+	api.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		template, err := route.GetPathTemplate()
+		if err != nil || route.GetHandler() == nil {
+			return nil
+		}
+		path := pathVariablePattern.ReplaceAllString(strings.TrimPrefix(template, prefix), "{$1}")
+		methods, err := route.GetMethods()
+		if err != nil {
+			methods = []string{http.MethodGet}
+		}
+
+		doc := openAPIOperations[methods[0]+" "+path]
+		if paths[path] == nil {
+			paths[path] = map[string]interface{}{}
+		}
+		for _, method := range methods {
+			// Routes sharing a path and a method, such as the two imports, are documented once
+			if _, exists := paths[path][strings.ToLower(method)]; !exists {
+				paths[path][strings.ToLower(method)] = schemas.operationSpec(doc, template)
+			}
+		}
+		return nil
+	})
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":   "Library API",
+			"version": strings.TrimPrefix(prefix, "/api/"),
+		},
+		"servers":    []interface{}{map[string]interface{}{"url": prefix}},
+		"paths":      paths,
+		"components": map[string]interface{}{"schemas": schemas},
+	}
+}
+
+// ServeOpenAPISpec returns a handler that serves an OpenAPI document as JSON
+func ServeOpenAPISpec(spec map[string]interface{}) (http.HandlerFunc, error) {
+	body, err := json.Marshal(spec)
+	if err != nil {
+		return nil, err
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(body)
+	}, nil
+}
+
+// swaggerUIPage renders the document of /openapi.json with Swagger UI, loaded from a CDN
+const swaggerUIPage = `<!DOCTYPE html>
+<html lang="en">
+<head>
+  <meta charset="utf-8">
+  <title>Library API</title>
+  <link rel="stylesheet" href="https://unpkg.com/swagger-ui-dist@5/swagger-ui.css">
+</head>
+<body>
+  <div id="swagger-ui"></div>
+  <script src="https://unpkg.com/swagger-ui-dist@5/swagger-ui-bundle.js"></script>
+  <script>
+    window.ui = SwaggerUIBundle({ url: "/openapi.json", dom_id: "#swagger-ui" });
+  </script>
+</body>
+</html>
+`
+
+// APIDocs serves a Swagger UI page for the OpenAPI document
+func APIDocs(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte(swaggerUIPage))
+}
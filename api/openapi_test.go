@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// testContext returns a context canceled when the test ends
+func testContext(t *testing.T) context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	return ctx
+}
+
+// newTestRouter builds the router of the API on db, with the photos stored in a temporary directory
+func newTestRouter(t *testing.T, db *sql.DB) *mux.Router {
+	t.Helper()
+	discardLogs(t)
+	photoConfig := PhotoConfig{Storage: &LocalStorage{Dir: t.TempDir(), BaseURL: "/upload"}, MaxSize: 1 << 20, MaxMemory: 1 << 20}
+	r, err := setupRouter(testContext(t), Config{RequestTimeout: time.Minute}, db, photoConfig)
+	if err != nil {
+		t.Fatalf("setupRouter: %v", err)
+	}
+	return r
+}
+
+func TestOpenAPISpecDocumentsEveryRoute(t *testing.T) {
+	r := newTestRouter(t, nil)
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/openapi.json", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET /openapi.json: status %d", rec.Code)
+	}
+	var spec struct {
+		Paths map[string]map[string]struct {
+			Summary string `json:"summary"`
+		} `json:"paths"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&spec); err != nil {
+		t.Fatalf("decoding the OpenAPI document: %v", err)
+	}
+
+	routes := 0
+	err := r.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		template, err := route.GetPathTemplate()
+		if err != nil || route.GetHandler() == nil || !strings.HasPrefix(template, apiV1Prefix+"/") {
+			return nil
+		}
+		path := pathVariablePattern.ReplaceAllString(strings.TrimPrefix(template, apiV1Prefix), "{$1}")
+		methods, err := route.GetMethods()
+		if err != nil {
+			methods = []string{http.MethodGet}
+		}
+		for _, method := range methods {
+			routes++
+			if spec.Paths[path][strings.ToLower(method)].Summary == "" {
+				t.Errorf("%s %s is missing from the OpenAPI document", method, path)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if routes == 0 {
+		t.Fatal("no route found under " + apiV1Prefix)
+	}
+}
+
+func TestOpenAPIDocs(t *testing.T) {
+	r := newTestRouter(t, nil)
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/docs", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET /docs: status %d", rec.Code)
+	}
+	if !strings.Contains(rec.Body.String(), "/openapi.json") {
+		t.Error("the docs page doesn't load /openapi.json")
+	}
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// errBookNotFound is returned by OpenLibraryClient.Lookup when OpenLibrary doesn't know an ISBN
+var errBookNotFound = errors.New("book not found")
+
+// OpenLibraryClient looks up book metadata in the OpenLibrary Books API.
+// HTTPClient can be replaced to stub the upstream service.
+type OpenLibraryClient struct {
+	BaseURL    string
+	HTTPClient *http.Client
+}
+
+// NewOpenLibraryClient returns a client for the OpenLibrary instance at baseURL, https://openlibrary.org by default
+func NewOpenLibraryClient(baseURL string) *OpenLibraryClient {
+	return &OpenLibraryClient{
+		BaseURL:    strings.TrimSuffix(baseURL, "/"),
+		HTTPClient: &http.Client{Timeout: 5 * time.Second},
+	}
+}
+
+// openLibraryBook is the part of a Books API "data" record the lookup uses
+type openLibraryBook struct {
+	Title    string `json:"title"`
+	Subtitle string `json:"subtitle"`
+	Authors  []struct {
+		Name string `json:"name"`
+	} `json:"authors"`
+	Publishers []struct {
+		Name string `json:"name"`
+	} `json:"publishers"`
+	PublishDate   string `json:"publish_date"`
+	NumberOfPages int    `json:"number_of_pages"`
+	Cover         struct {
+		Large string `json:"large"`
+	} `json:"cover"`
+}
+
+// BookLookup is a NewBook pre-filled from OpenLibrary, with the author's name for when the
+// author isn't in the library yet (author_id is then 0)
+type BookLookup struct {
+	NewBook
+	AuthorFirstname string `json:"author_firstname"`
+	AuthorLastname  string `json:"author_lastname"`
+}
+
+// Lookup fetches the metadata of a normalized ISBN. It returns errBookNotFound when OpenLibrary has no such book.
+func (c *OpenLibraryClient) Lookup(ctx context.Context, isbn string) (BookLookup, error) {
+	bibkey := "ISBN:" + isbn
+	query := url.Values{"bibkeys": {bibkey}, "format": {"json"}, "jscmd": {"data"}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.BaseURL+"/api/books?"+query.Encode(), nil)
+	if err != nil {
+		return BookLookup{}, err
+	}
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return BookLookup{}, fmt.Errorf("openlibrary request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return BookLookup{}, errBookNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		return BookLookup{}, fmt.Errorf("openlibrary responded %s", resp.Status)
+	}
+
+	// The response maps each requested bibkey to its record and is empty for unknown books
+	var records map[string]openLibraryBook
+	if err := json.NewDecoder(resp.Body).Decode(&records); err != nil {
+		return BookLookup{}, fmt.Errorf("invalid openlibrary response: %w", err)
+	}
+	record, ok := records[bibkey]
+	if !ok || record.Title == "" {
+		return BookLookup{}, errBookNotFound
+	}
+
+	lookup := BookLookup{NewBook: NewBook{
+		Title:   record.Title,
+		Photo:   record.Cover.Large,
+		Details: bookDetails(record),
+		ISBN:    isbn,
+	}}
+	if len(record.Authors) > 0 {
+		lookup.AuthorFirstname, lookup.AuthorLastname = splitAuthorName(record.Authors[0].Name)
+	}
+	return lookup, nil
+}
+
+// bookDetails summarizes the subtitle, publisher and size of a book
+func bookDetails(record openLibraryBook) string {
+	var details []string
+	if record.Subtitle != "" {
+		details = append(details, record.Subtitle)
+	}
+	if len(record.Publishers) > 0 && record.PublishDate != "" {
+		details = append(details, fmt.Sprintf("Published by %s, %s", record.Publishers[0].Name, record.PublishDate))
+	} else if record.PublishDate != "" {
+		details = append(details, "Published "+record.PublishDate)
+	}
+	if record.NumberOfPages > 0 {
+		details = append(details, fmt.Sprintf("%d pages", record.NumberOfPages))
+	}
+	return strings.Join(details, ". ")
+}
+
+// splitAuthorName takes the last word of a full name as the lastname and the rest as the firstname
+func splitAuthorName(name string) (string, string) {
+	fields := strings.Fields(name)
+	if len(fields) == 0 {
+		return "", ""
+	}
+	return strings.Join(fields[:len(fields)-1], " "), fields[len(fields)-1]
+}
+
+// lookupISBN validates the isbn query parameter and looks it up, writing the error response on failure.
+// Upstream failures and timeouts are reported as 502 Bad Gateway.
+func lookupISBN(client *OpenLibraryClient, w http.ResponseWriter, r *http.Request) (BookLookup, bool) {
+	isbn, err := NormalizeISBN(r.URL.Query().Get("isbn"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return BookLookup{}, false
+	}
+
+	lookup, err := client.Lookup(r.Context(), isbn)
+	if errors.Is(err, errBookNotFound) {
+		http.Error(w, "Book not found on OpenLibrary", http.StatusNotFound)
+		return BookLookup{}, false
+	}
+	if err != nil {
+		slog.Error("looking up ISBN failed", "isbn", isbn, "error", err)
+		http.Error(w, "OpenLibrary is unavailable", http.StatusBadGateway)
+		return BookLookup{}, false
+	}
+	return lookup, true
+}
+
+// findAuthorID returns the id of the author with the given name, or 0 when there is none
+func findAuthorID(ctx context.Context, q interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}, firstname, lastname string) (int, error) {
+	var id int
+	err := q.QueryRowContext(ctx, "SELECT id FROM authors WHERE Lastname = ? AND Firstname = ? LIMIT 1", lastname, firstname).Scan(&id)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return id, err
+}
+
+// LookupBook returns a handler that pre-fills a new book from the OpenLibrary record of an ISBN without saving it.
+// author_id is set when the author is already in the library.
+func LookupBook(db *sql.DB, client *OpenLibraryClient) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		lookup, ok := lookupISBN(client, w, r)
+		if !ok {
+			return
+		}
+
+		authorID, err := findAuthorID(r.Context(), db, lookup.AuthorFirstname, lookup.AuthorLastname)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		lookup.AuthorID = authorID
+
+		RespondWithJSON(w, http.StatusOK, lookup)
+	}
+}
+
+// ImportBook returns a handler that creates the book of an ISBN from its OpenLibrary record,
+// together with its author when the author isn't in the library yet.
+func ImportBook(db *sql.DB, client *OpenLibraryClient) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		lookup, ok := lookupISBN(client, w, r)
+		if !ok {
+			return
+		}
+		if lookup.AuthorLastname == "" {
+			http.Error(w, "OpenLibrary has no author for this book", http.StatusUnprocessableEntity)
+			return
+		}
+
+		tx, err := db.BeginTx(r.Context(), nil)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to start transaction: %v", err), http.StatusInternalServerError)
+			return
+		}
+		defer tx.Rollback()
+
+		var existingID int
+		err = tx.QueryRowContext(r.Context(), "SELECT id FROM books WHERE isbn = ? LIMIT 1 FOR UPDATE", lookup.ISBN).Scan(&existingID)
+		if err == nil {
+			RespondWithJSON(w, http.StatusConflict, map[string]interface{}{
+				"error":       "book already exists",
+				"existing_id": existingID,
+			})
+			return
+		}
+		if err != sql.ErrNoRows {
+			http.Error(w, fmt.Sprintf("Failed to check for existing book: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		authorID, err := findAuthorID(r.Context(), tx, lookup.AuthorFirstname, lookup.AuthorLastname)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if authorID == 0 {
+			result, err := tx.ExecContext(r.Context(), "INSERT INTO authors (lastname, firstname, photo) VALUES (?, ?, '')", lookup.AuthorLastname, lookup.AuthorFirstname)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("Failed to insert author: %v", err), http.StatusInternalServerError)
+				return
+			}
+			id, err := result.LastInsertId()
+			if err != nil {
+				http.Error(w, "Failed to get last insert ID", http.StatusInternalServerError)
+				return
+			}
+			authorID = int(id)
+		}
+
+		result, err := tx.ExecContext(r.Context(), `
+			INSERT INTO books (title, author_id, photo, is_borrowed, details, isbn)
+			VALUES (?, ?, ?, FALSE, ?, ?)
+		`, lookup.Title, authorID, lookup.Photo, lookup.Details, lookup.ISBN)
+		if isDuplicateEntry(err) {
+			RespondWithJSON(w, http.StatusConflict, map[string]string{"error": "book already exists"})
+			return
+		}
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to insert book: %v", err), http.StatusInternalServerError)
+			return
+		}
+		id, err := result.LastInsertId()
+		if err != nil {
+			http.Error(w, "Failed to get last insert ID", http.StatusInternalServerError)
+			return
+		}
+
+		_, err = tx.ExecContext(r.Context(), "INSERT INTO authors_books (author_id, book_id) VALUES (?, ?)", authorID, id)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to link the author: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		if err := tx.Commit(); err != nil {
+			http.Error(w, fmt.Sprintf("Failed to commit transaction: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		RespondWithJSON(w, http.StatusCreated, map[string]int{"id": int(id), "author_id": authorID})
+	}
+}
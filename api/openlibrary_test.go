@@ -0,0 +1,177 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+const openLibrary1984 = `{"ISBN:0451524934": {
+	"title": "1984",
+	"authors": [{"name": "George Orwell"}],
+	"publishers": [{"name": "Signet Classic"}],
+	"publish_date": "1961",
+	"number_of_pages": 328,
+	"cover": {"large": "https://covers.openlibrary.org/b/id/1-L.jpg"}
+}}`
+
+// newTestOpenLibrary returns a client of an OpenLibrary stub answering with status and body
+func newTestOpenLibrary(t *testing.T, status int, body string) *OpenLibraryClient {
+	t.Helper()
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/books" || r.URL.Query().Get("bibkeys") != "ISBN:0451524934" {
+			t.Errorf("unexpected upstream request %s", r.URL)
+		}
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(upstream.Close)
+	return NewOpenLibraryClient(upstream.URL + "/")
+}
+
+func TestLookupBook(t *testing.T) {
+	t.Run("found", func(t *testing.T) {
+		db, mock := newMockDB(t)
+		mock.ExpectQuery(sqlPattern("SELECT id FROM authors WHERE Lastname = ? AND Firstname = ?")).WithArgs("Orwell", "George").
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(2))
+
+		client := newTestOpenLibrary(t, http.StatusOK, openLibrary1984)
+		rec := serveRoute(LookupBook(db, client), http.MethodGet, "/books/lookup", "/books/lookup?isbn=0-451-52493-4", nil)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status %d, want 200: %s", rec.Code, rec.Body)
+		}
+		var lookup BookLookup
+		decodeJSON(t, rec, &lookup)
+		if lookup.Title != "1984" || lookup.AuthorID != 2 || lookup.AuthorFirstname != "George" || lookup.AuthorLastname != "Orwell" ||
+			lookup.ISBN != "0451524934" || lookup.Photo != "https://covers.openlibrary.org/b/id/1-L.jpg" {
+			t.Errorf("got %+v", lookup)
+		}
+		if want := "Published by Signet Classic, 1961. 328 pages"; lookup.Details != want {
+			t.Errorf("details %q, want %q", lookup.Details, want)
+		}
+	})
+
+	tests := []struct {
+		name   string
+		status int
+		body   string
+		want   int
+	}{
+		{name: "unknown ISBN", status: http.StatusOK, body: `{}`, want: http.StatusNotFound},
+		{name: "upstream 404", status: http.StatusNotFound, body: ``, want: http.StatusNotFound},
+		{name: "upstream error", status: http.StatusInternalServerError, body: ``, want: http.StatusBadGateway},
+		{name: "invalid upstream response", status: http.StatusOK, body: `<html>`, want: http.StatusBadGateway},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, _ := newMockDB(t)
+
+			client := newTestOpenLibrary(t, tt.status, tt.body)
+			rec := serveRoute(LookupBook(db, client), http.MethodGet, "/books/lookup", "/books/lookup?isbn=0451524934", nil)
+			if rec.Code != tt.want {
+				t.Errorf("status %d, want %d: %s", rec.Code, tt.want, rec.Body)
+			}
+		})
+	}
+
+	t.Run("upstream timeout", func(t *testing.T) {
+		db, _ := newMockDB(t)
+		release := make(chan struct{})
+		upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			<-release
+		}))
+		defer upstream.Close()
+		defer close(release)
+
+		client := NewOpenLibraryClient(upstream.URL)
+		client.HTTPClient.Timeout = 10 * time.Millisecond
+		rec := serveRoute(LookupBook(db, client), http.MethodGet, "/books/lookup", "/books/lookup?isbn=0451524934", nil)
+		if rec.Code != http.StatusBadGateway {
+			t.Errorf("status %d, want 502", rec.Code)
+		}
+	})
+
+	t.Run("invalid ISBN", func(t *testing.T) {
+		db, _ := newMockDB(t)
+
+		rec := serveRoute(LookupBook(db, NewOpenLibraryClient("http://127.0.0.1:0")), http.MethodGet, "/books/lookup", "/books/lookup?isbn=123", nil)
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("status %d, want 400", rec.Code)
+		}
+	})
+}
+
+func TestImportBook(t *testing.T) {
+	t.Run("new author", func(t *testing.T) {
+		db, mock := newMockDB(t)
+		mock.ExpectBegin()
+		mock.ExpectQuery(sqlPattern("SELECT id FROM books WHERE isbn = ?")).WithArgs("0451524934").WillReturnRows(sqlmock.NewRows([]string{"id"}))
+		mock.ExpectQuery(sqlPattern("SELECT id FROM authors")).WithArgs("Orwell", "George").WillReturnRows(sqlmock.NewRows([]string{"id"}))
+		mock.ExpectExec(sqlPattern("INSERT INTO authors")).WithArgs("Orwell", "George").WillReturnResult(sqlmock.NewResult(7, 1))
+		mock.ExpectExec(sqlPattern("INSERT INTO books")).
+			WithArgs("1984", 7, "https://covers.openlibrary.org/b/id/1-L.jpg", "Published by Signet Classic, 1961. 328 pages", "0451524934").
+			WillReturnResult(sqlmock.NewResult(12, 1))
+		mock.ExpectExec(sqlPattern("INSERT INTO authors_books")).WithArgs(7, 12).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		client := newTestOpenLibrary(t, http.StatusOK, openLibrary1984)
+		rec := serveRoute(ImportBook(db, client), http.MethodPost, "/books/import", "/books/import?isbn=0451524934", nil)
+		if rec.Code != http.StatusCreated {
+			t.Fatalf("status %d, want 201: %s", rec.Code, rec.Body)
+		}
+		var response map[string]int
+		decodeJSON(t, rec, &response)
+		if response["id"] != 12 || response["author_id"] != 7 {
+			t.Errorf("got %v", response)
+		}
+	})
+
+	t.Run("existing author", func(t *testing.T) {
+		db, mock := newMockDB(t)
+		mock.ExpectBegin()
+		mock.ExpectQuery(sqlPattern("SELECT id FROM books WHERE isbn = ?")).WithArgs("0451524934").WillReturnRows(sqlmock.NewRows([]string{"id"}))
+		mock.ExpectQuery(sqlPattern("SELECT id FROM authors")).WithArgs("Orwell", "George").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(2))
+		mock.ExpectExec(sqlPattern("INSERT INTO books")).WillReturnResult(sqlmock.NewResult(12, 1))
+		mock.ExpectExec(sqlPattern("INSERT INTO authors_books")).WithArgs(2, 12).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		client := newTestOpenLibrary(t, http.StatusOK, openLibrary1984)
+		rec := serveRoute(ImportBook(db, client), http.MethodPost, "/books/import", "/books/import?isbn=0451524934", nil)
+		if rec.Code != http.StatusCreated {
+			t.Errorf("status %d, want 201: %s", rec.Code, rec.Body)
+		}
+	})
+
+	t.Run("already imported", func(t *testing.T) {
+		db, mock := newMockDB(t)
+		mock.ExpectBegin()
+		mock.ExpectQuery(sqlPattern("SELECT id FROM books WHERE isbn = ?")).WithArgs("0451524934").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(12))
+		mock.ExpectRollback()
+
+		client := newTestOpenLibrary(t, http.StatusOK, openLibrary1984)
+		rec := serveRoute(ImportBook(db, client), http.MethodPost, "/books/import", "/books/import?isbn=0451524934", nil)
+		if rec.Code != http.StatusConflict {
+			t.Errorf("status %d, want 409: %s", rec.Code, rec.Body)
+		}
+	})
+}
+
+func TestSplitAuthorName(t *testing.T) {
+	tests := []struct {
+		name, firstname, lastname string
+	}{
+		{name: "George Orwell", firstname: "George", lastname: "Orwell"},
+		{name: "  J. R. R.  Tolkien ", firstname: "J. R. R.", lastname: "Tolkien"},
+		{name: "Homer", firstname: "", lastname: "Homer"},
+		{name: "", firstname: "", lastname: ""},
+	}
+	for _, tt := range tests {
+		firstname, lastname := splitAuthorName(tt.name)
+		if firstname != tt.firstname || lastname != tt.lastname {
+			t.Errorf("splitAuthorName(%q) = %q, %q, want %q, %q", tt.name, firstname, lastname, tt.firstname, tt.lastname)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	"time"
+
+	"github.com/jung-kurt/gofpdf"
+)
+
+// pdfGenerator renders the subscribers as a PDF document. The export handler takes it as a parameter so
+// that the rendering can be replaced.
+type pdfGenerator func(subscribers []Subscriber) ([]byte, error)
+
+// subscribersPDFColumns are the columns of the subscriber list and their widths in millimetres, they fill
+// the width of an A4 page between its 10 mm margins
+var subscribersPDFColumns = []struct {
+	title string
+	width float64
+}{
+	{"Lastname", 40},
+	{"Firstname", 40},
+	{"Email", 75},
+	{"Phone", 35},
+}
+
+// generateSubscribersPDF lays the subscribers out as a table on A4 pages. Every page starts with the title,
+// the export date and the column headers.
+func generateSubscribersPDF(subscribers []Subscriber) ([]byte, error) {
+	pdf := gofpdf.New("P", "mm", "A4", "")
+	// The core fonts are encoded in cp1252, the names are UTF-8
+	translate := pdf.UnicodeTranslatorFromDescriptor("")
+	title := translate("Library Subscribers — exported on " + time.Now().Format("2006-01-02"))
+
+	pdf.SetHeaderFunc(func() {
+		pdf.SetFont("Helvetica", "B", 14)
+		pdf.CellFormat(0, 10, title, "", 1, "L", false, 0, "")
+		pdf.SetFont("Helvetica", "B", 10)
+		pdf.SetFillColor(230, 230, 230)
+		for _, column := range subscribersPDFColumns {
+			pdf.CellFormat(column.width, 7, column.title, "1", 0, "L", true, 0, "")
+		}
+		pdf.Ln(-1)
+		pdf.SetFont("Helvetica", "", 10)
+	})
+	pdf.AddPage()
+
+	for _, subscriber := range subscribers {
+		values := []string{subscriber.Lastname, subscriber.Firstname, subscriber.Email, subscriber.Phone}
+		for i, column := range subscribersPDFColumns {
+			pdf.CellFormat(column.width, 6, fitPDFCell(pdf, translate(values[i]), column.width-2), "1", 0, "L", false, 0, "")
+		}
+		pdf.Ln(-1)
+	}
+
+	var document bytes.Buffer
+	if err := pdf.Output(&document); err != nil {
+		return nil, err
+	}
+	return document.Bytes(), nil
+}
+
+// fitPDFCell shortens text with an ellipsis until it fits in width with the current font, a cell doesn't wrap
+func fitPDFCell(pdf *gofpdf.Fpdf, text string, width float64) string {
+	if pdf.GetStringWidth(text) <= width {
+		return text
+	}
+	for len(text) > 0 && pdf.GetStringWidth(text+"...") > width {
+		text = text[:len(text)-1]
+	}
+	return text + "..."
+}
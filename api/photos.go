@@ -0,0 +1,582 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// PhotoConfig holds the storage and limits of the photo handlers
+type PhotoConfig struct {
+	Storage   Storage // where the photos are kept
+	MaxSize   int64   // largest accepted request body in bytes
+	MaxMemory int64   // part of a multipart upload kept in memory, the rest is spooled to disk
+
+	ConvertToWebP bool // re-encode JPEG and PNG uploads as WebP
+}
+
+// loadPhotoConfig sets up the storage and the limits of the photos from cfg. CONVERT_TO_WEBP needs the cwebp tool.
+func loadPhotoConfig(cfg Config) (PhotoConfig, error) {
+	if cfg.ConvertToWebP {
+		if _, err := exec.LookPath("cwebp"); err != nil {
+			return PhotoConfig{}, fmt.Errorf("CONVERT_TO_WEBP needs cwebp: %w", err)
+		}
+	}
+	storage, err := newStorage(cfg)
+	if err != nil {
+		return PhotoConfig{}, err
+	}
+	return PhotoConfig{Storage: storage, MaxSize: cfg.MaxPhotoSize, MaxMemory: cfg.PhotoMemoryLimit, ConvertToWebP: cfg.ConvertToWebP}, nil
+}
+
+// AuthorDir is the storage key prefix of the photos of an author
+func (c PhotoConfig) AuthorDir(authorID int) string {
+	return fmt.Sprintf("%d", authorID)
+}
+
+// BookDir is the storage key prefix of the photos of a book
+func (c PhotoConfig) BookDir(bookID int) string {
+	return fmt.Sprintf("books/%d", bookID)
+}
+
+// isRemotePhoto reports whether photoURL was set with a photo-url endpoint and points outside of the storage
+func (c PhotoConfig) isRemotePhoto(photoURL string) bool {
+	if !strings.HasPrefix(photoURL, "https://") {
+		return false
+	}
+	if s3, ok := c.Storage.(*S3Storage); ok && strings.HasPrefix(photoURL, s3.PublicURL+"/") {
+		return false
+	}
+	return true
+}
+
+// removeUploadDir deletes the photos under dir after their record is gone.
+// This is best-effort: failures are logged, the record is deleted either way.
+func (c PhotoConfig) removeUploadDir(ctx context.Context, dir string) {
+	if err := c.Storage.Delete(ctx, dir); err != nil {
+		slog.Error("removing upload directory failed", "dir", dir, "error", err)
+	}
+}
+
+// imageConverter re-encodes a photo as WebP when CONVERT_TO_WEBP is enabled; tests can replace it
+var imageConverter = cwebpConvert
+
+// cwebpConvert re-encodes an image as WebP with the cwebp tool
+func cwebpConvert(src io.Reader) (io.Reader, error) {
+	in, err := os.CreateTemp("", "photo-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(in.Name())
+	_, err = io.Copy(in, src)
+	if closeErr := in.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	out := in.Name() + ".webp"
+	defer os.Remove(out)
+	if output, err := exec.Command("cwebp", "-quiet", "-q", "80", in.Name(), "-o", out).CombinedOutput(); err != nil {
+		return nil, fmt.Errorf("cwebp failed: %v: %s", err, output)
+	}
+
+	data, err := os.ReadFile(out)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.NewReader(data), nil
+}
+
+// photoExtensions maps the accepted image types to the extension their files are saved with
+var photoExtensions = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/webp": ".webp",
+}
+
+// ValidateUploadedFile sniffs the first bytes of an upload and returns its MIME type
+// if it is one of the accepted image types. The file is rewound afterwards.
+func ValidateUploadedFile(file multipart.File) (string, error) {
+	buffer := make([]byte, 512)
+	n, err := io.ReadFull(file, buffer)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", fmt.Errorf("failed to read uploaded file: %w", err)
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return "", fmt.Errorf("failed to rewind uploaded file: %w", err)
+	}
+
+	contentType := http.DetectContentType(buffer[:n])
+	if _, ok := photoExtensions[contentType]; !ok {
+		return "", fmt.Errorf("unsupported file type %s, only JPEG, PNG and WebP images are accepted", contentType)
+	}
+	return contentType, nil
+}
+
+// photoVersion names the files of an upload after a hash of its content, so a replaced photo gets new URLs
+// and clients can cache them forever. The file is rewound afterwards.
+func photoVersion(file io.ReadSeeker) (string, error) {
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", fmt.Errorf("failed to read uploaded file: %w", err)
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return "", fmt.Errorf("failed to rewind uploaded file: %w", err)
+	}
+	return hex.EncodeToString(hash.Sum(nil))[:16], nil
+}
+
+// photoKeys returns the storage keys of the fullsize photo stored at photoURL and its variants.
+// Photos stored before versioning are named fullsize.<ext>, medium.jpg and thumbnail.jpg.
+func photoKeys(dir, photoURL string) []string {
+	name := path.Base(photoURL)
+	suffix := ""
+	if strings.HasPrefix(name, "fullsize-") {
+		suffix = "-" + strings.TrimSuffix(strings.TrimPrefix(name, "fullsize-"), path.Ext(name))
+	}
+	return []string{dir + "/" + name, dir + "/medium" + suffix + ".jpg", dir + "/thumbnail" + suffix + ".jpg"}
+}
+
+// savePhoto stores the uploaded file under dir as fullsize-<version>.<ext> and returns its key and URL.
+func savePhoto(ctx context.Context, storage Storage, file io.Reader, dir, version, contentType string) (string, string, error) {
+	key := dir + "/fullsize-" + version + photoExtensions[contentType]
+	photoURL, err := storage.Save(ctx, key, file)
+	if err != nil {
+		return "", "", err
+	}
+	return key, photoURL, nil
+}
+
+// isMultipart reports whether the body of r is multipart/form-data
+func isMultipart(r *http.Request) bool {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return err == nil && mediaType == "multipart/form-data"
+}
+
+// parsePhotoForm parses a multipart request, limiting its size to config.MaxSize.
+// It writes the error response and returns false when the form can't be read.
+func parsePhotoForm(config PhotoConfig, w http.ResponseWriter, r *http.Request) bool {
+	r.Body = http.MaxBytesReader(w, r.Body, config.MaxSize)
+	if err := r.ParseMultipartForm(config.MaxMemory); err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			http.Error(w, fmt.Sprintf("Photo is too large, the limit is %d bytes", config.MaxSize), http.StatusRequestEntityTooLarge)
+			return false
+		}
+		http.Error(w, "Invalid multipart form", http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+// storePhoto validates an uploaded file, stores it with its variants under dir and saves its URL in the photo
+// column of the record id of table. On failure it returns the HTTP status and an error meant for the client.
+func storePhoto(ctx context.Context, db *sql.DB, config PhotoConfig, file multipart.File, table, dir string, id int) (PhotoVariants, int, error) {
+	contentType, err := ValidateUploadedFile(file)
+	if err != nil {
+		return PhotoVariants{}, http.StatusBadRequest, err
+	}
+
+	// Re-encode JPEG and PNG uploads as WebP when enabled
+	var photo io.ReadSeeker = file
+	if config.ConvertToWebP && contentType != "image/webp" {
+		converted, err := imageConverter(file)
+		if err != nil {
+			slog.Error("converting photo to WebP failed", "error", err)
+			return PhotoVariants{}, http.StatusInternalServerError, errors.New("failed to convert photo")
+		}
+		data, err := io.ReadAll(converted)
+		if err != nil {
+			return PhotoVariants{}, http.StatusInternalServerError, errors.New("failed to convert photo")
+		}
+		photo = bytes.NewReader(data)
+		contentType = "image/webp"
+	}
+
+	version, err := photoVersion(photo)
+	if err != nil {
+		slog.Error("hashing photo failed", "error", err)
+		return PhotoVariants{}, http.StatusInternalServerError, errors.New("failed to read photo")
+	}
+
+	var previous sql.NullString
+	err = db.QueryRowContext(ctx, "SELECT photo FROM "+table+" WHERE id = ?", id).Scan(&previous)
+	if err != nil {
+		return PhotoVariants{}, http.StatusInternalServerError, fmt.Errorf("failed to read current photo: %v", err)
+	}
+
+	photoKey, photoPath, err := savePhoto(ctx, config.Storage, photo, dir, version, contentType)
+	if err != nil {
+		slog.Error("saving photo failed", "error", err)
+		return PhotoVariants{}, http.StatusInternalServerError, errors.New("failed to save photo")
+	}
+
+	// Generate the smaller variants from the same upload
+	if _, err := photo.Seek(0, io.SeekStart); err != nil {
+		config.Storage.Delete(ctx, photoKey)
+		return PhotoVariants{}, http.StatusInternalServerError, errors.New("failed to read photo")
+	}
+	variants, err := GenerateSizes(ctx, config.Storage, photo, dir, version)
+	if err != nil {
+		config.Storage.Delete(ctx, photoKey)
+		if errors.Is(err, errInvalidImage) {
+			return PhotoVariants{}, http.StatusBadRequest, errors.New("uploaded file is not a valid image")
+		}
+		slog.Error("resizing photo failed", "error", err)
+		return PhotoVariants{}, http.StatusInternalServerError, errors.New("failed to resize photo")
+	}
+	variants.Fullsize = photoPath
+
+	_, err = db.ExecContext(ctx, "UPDATE "+table+" SET photo = ? WHERE id = ?", photoPath, id)
+	if err != nil {
+		// The files of the same picture uploaded again are still those of the record
+		if previous.String != photoPath {
+			for _, key := range photoKeys(dir, photoPath) {
+				config.Storage.Delete(ctx, key)
+			}
+		}
+		return PhotoVariants{}, http.StatusInternalServerError, fmt.Errorf("failed to update photo: %v", err)
+	}
+
+	// Record the upload. The API has no user accounts yet, so the uploader is left NULL.
+	_, err = db.ExecContext(ctx, `
+		INSERT INTO photo_uploads (entity_type, entity_id, file_path, uploaded_by_user_id, uploaded_at)
+		VALUES (?, ?, ?, NULL, NOW())
+	`, table, id, photoPath)
+	if err != nil {
+		slog.Error("recording photo upload failed", "table", table, "id", id, "error", err)
+	}
+
+	// Remove the files of the photo this upload replaces, unless it is the same picture uploaded again
+	if previous.String != "" && previous.String != photoPath && !config.isRemotePhoto(previous.String) {
+		for _, key := range photoKeys(dir, previous.String) {
+			if err := config.Storage.Delete(ctx, key); err != nil {
+				slog.Error("removing superseded photo failed", "key", key, "error", err)
+			}
+		}
+	}
+
+	return variants, http.StatusOK, nil
+}
+
+// uploadPhoto stores the "file" field of a multipart request under dir and saves its URL in the photo column of table.
+func uploadPhoto(db *sql.DB, config PhotoConfig, w http.ResponseWriter, r *http.Request, table, dir string, id int) {
+	var exists bool
+	err := db.QueryRowContext(r.Context(), "SELECT EXISTS(SELECT 1 FROM "+table+" WHERE id = ?)", id).Scan(&exists)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !exists {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+
+	if !parsePhotoForm(config, w, r) {
+		return
+	}
+	defer r.MultipartForm.RemoveAll()
+
+	file, _, err := r.FormFile("file")
+	if err != nil {
+		http.Error(w, "Missing file field", http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+
+	variants, status, err := storePhoto(r.Context(), db, config, file, table, dir, id)
+	if err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	RespondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"photo":    variants.Fullsize,
+		"variants": variants,
+	})
+}
+
+// attachFormPhoto stores the optional "photo" file of a multipart create request for the record just inserted
+// and adds the outcome to response. The record is kept when the photo fails; the response then carries a warning.
+func attachFormPhoto(db *sql.DB, config PhotoConfig, r *http.Request, table, dir string, id int, response map[string]interface{}) {
+	file, _, err := r.FormFile("photo")
+	if err == http.ErrMissingFile {
+		return
+	}
+	if err != nil {
+		response["warning"] = fmt.Sprintf("created without photo: %v", err)
+		return
+	}
+	defer file.Close()
+
+	variants, _, err := storePhoto(r.Context(), db, config, file, table, dir, id)
+	if err != nil {
+		response["warning"] = fmt.Sprintf("created without photo: %v", err)
+		return
+	}
+	response["photo"] = variants.Fullsize
+	response["variants"] = variants
+}
+
+// AddAuthorPhoto uploads the photo of an author to {id}/ in the storage
+func AddAuthorPhoto(db *sql.DB, config PhotoConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		authorID, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			http.Error(w, "Invalid author ID", http.StatusBadRequest)
+			return
+		}
+
+		uploadPhoto(db, config, w, r, "authors", config.AuthorDir(authorID), authorID)
+	}
+}
+
+// AddBookPhoto uploads the photo of a book to books/{id}/ in the storage
+func AddBookPhoto(db *sql.DB, config PhotoConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		bookID, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			http.Error(w, "Invalid book ID", http.StatusBadRequest)
+			return
+		}
+
+		uploadPhoto(db, config, w, r, "books", config.BookDir(bookID), bookID)
+	}
+}
+
+// deletePhoto clears the photo column of a record in table and then removes its stored photo with every variant.
+// The column is cleared first so a failed removal can only leave unreferenced files behind.
+func deletePhoto(db *sql.DB, config PhotoConfig, w http.ResponseWriter, r *http.Request, table, dir string, id int) {
+	var photo sql.NullString
+	err := db.QueryRowContext(r.Context(), "SELECT photo FROM "+table+" WHERE id = ?", id).Scan(&photo)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if photo.String == "" {
+		http.Error(w, "No photo to delete", http.StatusNotFound)
+		return
+	}
+
+	_, err = db.ExecContext(r.Context(), "UPDATE "+table+" SET photo = '' WHERE id = ?", id)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to clear photo: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	config.removeUploadDir(r.Context(), dir)
+
+	RespondWithJSON(w, http.StatusOK, map[string]string{"message": "Photo deleted successfully"})
+}
+
+// DeleteAuthorPhoto removes the photo of an author
+func DeleteAuthorPhoto(db *sql.DB, config PhotoConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		authorID, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			http.Error(w, "Invalid author ID", http.StatusBadRequest)
+			return
+		}
+
+		deletePhoto(db, config, w, r, "authors", config.AuthorDir(authorID), authorID)
+	}
+}
+
+// DeleteBookPhoto removes the photo of a book
+func DeleteBookPhoto(db *sql.DB, config PhotoConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		bookID, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			http.Error(w, "Invalid book ID", http.StatusBadRequest)
+			return
+		}
+
+		deletePhoto(db, config, w, r, "books", config.BookDir(bookID), bookID)
+	}
+}
+
+// servePhoto serves the photo of a record in table, the medium or thumbnail variant with ?size=medium or
+// ?size=thumbnail. The file is looked up in the storage below dir whatever path the column holds, so a
+// tampered value can't reach files outside of the upload directory. Photos kept on S3 and remote photos
+// are redirected to.
+func servePhoto(db *sql.DB, config PhotoConfig, w http.ResponseWriter, r *http.Request, table, dir string, id int) {
+	var photo sql.NullString
+	err := db.QueryRowContext(r.Context(), "SELECT photo FROM "+table+" WHERE id = ?", id).Scan(&photo)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if photo.String == "" {
+		http.Error(w, "No photo", http.StatusNotFound)
+		return
+	}
+
+	// A remote photo has no smaller variants
+	if config.isRemotePhoto(photo.String) {
+		http.Redirect(w, r, photo.String, http.StatusFound)
+		return
+	}
+
+	keys := photoKeys(dir, photo.String)
+	key := keys[0]
+	switch size := r.URL.Query().Get("size"); size {
+	case "", "fullsize":
+	case "medium":
+		key = keys[1]
+	case "thumbnail":
+		key = keys[2]
+	default:
+		http.Error(w, "size must be fullsize, medium or thumbnail", http.StatusBadRequest)
+		return
+	}
+
+	local, ok := config.Storage.(*LocalStorage)
+	if !ok {
+		http.Redirect(w, r, config.Storage.URL(key), http.StatusFound)
+		return
+	}
+	file, err := local.File(key)
+	if err != nil {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+	if info, err := os.Stat(file); err != nil || info.IsDir() {
+		http.Error(w, "Photo file not found", http.StatusNotFound)
+		return
+	}
+	http.ServeFile(w, r, file)
+}
+
+// authorPhotoURL is the absolute URL ServeAuthorPhoto serves the photo of an author at
+func authorPhotoURL(baseURL string, authorID int) string {
+	return fmt.Sprintf("%s%s/author/photo/%d", baseURL, apiV1Prefix, authorID)
+}
+
+// ServeAuthorPhoto serves the photo of an author
+func ServeAuthorPhoto(db *sql.DB, config PhotoConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		authorID, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			http.Error(w, "Invalid author ID", http.StatusBadRequest)
+			return
+		}
+
+		servePhoto(db, config, w, r, "authors", config.AuthorDir(authorID), authorID)
+	}
+}
+
+// ServeBookPhoto serves the photo of a book
+func ServeBookPhoto(db *sql.DB, config PhotoConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		bookID, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			http.Error(w, "Invalid book ID", http.StatusBadRequest)
+			return
+		}
+
+		servePhoto(db, config, w, r, "books", config.BookDir(bookID), bookID)
+	}
+}
+
+// setPhotoURL points the photo of a record in table to a remote HTTPS URL, such as a CDN, from a
+// {"photo_url": "..."} body. The photos stored under dir are removed since they are no longer used.
+func setPhotoURL(db *sql.DB, config PhotoConfig, w http.ResponseWriter, r *http.Request, table, dir string, id int) {
+	var requestBody struct {
+		PhotoURL string `json:"photo_url"`
+	}
+	if err := StrictJSONDecoder(r.Body, &requestBody); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	photoURL := strings.TrimSpace(requestBody.PhotoURL)
+	parsed, err := url.ParseRequestURI(photoURL)
+	if err != nil || parsed.Host == "" {
+		http.Error(w, "photo_url must be a valid URL", http.StatusBadRequest)
+		return
+	}
+	if parsed.Scheme != "https" || !strings.HasPrefix(photoURL, "https://") {
+		http.Error(w, "photo_url must start with https://", http.StatusBadRequest)
+		return
+	}
+	if err := validateOptionalField("photo_url", photoURL, maxPhotoURLLength); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var previous sql.NullString
+	err = db.QueryRowContext(r.Context(), "SELECT photo FROM "+table+" WHERE id = ?", id).Scan(&previous)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	_, err = db.ExecContext(r.Context(), "UPDATE "+table+" SET photo = ? WHERE id = ?", photoURL, id)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to update photo: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if previous.String != "" && !config.isRemotePhoto(previous.String) {
+		config.removeUploadDir(r.Context(), dir)
+	}
+
+	RespondWithJSON(w, http.StatusOK, map[string]string{"message": "Photo URL updated successfully", "photo": photoURL})
+}
+
+// SetAuthorPhotoURL sets a remote photo URL for an author
+func SetAuthorPhotoURL(db *sql.DB, config PhotoConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		authorID, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			http.Error(w, "Invalid author ID", http.StatusBadRequest)
+			return
+		}
+
+		setPhotoURL(db, config, w, r, "authors", config.AuthorDir(authorID), authorID)
+	}
+}
+
+// SetBookPhotoURL sets a remote photo URL for a book
+func SetBookPhotoURL(db *sql.DB, config PhotoConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		bookID, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			http.Error(w, "Invalid book ID", http.StatusBadRequest)
+			return
+		}
+
+		setPhotoURL(db, config, w, r, "books", config.BookDir(bookID), bookID)
+	}
+}
@@ -0,0 +1,589 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gorilla/mux"
+)
+
+func TestValidateUploadedFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		content []byte
+		want    string
+		wantErr bool
+	}{
+		{name: "png", content: pngPhoto(t), want: "image/png"},
+		{name: "jpeg", content: append([]byte{0xFF, 0xD8, 0xFF, 0xE0}, make([]byte, 16)...), want: "image/jpeg"},
+		{name: "text named .jpg", content: []byte("this is not an image, just text"), wantErr: true},
+		{name: "empty", content: nil, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ValidateUploadedFile(nopCloserFile{bytes.NewReader(tt.content)})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error %v, want error %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// nopCloserFile is a multipart.File reading from memory
+type nopCloserFile struct {
+	*bytes.Reader
+}
+
+func (nopCloserFile) Close() error { return nil }
+
+func TestUploadPhotoLimits(t *testing.T) {
+	tests := []struct {
+		name  string
+		photo []byte
+		want  int
+	}{
+		{name: "oversized payload", photo: bytes.Repeat([]byte{0xFF}, 4096), want: http.StatusRequestEntityTooLarge},
+		{name: "text masquerading as an image", photo: []byte("plain text in a file called photo.png"), want: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			storage := newMemStorage()
+			config := PhotoConfig{Storage: storage, MaxSize: 1024, MaxMemory: 512}
+			db, mock := newMockDB(t)
+			mock.ExpectQuery(sqlPattern("SELECT EXISTS(SELECT 1 FROM books WHERE id = ?)")).WithArgs(4).
+				WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+
+			router := mux.NewRouter()
+			router.Handle("/books/photo/{id}", AddBookPhoto(db, config))
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, photoUploadRequest(t, "/books/photo/4", tt.photo))
+			if rec.Code != tt.want {
+				t.Errorf("status %d, want %d: %s", rec.Code, tt.want, rec.Body)
+			}
+			if keys := storage.keys(); len(keys) != 0 {
+				t.Errorf("stored %v, want nothing", keys)
+			}
+		})
+	}
+}
+
+func TestPhotoExtensionMatchesType(t *testing.T) {
+	storage := newMemStorage()
+	for contentType, extension := range photoExtensions {
+		key, _, err := savePhoto(context.Background(), storage, strings.NewReader("photo"), "7", "abc", contentType)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := "7/fullsize-abc" + extension; key != want {
+			t.Errorf("%s saved as %s, want %s", contentType, key, want)
+		}
+	}
+}
+
+func TestDeleteAuthorPhoto(t *testing.T) {
+	t.Run("deleted", func(t *testing.T) {
+		storage := newMemStorage()
+		storage.objects["2/fullsize-abc.png"] = []byte("photo")
+		storage.objects["2/medium-abc.jpg"] = []byte("photo")
+		storage.objects["2/thumbnail-abc.jpg"] = []byte("photo")
+		db, mock := newMockDB(t)
+		mock.ExpectQuery(sqlPattern("SELECT photo FROM authors WHERE id = ?")).WithArgs(2).
+			WillReturnRows(sqlmock.NewRows([]string{"photo"}).AddRow("/mem/2/fullsize-abc.png"))
+		mock.ExpectExec(sqlPattern("UPDATE authors SET photo = '' WHERE id = ?")).WithArgs(2).WillReturnResult(sqlmock.NewResult(0, 1))
+
+		rec := serveRoute(DeleteAuthorPhoto(db, PhotoConfig{Storage: storage}), http.MethodDelete, "/author/photo/{id}", "/author/photo/2", nil)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status %d, want 200: %s", rec.Code, rec.Body)
+		}
+		if keys := storage.keys(); len(keys) != 0 {
+			t.Errorf("stored %v, want every variant removed", keys)
+		}
+	})
+
+	t.Run("files kept when the column can't be cleared", func(t *testing.T) {
+		storage := newMemStorage()
+		storage.objects["2/fullsize-abc.png"] = []byte("photo")
+		db, mock := newMockDB(t)
+		mock.ExpectQuery(sqlPattern("SELECT photo FROM authors WHERE id = ?")).WithArgs(2).
+			WillReturnRows(sqlmock.NewRows([]string{"photo"}).AddRow("/mem/2/fullsize-abc.png"))
+		mock.ExpectExec(sqlPattern("UPDATE authors SET photo = ''")).WithArgs(2).WillReturnError(errors.New("connection lost"))
+
+		rec := serveRoute(DeleteAuthorPhoto(db, PhotoConfig{Storage: storage}), http.MethodDelete, "/author/photo/{id}", "/author/photo/2", nil)
+		if rec.Code != http.StatusInternalServerError {
+			t.Errorf("status %d, want 500", rec.Code)
+		}
+		if keys := storage.keys(); len(keys) != 1 {
+			t.Errorf("stored %v, want the photo kept", keys)
+		}
+	})
+
+	for name, rows := range map[string]*sqlmock.Rows{
+		"no photo":       sqlmock.NewRows([]string{"photo"}).AddRow(""),
+		"unknown author": sqlmock.NewRows([]string{"photo"}),
+	} {
+		t.Run(name, func(t *testing.T) {
+			db, mock := newMockDB(t)
+			mock.ExpectQuery(sqlPattern("SELECT photo FROM authors WHERE id = ?")).WithArgs(2).WillReturnRows(rows)
+
+			rec := serveRoute(DeleteAuthorPhoto(db, PhotoConfig{Storage: newMemStorage()}), http.MethodDelete, "/author/photo/{id}", "/author/photo/2", nil)
+			if rec.Code != http.StatusNotFound {
+				t.Errorf("status %d, want 404", rec.Code)
+			}
+		})
+	}
+}
+
+// failingStorage is a Storage whose deletes fail
+type failingStorage struct {
+	*memStorage
+}
+
+func (failingStorage) Delete(ctx context.Context, key string) error {
+	return errors.New("permission denied")
+}
+
+func TestDeleteRemovesUploadDirectories(t *testing.T) {
+	t.Run("author", func(t *testing.T) {
+		storage := newMemStorage()
+		storage.objects["6/fullsize-abc.png"] = []byte("photo")
+		storage.objects["books/6/fullsize-def.png"] = []byte("photo of book 6")
+		db, mock := newMockDB(t)
+		mock.ExpectQuery(sqlPattern("FROM books")).WithArgs(6, 6).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+		mock.ExpectExec(sqlPattern("DELETE FROM authors")).WithArgs(6).WillReturnResult(sqlmock.NewResult(0, 1))
+		expectAudit(mock, "delete", "author", 6)
+
+		rec := serveRoute(DeleteAuthor(db, PhotoConfig{Storage: storage}), http.MethodDelete, "/authors/{id}", "/authors/6", nil)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status %d, want 200: %s", rec.Code, rec.Body)
+		}
+		if keys := storage.keys(); len(keys) != 1 || keys[0] != "books/6/fullsize-def.png" {
+			t.Errorf("stored %v, want only the photo of book 6", keys)
+		}
+	})
+
+	t.Run("book", func(t *testing.T) {
+		storage := newMemStorage()
+		storage.objects["books/5/fullsize-abc.png"] = []byte("photo")
+		storage.objects["2/fullsize-def.png"] = []byte("photo of author 2")
+		db, mock := newMockDB(t)
+		mock.ExpectBegin()
+		mock.ExpectQuery(sqlPattern("SELECT author_id")).WithArgs(5).WillReturnRows(sqlmock.NewRows([]string{"author_id"}).AddRow(2))
+		mock.ExpectQuery(sqlPattern("return_date IS NULL")).WithArgs(5).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+		for _, table := range []string{"borrowed_books", "book_tags", "book_genres", "reviews", "authors_books"} {
+			mock.ExpectExec(sqlPattern("DELETE FROM " + table)).WithArgs(5).WillReturnResult(sqlmock.NewResult(0, 0))
+		}
+		mock.ExpectQuery(sqlPattern("WHERE author_id = ? AND id != ?")).WithArgs(2, 5, 2).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+		mock.ExpectExec(sqlPattern("DELETE FROM books")).WithArgs(5).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+		expectAudit(mock, "delete", "book", 5)
+
+		rec := serveRoute(DeleteBook(db, PhotoConfig{Storage: storage}), http.MethodDelete, "/books/{id}", "/books/5", nil)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status %d, want 200: %s", rec.Code, rec.Body)
+		}
+		if keys := storage.keys(); len(keys) != 1 || keys[0] != "2/fullsize-def.png" {
+			t.Errorf("stored %v, want only the photo of the author, who has other books", keys)
+		}
+	})
+
+	t.Run("author not deleted", func(t *testing.T) {
+		storage := newMemStorage()
+		storage.objects["6/fullsize-abc.png"] = []byte("photo")
+		db, mock := newMockDB(t)
+		mock.ExpectQuery(sqlPattern("FROM books")).WithArgs(6, 6).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+		mock.ExpectExec(sqlPattern("DELETE FROM authors")).WithArgs(6).WillReturnError(errors.New("connection lost"))
+
+		rec := serveRoute(DeleteAuthor(db, PhotoConfig{Storage: storage}), http.MethodDelete, "/authors/{id}", "/authors/6", nil)
+		if rec.Code != http.StatusInternalServerError {
+			t.Fatalf("status %d, want 500", rec.Code)
+		}
+		if keys := storage.keys(); len(keys) != 1 {
+			t.Errorf("stored %v, want the photo kept", keys)
+		}
+	})
+
+	t.Run("removal fails", func(t *testing.T) {
+		db, mock := newMockDB(t)
+		mock.ExpectQuery(sqlPattern("FROM books")).WithArgs(6, 6).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+		mock.ExpectExec(sqlPattern("DELETE FROM authors")).WithArgs(6).WillReturnResult(sqlmock.NewResult(0, 1))
+		expectAudit(mock, "delete", "author", 6)
+
+		config := PhotoConfig{Storage: failingStorage{newMemStorage()}}
+		rec := serveRoute(DeleteAuthor(db, config), http.MethodDelete, "/authors/{id}", "/authors/6", nil)
+		if rec.Code != http.StatusOK {
+			t.Errorf("status %d, want 200 although the photos couldn't be removed: %s", rec.Code, rec.Body)
+		}
+	})
+}
+
+func TestPhotoUploadRecorded(t *testing.T) {
+	for name, recordErr := range map[string]error{"recorded": nil, "recording fails": errors.New("table is locked")} {
+		t.Run(name, func(t *testing.T) {
+			db, mock := newMockDB(t)
+			mock.ExpectQuery(sqlPattern("SELECT EXISTS(SELECT 1 FROM books WHERE id = ?)")).WithArgs(4).
+				WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+			mock.ExpectQuery(sqlPattern("SELECT photo FROM books WHERE id = ?")).WithArgs(4).WillReturnRows(sqlmock.NewRows([]string{"photo"}).AddRow(""))
+			mock.ExpectExec(sqlPattern("UPDATE books SET photo = ?")).WithArgs(sqlmock.AnyArg(), 4).WillReturnResult(sqlmock.NewResult(0, 1))
+			// There are no user accounts, so the uploader is NULL
+			record := mock.ExpectExec(sqlPattern("VALUES (?, ?, ?, NULL, NOW())")).WithArgs("books", 4, sqlmock.AnyArg())
+			if recordErr != nil {
+				record.WillReturnError(recordErr)
+			} else {
+				record.WillReturnResult(sqlmock.NewResult(1, 1))
+			}
+
+			router := mux.NewRouter()
+			router.Handle("/books/photo/{id}", AddBookPhoto(db, PhotoConfig{Storage: newMemStorage(), MaxSize: 1 << 20, MaxMemory: 1 << 20}))
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, photoUploadRequest(t, "/books/photo/4", pngPhoto(t)))
+			if rec.Code != http.StatusOK {
+				t.Errorf("status %d, want 200: %s", rec.Code, rec.Body)
+			}
+		})
+	}
+}
+
+// createFormRequest builds a multipart create request with fields and an optional "photo" file
+func createFormRequest(t *testing.T, target string, fields map[string]string, photo []byte) *http.Request {
+	t.Helper()
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	for name, value := range fields {
+		form.WriteField(name, value)
+	}
+	if photo != nil {
+		part, err := form.CreateFormFile("photo", "photo.png")
+		if err != nil {
+			t.Fatal(err)
+		}
+		part.Write(photo)
+	}
+	form.Close()
+	req := httptest.NewRequest(http.MethodPost, target, &body)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	return req
+}
+
+func TestAddAuthorWithPhoto(t *testing.T) {
+	fields := map[string]string{"firstname": "George", "lastname": "Orwell"}
+	expectAuthorInsert := func(mock sqlmock.Sqlmock) {
+		mock.ExpectQuery(sqlPattern("SELECT id FROM authors WHERE Lastname = ?")).WithArgs("Orwell", "George").
+			WillReturnRows(sqlmock.NewRows([]string{"id"}))
+		mock.ExpectExec(sqlPattern("INSERT INTO authors")).WithArgs("Orwell", "George", "").WillReturnResult(sqlmock.NewResult(5, 1))
+		expectAudit(mock, "create", "author", 5)
+	}
+
+	t.Run("with a photo", func(t *testing.T) {
+		storage := newMemStorage()
+		db, mock := newMockDB(t)
+		expectAuthorInsert(mock)
+		mock.ExpectQuery(sqlPattern("SELECT photo FROM authors WHERE id = ?")).WithArgs(5).WillReturnRows(sqlmock.NewRows([]string{"photo"}).AddRow(""))
+		mock.ExpectExec(sqlPattern("UPDATE authors SET photo = ?")).WithArgs(sqlmock.AnyArg(), 5).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(sqlPattern("INSERT INTO photo_uploads")).WillReturnResult(sqlmock.NewResult(1, 1))
+
+		rec := httptest.NewRecorder()
+		AddAuthor(db, PhotoConfig{Storage: storage, MaxSize: 1 << 20, MaxMemory: 1 << 20}).
+			ServeHTTP(rec, createFormRequest(t, "/authors/new", fields, pngPhoto(t)))
+		if rec.Code != http.StatusCreated {
+			t.Fatalf("status %d, want 201: %s", rec.Code, rec.Body)
+		}
+		var response map[string]interface{}
+		decodeJSON(t, rec, &response)
+		if photo, _ := response["photo"].(string); !strings.HasPrefix(photo, "/mem/5/fullsize-") {
+			t.Errorf("got %v, want the stored photo", response)
+		}
+		if len(storage.keys()) != 3 {
+			t.Errorf("stored %v, want the photo and its two variants", storage.keys())
+		}
+	})
+
+	t.Run("without a photo", func(t *testing.T) {
+		db, mock := newMockDB(t)
+		expectAuthorInsert(mock)
+
+		rec := httptest.NewRecorder()
+		AddAuthor(db, PhotoConfig{Storage: newMemStorage(), MaxSize: 1 << 20, MaxMemory: 1 << 20}).
+			ServeHTTP(rec, createFormRequest(t, "/authors/new", fields, nil))
+		if rec.Code != http.StatusCreated {
+			t.Fatalf("status %d, want 201: %s", rec.Code, rec.Body)
+		}
+		var response map[string]interface{}
+		decodeJSON(t, rec, &response)
+		if _, ok := response["photo"]; ok {
+			t.Errorf("got %v, want no photo", response)
+		}
+	})
+
+	t.Run("invalid photo", func(t *testing.T) {
+		db, mock := newMockDB(t)
+		expectAuthorInsert(mock)
+
+		rec := httptest.NewRecorder()
+		AddAuthor(db, PhotoConfig{Storage: newMemStorage(), MaxSize: 1 << 20, MaxMemory: 1 << 20}).
+			ServeHTTP(rec, createFormRequest(t, "/authors/new", fields, []byte("not an image")))
+		if rec.Code != http.StatusCreated {
+			t.Fatalf("status %d, want 201: %s", rec.Code, rec.Body)
+		}
+		var response map[string]interface{}
+		decodeJSON(t, rec, &response)
+		if warning, _ := response["warning"].(string); !strings.HasPrefix(warning, "created without photo") {
+			t.Errorf("got %v, want a warning", response)
+		}
+	})
+}
+
+func TestPhotoVersion(t *testing.T) {
+	first, err := photoVersion(bytes.NewReader([]byte("first photo")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, _ := photoVersion(bytes.NewReader([]byte("first photo")))
+	second, _ := photoVersion(bytes.NewReader([]byte("second photo")))
+	if first != again {
+		t.Errorf("the same content got versions %s and %s", first, again)
+	}
+	if first == second {
+		t.Errorf("different contents got the same version %s", first)
+	}
+}
+
+func TestPhotoKeys(t *testing.T) {
+	tests := []struct {
+		photoURL string
+		want     []string
+	}{
+		{photoURL: "/upload/books/3/fullsize-abc.png", want: []string{"books/3/fullsize-abc.png", "books/3/medium-abc.jpg", "books/3/thumbnail-abc.jpg"}},
+		{photoURL: "./upload/books/3/fullsize.jpg", want: []string{"books/3/fullsize.jpg", "books/3/medium.jpg", "books/3/thumbnail.jpg"}},
+	}
+	for _, tt := range tests {
+		if got := photoKeys("books/3", tt.photoURL); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("photoKeys(%q) = %q, want %q", tt.photoURL, got, tt.want)
+		}
+	}
+}
+
+func TestReplacePhotoChangesURL(t *testing.T) {
+	storage := newMemStorage()
+	config := PhotoConfig{Storage: storage, MaxSize: 1 << 20, MaxMemory: 1 << 20}
+	upload := func(previous string) string {
+		db, mock := newMockDB(t)
+		mock.ExpectQuery(sqlPattern("SELECT EXISTS")).WithArgs(4).WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+		mock.ExpectQuery(sqlPattern("SELECT photo FROM books")).WithArgs(4).WillReturnRows(sqlmock.NewRows([]string{"photo"}).AddRow(previous))
+		mock.ExpectExec(sqlPattern("UPDATE books SET photo = ?")).WithArgs(sqlmock.AnyArg(), 4).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(sqlPattern("INSERT INTO photo_uploads")).WillReturnResult(sqlmock.NewResult(1, 1))
+
+		router := mux.NewRouter()
+		router.Handle("/books/photo/{id}", AddBookPhoto(db, config))
+		rec := httptest.NewRecorder()
+		photo := pngPhoto(t)
+		if previous != "" {
+			photo = append(photo, "another version"...)
+		}
+		router.ServeHTTP(rec, photoUploadRequest(t, "/books/photo/4", photo))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status %d, want 200: %s", rec.Code, rec.Body)
+		}
+		var response struct {
+			Photo string `json:"photo"`
+		}
+		decodeJSON(t, rec, &response)
+		return response.Photo
+	}
+
+	first := upload("")
+	second := upload(first)
+	if first == second {
+		t.Fatalf("the replaced photo kept its URL %s", first)
+	}
+	for _, key := range storage.keys() {
+		if "/mem/"+key == first {
+			t.Errorf("the superseded photo %s is still stored", key)
+		}
+	}
+}
+
+func TestLocalStorageCacheControl(t *testing.T) {
+	storage, err := NewLocalStorage(t.TempDir(), "/upload")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := storage.Save(context.Background(), "4/fullsize-abc.png", bytes.NewReader(pngPhoto(t))); err != nil {
+		t.Fatal(err)
+	}
+
+	rec := httptest.NewRecorder()
+	storage.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/upload/4/fullsize-abc.png", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d, want 200", rec.Code)
+	}
+	if got := rec.Header().Get("Cache-Control"); got != immutableCacheControl {
+		t.Errorf("Cache-Control %q, want %q", got, immutableCacheControl)
+	}
+}
+
+// stubImageConverter replaces imageConverter for the duration of a test
+func stubImageConverter(t *testing.T, converter func(src io.Reader) (io.Reader, error)) {
+	previous := imageConverter
+	imageConverter = converter
+	t.Cleanup(func() { imageConverter = previous })
+}
+
+func TestConvertPhotoToWebP(t *testing.T) {
+	config := func(storage Storage) PhotoConfig {
+		return PhotoConfig{Storage: storage, MaxSize: 1 << 20, MaxMemory: 1 << 20, ConvertToWebP: true}
+	}
+
+	t.Run("converted", func(t *testing.T) {
+		converted := 0
+		// The stub keeps the PNG bytes, only the stored type changes
+		stubImageConverter(t, func(src io.Reader) (io.Reader, error) {
+			converted++
+			return src, nil
+		})
+		storage := newMemStorage()
+		db, mock := newMockDB(t)
+		mock.ExpectQuery(sqlPattern("SELECT EXISTS")).WithArgs(4).WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+		mock.ExpectQuery(sqlPattern("SELECT photo FROM books")).WithArgs(4).WillReturnRows(sqlmock.NewRows([]string{"photo"}).AddRow(""))
+		mock.ExpectExec(sqlPattern("UPDATE books SET photo = ?")).WithArgs(sqlmock.AnyArg(), 4).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(sqlPattern("INSERT INTO photo_uploads")).WillReturnResult(sqlmock.NewResult(1, 1))
+
+		router := mux.NewRouter()
+		router.Handle("/books/photo/{id}", AddBookPhoto(db, config(storage)))
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, photoUploadRequest(t, "/books/photo/4", pngPhoto(t)))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status %d, want 200: %s", rec.Code, rec.Body)
+		}
+		var response struct {
+			Photo string `json:"photo"`
+		}
+		decodeJSON(t, rec, &response)
+		if converted != 1 || !strings.HasSuffix(response.Photo, ".webp") {
+			t.Errorf("converted %d times into %q, want once into a .webp", converted, response.Photo)
+		}
+	})
+
+	t.Run("conversion fails", func(t *testing.T) {
+		stubImageConverter(t, func(src io.Reader) (io.Reader, error) {
+			return nil, errors.New("cwebp: exit status 1")
+		})
+		storage := newMemStorage()
+		db, mock := newMockDB(t)
+		mock.ExpectQuery(sqlPattern("SELECT EXISTS")).WithArgs(4).WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+
+		router := mux.NewRouter()
+		router.Handle("/books/photo/{id}", AddBookPhoto(db, config(storage)))
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, photoUploadRequest(t, "/books/photo/4", pngPhoto(t)))
+		if rec.Code != http.StatusInternalServerError {
+			t.Errorf("status %d, want 500", rec.Code)
+		}
+		if keys := storage.keys(); len(keys) != 0 {
+			t.Errorf("stored %v, want nothing", keys)
+		}
+	})
+}
+
+func TestServePhoto(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler func(*sql.DB, PhotoConfig) http.HandlerFunc
+		table   string
+		target  string
+		photo   interface{}
+		file    string // the file written in the upload directory
+		want    int
+	}{
+		{name: "author", handler: ServeAuthorPhoto, table: "authors", target: "/photo/3", photo: "/upload/3/fullsize-v1.jpg",
+			file: "3/fullsize-v1.jpg", want: http.StatusOK},
+		{name: "book thumbnail", handler: ServeBookPhoto, table: "books", target: "/photo/3?size=thumbnail", photo: "/upload/books/3/fullsize-v1.png",
+			file: "books/3/thumbnail-v1.jpg", want: http.StatusOK},
+		{name: "legacy path", handler: ServeAuthorPhoto, table: "authors", target: "/photo/3", photo: "./upload/3/fullsize.jpg",
+			file: "3/fullsize.jpg", want: http.StatusOK},
+		{name: "missing file", handler: ServeAuthorPhoto, table: "authors", target: "/photo/3", photo: "/upload/3/fullsize-v1.jpg",
+			want: http.StatusNotFound},
+		{name: "path outside of the upload directory", handler: ServeAuthorPhoto, table: "authors", target: "/photo/3", photo: "../../secret.jpg",
+			file: "../secret.jpg", want: http.StatusNotFound},
+		{name: "no photo", handler: ServeBookPhoto, table: "books", target: "/photo/3", photo: nil, want: http.StatusNotFound},
+		{name: "invalid size", handler: ServeAuthorPhoto, table: "authors", target: "/photo/3?size=huge", photo: "/upload/3/fullsize-v1.jpg",
+			file: "3/fullsize-v1.jpg", want: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock := newMockDB(t)
+			config := newTestPhotoConfig(t)
+			mock.ExpectQuery(sqlPattern("SELECT photo FROM " + tt.table + " WHERE id = ?")).WithArgs(3).
+				WillReturnRows(sqlmock.NewRows([]string{"photo"}).AddRow(tt.photo))
+			if tt.file != "" {
+				file := filepath.Join(config.Storage.(*LocalStorage).Dir, filepath.FromSlash(tt.file))
+				if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(file, []byte("photo of "+tt.file), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			rec := serveRoute(tt.handler(db, config), http.MethodGet, "/photo/{id}", tt.target, nil)
+			if rec.Code != tt.want {
+				t.Fatalf("status %d, want %d: %s", rec.Code, tt.want, rec.Body)
+			}
+			if tt.want == http.StatusOK && rec.Body.String() != "photo of "+tt.file {
+				t.Errorf("served %q, want the photo file", rec.Body)
+			}
+		})
+	}
+
+	t.Run("unknown author", func(t *testing.T) {
+		db, mock := newMockDB(t)
+		mock.ExpectQuery(sqlPattern("SELECT photo FROM authors WHERE id = ?")).WithArgs(3).WillReturnRows(sqlmock.NewRows([]string{"photo"}))
+
+		rec := serveRoute(ServeAuthorPhoto(db, newTestPhotoConfig(t)), http.MethodGet, "/photo/{id}", "/photo/3", nil)
+		if rec.Code != http.StatusNotFound {
+			t.Errorf("status %d, want 404", rec.Code)
+		}
+	})
+
+	t.Run("remote photo", func(t *testing.T) {
+		db, mock := newMockDB(t)
+		mock.ExpectQuery(sqlPattern("SELECT photo FROM authors WHERE id = ?")).WithArgs(3).
+			WillReturnRows(sqlmock.NewRows([]string{"photo"}).AddRow("https://cdn.example.com/orwell.jpg"))
+
+		rec := serveRoute(ServeAuthorPhoto(db, newTestPhotoConfig(t)), http.MethodGet, "/photo/{id}", "/photo/3", nil)
+		if rec.Code != http.StatusFound || rec.Header().Get("Location") != "https://cdn.example.com/orwell.jpg" {
+			t.Errorf("got %d to %q, want a redirect to the photo", rec.Code, rec.Header().Get("Location"))
+		}
+	})
+	for _, tt := range []struct{ size, want string }{
+		{"", "https://bucket.example.com/books/3/fullsize-v1.png"},
+		{"medium", "https://bucket.example.com/books/3/medium-v1.jpg"},
+		{"thumbnail", "https://bucket.example.com/books/3/thumbnail-v1.jpg"},
+	} {
+		t.Run("s3 "+tt.size, func(t *testing.T) {
+			db, mock := newMockDB(t)
+			mock.ExpectQuery(sqlPattern("SELECT photo FROM books WHERE id = ?")).WithArgs(3).
+				WillReturnRows(sqlmock.NewRows([]string{"photo"}).AddRow("https://bucket.example.com/books/3/fullsize-v1.png"))
+			config := PhotoConfig{Storage: &S3Storage{PublicURL: "https://bucket.example.com"}}
+
+			rec := serveRoute(ServeBookPhoto(db, config), http.MethodGet, "/photo/{id}", "/photo/3?size="+tt.size, nil)
+			if rec.Code != http.StatusFound || rec.Header().Get("Location") != tt.want {
+				t.Errorf("got %d to %q, want a redirect to %s", rec.Code, rec.Header().Get("Location"), tt.want)
+			}
+		})
+	}
+}
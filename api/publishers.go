@@ -0,0 +1,34 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+)
+
+// GetPublishers returns a handler that lists the distinct publishers of the books, by name
+func GetPublishers(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rows, err := db.QueryContext(r.Context(), "SELECT DISTINCT publisher FROM books WHERE publisher != '' ORDER BY publisher")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer rows.Close()
+
+		publishers := []string{}
+		for rows.Next() {
+			var publisher string
+			if err := rows.Scan(&publisher); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			publishers = append(publishers, publisher)
+		}
+		if err := rows.Err(); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		RespondWithJSON(w, http.StatusOK, publishers)
+	}
+}
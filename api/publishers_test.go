@@ -0,0 +1,142 @@
+package main
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestGetPublishers(t *testing.T) {
+	tests := []struct {
+		name string
+		rows *sqlmock.Rows
+		want []string
+	}{
+		{name: "no publishers", rows: sqlmock.NewRows([]string{"publisher"}), want: []string{}},
+		{name: "publishers", rows: sqlmock.NewRows([]string{"publisher"}).AddRow("Penguin").AddRow("Signet Classics"), want: []string{"Penguin", "Signet Classics"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock := newMockDB(t)
+			mock.ExpectQuery(sqlPattern("SELECT DISTINCT publisher FROM books WHERE publisher != '' ORDER BY publisher")).WillReturnRows(tt.rows)
+
+			rec := serveRoute(GetPublishers(db), http.MethodGet, "/publishers", "/publishers", nil)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status %d, want 200: %s", rec.Code, rec.Body)
+			}
+			var publishers []string
+			decodeJSON(t, rec, &publishers)
+			if !reflect.DeepEqual(publishers, tt.want) {
+				t.Errorf("got %v, want %v", publishers, tt.want)
+			}
+		})
+	}
+}
+
+func TestBooksByPublisher(t *testing.T) {
+	signet := []BookAuthorInfo{{BookID: 3, BookTitle: "Nineteen Eighty-Four", AuthorID: 2, Publisher: "Signet Classics",
+		Authors: []AuthorInfo{{ID: 2, Firstname: "George", Lastname: "Orwell"}}}}
+	tests := []struct {
+		name    string
+		handler func(*sql.DB) http.HandlerFunc
+		pattern string
+		target  string
+		fixture Fixture
+		expect  func(Fixture, sqlmock.Sqlmock, []BookAuthorInfo)
+	}{
+		{"book list", GetAllBooks, "/books", "/books?publisher=+Signet+Classics",
+			Fixture{Where: "WHERE books.publisher = ?", Args: []driver.Value{"Signet Classics"}}, Fixture.Books},
+		{"search", SearchBooks, "/search_books", "/search_books?publisher=Signet+Classics",
+			Fixture{Where: "WHERE books.publisher = ?", Args: []driver.Value{"Signet Classics"}}, Fixture.SearchBooks},
+		{"search with a query", SearchBooks, "/search_books", "/search_books?query=1984&publisher=Signet+Classics",
+			Fixture{Where: "WHERE (books.title LIKE ? OR authors.Firstname LIKE ? OR authors.Lastname LIKE ?) AND books.publisher = ?",
+				Args: []driver.Value{"%1984%", "%1984%", "%1984%", "Signet Classics"}}, Fixture.SearchBooks},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock := newMockDB(t)
+			tt.expect(tt.fixture, mock, signet)
+
+			rec := serveRoute(tt.handler(db), http.MethodGet, tt.pattern, tt.target, nil)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status %d, want 200: %s", rec.Code, rec.Body)
+			}
+			var books []BookAuthorInfo
+			decodeJSON(t, rec, &books)
+			if len(books) != 1 || books[0].Publisher != "Signet Classics" {
+				t.Errorf("got %+v", books)
+			}
+		})
+	}
+}
+
+func TestBookPublisher(t *testing.T) {
+	t.Run("add", func(t *testing.T) {
+		db, mock := newMockDB(t)
+		mock.ExpectBegin()
+		mock.ExpectExec(sqlPattern("INSERT INTO books (title, author_id, photo, is_borrowed, details, isbn, publisher, format)")).
+			WithArgs("1984", 2, "", false, "", nil, "Signet Classics", "").WillReturnResult(sqlmock.NewResult(9, 1))
+		expectBookLinks(mock, 9, 2)
+		mock.ExpectCommit()
+		expectAudit(mock, "create", "book", 9)
+
+		rec := serveRoute(AddBook(db, newTestPhotoConfig(t)), http.MethodPost, "/books/new", "/books/new",
+			strings.NewReader(`{"title":"1984","author_id":2,"publisher":" Signet Classics "}`))
+		if rec.Code != http.StatusCreated {
+			t.Errorf("status %d, want 201: %s", rec.Code, rec.Body)
+		}
+	})
+
+	t.Run("update", func(t *testing.T) {
+		db, mock := newMockDB(t)
+		mock.ExpectBegin()
+		mock.ExpectQuery(sqlPattern("SELECT author_id FROM books WHERE id = ? FOR UPDATE")).WithArgs(3).
+			WillReturnRows(sqlmock.NewRows([]string{"author_id"}).AddRow(2))
+		mock.ExpectExec(sqlPattern("UPDATE books SET title = ?, author_id = ?, photo = ?, details = ?, is_borrowed = ?, isbn = ?, publisher = ?, format = ?")).
+			WithArgs("1984", 2, "", "", false, nil, "Penguin", "", 3).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+		expectAudit(mock, "update", "book", 3)
+
+		rec := serveRoute(UpdateBook(db), http.MethodPut, "/books/{id}", "/books/3",
+			strings.NewReader(`{"title":"1984","author_id":2,"publisher":"Penguin"}`))
+		if rec.Code != http.StatusOK {
+			t.Errorf("status %d, want 200: %s", rec.Code, rec.Body)
+		}
+	})
+
+	t.Run("get", func(t *testing.T) {
+		db, mock := newMockDB(t)
+		mock.ExpectQuery(sqlPattern("COALESCE(books.publisher, '') AS publisher")).WithArgs(3).
+			WillReturnRows(sqlmock.NewRows([]string{"book_title", "author_id", "book_photo", "is_borrowed", "book_id", "book_details",
+				"author_lastname", "author_firstname", "isbn", "publisher", "format", "borrow_count"}).
+				AddRow("Nineteen Eighty-Four", 2, "", false, 3, "", "Orwell", "George", "", "Signet Classics", "", 0))
+		mock.ExpectQuery(sqlPattern("FROM book_tags")).WithArgs(3).WillReturnRows(sqlmock.NewRows([]string{"name"}))
+		mock.ExpectQuery(sqlPattern("FROM book_genres")).WillReturnRows(sqlmock.NewRows([]string{"book_id", "id", "name"}))
+		mock.ExpectQuery(sqlPattern("FROM authors_books")).WillReturnRows(sqlmock.NewRows([]string{"book_id", "id", "firstname", "lastname"}))
+		mock.ExpectQuery(sqlPattern("FROM reviews WHERE book_id = ?")).WithArgs(3).
+			WillReturnRows(sqlmock.NewRows([]string{"count", "avg"}).AddRow(0, nil))
+
+		rec := serveRoute(GetBookByID(db), http.MethodGet, "/books/{id}", "/books/3", nil)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status %d, want 200: %s", rec.Code, rec.Body)
+		}
+		var book map[string]interface{}
+		decodeJSON(t, rec, &book)
+		if book["publisher"] != "Signet Classics" {
+			t.Errorf("publisher %v, want Signet Classics", book["publisher"])
+		}
+	})
+
+	t.Run("too long", func(t *testing.T) {
+		rec := serveRoute(AddBook(nil, newTestPhotoConfig(t)), http.MethodPost, "/books/new", "/books/new",
+			strings.NewReader(`{"title":"1984","author_id":2,"publisher":"`+strings.Repeat("a", maxNameLength+1)+`"}`))
+		if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "publisher") {
+			t.Errorf("got %d %q, want 400 about the publisher", rec.Code, rec.Body)
+		}
+	})
+}
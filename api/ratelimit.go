@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// rateLimitBucket is the token bucket of a client
+type rateLimitBucket struct {
+	tokens   float64
+	lastSeen time.Time
+}
+
+// RateLimiter is a token bucket rate limiter per key, the client IP. A bucket holds up to Burst tokens
+// and refills at Rate tokens per second; a request takes one token.
+type RateLimiter struct {
+	Rate  float64
+	Burst float64
+
+	now     func() time.Time // replaced by tests
+	mu      sync.Mutex
+	buckets map[string]*rateLimitBucket
+}
+
+// NewRateLimiter creates a limiter of rate requests per second with bursts of burst requests. The buckets
+// that have been idle for idleTTL are removed every idleTTL until ctx is done, so memory stays bounded by
+// the active clients.
+func NewRateLimiter(ctx context.Context, rate float64, burst int, idleTTL time.Duration) *RateLimiter {
+	l := &RateLimiter{Rate: rate, Burst: float64(burst), now: time.Now, buckets: make(map[string]*rateLimitBucket)}
+	go func() {
+		ticker := time.NewTicker(idleTTL)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				l.evictIdle(idleTTL)
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return l
+}
+
+// Allow takes a token from the bucket of key. When it is empty, Allow returns false and how long it takes
+// for a token to be available.
+func (l *RateLimiter) Allow(key string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	bucket, ok := l.buckets[key]
+	if !ok {
+		bucket = &rateLimitBucket{tokens: l.Burst, lastSeen: now}
+		l.buckets[key] = bucket
+	}
+	bucket.tokens = math.Min(l.Burst, bucket.tokens+now.Sub(bucket.lastSeen).Seconds()*l.Rate)
+	bucket.lastSeen = now
+
+	if bucket.tokens < 1 {
+		return false, time.Duration((1 - bucket.tokens) / l.Rate * float64(time.Second))
+	}
+	bucket.tokens--
+	return true, 0
+}
+
+// evictIdle removes the buckets not used for idleTTL. A bucket that is idle long enough is full again,
+// so removing it changes nothing for its client.
+func (l *RateLimiter) evictIdle(idleTTL time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	for key, bucket := range l.buckets {
+		if now.Sub(bucket.lastSeen) > idleTTL {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// clientIP is the address of the client of r. Behind a trusted proxy it is the last address of
+// X-Forwarded-For, the one the proxy added; the earlier ones are set by the client and can't be trusted.
+func clientIP(r *http.Request, trustProxy bool) string {
+	if trustProxy {
+		if forwarded := r.Header.Values("X-Forwarded-For"); len(forwarded) > 0 {
+			addresses := strings.Split(forwarded[len(forwarded)-1], ",")
+			if ip := strings.TrimSpace(addresses[len(addresses)-1]); ip != "" {
+				return ip
+			}
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// newRateLimiterFromConfig builds the limiter of RATE_LIMIT_RPS requests per second per client with bursts
+// of RATE_LIMIT_BURST. It returns nil when the rate is 0, which disables rate limiting.
+func newRateLimiterFromConfig(ctx context.Context, cfg Config) *RateLimiter {
+	if cfg.RateLimitRPS == 0 {
+		return nil
+	}
+	return NewRateLimiter(ctx, cfg.RateLimitRPS, cfg.RateLimitBurst, 10*time.Minute)
+}
+
+// RateLimitMiddleware answers 429 Too Many Requests with a Retry-After header to the clients that exceed
+// the limits of limiter. With trustProxy the client is identified by X-Forwarded-For, which should only be
+// enabled behind a proxy that sets it. A nil limiter lets every request through.
+func RateLimitMiddleware(limiter *RateLimiter, trustProxy bool) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		if limiter == nil {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			allowed, retryAfter := limiter.Allow(clientIP(r, trustProxy))
+			if !allowed {
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+				http.Error(w, "Too many requests", http.StatusTooManyRequests)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
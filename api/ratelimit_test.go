@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// fakeClock is a clock that only moves when a test advances it
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time { return c.now }
+
+func (c *fakeClock) Advance(d time.Duration) { c.now = c.now.Add(d) }
+
+// newTestRateLimiter returns a limiter on clock, without the goroutine evicting idle buckets
+func newTestRateLimiter(rate float64, burst int, clock *fakeClock) *RateLimiter {
+	return &RateLimiter{Rate: rate, Burst: float64(burst), now: clock.Now, buckets: make(map[string]*rateLimitBucket)}
+}
+
+func TestRateLimiterRefill(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)}
+	limiter := newTestRateLimiter(2, 3, clock)
+
+	for i := 0; i < 3; i++ {
+		if allowed, _ := limiter.Allow("10.0.0.1"); !allowed {
+			t.Fatalf("request %d of the burst was refused", i+1)
+		}
+	}
+	allowed, retryAfter := limiter.Allow("10.0.0.1")
+	if allowed || retryAfter != 500*time.Millisecond {
+		t.Fatalf("after the burst: got %v, retry after %s, want refused for 500ms", allowed, retryAfter)
+	}
+
+	clock.Advance(250 * time.Millisecond)
+	if allowed, retryAfter := limiter.Allow("10.0.0.1"); allowed || retryAfter != 250*time.Millisecond {
+		t.Errorf("half a token later: got %v, retry after %s, want refused for 250ms", allowed, retryAfter)
+	}
+	clock.Advance(250 * time.Millisecond)
+	if allowed, _ := limiter.Allow("10.0.0.1"); !allowed {
+		t.Errorf("the refilled token was refused")
+	}
+
+	// An idle client gets its burst back, but no more
+	clock.Advance(time.Hour)
+	for i := 0; i < 3; i++ {
+		if allowed, _ := limiter.Allow("10.0.0.1"); !allowed {
+			t.Fatalf("request %d of the refilled burst was refused", i+1)
+		}
+	}
+	if allowed, _ := limiter.Allow("10.0.0.1"); allowed {
+		t.Errorf("the bucket refilled beyond the burst")
+	}
+
+	// Clients have their own buckets
+	if allowed, _ := limiter.Allow("10.0.0.2"); !allowed {
+		t.Errorf("another client was refused")
+	}
+}
+
+func TestRateLimiterEvictIdle(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)}
+	limiter := newTestRateLimiter(1, 1, clock)
+
+	limiter.Allow("10.0.0.1")
+	clock.Advance(2 * time.Minute)
+	limiter.Allow("10.0.0.2")
+	clock.Advance(30 * time.Second)
+
+	limiter.evictIdle(time.Minute)
+	if _, ok := limiter.buckets["10.0.0.1"]; ok {
+		t.Errorf("the idle bucket was kept")
+	}
+	if _, ok := limiter.buckets["10.0.0.2"]; !ok {
+		t.Errorf("the active bucket was evicted")
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	tests := []struct {
+		name       string
+		forwarded  []string
+		trustProxy bool
+		want       string
+	}{
+		{name: "remote address", want: "192.0.2.1"},
+		{name: "untrusted X-Forwarded-For", forwarded: []string{"203.0.113.7"}, want: "192.0.2.1"},
+		{name: "trusted proxy", forwarded: []string{"203.0.113.7"}, trustProxy: true, want: "203.0.113.7"},
+		{name: "spoofed address before the proxy's", forwarded: []string{"198.51.100.1, 203.0.113.7"}, trustProxy: true, want: "203.0.113.7"},
+		{name: "several headers", forwarded: []string{"198.51.100.1", "203.0.113.7"}, trustProxy: true, want: "203.0.113.7"},
+		{name: "no header behind the proxy", trustProxy: true, want: "192.0.2.1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/search_books", nil)
+			req.RemoteAddr = "192.0.2.1:51234"
+			for _, value := range tt.forwarded {
+				req.Header.Add("X-Forwarded-For", value)
+			}
+			if got := clientIP(req, tt.trustProxy); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRateLimitMiddleware(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)}
+	limiter := newTestRateLimiter(0.5, 1, clock)
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	request := func() *http.Request { return httptest.NewRequest(http.MethodGet, "/search_books", nil) }
+
+	if rec := serveWithMiddleware(RateLimitMiddleware(limiter, false), ok, "/search_books", request()); rec.Code != http.StatusOK {
+		t.Fatalf("first request: status %d, want 200", rec.Code)
+	}
+	rec := serveWithMiddleware(RateLimitMiddleware(limiter, false), ok, "/search_books", request())
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") != "2" {
+		t.Errorf("second request: status %d with Retry-After %q, want 429 after 2 seconds", rec.Code, rec.Header().Get("Retry-After"))
+	}
+	clock.Advance(2 * time.Second)
+	if rec := serveWithMiddleware(RateLimitMiddleware(limiter, false), ok, "/search_books", request()); rec.Code != http.StatusOK {
+		t.Errorf("after the refill: status %d, want 200", rec.Code)
+	}
+
+	t.Run("disabled", func(t *testing.T) {
+		for i := 0; i < 5; i++ {
+			if rec := serveWithMiddleware(RateLimitMiddleware(nil, false), ok, "/search_books", request()); rec.Code != http.StatusOK {
+				t.Fatalf("status %d, want 200 without a limiter", rec.Code)
+			}
+		}
+	})
+}
+
+func TestNewRateLimiterStopsWithContext(t *testing.T) {
+	before := runtime.NumGoroutine()
+	ctx, cancel := context.WithCancel(context.Background())
+	NewRateLimiter(ctx, 1, 1, time.Millisecond)
+
+	cancel()
+	expectGoroutinesStopped(t, before)
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// dueLoan is a loan that has not been returned, with what its reminder needs
+type dueLoan struct {
+	SubscriberID int
+	BookID       int
+	BorrowedAt   time.Time
+	DueDate      time.Time
+	BookTitle    string
+	Firstname    string
+	Email        string
+}
+
+// SendDueReminders emails the subscribers whose loans are overdue or due before now plus within. A loan
+// gets a single reminder: last_reminded_at is set once it is sent, and the reminded loans are skipped the
+// next time. A failed email is logged and tried again on the next run. now is a parameter so that the
+// reminders don't depend on the clock of the caller.
+func SendDueReminders(ctx context.Context, db *sql.DB, mailer Mailer, now time.Time, within time.Duration) (int, error) {
+	loans, err := dueLoans(ctx, db, now.Add(within))
+	if err != nil {
+		return 0, err
+	}
+
+	sent := 0
+	var errs []error
+	for _, loan := range loans {
+		subject, body := reminderEmail(loan, now)
+		if err := mailer.Send(ctx, loan.Email, subject, body); err != nil {
+			slog.Error("sending reminder failed", "subscriber_id", loan.SubscriberID, "book_id", loan.BookID, "error", err)
+			errs = append(errs, err)
+			continue
+		}
+
+		_, err := db.ExecContext(ctx, `
+			UPDATE borrowed_books SET last_reminded_at = ?
+			WHERE subscriber_id = ? AND book_id = ? AND date_of_borrow = ? AND return_date IS NULL
+		`, now, loan.SubscriberID, loan.BookID, loan.BorrowedAt)
+		if err != nil {
+			// The email is sent, stop before the same subscribers get it again
+			return sent, fmt.Errorf("failed to record the reminder: %w", err)
+		}
+		sent++
+	}
+	if len(errs) > 0 {
+		return sent, fmt.Errorf("%d of %d reminders failed: %w", len(errs), len(loans), errors.Join(errs...))
+	}
+	return sent, nil
+}
+
+// dueLoans returns the loans due before dueBefore that have not been returned nor reminded yet
+func dueLoans(ctx context.Context, db *sql.DB, dueBefore time.Time) ([]dueLoan, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT borrowed_books.subscriber_id, borrowed_books.book_id, borrowed_books.date_of_borrow, borrowed_books.due_date,
+			books.title, subscribers.Firstname, subscribers.Email
+		FROM borrowed_books
+		JOIN books ON borrowed_books.book_id = books.id
+		JOIN subscribers ON borrowed_books.subscriber_id = subscribers.id
+		WHERE borrowed_books.return_date IS NULL
+			AND borrowed_books.last_reminded_at IS NULL
+			AND borrowed_books.due_date < ?
+			AND subscribers.Email != ''
+		ORDER BY borrowed_books.due_date
+	`, dueBefore)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var loans []dueLoan
+	for rows.Next() {
+		var loan dueLoan
+		if err := rows.Scan(&loan.SubscriberID, &loan.BookID, &loan.BorrowedAt, &loan.DueDate, &loan.BookTitle, &loan.Firstname, &loan.Email); err != nil {
+			return nil, err
+		}
+		loans = append(loans, loan)
+	}
+	return loans, rows.Err()
+}
+
+// reminderEmail writes the subject and body of the reminder of loan
+func reminderEmail(loan dueLoan, now time.Time) (string, string) {
+	dueDate := loan.DueDate.Format(time.DateOnly)
+	if loan.DueDate.Before(now) {
+		return fmt.Sprintf("Overdue: %s", loan.BookTitle), fmt.Sprintf(
+			"Hello %s,\n\n%q was due back on %s. Please return it to the library as soon as possible.\n",
+			loan.Firstname, loan.BookTitle, dueDate)
+	}
+	return fmt.Sprintf("Reminder: %s is due on %s", loan.BookTitle, dueDate), fmt.Sprintf(
+		"Hello %s,\n\n%q is due back on %s. Please return it by then.\n",
+		loan.Firstname, loan.BookTitle, dueDate)
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// sentEmail is an email sent through a fakeMailer
+type sentEmail struct {
+	to, subject, body string
+}
+
+// fakeMailer records the emails it is asked to send and fails those to the addresses of failing
+type fakeMailer struct {
+	sent    []sentEmail
+	failing map[string]bool
+}
+
+func (m *fakeMailer) Send(ctx context.Context, to, subject, body string) error {
+	if m.failing[to] {
+		return errors.New("mailbox unavailable")
+	}
+	m.sent = append(m.sent, sentEmail{to: to, subject: subject, body: body})
+	return nil
+}
+
+// reminderNow is the time the reminders are sent at in the tests
+var reminderNow = time.Date(2024, 5, 20, 9, 0, 0, 0, time.UTC)
+
+// expectDueLoans expects the loans due before reminderNow plus two days to be read. Emma's loan is overdue,
+// Oliver's is due tomorrow.
+func expectDueLoans(mock sqlmock.Sqlmock) {
+	mock.ExpectQuery(sqlPattern("FROM borrowed_books")).WithArgs(reminderNow.Add(48 * time.Hour)).
+		WillReturnRows(sqlmock.NewRows([]string{"subscriber_id", "book_id", "date_of_borrow", "due_date", "title", "firstname", "email"}).
+			AddRow(1, 2, time.Date(2024, 5, 2, 10, 0, 0, 0, time.UTC), time.Date(2024, 5, 16, 10, 0, 0, 0, time.UTC), "Emma", "Emma", "emma@example.com").
+			AddRow(3, 5, time.Date(2024, 5, 7, 10, 0, 0, 0, time.UTC), time.Date(2024, 5, 21, 10, 0, 0, 0, time.UTC), "The Hobbit", "Oliver", "oliver@example.com"))
+}
+
+// expectReminded expects the loan of subscriberID and bookID borrowed at borrowedAt to be marked as reminded
+func expectReminded(mock sqlmock.Sqlmock, subscriberID, bookID int, borrowedAt time.Time) *sqlmock.ExpectedExec {
+	return mock.ExpectExec(sqlPattern("UPDATE borrowed_books SET last_reminded_at = ?")).
+		WithArgs(reminderNow, subscriberID, bookID, borrowedAt)
+}
+
+func TestSendDueReminders(t *testing.T) {
+	db, mock := newMockDB(t)
+	expectDueLoans(mock)
+	expectReminded(mock, 1, 2, time.Date(2024, 5, 2, 10, 0, 0, 0, time.UTC)).WillReturnResult(sqlmock.NewResult(0, 1))
+	expectReminded(mock, 3, 5, time.Date(2024, 5, 7, 10, 0, 0, 0, time.UTC)).WillReturnResult(sqlmock.NewResult(0, 1))
+	mailer := &fakeMailer{}
+
+	sent, err := SendDueReminders(context.Background(), db, mailer, reminderNow, 48*time.Hour)
+	if err != nil || sent != 2 {
+		t.Fatalf("sent %d, %v, want 2 reminders", sent, err)
+	}
+	if len(mailer.sent) != 2 {
+		t.Fatalf("got %+v", mailer.sent)
+	}
+	overdue, dueSoon := mailer.sent[0], mailer.sent[1]
+	if overdue.to != "emma@example.com" || overdue.subject != "Overdue: Emma" ||
+		!strings.Contains(overdue.body, `"Emma" was due back on 2024-05-16`) {
+		t.Errorf("overdue reminder %+v", overdue)
+	}
+	if dueSoon.to != "oliver@example.com" || dueSoon.subject != "Reminder: The Hobbit is due on 2024-05-21" ||
+		!strings.Contains(dueSoon.body, "Hello Oliver") {
+		t.Errorf("due soon reminder %+v", dueSoon)
+	}
+}
+
+func TestSendDueRemindersFailures(t *testing.T) {
+	t.Run("email failed", func(t *testing.T) {
+		db, mock := newMockDB(t)
+		expectDueLoans(mock)
+		// Emma's loan isn't marked as reminded, she gets the reminder on the next run
+		expectReminded(mock, 3, 5, time.Date(2024, 5, 7, 10, 0, 0, 0, time.UTC)).WillReturnResult(sqlmock.NewResult(0, 1))
+		mailer := &fakeMailer{failing: map[string]bool{"emma@example.com": true}}
+
+		discardLogs(t)
+		sent, err := SendDueReminders(context.Background(), db, mailer, reminderNow, 48*time.Hour)
+		if err == nil || sent != 1 || len(mailer.sent) != 1 {
+			t.Errorf("sent %d, %v, want 1 reminder and an error", sent, err)
+		}
+	})
+
+	t.Run("recording failed", func(t *testing.T) {
+		db, mock := newMockDB(t)
+		expectDueLoans(mock)
+		expectReminded(mock, 1, 2, time.Date(2024, 5, 2, 10, 0, 0, 0, time.UTC)).WillReturnError(errors.New("connection reset"))
+		mailer := &fakeMailer{}
+
+		sent, err := SendDueReminders(context.Background(), db, mailer, reminderNow, 48*time.Hour)
+		// The run stops rather than emailing subscribers whose reminders can't be recorded
+		if err == nil || sent != 0 || len(mailer.sent) != 1 {
+			t.Errorf("sent %d, %v after %d emails, want an error after the first email", sent, err, len(mailer.sent))
+		}
+	})
+
+	t.Run("nothing due", func(t *testing.T) {
+		db, mock := newMockDB(t)
+		mock.ExpectQuery(sqlPattern("FROM borrowed_books")).WithArgs(reminderNow.Add(24 * time.Hour)).
+			WillReturnRows(sqlmock.NewRows([]string{"subscriber_id", "book_id", "date_of_borrow", "due_date", "title", "firstname", "email"}))
+		mailer := &fakeMailer{}
+
+		sent, err := SendDueReminders(context.Background(), db, mailer, reminderNow, 24*time.Hour)
+		if err != nil || sent != 0 || len(mailer.sent) != 0 {
+			t.Errorf("sent %d, %v, want nothing", sent, err)
+		}
+	})
+}
+
+func TestNewMailerFromConfig(t *testing.T) {
+	if _, ok := newMailerFromConfig(Config{}).(LogMailer); !ok {
+		t.Error("without SMTP_HOST the emails should only be logged")
+	}
+
+	mailer, ok := newMailerFromConfig(Config{SMTPHost: "smtp.example.com", SMTPPort: "587", MailFrom: "library@example.com"}).(*SMTPMailer)
+	if !ok || mailer.Addr != "smtp.example.com:587" || mailer.From != "library@example.com" || mailer.Auth != nil {
+		t.Errorf("got %+v", mailer)
+	}
+	mailer, _ = newMailerFromConfig(Config{SMTPHost: "smtp.example.com", SMTPPort: "587", SMTPUsername: "library", SMTPPassword: "secret"}).(*SMTPMailer)
+	if mailer == nil || mailer.Auth == nil {
+		t.Errorf("got %+v, want a login with SMTP_USERNAME", mailer)
+	}
+}
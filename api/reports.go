@@ -0,0 +1,110 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// reportDateLayout is the YYYY-MM-DD format of the report date parameters
+const reportDateLayout = "2006-01-02"
+
+// TopBookReportRow is a book with the number of times it was borrowed in the period of a report
+type TopBookReportRow struct {
+	BookID          int    `json:"book_id"`
+	BookTitle       string `json:"book_title"`
+	AuthorID        int    `json:"author_id"`
+	AuthorFirstname string `json:"author_firstname"`
+	AuthorLastname  string `json:"author_lastname"`
+	BorrowCount     int    `json:"borrow_count"`
+}
+
+// parseReportPeriod reads the required from and to query parameters, both inclusive.
+func parseReportPeriod(r *http.Request) (time.Time, time.Time, error) {
+	from, err := time.Parse(reportDateLayout, r.URL.Query().Get("from"))
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("from must be a date in the YYYY-MM-DD format")
+	}
+	to, err := time.Parse(reportDateLayout, r.URL.Query().Get("to"))
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("to must be a date in the YYYY-MM-DD format")
+	}
+	if from.After(to) {
+		return time.Time{}, time.Time{}, fmt.Errorf("from must not be after to")
+	}
+	return from, to, nil
+}
+
+// wantsCSV reports whether the client asked for CSV with ?format=csv or an Accept: text/csv header
+func wantsCSV(r *http.Request) bool {
+	return r.URL.Query().Get("format") == "csv" || strings.Contains(r.Header.Get("Accept"), "text/csv")
+}
+
+// GetTopBooksReport returns a handler ranking the books by the number of times they were borrowed between
+// the from and to dates, as JSON or as CSV.
+func GetTopBooksReport(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		from, to, err := parseReportPeriod(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		limit, err := ParseLimit(r, 20, maxPageSize)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		rows, err := db.QueryContext(r.Context(), `
+			SELECT books.id, books.title, authors.id, authors.Firstname, authors.Lastname, COUNT(*) AS borrow_count
+			FROM borrowed_books
+			JOIN books ON borrowed_books.book_id = books.id
+			JOIN authors ON books.author_id = authors.id
+			WHERE borrowed_books.date_of_borrow >= ? AND borrowed_books.date_of_borrow < ?
+			GROUP BY books.id, books.title, authors.id, authors.Firstname, authors.Lastname
+			ORDER BY borrow_count DESC, books.id
+			LIMIT ?
+		`, from.Format(reportDateLayout), to.AddDate(0, 0, 1).Format(reportDateLayout), limit)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer rows.Close()
+
+		report := []TopBookReportRow{}
+		for rows.Next() {
+			var row TopBookReportRow
+			if err := rows.Scan(&row.BookID, &row.BookTitle, &row.AuthorID, &row.AuthorFirstname, &row.AuthorLastname, &row.BorrowCount); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			report = append(report, row)
+		}
+		if err := rows.Err(); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if !wantsCSV(r) {
+			RespondWithJSON(w, http.StatusOK, report)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="top-books-%s-%s.csv"`,
+			from.Format(reportDateLayout), to.Format(reportDateLayout)))
+		writer := csv.NewWriter(w)
+		writer.Write([]string{"book_id", "book_title", "author_id", "author_firstname", "author_lastname", "borrow_count"})
+		for _, row := range report {
+			writer.Write([]string{
+				strconv.Itoa(row.BookID), row.BookTitle, strconv.Itoa(row.AuthorID),
+				row.AuthorFirstname, row.AuthorLastname, strconv.Itoa(row.BorrowCount),
+			})
+		}
+		writer.Flush()
+	}
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// Review is the rating a subscriber gave a book
+type Review struct {
+	ID                  int    `json:"id"`
+	BookID              int    `json:"book_id"`
+	SubscriberID        int    `json:"subscriber_id"`
+	SubscriberFirstname string `json:"subscriber_firstname,omitempty"`
+	SubscriberLastname  string `json:"subscriber_lastname,omitempty"`
+	Rating              int    `json:"rating"`
+	Comment             string `json:"comment"`
+	CreatedAt           string `json:"created_at"`
+}
+
+// AddReview returns a handler that records the review of a book by a subscriber. A subscriber can review a book once.
+func AddReview(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		bookID, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			http.Error(w, "Invalid book ID", http.StatusBadRequest)
+			return
+		}
+
+		var review Review
+		if err := json.NewDecoder(r.Body).Decode(&review); err != nil {
+			http.Error(w, "Invalid JSON data", http.StatusBadRequest)
+			return
+		}
+		defer r.Body.Close()
+
+		review.Comment = strings.TrimSpace(review.Comment)
+		if err := ValidateReview(review); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		var bookExists, subscriberExists bool
+		err = db.QueryRowContext(r.Context(), `
+			SELECT EXISTS(SELECT 1 FROM books WHERE id = ?), EXISTS(SELECT 1 FROM subscribers WHERE id = ?)
+		`, bookID, review.SubscriberID).Scan(&bookExists, &subscriberExists)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !bookExists {
+			http.Error(w, "Book not found", http.StatusNotFound)
+			return
+		}
+		if !subscriberExists {
+			http.Error(w, "Subscriber not found", http.StatusNotFound)
+			return
+		}
+
+		result, err := db.ExecContext(r.Context(), `
+			INSERT INTO reviews (subscriber_id, book_id, rating, comment, created_at)
+			VALUES (?, ?, ?, ?, NOW())
+		`, review.SubscriberID, bookID, review.Rating, review.Comment)
+		if isDuplicateEntry(err) {
+			http.Error(w, "subscriber already reviewed this book", http.StatusConflict)
+			return
+		}
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to insert review: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		id, err := result.LastInsertId()
+		if err != nil {
+			http.Error(w, "Failed to get last insert ID", http.StatusInternalServerError)
+			return
+		}
+
+		RespondWithJSON(w, http.StatusCreated, map[string]int{"id": int(id)})
+	}
+}
+
+// GetBookReviews returns a handler that lists the reviews of a book, newest first, with the names of their authors.
+func GetBookReviews(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		bookID, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			http.Error(w, "Invalid book ID", http.StatusBadRequest)
+			return
+		}
+
+		var exists bool
+		err = db.QueryRowContext(r.Context(), "SELECT EXISTS(SELECT 1 FROM books WHERE id = ?)", bookID).Scan(&exists)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !exists {
+			http.Error(w, "Book not found", http.StatusNotFound)
+			return
+		}
+
+		rows, err := db.QueryContext(r.Context(), `
+			SELECT reviews.id, reviews.book_id, reviews.subscriber_id, subscribers.Firstname, subscribers.Lastname,
+				reviews.rating, reviews.comment, reviews.created_at
+			FROM reviews
+			JOIN subscribers ON reviews.subscriber_id = subscribers.id
+			WHERE reviews.book_id = ?
+			ORDER BY reviews.created_at DESC, reviews.id DESC
+		`, bookID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer rows.Close()
+
+		reviews := []Review{}
+		for rows.Next() {
+			var review Review
+			if err := rows.Scan(&review.ID, &review.BookID, &review.SubscriberID, &review.SubscriberFirstname, &review.SubscriberLastname,
+				&review.Rating, &review.Comment, &review.CreatedAt); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			reviews = append(reviews, review)
+		}
+		if err := rows.Err(); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		RespondWithJSON(w, http.StatusOK, reviews)
+	}
+}
+
+// DeleteReview returns a handler that deletes a review. Only the subscriber who wrote it, given as
+// ?subscriber_id=, may delete it.
+func DeleteReview(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		bookID, err := strconv.Atoi(vars["id"])
+		if err != nil {
+			http.Error(w, "Invalid book ID", http.StatusBadRequest)
+			return
+		}
+		reviewID, err := strconv.Atoi(vars["reviewID"])
+		if err != nil {
+			http.Error(w, "Invalid review ID", http.StatusBadRequest)
+			return
+		}
+		subscriberID, err := strconv.Atoi(r.URL.Query().Get("subscriber_id"))
+		if err != nil {
+			http.Error(w, "subscriber_id parameter is required", http.StatusBadRequest)
+			return
+		}
+
+		var authorID int
+		err = db.QueryRowContext(r.Context(), "SELECT subscriber_id FROM reviews WHERE id = ? AND book_id = ?", reviewID, bookID).Scan(&authorID)
+		if err == sql.ErrNoRows {
+			http.Error(w, "Review not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if authorID != subscriberID {
+			http.Error(w, "Only the author of a review can delete it", http.StatusForbidden)
+			return
+		}
+
+		_, err = db.ExecContext(r.Context(), "DELETE FROM reviews WHERE id = ?", reviewID)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to delete review: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		RespondWithJSON(w, http.StatusOK, map[string]string{"message": "Review deleted successfully"})
+	}
+}
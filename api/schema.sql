@@ -1,3 +1,6 @@
+-- Tables of migrations/0001_initial_schema.sql and sample data, loaded into a new database by the
+-- MySQL container. The later changes of the schema are in migrations/, applied when the server starts.
+
 CREATE TABLE `authors` (
   `id` INTEGER AUTO_INCREMENT PRIMARY KEY,
   `Lastname` VARCHAR(255),
@@ -35,7 +38,6 @@ CREATE TABLE `borrowed_books` (
 );
 
 ALTER TABLE `books` ADD FOREIGN KEY (`author_id`) REFERENCES `authors` (`id`);
-ALTER TABLE `books` ADD FOREIGN KEY (`is_borrowed`) REFERENCES `subscribers` (`id`);
 ALTER TABLE `borrowed_books` ADD FOREIGN KEY (`subscriber_id`) REFERENCES `subscribers` (`id`);
 ALTER TABLE `borrowed_books` ADD FOREIGN KEY (`book_id`) REFERENCES `books` (`id`);
 
@@ -51,17 +53,17 @@ INSERT INTO authors (Lastname, Firstname, photo) VALUES
 ('Martinez', 'David', 'david_martinez.jpg'),
 ('White', 'Sophia', 'sophia_white.jpg');
 
-INSERT INTO authors_books (author_id, book_id) VALUES
-(1, 1),
-(2, 2),
-(3, 3),
-(4, 4),
-(5, 5),
-(6, 6),
-(7, 7),
-(8, 8),
-(9, 9),
-(10, 10);
+INSERT INTO authors_books (id, author_id, book_id) VALUES
+(1, 1, 1),
+(2, 2, 2),
+(3, 3, 3),
+(4, 4, 4),
+(5, 5, 5),
+(6, 6, 6),
+(7, 7, 7),
+(8, 8, 8),
+(9, 9, 9),
+(10, 10, 10);
 
 INSERT INTO books (photo, title, author_id, details, is_borrowed) VALUES
 ('book1.jpg', 'Book 1', 1, 'Description for Book 1', FALSE),
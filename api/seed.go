@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// errDatabaseNotEmpty is returned by Seed on a database that already holds authors, books or subscribers
+var errDatabaseNotEmpty = errors.New("the database isn't empty, seed only fills a new database")
+
+// seedStatements are the rows of the demo dataset. The ids and dates are fixed so that every seeded database
+// is the same, which the end-to-end tests rely on. A table comes after the ones its foreign keys reference.
+var seedStatements = []string{
+	`INSERT INTO subscribers (id, Lastname, Firstname, Email, phone) VALUES
+		(1, 'Johnson', 'Emma', 'emma.johnson@example.com', '+40700000001'),
+		(2, 'Brown', 'Sophia', 'sophia.brown@example.com', '+40700000002'),
+		(3, 'Williams', 'Oliver', 'oliver.williams@example.com', NULL)`,
+	`INSERT INTO authors (id, Lastname, Firstname, photo) VALUES
+		(1, 'Austen', 'Jane', ''),
+		(2, 'Orwell', 'George', ''),
+		(3, 'Tolkien', 'John', ''),
+		(4, 'Woolf', 'Virginia', '')`,
+	`INSERT INTO books (id, title, author_id, photo, is_borrowed, details, isbn, publisher, format) VALUES
+		(1, 'Pride and Prejudice', 1, '', FALSE, 'A novel of manners', '9780141439518', 'Penguin Classics', 'paperback'),
+		(2, 'Emma', 1, '', TRUE, 'A comedy of matchmaking', '9780141439587', 'Penguin Classics', 'hardcover'),
+		(3, 'Nineteen Eighty-Four', 2, '', TRUE, 'A dystopian novel', '9780451524935', 'Signet Classics', 'ebook'),
+		(4, 'Animal Farm', 2, '', FALSE, 'A fable about a revolution', '9780451526342', 'Signet Classics', 'paperback'),
+		(5, 'The Hobbit', 3, '', FALSE, 'There and back again', '9780547928227', 'Mariner Books', 'audiobook'),
+		(6, 'Mrs Dalloway', 4, '', FALSE, 'A day in London', '9780156628709', 'Harcourt', '')`,
+	`INSERT INTO authors_books (author_id, book_id) VALUES (1, 1), (1, 2), (2, 3), (2, 4), (3, 5), (4, 6)`,
+	`INSERT INTO borrowed_books (subscriber_id, book_id, date_of_borrow, due_date, return_date) VALUES
+		(1, 1, '2024-04-01 10:00:00', '2024-04-15 10:00:00', '2024-04-10 10:00:00'),
+		(1, 2, '2024-05-02 10:00:00', '2024-05-16 10:00:00', NULL),
+		(2, 3, '2024-05-03 10:00:00', '2024-05-17 10:00:00', NULL)`,
+}
+
+// Seed inserts the demo dataset used for local development and the end-to-end tests: a few authors and
+// their books, subscribers, a returned borrow and two ongoing ones. It refuses to run on a database that
+// already has data rather than mixing the two.
+func Seed(ctx context.Context, db *sql.DB) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, table := range []string{"authors", "books", "subscribers"} {
+		var exists bool
+		if err := tx.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM "+table+")").Scan(&exists); err != nil {
+			return err
+		}
+		if exists {
+			return errDatabaseNotEmpty
+		}
+	}
+
+	for _, statement := range seedStatements {
+		if _, err := tx.ExecContext(ctx, statement); err != nil {
+			return fmt.Errorf("failed to insert the demo data: %w", err)
+		}
+	}
+	return tx.Commit()
+}
@@ -1,16 +1,20 @@
 package main
 
 import (
+	"log/slog"
+	"context"
 	"database/sql"
 	// "io/ioutil"
 	"encoding/json"
-	"flag"
+	"errors"
 	"fmt"
-	"log"
 	"net/http"
+	"os"
 	"strconv"
+	"strings"
+	"time"
 	
-	_ "github.com/go-sql-driver/mysql"
+	"github.com/go-sql-driver/mysql"
 	"github.com/gorilla/mux"
 
 )
@@ -23,6 +27,30 @@ type Author struct {
 	Photo        string `json:"photo"`
 }
 
+// AuthorProfile is the author returned by GetAuthorByID. It has the address the photo is served at
+// rather than where it is stored.
+type AuthorProfile struct {
+	ID        int    `json:"id"`
+	Lastname  string `json:"lastname"`
+	Firstname string `json:"firstname"`
+	PhotoURL  string `json:"photo_url,omitempty"`
+}
+
+// AuthorWithCount is an author together with the number of books they have
+type AuthorWithCount struct {
+	Author
+	BookCount int `json:"book_count"`
+}
+
+// AuthorStats summarizes the books of an author and how they circulate
+type AuthorStats struct {
+	AuthorID                  int     `json:"author_id"`
+	TotalBooks                int     `json:"total_books"`
+	BorrowedBooks             int     `json:"borrowed_books"`
+	AvailableBooks            int     `json:"available_books"`
+	TotalTimesBorrowed        int     `json:"total_times_borrowed"`
+	AverageBorrowDurationDays float64 `json:"average_borrow_duration_days"`
+}
 
 type AuthorBook struct {
 	AuthorFirstname string `json:"author_firstname"`
@@ -32,6 +60,13 @@ type AuthorBook struct {
 
 }
 
+// AuthorInfo names one of the authors of a book
+type AuthorInfo struct {
+	ID        int    `json:"id"`
+	Firstname string `json:"firstname"`
+	Lastname  string `json:"lastname"`
+}
+
 type BookAuthorInfo struct {
     BookID          int    `json:"book_id"`
     BookTitle       string `json:"book_title"`
@@ -39,29 +74,61 @@ type BookAuthorInfo struct {
     BookPhoto       string `json:"book_photo"`
     IsBorrowed      bool   `json:"is_borrowed"`
     BookDetails     string `json:"book_details"`
-    AuthorLastname  string `json:"author_lastname"`
-    AuthorFirstname string `json:"author_firstname"`
+    Authors         []AuthorInfo `json:"authors"`
+    ISBN            string   `json:"isbn,omitempty"`
+    Publisher       string   `json:"publisher,omitempty"`
+    Format          string   `json:"format,omitempty"`
+    Tags            []string `json:"tags,omitempty"`
+    Genres          []Genre  `json:"genres,omitempty"`
+    AverageRating   *float64 `json:"average_rating,omitempty"`
+    ReviewCount     *int     `json:"review_count,omitempty"`
+    BorrowCount     int      `json:"borrow_count"`
 }
 
 type Subscriber struct {
+	ID        int    `json:"id"`
 	Lastname  string `json:"lastname"`
 	Firstname string `json:"firstname"`
 	Email     string `json:"email"`
+	Phone     string `json:"phone,omitempty"`
+
+	MembershipExpiry *MembershipDate `json:"membership_expiry,omitempty"`
 }
 
 type NewBook struct {
     Title       string `json:"title"`
     AuthorID    int    `json:"author_id"`
+    AuthorIDs   []int  `json:"author_ids"`
     Photo       string `json:"photo"`
     IsBorrowed  bool   `json:"is_borrowed"`
     Details     string `json:"details"`
+    ISBN        string `json:"isbn"`
+    Publisher   string `json:"publisher"`
+    Format      string `json:"format"`
+    TagNames    []string `json:"tag_names"`
+    Genres      []GenreRef `json:"genres"`
 }
 
+// Default and maximum page sizes for the list endpoints
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+// mysqlErrDuplicateEntry is the MySQL error code for a unique key violation
+const mysqlErrDuplicateEntry = 1062
+
+// maxActiveBorrows is the number of books a subscriber may hold at the same time
+const maxActiveBorrows = 5
+
+// loanPeriodDays is the number of days a borrowed book is due back after
+const loanPeriodDays = 14
+
 func initDB(username, password, hostname, port, dbname string) (*sql.DB, error) {
 	var err error
 
 	// Constructing the DSN (Data Source Name)
-	dsn := fmt.Sprintf("%s:%s@tcp(%s:%s)/%s", username, password, hostname, port, dbname)
+	dsn := fmt.Sprintf("%s:%s@tcp(%s:%s)/%s?parseTime=true", username, password, hostname, port, dbname)
 
 	// Open a connection to the database
 	var db *sql.DB
@@ -75,129 +142,389 @@ func initDB(username, password, hostname, port, dbname string) (*sql.DB, error)
 	if err != nil {
 		return nil, fmt.Errorf("failed to ping database: %w", err)
 	}
-	log.Println("Connected to the MySQL database!")
+	slog.Info("connected to the MySQL database")
 	return db, nil
 }
 
-func main() {
-	port := flag.String("port", "8080", "Server Port")
-	dbUsername := flag.String("db-user", "root", "Database Username")
-	dbPassword := flag.String("db-password", "password", "Database Password")
-	dbHostname := flag.String("db-hostname", "localhost", "Database hostname")
-	dbPort := flag.String("db-port", "4450", "Database port")
-	dbName := flag.String("db-name", "library", "Database name")
+// getEnv returns the value of the environment variable key, or fallback when it is unset.
+func getEnv(key, fallback string) string {
+	if value, ok := os.LookupEnv(key); ok {
+		return value
+	}
+	return fallback
+}
 
-	db, err := initDB(*dbUsername, *dbPassword, *dbHostname, *dbPort, *dbName)
+// serve runs the API server on the migrated database db until it fails
+func serve(cfg Config, db *sql.DB) error {
+	shutdownTracing, err := setupTracing(context.Background())
 	if err != nil {
-		log.Fatalf("Error initializing database: %v", err)
+		return fmt.Errorf("error setting up tracing: %w", err)
 	}
-	defer db.Close()
+	defer shutdownTracing(context.Background())
 
-	log.Println("Starting our server.")
+	photoConfig, err := loadPhotoConfig(cfg)
+	if err != nil {
+		return fmt.Errorf("error loading photo configuration: %w", err)
+	}
 
-	r := mux.NewRouter()
+	slog.Info("starting the server")
 
-	r.HandleFunc("/", Home)
-	r.HandleFunc("/info", Info)
-	r.HandleFunc("/books", GetAllBooks(db)).Methods("GET")
-	r.HandleFunc("/authors", GetAuthors(db)).Methods("GET")
-	r.HandleFunc("/authorsbooks", GetAuthorsAndBooks(db)).Methods("GET")
-	r.HandleFunc("/authors/{id}", GetAuthorBooksByID(db)).Methods("GET")
-	r.HandleFunc("/books/{id}", GetBookByID(db)).Methods("GET")
-	r.HandleFunc("/subscribers/{id}", GetSubscribersByBookID(db)).Methods("GET")
-	r.HandleFunc("/subscribers", GetAllSubscribers(db)).Methods("GET")
-	r.HandleFunc("/book/borrow", BorrowBook(db)).Methods("POST")
-	r.HandleFunc("/book/return", ReturnBorrowedBook(db)).Methods("POST")
-	r.HandleFunc("/authors/new", AddAuthor(db)).Methods("POST")
-	r.HandleFunc("/books/new", AddBook(db)).Methods("POST")
-	r.HandleFunc("/subscribers/new", AddSubscriber(db)).Methods("POST")
-	r.HandleFunc("/authors/{id}", UpdateAuthor(db)).Methods("PUT", "POST")
-	r.HandleFunc("/books/{id}", UpdateBook(db)).Methods("PUT", "POST")
-	r.HandleFunc("/subscribers/{id}", UpdateSubscriber(db)).Methods("PUT", "POST")
-	r.HandleFunc("/authors/{id}", DeleteAuthor(db)).Methods("DELETE")
-	r.HandleFunc("/books/{id}", DeleteBook(db)).Methods("DELETE")
-	r.HandleFunc("/subscribers/{id}", DeleteSubscriber(db)).Methods("DELETE")
-    r.HandleFunc("/search_books", SearchBooks(db)).Methods("GET")
-
-
-
-	http.Handle("/", r)
-
-
-	log.Println("Started on port", *port)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	handler, err := setupHandler(ctx, cfg, db, photoConfig)
+	if err != nil {
+		return err
+	}
+
+	server := buildServer(":"+cfg.Port, handler)
+
+	slog.Info("started", "port", cfg.Port)
 	fmt.Println("To close connection CTRL+C :-)")
 
 	// Spinning up the server.
-	err = http.ListenAndServe(":"+*port, nil)
+	// TLS is enabled by setting both the certificate and its key
+	if cfg.TLSCertFile != "" {
+		slog.Info("serving HTTPS")
+		err = server.ListenAndServeTLS(cfg.TLSCertFile, cfg.TLSKeyFile)
+	} else {
+		err = server.ListenAndServe()
+	}
+	return err
+}
+
+// setupHandler returns the router of the API, behind the debug endpoints when they are enabled
+func setupHandler(ctx context.Context, cfg Config, db *sql.DB, photoConfig PhotoConfig) (http.Handler, error) {
+	r, err := setupRouter(ctx, cfg, db, photoConfig)
+	if err != nil {
+		return nil, err
+	}
+	if !cfg.DebugEndpoints {
+		return r, nil
+	}
+	slog.Warn("debug endpoints enabled under /debug/")
+	return withDebugEndpoints(db, r), nil
+}
+
+// setupRouter registers the routes of the API and the middlewares that wrap them. The background work of
+// the middlewares stops when ctx is done.
+func setupRouter(ctx context.Context, cfg Config, db *sql.DB, photoConfig PhotoConfig) (*mux.Router, error) {
+	openLibrary := NewOpenLibraryClient(cfg.OpenLibraryURL)
+	cache := NewCache(ctx, time.Minute)
+	webhooks := NewWebhookDispatcher(db)
+
+	r := mux.NewRouter()
+	r.NotFoundHandler = NotFoundHandler(r)
+	r.MethodNotAllowedHandler = MethodNotAllowedHandler(r)
+	// RecoveryMiddleware isn't the outermost layer on purpose: the middlewares before it turn a panic into
+	// a 500 like any other response, so it carries the request ID and is access logged, timed, compressed
+	// and traced. They don't call the handlers' code and are kept simple enough not to panic themselves.
+	r.Use(RequestIDMiddleware())
+	r.Use(AccessLogMiddleware())
+	r.Use(ResponseTimeMiddleware())
+	r.Use(RateLimitMiddleware(newRateLimiterFromConfig(ctx, cfg), cfg.TrustProxy))
+	r.Use(GzipMiddleware())
+	r.Use(TracingMiddleware())
+	r.Use(RecoveryMiddleware())
+	r.Use(TimeoutMiddleware(cfg.RequestTimeout))
+	r.Use(InvalidateCacheMiddleware(cache))
+
+	r.HandleFunc("/", Home)
+	// The photo URLs stored in the database point to the files, they stay outside of the versions
+	if local, ok := photoConfig.Storage.(*LocalStorage); ok {
+		r.PathPrefix(local.BaseURL + "/").Handler(local.Handler()).Methods("GET")
+	}
+
+	// Version 1 of the API, new routes are only registered under its prefix
+	api := r.PathPrefix(apiV1Prefix).Subrouter()
+	api.NotFoundHandler = NotFoundHandler(api)
+	api.MethodNotAllowedHandler = MethodNotAllowedHandler(api)
+	api.HandleFunc("/info", Info)
+	api.Handle("/books", CacheMiddleware(cache, "books", cfg.CacheTTL)(GetAllBooks(db))).Methods("GET")
+	api.Handle("/authors", CacheMiddleware(cache, "authors", cfg.CacheTTL)(GetAuthors(db))).Methods("GET")
+	api.Handle("/authorsbooks", CacheMiddleware(cache, "authors", cfg.CacheTTL)(GetAuthorsAndBooks(db))).Methods("GET")
+	api.HandleFunc("/authors/{id}", GetAuthorBooksByID(db)).Methods("GET")
+	api.HandleFunc("/authors/{id}/profile", GetAuthorByID(db, cfg.BaseURL)).Methods("GET")
+	api.HandleFunc("/authors/{id}/stats", GetAuthorStats(db)).Methods("GET")
+	api.Handle("/books/available", CacheMiddleware(cache, "books", cfg.CacheTTL)(GetAvailableBooks(db))).Methods("GET")
+	api.HandleFunc("/books/popular", GetMostBorrowedBooks(db)).Methods("GET")
+	api.HandleFunc("/books/by-author", GetBooksByAuthorName(db)).Methods("GET")
+	api.HandleFunc("/books/isbn/{isbn}", GetBookByISBN(db)).Methods("GET")
+	api.HandleFunc("/books/lookup", LookupBook(db, openLibrary)).Methods("GET")
+	api.HandleFunc("/books/formats", GetBookFormats(db)).Methods("GET")
+	api.HandleFunc("/books/export", ExportBooks(db)).Methods("GET")
+	api.HandleFunc("/subscribers/export", ExportSubscribers(db, generateSubscribersPDF)).Methods("GET")
+	api.HandleFunc("/subscribers/search", SearchSubscribers(db)).Methods("GET")
+	api.HandleFunc("/subscribers/expired-memberships", GetExpiredMemberships(db)).Methods("GET")
+	// The ids are numeric, so that a GET on /books/new is a 405 and not an invalid book id
+	api.HandleFunc("/books/{id:[0-9]+}", GetBookByID(db)).Methods("GET")
+	api.HandleFunc("/books/{id}/subscribers", GetSubscribersByBookID(db)).Methods("GET")
+	api.HandleFunc("/books/{id}/borrow-history", GetBookBorrowHistory(db)).Methods("GET")
+	api.HandleFunc("/books/{id}/availability", GetBookAvailability(db)).Methods("GET")
+	api.HandleFunc("/books/{id}/similar", GetSimilarBooks(db)).Methods("GET")
+	api.HandleFunc("/books/{id}/reviews", GetBookReviews(db)).Methods("GET")
+	api.HandleFunc("/books/{id}/reviews", AddReview(db)).Methods("POST")
+	api.HandleFunc("/books/{id}/reviews/{reviewID}", DeleteReview(db)).Methods("DELETE")
+	api.HandleFunc("/books/{id}/tags/{tag_name}", RemoveBookTag(db)).Methods("DELETE")
+	api.HandleFunc("/subscribers/{id}", GetSubscriberByID(db)).Methods("GET")
+	api.HandleFunc("/subscribers/{id}/active-borrows", GetSubscriberActiveBorrows(db)).Methods("GET")
+	api.HandleFunc("/subscribers/{id}/export", ExportSubscriberData(db)).Methods("GET")
+	api.HandleFunc("/subscribers/{id}/anonymize", AnonymizeSubscriber(db)).Methods("POST")
+	api.HandleFunc("/subscribers", GetAllSubscribers(db)).Methods("GET")
+	api.HandleFunc("/stats", GetStats(db)).Methods("GET")
+	api.HandleFunc("/stats/monthly-borrows", GetMonthlyBorrows(db)).Methods("GET")
+	api.HandleFunc("/audit", GetAuditLog(db)).Methods("GET")
+	api.HandleFunc("/admin/loglevel", SetLogLevelHandler()).Methods("POST")
+	api.HandleFunc("/webhooks", GetWebhooks(db)).Methods("GET")
+	api.HandleFunc("/webhooks", AddWebhook(db)).Methods("POST")
+	api.HandleFunc("/webhooks/{id}", DeleteWebhook(db)).Methods("DELETE")
+	api.HandleFunc("/webhooks/{id}/deliveries", GetWebhookDeliveries(db)).Methods("GET")
+	api.HandleFunc("/reports/top-books", GetTopBooksReport(db)).Methods("GET")
+	api.HandleFunc("/genres", GetGenres(db)).Methods("GET")
+	api.HandleFunc("/publishers", GetPublishers(db)).Methods("GET")
+	api.HandleFunc("/genres/new", AddGenre(db)).Methods("POST")
+	api.HandleFunc("/genres/{id}", DeleteGenre(db)).Methods("DELETE")
+	api.HandleFunc("/book/borrow", BorrowBook(db, webhooks)).Methods("POST")
+	api.HandleFunc("/book/return", ReturnBorrowedBook(db, webhooks)).Methods("POST")
+	api.HandleFunc("/book/transfer", TransferBorrow(db)).Methods("POST")
+	api.HandleFunc("/books/{id}/borrow-status", SetBookBorrowStatus(db)).Methods("PATCH")
+	idempotent := IdempotencyMiddleware(db)
+	api.Handle("/authors/new", idempotent(AddAuthor(db, photoConfig))).Methods("POST")
+	api.Handle("/books/new", idempotent(AddBook(db, photoConfig))).Methods("POST")
+	// A CSV upload is a bulk import, otherwise a single book is imported from OpenLibrary by its ISBN
+	api.HandleFunc("/books/import", ImportBooks(db)).Methods("POST").HeadersRegexp("Content-Type", "^multipart/form-data")
+	api.HandleFunc("/books/import", ImportBook(db, openLibrary)).Methods("POST")
+	api.HandleFunc("/subscribers/new", AddSubscriber(db)).Methods("POST")
+	api.HandleFunc("/subscribers/bulk", AddSubscribersBulk(db)).Methods("POST")
+	api.HandleFunc("/authors/{id}", UpdateAuthor(db)).Methods("PUT", "POST")
+	api.HandleFunc("/books/{id:[0-9]+}", UpdateBook(db)).Methods("PUT", "POST")
+	api.HandleFunc("/subscribers/{id}", UpdateSubscriber(db)).Methods("PUT", "POST")
+	api.HandleFunc("/subscribers/{id}", PatchSubscriber(db)).Methods("PATCH")
+	api.HandleFunc("/authors/{id}", DeleteAuthor(db, photoConfig)).Methods("DELETE")
+	api.HandleFunc("/authors/{id}/merge", MergeAuthors(db, photoConfig)).Methods("POST")
+	api.HandleFunc("/authors/{id}/books", LinkBookToAuthor(db)).Methods("POST")
+	api.HandleFunc("/authors/{id}/books/{book_id}", UnlinkBookFromAuthor(db)).Methods("DELETE")
+	api.HandleFunc("/books/{id:[0-9]+}", DeleteBook(db, photoConfig)).Methods("DELETE")
+	api.HandleFunc("/subscribers/{id}", DeleteSubscriber(db)).Methods("DELETE")
+	api.HandleFunc("/author/photo/{id}", ServeAuthorPhoto(db, photoConfig)).Methods("GET")
+	api.HandleFunc("/books/photo/{id}", ServeBookPhoto(db, photoConfig)).Methods("GET")
+	api.HandleFunc("/author/photo/{id}", AddAuthorPhoto(db, photoConfig)).Methods("POST")
+	api.HandleFunc("/books/photo/{id}", AddBookPhoto(db, photoConfig)).Methods("POST")
+	api.HandleFunc("/author/photo/{id}", DeleteAuthorPhoto(db, photoConfig)).Methods("DELETE")
+	api.HandleFunc("/author/photo-url/{id}", SetAuthorPhotoURL(db, photoConfig)).Methods("PATCH")
+	api.HandleFunc("/books/photo-url/{id}", SetBookPhotoURL(db, photoConfig)).Methods("PATCH")
+	api.HandleFunc("/books/photo/{id}", DeleteBookPhoto(db, photoConfig)).Methods("DELETE")
+    api.HandleFunc("/search_books", SearchBooks(db)).Methods("GET")
+
+
+	serveSpec, err := ServeOpenAPISpec(BuildOpenAPISpec(api, apiV1Prefix))
 	if err != nil {
-		log.Fatal(err)
+		return nil, fmt.Errorf("error building the OpenAPI document: %w", err)
+	}
+	r.HandleFunc("/openapi.json", serveSpec).Methods("GET")
+	r.HandleFunc("/docs", APIDocs).Methods("GET")
+
+	// The unprefixed paths of the routes of version 1 that predate it remain as deprecated aliases
+	r.PathPrefix("/").MatcherFunc(legacyPathMatcher(apiV1Prefix, api)).Handler(LegacyAlias(apiV1Prefix, api))
+
+	return r, nil
+}
+
+// buildServer configures the HTTP server for handler, the same with and without TLS
+func buildServer(addr string, handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+		ReadTimeout:       60 * time.Second,
+		IdleTimeout:       120 * time.Second,
 	}
 }
 
 
 // Handler functions...
 
-// Home handles requests to the homepage
+// RespondWithJSON writes payload as JSON with the given status code.
+// The status has to be written before the body, otherwise it defaults to 200.
+func RespondWithJSON(w http.ResponseWriter, status int, payload interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(payload)
+}
+
+// isDuplicateEntry reports whether err is a MySQL unique key violation.
+func isDuplicateEntry(err error) bool {
+	var mysqlErr *mysql.MySQLError
+	return errors.As(err, &mysqlErr) && mysqlErr.Number == mysqlErrDuplicateEntry
+}
+
+// subscriberConflictMessage describes which unique subscriber field a duplicate entry error is about.
+func subscriberConflictMessage(err error) string {
+	if strings.Contains(err.Error(), "uq_subscribers_phone") {
+		return "phone already registered"
+	}
+	return "email already registered"
+}
+
+// nullIfEmpty maps an empty string to NULL so optional unique columns don't collide on ''.
+func nullIfEmpty(value string) interface{} {
+	if value == "" {
+		return nil
+	}
+	return value
+}
+
+// WriteListResponse writes a list payload together with the total number of matching records.
+func WriteListResponse(w http.ResponseWriter, status int, data interface{}, total int) {
+	w.Header().Set("X-Total-Count", strconv.Itoa(total))
+	RespondWithJSON(w, status, data)
+}
+
+// ParsePagination reads the optional page and page_size query parameters.
+// A limit of 0 means no pagination was requested and every record should be returned.
+func ParsePagination(r *http.Request) (limit, offset int, err error) {
+	pageParam := r.URL.Query().Get("page")
+	pageSizeParam := r.URL.Query().Get("page_size")
+	if pageParam == "" && pageSizeParam == "" {
+		return 0, 0, nil
+	}
+
+	page := 1
+	if pageParam != "" {
+		page, err = strconv.Atoi(pageParam)
+		if err != nil || page < 1 {
+			return 0, 0, fmt.Errorf("invalid page parameter")
+		}
+	}
+
+	pageSize := defaultPageSize
+	if pageSizeParam != "" {
+		pageSize, err = strconv.Atoi(pageSizeParam)
+		if err != nil || pageSize < 1 || pageSize > maxPageSize {
+			return 0, 0, fmt.Errorf("page_size must be between 1 and %d", maxPageSize)
+		}
+	}
+
+	return pageSize, (page - 1) * pageSize, nil
+}
+
+// ParseLimit reads the optional limit query parameter, clamped to 1-max.
+func ParseLimit(r *http.Request, defaultLimit, max int) (int, error) {
+	limitParam := r.URL.Query().Get("limit")
+	if limitParam == "" {
+		return defaultLimit, nil
+	}
+	limit, err := strconv.Atoi(limitParam)
+	if err != nil {
+		return 0, fmt.Errorf("invalid limit parameter")
+	}
+	if limit < 1 {
+		limit = 1
+	}
+	if limit > max {
+		limit = max
+	}
+	return limit, nil
+}
+
+// paginate appends a LIMIT/OFFSET clause to query when a limit is set.
+func paginate(query string, args []interface{}, limit, offset int) (string, []interface{}) {
+	if limit == 0 {
+		return query, args
+	}
+	return query + " LIMIT ? OFFSET ?", append(args, limit, offset)
+}
+
+// allowedBookSortColumns maps the sort parameter of the book list to the expression it orders by
+var allowedBookSortColumns = map[string]string{
+	"id":           "books.id",
+	"title":        "books.title",
+	"author":       "authors.Lastname",
+	"borrow_count": "(SELECT COUNT(*) FROM borrowed_books WHERE borrowed_books.book_id = books.id)",
+}
+
+// allowedAuthorSortColumns maps the sort parameter of the author list to the expression it orders by
+var allowedAuthorSortColumns = map[string]string{
+	"id":         "authors.id",
+	"lastname":   "authors.Lastname",
+	"firstname":  "authors.Firstname",
+	"book_count": "book_count",
+}
+
+// allowedAuthorBookSortColumns maps the sort parameter of the author and book pairs to the expression it orders by
+var allowedAuthorBookSortColumns = map[string]string{
+	"id":     "ab.id",
+	"author": "a.Lastname",
+	"book":   "b.title",
+}
+
+// ParseSort reads the optional sort and order query parameters into an ORDER BY clause. Only the columns of
+// allowed can be used, so the clause is never built from user input. idColumn breaks ties to keep pages stable.
+func ParseSort(r *http.Request, allowed map[string]string, defaultSort, idColumn string) (string, error) {
+	sort := r.URL.Query().Get("sort")
+	if sort == "" {
+		sort = defaultSort
+	}
+	column, ok := allowed[sort]
+	if !ok {
+		return "", fmt.Errorf("invalid sort parameter %q", sort)
+	}
+
+	direction := "ASC"
+	switch strings.ToLower(r.URL.Query().Get("order")) {
+	case "", "asc":
+	case "desc":
+		direction = "DESC"
+	default:
+		return "", fmt.Errorf("order must be asc or desc")
+	}
+
+	if column == idColumn {
+		return "ORDER BY " + column + " " + direction, nil
+	}
+	return "ORDER BY " + column + " " + direction + ", " + idColumn, nil
+}
+
+// Home handles requests to the homepage, it lists the versions of the API
 func Home(w http.ResponseWriter, r *http.Request) {
-	fmt.Fprintf(w, "Homepage")
+	RespondWithJSON(w, http.StatusOK, map[string]interface{}{"message": "Homepage", "versions": apiVersions})
 }
 
 // Info handles requests to the info page
 func Info(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
 	fmt.Fprintf(w, "Info page")
 }
 
-// GetAllBooks returns a handler that gets all the books in the database along with the author's first and last name.
+// GetAllBooks returns a handler that gets all the books in the database along with the author's first and last name
+// and their genres. The list can be filtered with ?genre=, ?author_id= and ?is_borrowed=.
 func GetAllBooks(db *sql.DB) http.HandlerFunc {
     return func(w http.ResponseWriter, r *http.Request) {
-        query := `
-            SELECT 
-                books.id AS book_id,
-                books.title AS book_title, 
-                books.author_id AS author_id, 
-                books.photo AS book_photo, 
-                books.is_borrowed AS is_borrowed, 
-                books.details AS book_details,
-                authors.Lastname AS author_lastname, 
-                authors.Firstname AS author_firstname
-            FROM books
-            JOIN authors ON books.author_id = authors.id
-        `
-        rows, err := db.Query(query)
+        limit, offset, err := ParsePagination(r)
         if err != nil {
-            http.Error(w, err.Error(), http.StatusInternalServerError)
+            http.Error(w, err.Error(), http.StatusBadRequest)
             return
         }
-        defer rows.Close()
-        var books []BookAuthorInfo
-        for rows.Next() {
-            var book BookAuthorInfo
-            if err := rows.Scan(&book.BookID, &book.BookTitle, &book.AuthorID, &book.BookPhoto, &book.IsBorrowed, &book.BookDetails, &book.AuthorLastname, &book.AuthorFirstname); err != nil {
-                http.Error(w, err.Error(), http.StatusInternalServerError)
-                return
-            }
 
-            books = append(books, book)
-        }
-        if err := rows.Err(); err != nil {
-            http.Error(w, err.Error(), http.StatusInternalServerError)
+        where, filterArgs, err := bookListFilter(r)
+        if err != nil {
+            http.Error(w, err.Error(), http.StatusBadRequest)
             return
         }
-        json.NewEncoder(w).Encode(books)
-    }
-}
 
+        orderBy, err := ParseSort(r, allowedBookSortColumns, "id", "books.id")
+        if err != nil {
+            http.Error(w, err.Error(), http.StatusBadRequest)
+            return
+        }
 
-// SearchBooks returns a handler that searches for books by title or author.
-func SearchBooks(db *sql.DB) http.HandlerFunc {
-    return func(w http.ResponseWriter, r *http.Request) {
-        query := r.URL.Query().Get("query")
-        if query == "" {
-            http.Error(w, "Query parameter is missing", http.StatusBadRequest)
+        var total int
+        err = db.QueryRowContext(r.Context(), "SELECT COUNT(*) FROM books JOIN authors ON books.author_id = authors.id "+where, filterArgs...).Scan(&total)
+        if err != nil {
+            http.Error(w, err.Error(), http.StatusInternalServerError)
             return
         }
 
-        sqlQuery := `
+        query := `
             SELECT 
                 books.id AS book_id,
                 books.title AS book_title, 
@@ -206,129 +533,576 @@ func SearchBooks(db *sql.DB) http.HandlerFunc {
                 books.is_borrowed AS is_borrowed, 
                 books.details AS book_details,
                 authors.Lastname AS author_lastname, 
-                authors.Firstname AS author_firstname
+                authors.Firstname AS author_firstname,
+                COALESCE(books.isbn, '') AS isbn,
+                COALESCE(books.publisher, '') AS publisher,
+                books.format,
+                (SELECT COUNT(*) FROM borrowed_books WHERE borrowed_books.book_id = books.id) AS borrow_count
             FROM books
             JOIN authors ON books.author_id = authors.id
-            WHERE books.title LIKE ? OR authors.Firstname LIKE ? OR authors.Lastname LIKE ?
+            ` + where + `
+            ` + orderBy + `
         `
-        rows, err := db.Query(sqlQuery, "%"+query+"%", "%"+query+"%", "%"+query+"%")
+        query, args := paginate(query, filterArgs, limit, offset)
+        rows, err := db.QueryContext(r.Context(), query, args...)
         if err != nil {
             http.Error(w, err.Error(), http.StatusInternalServerError)
             return
         }
         defer rows.Close()
 
-        var books []BookAuthorInfo
-        for rows.Next() {
-            var book BookAuthorInfo
-            if err := rows.Scan(&book.BookID, &book.BookTitle, &book.AuthorID, &book.BookPhoto, &book.IsBorrowed, &book.BookDetails, &book.AuthorLastname, &book.AuthorFirstname); err != nil {
-                http.Error(w, err.Error(), http.StatusInternalServerError)
-                return
-            }
+        books, err := ScanBooks(rows)
+        if err != nil {
+            http.Error(w, err.Error(), http.StatusInternalServerError)
+            return
+        }
+        if books == nil {
+            books = []BookAuthorInfo{}
+        }
 
-            books = append(books, book)
+        if err := loadBookGenres(r.Context(), db, books); err != nil {
+            http.Error(w, err.Error(), http.StatusInternalServerError)
+            return
         }
-        if err := rows.Err(); err != nil {
+
+        if err := loadBookAuthors(r.Context(), db, books); err != nil {
             http.Error(w, err.Error(), http.StatusInternalServerError)
             return
         }
-        json.NewEncoder(w).Encode(books)
+        w.Header().Set("X-Total-Count", strconv.Itoa(total))
+        RespondWithETag(w, r, http.StatusOK, books)
     }
 }
 
-func GetAuthors(db *sql.DB) http.HandlerFunc {
+// GetAvailableBooks returns a handler that lists the books that aren't borrowed. It is the book list with
+// is_borrowed=false, so it takes the same filters, sorting and pagination.
+func GetAvailableBooks(db *sql.DB) http.HandlerFunc {
+	listBooks := GetAllBooks(db)
 	return func(w http.ResponseWriter, r *http.Request) {
-		rows, err := db.Query("SELECT id, lastname, firstname, photo FROM authors")
+		r = r.Clone(r.Context())
+		query := r.URL.Query()
+		query.Set("is_borrowed", "false")
+		r.URL.RawQuery = query.Encode()
+		listBooks(w, r)
+	}
+}
+
+// bookListFilter builds the WHERE clause of the genre, author_id, publisher, format and is_borrowed filters of the book list and export
+func bookListFilter(r *http.Request) (string, []interface{}, error) {
+	var conditions []string
+	var args []interface{}
+	query := r.URL.Query()
+
+	if genre := strings.TrimSpace(query.Get("genre")); genre != "" {
+		conditions = append(conditions, "books.id IN (SELECT book_genres.book_id FROM book_genres JOIN genres ON book_genres.genre_id = genres.id WHERE genres.name = ?)")
+		args = append(args, genre)
+	}
+	if authorID := query.Get("author_id"); authorID != "" {
+		id, err := strconv.Atoi(authorID)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
+			return "", nil, fmt.Errorf("invalid author_id parameter")
 		}
-		defer rows.Close()
+		conditions = append(conditions, "(books.author_id = ? OR books.id IN (SELECT authors_books.book_id FROM authors_books WHERE authors_books.author_id = ?))")
+		args = append(args, id, id)
+	}
+	if publisher := strings.TrimSpace(query.Get("publisher")); publisher != "" {
+		conditions = append(conditions, "books.publisher = ?")
+		args = append(args, publisher)
+	}
+	if format := strings.TrimSpace(query.Get("format")); format != "" {
+		if err := ValidateBookFormat(format); err != nil {
+			return "", nil, err
+		}
+		conditions = append(conditions, "books.format = ?")
+		args = append(args, format)
+	}
+	if isBorrowed := query.Get("is_borrowed"); isBorrowed != "" {
+		borrowed, err := strconv.ParseBool(isBorrowed)
+		if err != nil {
+			return "", nil, fmt.Errorf("is_borrowed must be true or false")
+		}
+		conditions = append(conditions, "books.is_borrowed = ?")
+		args = append(args, borrowed)
+	}
 
-		var authors []Author
-		for rows.Next() {
-			var author Author
-			if err := rows.Scan(&author.ID, &author.Lastname, &author.Firstname, &author.Photo); err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
-				return
-			}
-			authors = append(authors, author)
+	if len(conditions) == 0 {
+		return "", nil, nil
+	}
+	return "WHERE " + strings.Join(conditions, " AND "), args, nil
+}
+
+// ScanBooks reads books with their main author from rows selected in the order
+// book_id, book_title, author_id, book_photo, is_borrowed, book_details, author_lastname, author_firstname, isbn, publisher, format, borrow_count.
+func ScanBooks(rows *sql.Rows) ([]BookAuthorInfo, error) {
+	var books []BookAuthorInfo
+	for rows.Next() {
+		var book BookAuthorInfo
+		var author AuthorInfo
+		if err := rows.Scan(&book.BookID, &book.BookTitle, &book.AuthorID, &book.BookPhoto, &book.IsBorrowed, &book.BookDetails, &author.Lastname, &author.Firstname, &book.ISBN, &book.Publisher, &book.Format, &book.BorrowCount); err != nil {
+			return nil, err
 		}
-		if err := rows.Err(); err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
+		author.ID = book.AuthorID
+		book.Authors = []AuthorInfo{author}
+		books = append(books, book)
+	}
+	return books, rows.Err()
+}
+
+// loadBookAuthors replaces the main author of books by all their authors from authors_books, in the order
+// they were linked. Books without links keep their main author.
+func loadBookAuthors(ctx context.Context, db *sql.DB, books []BookAuthorInfo) error {
+	if len(books) == 0 {
+		return nil
+	}
+
+	placeholders := make([]string, len(books))
+	args := make([]interface{}, len(books))
+	for i, book := range books {
+		placeholders[i] = "?"
+		args[i] = book.BookID
+	}
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT authors_books.book_id, authors.id, authors.Firstname, authors.Lastname
+		FROM authors_books
+		JOIN authors ON authors_books.author_id = authors.id
+		WHERE authors_books.book_id IN (`+strings.Join(placeholders, ", ")+`)
+		ORDER BY authors_books.id
+	`, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	authors := make(map[int][]AuthorInfo)
+	for rows.Next() {
+		var bookID int
+		var author AuthorInfo
+		if err := rows.Scan(&bookID, &author.ID, &author.Firstname, &author.Lastname); err != nil {
+			return err
 		}
+		authors[bookID] = append(authors[bookID], author)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
 
-		json.NewEncoder(w).Encode(authors)
+	for i, book := range books {
+		if bookAuthors, ok := authors[book.BookID]; ok {
+			books[i].Authors = bookAuthors
+		}
 	}
+	return nil
 }
 
+// setBookAuthors replaces the authors_books links of a book by authorIDs, dropping repeated ids.
+// It fails with errUnknownAuthor when one of the authors doesn't exist.
+func setBookAuthors(ctx context.Context, tx *sql.Tx, bookID int64, authorIDs []int) error {
+	if _, err := tx.ExecContext(ctx, "DELETE FROM authors_books WHERE book_id = ?", bookID); err != nil {
+		return err
+	}
 
+	seen := make(map[int]bool)
+	for _, authorID := range authorIDs {
+		if seen[authorID] {
+			continue
+		}
+		seen[authorID] = true
 
-// GetAuthorsAndBooks returns a handler function that retrieves information about authors and their books.
-func GetAuthorsAndBooks(db *sql.DB) http.HandlerFunc {
+		var exists bool
+		if err := tx.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM authors WHERE id = ?)", authorID).Scan(&exists); err != nil {
+			return err
+		}
+		if !exists {
+			return fmt.Errorf("%w: %d", errUnknownAuthor, authorID)
+		}
+
+		if _, err := tx.ExecContext(ctx, "INSERT INTO authors_books (author_id, book_id) VALUES (?, ?)", authorID, bookID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setBookMainAuthor moves the authors_books link of the previous main author of a book to authorID, keeping
+// the links of its co-authors. It fails with errUnknownAuthor when the author doesn't exist.
+func setBookMainAuthor(ctx context.Context, tx *sql.Tx, bookID int64, previousAuthorID, authorID int) error {
+	if previousAuthorID == authorID {
+		return nil
+	}
+
+	var exists bool
+	if err := tx.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM authors WHERE id = ?)", authorID).Scan(&exists); err != nil {
+		return err
+	}
+	if !exists {
+		return fmt.Errorf("%w: %d", errUnknownAuthor, authorID)
+	}
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM authors_books WHERE author_id = ? AND book_id = ?", previousAuthorID, bookID); err != nil {
+		return err
+	}
+	_, err := tx.ExecContext(ctx, "INSERT IGNORE INTO authors_books (author_id, book_id) VALUES (?, ?)", authorID, bookID)
+	return err
+}
+
+// errUnknownAuthor is returned when a book is linked to an author that doesn't exist
+var errUnknownAuthor = errors.New("unknown author")
+
+// GetBooksByAuthorName returns a handler that finds the books of authors matching a first and/or last name.
+func GetBooksByAuthorName(db *sql.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		firstname := strings.TrimSpace(r.URL.Query().Get("firstname"))
+		lastname := strings.TrimSpace(r.URL.Query().Get("lastname"))
+		if firstname == "" && lastname == "" {
+			http.Error(w, "firstname or lastname parameter is required", http.StatusBadRequest)
+			return
+		}
+
+		// A missing name matches any value
 		query := `
-			SELECT a.Firstname AS author_firstname, a.Lastname AS author_lastname, b.title AS book_title, b.photo AS book_photo
-			FROM authors_books ab
-			JOIN authors a ON ab.author_id = a.id
-			JOIN books b ON ab.book_id = b.id
+			SELECT
+				books.id AS book_id,
+				books.title AS book_title,
+				books.author_id AS author_id,
+				books.photo AS book_photo,
+				books.is_borrowed AS is_borrowed,
+				books.details AS book_details,
+				authors.Lastname AS author_lastname,
+				authors.Firstname AS author_firstname,
+				COALESCE(books.isbn, '') AS isbn,
+				COALESCE(books.publisher, '') AS publisher,
+				books.format,
+				(SELECT COUNT(*) FROM borrowed_books WHERE borrowed_books.book_id = books.id) AS borrow_count
+			FROM books
+			JOIN authors ON books.author_id = authors.id
+			WHERE authors.Firstname LIKE ? AND authors.Lastname LIKE ?
+			ORDER BY books.title
 		`
-		rows, err := db.Query(query)
+		rows, err := db.QueryContext(r.Context(), query, "%"+firstname+"%", "%"+lastname+"%")
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
-
 		defer rows.Close()
 
-		var authorsAndBooks []AuthorBook
-		for rows.Next() {
-			var authorFirstname, authorLastname, bookTitle, bookPhoto string
-			if err := rows.Scan(&authorFirstname, &authorLastname, &bookTitle, &bookPhoto); err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
-				return
-			}
+		books, err := ScanBooks(rows)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if books == nil {
+			books = []BookAuthorInfo{}
+		}
 
-			authorBook := AuthorBook{
-				AuthorFirstname: authorFirstname,
-				AuthorLastname:  authorLastname,
-				BookTitle:       bookTitle,
-				BookPhoto:       bookPhoto,
-			}
+		RespondWithJSON(w, http.StatusOK, books)
+	}
+}
 
-			authorsAndBooks = append(authorsAndBooks, authorBook)
+// GetBookAvailability returns a handler that only tells whether a book can be borrowed right now. It reads
+// a single column and is deliberately left out of the cache so it always reflects the live state.
+func GetBookAvailability(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		bookID, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			http.Error(w, "Invalid book ID", http.StatusBadRequest)
+			return
 		}
 
-		if err := rows.Err(); err != nil {
+		var isBorrowed bool
+		err = db.QueryRowContext(r.Context(), "SELECT is_borrowed FROM books WHERE id = ?", bookID).Scan(&isBorrowed)
+		if err == sql.ErrNoRows {
+			RespondWithJSON(w, http.StatusNotFound, map[string]interface{}{"available": false, "reason": "not found"})
+			return
+		}
+		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
 
-		json.NewEncoder(w).Encode(authorsAndBooks)
+		w.Header().Set("Cache-Control", "no-store")
+		RespondWithJSON(w, http.StatusOK, map[string]interface{}{
+			"book_id":     bookID,
+			"is_borrowed": isBorrowed,
+			"available":   !isBorrowed,
+		})
 	}
 }
 
-// GetAuthorBooksByID returns a handler function that retrieves information about an author and their books by the author's ID.
-func GetAuthorBooksByID(db *sql.DB) http.HandlerFunc {
+// SearchBooks returns a handler that searches for books by title or author.
+func SearchBooks(db *sql.DB) http.HandlerFunc {
     return func(w http.ResponseWriter, r *http.Request) {
-		vars := mux.Vars(r)
-        authorID := vars["id"]
-        id, err := strconv.Atoi(authorID)
+        query := r.URL.Query().Get("query")
+        tagsParam := r.URL.Query().Get("tags")
+        publisher := strings.TrimSpace(r.URL.Query().Get("publisher"))
+        if query == "" && tagsParam == "" && publisher == "" {
+            http.Error(w, "Query parameter is missing", http.StatusBadRequest)
+            return
+        }
+
+        limit, offset, err := ParsePagination(r)
         if err != nil {
-            http.Error(w, "Invalid author ID", http.StatusBadRequest)
+            http.Error(w, err.Error(), http.StatusBadRequest)
             return
         }
 
-        query := `
-            SELECT a.Firstname AS author_firstname, a.Lastname AS author_lastname, a.Photo AS author_photo, b.title AS book_title, b.photo AS book_photo
+        var conditions []string
+        var args []interface{}
+        if query != "" {
+            conditions = append(conditions, "(books.title LIKE ? OR authors.Firstname LIKE ? OR authors.Lastname LIKE ?)")
+            args = append(args, "%"+query+"%", "%"+query+"%", "%"+query+"%")
+        }
+        if publisher != "" {
+            conditions = append(conditions, "books.publisher = ?")
+            args = append(args, publisher)
+        }
+
+        // Keep the books that have at least one of the comma-separated tags
+        if tagNames := normalizeTagNames(strings.Split(tagsParam, ",")); len(tagNames) > 0 {
+            placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(tagNames)), ", ")
+            conditions = append(conditions, "books.id IN (SELECT book_tags.book_id FROM book_tags JOIN tags ON book_tags.tag_id = tags.id WHERE tags.name IN ("+placeholders+"))")
+            for _, name := range tagNames {
+                args = append(args, name)
+            }
+        }
+        if len(conditions) == 0 {
+            http.Error(w, "Query parameter is missing", http.StatusBadRequest)
+            return
+        }
+        where := "WHERE " + strings.Join(conditions, " AND ")
+
+        var total int
+        err = db.QueryRowContext(r.Context(), "SELECT COUNT(*) FROM books JOIN authors ON books.author_id = authors.id "+where, args...).Scan(&total)
+        if err != nil {
+            http.Error(w, err.Error(), http.StatusInternalServerError)
+            return
+        }
+
+        sqlQuery := `
+            SELECT 
+                books.id AS book_id,
+                books.title AS book_title, 
+                books.author_id AS author_id, 
+                books.photo AS book_photo, 
+                books.is_borrowed AS is_borrowed, 
+                books.details AS book_details,
+                authors.Lastname AS author_lastname, 
+                authors.Firstname AS author_firstname,
+                COALESCE(books.isbn, '') AS isbn,
+                COALESCE(books.publisher, '') AS publisher,
+                books.format,
+                (SELECT COUNT(*) FROM borrowed_books WHERE borrowed_books.book_id = books.id) AS borrow_count
+            FROM books
+            JOIN authors ON books.author_id = authors.id
+            ` + where + `
+            ORDER BY books.id
+        `
+        sqlQuery, args = paginate(sqlQuery, args, limit, offset)
+        rows, err := db.QueryContext(r.Context(), sqlQuery, args...)
+        if err != nil {
+            http.Error(w, err.Error(), http.StatusInternalServerError)
+            return
+        }
+        defer rows.Close()
+
+        books, err := ScanBooks(rows)
+        if err != nil {
+            http.Error(w, err.Error(), http.StatusInternalServerError)
+            return
+        }
+        WriteListResponse(w, http.StatusOK, books, total)
+    }
+}
+
+// authorHasBooks is the condition on authors that they are the main author or a co-author of a book
+const authorHasBooks = "(EXISTS (SELECT 1 FROM books WHERE books.author_id = authors.id)" +
+	" OR EXISTS (SELECT 1 FROM authors_books WHERE authors_books.author_id = authors.id))"
+
+// GetAuthors returns a handler that gets all the authors in the database along with their number of books.
+// The optional has_books=true|false parameter keeps only the authors with or without books, no_books=true
+// is the same as has_books=false.
+func GetAuthors(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		limit, offset, err := ParsePagination(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		hasBooks := r.URL.Query().Get("has_books")
+		switch r.URL.Query().Get("no_books") {
+		case "":
+		case "true":
+			if hasBooks == "true" {
+				http.Error(w, "no_books=true can't be combined with has_books=true", http.StatusBadRequest)
+				return
+			}
+			hasBooks = "false"
+		case "false":
+		default:
+			http.Error(w, "no_books must be true or false", http.StatusBadRequest)
+			return
+		}
+
+		where := ""
+		switch hasBooks {
+		case "":
+		case "true":
+			where = "WHERE " + authorHasBooks
+		case "false":
+			where = "WHERE NOT " + authorHasBooks
+		default:
+			http.Error(w, "has_books must be true or false", http.StatusBadRequest)
+			return
+		}
+
+		orderBy, err := ParseSort(r, allowedAuthorSortColumns, "id", "authors.id")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		var total int
+		if err := db.QueryRowContext(r.Context(), "SELECT COUNT(*) FROM authors "+where).Scan(&total); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		query := `
+			SELECT authors.id, authors.lastname, authors.firstname, authors.photo, COUNT(written.book_id) AS book_count
+			FROM authors
+			LEFT JOIN (
+				SELECT author_id, id AS book_id FROM books
+				UNION
+				SELECT author_id, book_id FROM authors_books
+			) written ON written.author_id = authors.id
+			` + where + `
+			GROUP BY authors.id
+			` + orderBy + `
+		`
+		query, args := paginate(query, nil, limit, offset)
+		rows, err := db.QueryContext(r.Context(), query, args...)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer rows.Close()
+
+		authors, err := ScanAuthorsWithCount(rows)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("X-Total-Count", strconv.Itoa(total))
+		RespondWithETag(w, r, http.StatusOK, authors)
+	}
+}
+
+
+
+// ScanAuthorsWithCount reads authors and their book count from rows.
+func ScanAuthorsWithCount(rows *sql.Rows) ([]AuthorWithCount, error) {
+	authors := []AuthorWithCount{}
+	for rows.Next() {
+		var author AuthorWithCount
+		if err := rows.Scan(&author.ID, &author.Lastname, &author.Firstname, &author.Photo, &author.BookCount); err != nil {
+			return nil, err
+		}
+		authors = append(authors, author)
+	}
+	return authors, rows.Err()
+}
+
+// GetAuthorsAndBooks returns a handler function that retrieves information about authors and their books.
+// ?author_id= and ?book_id= narrow it down to the books of an author or the authors of a book, and it is
+// sorted by author or book with ?sort= and ?order=.
+func GetAuthorsAndBooks(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		where := "WHERE 1 = 1"
+		var args []interface{}
+		for _, filter := range []struct{ param, column string }{{"author_id", "ab.author_id"}, {"book_id", "ab.book_id"}} {
+			value := r.URL.Query().Get(filter.param)
+			if value == "" {
+				continue
+			}
+			id, err := strconv.Atoi(value)
+			if err != nil {
+				http.Error(w, "Invalid "+filter.param, http.StatusBadRequest)
+				return
+			}
+			where += " AND " + filter.column + " = ?"
+			args = append(args, id)
+		}
+
+		orderBy, err := ParseSort(r, allowedAuthorBookSortColumns, "id", "ab.id")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		query := `
+			SELECT a.Firstname AS author_firstname, a.Lastname AS author_lastname, b.title AS book_title, b.photo AS book_photo
+			FROM authors_books ab
+			JOIN authors a ON ab.author_id = a.id
+			JOIN books b ON ab.book_id = b.id
+			` + where + `
+			` + orderBy
+		rows, err := db.QueryContext(r.Context(), query, args...)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		defer rows.Close()
+
+		authorsAndBooks := []AuthorBook{}
+		for rows.Next() {
+			var authorFirstname, authorLastname, bookTitle, bookPhoto string
+			if err := rows.Scan(&authorFirstname, &authorLastname, &bookTitle, &bookPhoto); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+
+			authorBook := AuthorBook{
+				AuthorFirstname: authorFirstname,
+				AuthorLastname:  authorLastname,
+				BookTitle:       bookTitle,
+				BookPhoto:       bookPhoto,
+			}
+
+			authorsAndBooks = append(authorsAndBooks, authorBook)
+		}
+
+		if err := rows.Err(); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		RespondWithETag(w, r, http.StatusOK, authorsAndBooks)
+	}
+}
+
+// GetAuthorBooksByID returns a handler function that retrieves information about an author and their books by the author's ID.
+func GetAuthorBooksByID(db *sql.DB) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+        authorID := vars["id"]
+        id, err := strconv.Atoi(authorID)
+        if err != nil {
+            http.Error(w, "Invalid author ID", http.StatusBadRequest)
+            return
+        }
+
+        query := `
+            SELECT a.Firstname AS author_firstname, a.Lastname AS author_lastname, a.Photo AS author_photo, b.title AS book_title, b.photo AS book_photo
             FROM authors_books ab
             JOIN authors a ON ab.author_id = a.id
             JOIN books b ON ab.book_id = b.id
             WHERE a.id = ?
         `
 
-        rows, err := db.Query(query, id)
+        rows, err := db.QueryContext(r.Context(), query, id)
         if err != nil {
             http.Error(w, err.Error(), http.StatusInternalServerError)
             return
@@ -366,680 +1140,2323 @@ func GetAuthorBooksByID(db *sql.DB) http.HandlerFunc {
             Books:           books,
         }
 
-        json.NewEncoder(w).Encode(authorAndBooks)
+        RespondWithETag(w, r, http.StatusOK, authorAndBooks)
     }
 }
 
 
-// GetBookById retrieves information about a specific book based on its ID
-func GetBookByID(db *sql.DB) http.HandlerFunc {
+// GetMostBorrowedBooks returns a handler that ranks the books by how many times they have been borrowed.
+func GetMostBorrowedBooks(db *sql.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		bookID := mux.Vars(r)["id"]
-		intBookID, err := strconv.Atoi(bookID)
-        if err != nil {
-            http.Error(w, "Invalid book ID", http.StatusBadRequest)
-            return
-        }
-		query :=`
-			SELECT 
-				books.title AS book_title, 
-				books.author_id AS author_id, 
-				books.photo AS book_photo, 
-				books.is_borrowed AS is_borrowed, 
+		limit, err := ParseLimit(r, 10, 50)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		query := `
+			SELECT
 				books.id AS book_id,
+				books.title AS book_title,
+				books.author_id AS author_id,
+				books.photo AS book_photo,
+				books.is_borrowed AS is_borrowed,
 				books.details AS book_details,
-				authors.Lastname AS author_lastname, 
-				authors.Firstname AS author_firstname
+				authors.Lastname AS author_lastname,
+				authors.Firstname AS author_firstname,
+				COALESCE(books.isbn, '') AS isbn,
+				COALESCE(books.publisher, '') AS publisher,
+				books.format,
+				COUNT(*) AS borrow_count
+			FROM borrowed_books
+			JOIN books ON borrowed_books.book_id = books.id
+			JOIN authors ON books.author_id = authors.id
+			GROUP BY books.id, authors.id
+			ORDER BY borrow_count DESC, books.id
+			LIMIT ?
+		`
+		rows, err := db.QueryContext(r.Context(), query, limit)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer rows.Close()
+
+		// The books are listed as in GET /books, with their genres and co-authors
+		books, err := ScanBooks(rows)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if books == nil {
+			books = []BookAuthorInfo{}
+		}
+		if err := loadBookGenres(r.Context(), db, books); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if err := loadBookAuthors(r.Context(), db, books); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		RespondWithJSON(w, http.StatusOK, books)
+	}
+}
+
+// GetAuthorByID returns a handler that gets the basic record of a single author. photo_url is the address
+// the photo is served at under baseURL, so clients don't need to know where it is stored; the stored path
+// itself isn't returned.
+func GetAuthorByID(db *sql.DB, baseURL string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		authorID, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			http.Error(w, "Invalid author ID", http.StatusBadRequest)
+			return
+		}
+
+		var author AuthorProfile
+		var photo string
+		err = db.QueryRowContext(r.Context(), "SELECT id, lastname, firstname, photo FROM authors WHERE id = ?", authorID).Scan(&author.ID, &author.Lastname, &author.Firstname, &photo)
+		if err == sql.ErrNoRows {
+			http.Error(w, "Author not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if photo != "" {
+			author.PhotoURL = authorPhotoURL(baseURL, author.ID)
+		}
+
+		RespondWithJSON(w, http.StatusOK, author)
+	}
+}
+
+// GetAuthorStats returns a handler that computes the circulation metrics of an author's books.
+func GetAuthorStats(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		authorID, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			http.Error(w, "Invalid author ID", http.StatusBadRequest)
+			return
+		}
+
+		// One row per author; books and borrows are counted with conditional aggregation
+		query := `
+			SELECT
+				a.id,
+				COUNT(DISTINCT b.id) AS total_books,
+				COUNT(DISTINCT CASE WHEN b.is_borrowed THEN b.id END) AS borrowed_books,
+				COUNT(DISTINCT CASE WHEN NOT b.is_borrowed THEN b.id END) AS available_books,
+				COUNT(bb.book_id) AS total_times_borrowed,
+				COALESCE(AVG(CASE WHEN bb.return_date IS NOT NULL THEN DATEDIFF(bb.return_date, bb.date_of_borrow) END), 0) AS average_borrow_duration_days
+			FROM authors a
+			LEFT JOIN books b ON b.author_id = a.id
+			LEFT JOIN borrowed_books bb ON bb.book_id = b.id
+			WHERE a.id = ?
+			GROUP BY a.id
+		`
+
+		var stats AuthorStats
+		err = db.QueryRowContext(r.Context(), query, authorID).Scan(&stats.AuthorID, &stats.TotalBooks, &stats.BorrowedBooks, &stats.AvailableBooks, &stats.TotalTimesBorrowed, &stats.AverageBorrowDurationDays)
+		if err == sql.ErrNoRows {
+			http.Error(w, "Author not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		RespondWithJSON(w, http.StatusOK, stats)
+	}
+}
+
+// GetBookByISBN returns a handler that finds a book by its ISBN, given with or without hyphens.
+func GetBookByISBN(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		isbn, err := NormalizeISBN(mux.Vars(r)["isbn"])
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		query := `
+			SELECT
+				books.id AS book_id,
+				books.title AS book_title,
+				books.author_id AS author_id,
+				books.photo AS book_photo,
+				books.is_borrowed AS is_borrowed,
+				books.details AS book_details,
+				authors.Lastname AS author_lastname,
+				authors.Firstname AS author_firstname,
+				COALESCE(books.isbn, '') AS isbn,
+				COALESCE(books.publisher, '') AS publisher,
+				books.format,
+				(SELECT COUNT(*) FROM borrowed_books WHERE borrowed_books.book_id = books.id) AS borrow_count
 			FROM books
 			JOIN authors ON books.author_id = authors.id
-			WHERE books.id = ?
+			WHERE books.isbn = ?
 		`
+		rows, err := db.QueryContext(r.Context(), query, isbn)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer rows.Close()
+
+		books, err := ScanBooks(rows)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if len(books) == 0 {
+			http.Error(w, "Book not found", http.StatusNotFound)
+			return
+		}
+
+		books[0].Tags, err = getBookTags(r.Context(), db, books[0].BookID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		RespondWithJSON(w, http.StatusOK, books[0])
+	}
+}
+
+// GetSimilarBooks returns a handler that recommends books sharing the author or at least one tag with a book,
+// the ones with the most shared tags first.
+func GetSimilarBooks(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		bookID, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			http.Error(w, "Invalid book ID", http.StatusBadRequest)
+			return
+		}
+
+		limit, err := ParseLimit(r, 5, 50)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		var authorID int
+		err = db.QueryRowContext(r.Context(), "SELECT author_id FROM books WHERE id = ?", bookID).Scan(&authorID)
+		if err == sql.ErrNoRows {
+			http.Error(w, "Book not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		// shared counts, per other book, the tags it has in common with the source book
+		query := `
+			SELECT
+				books.id AS book_id,
+				books.title AS book_title,
+				books.author_id AS author_id,
+				books.photo AS book_photo,
+				books.is_borrowed AS is_borrowed,
+				books.details AS book_details,
+				authors.Lastname AS author_lastname,
+				authors.Firstname AS author_firstname,
+				COALESCE(books.isbn, '') AS isbn,
+				COALESCE(books.publisher, '') AS publisher,
+				books.format,
+				(SELECT COUNT(*) FROM borrowed_books WHERE borrowed_books.book_id = books.id) AS borrow_count
+			FROM books
+			JOIN authors ON books.author_id = authors.id
+			LEFT JOIN (
+				SELECT other.book_id, COUNT(*) AS shared_tags
+				FROM book_tags source
+				JOIN book_tags other ON other.tag_id = source.tag_id
+				WHERE source.book_id = ?
+				GROUP BY other.book_id
+			) shared ON shared.book_id = books.id
+			WHERE books.id != ? AND (books.author_id = ? OR shared.shared_tags > 0)
+			ORDER BY COALESCE(shared.shared_tags, 0) DESC, books.id
+			LIMIT ?
+		`
+		rows, err := db.QueryContext(r.Context(), query, bookID, bookID, authorID, limit)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer rows.Close()
+
+		books, err := ScanBooks(rows)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if books == nil {
+			books = []BookAuthorInfo{}
+		}
+
+		RespondWithJSON(w, http.StatusOK, books)
+	}
+}
+
+// GetBookById retrieves information about a specific book based on its ID
+func GetBookByID(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		bookID := mux.Vars(r)["id"]
+		intBookID, err := strconv.Atoi(bookID)
+        if err != nil {
+            http.Error(w, "Invalid book ID", http.StatusBadRequest)
+            return
+        }
+		query :=`
+			SELECT 
+				books.title AS book_title, 
+				books.author_id AS author_id, 
+				books.photo AS book_photo, 
+				books.is_borrowed AS is_borrowed, 
+				books.id AS book_id,
+				books.details AS book_details,
+				authors.Lastname AS author_lastname, 
+				authors.Firstname AS author_firstname,
+				COALESCE(books.isbn, '') AS isbn,
+				COALESCE(books.publisher, '') AS publisher,
+				books.format,
+				(SELECT COUNT(*) FROM borrowed_books WHERE borrowed_books.book_id = books.id) AS borrow_count
+			FROM books
+			JOIN authors ON books.author_id = authors.id
+			WHERE books.id = ?
+		`
+
+		rows, err := db.QueryContext(r.Context(), query, intBookID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer rows.Close()
+
+		var books []BookAuthorInfo
+		for rows.Next() {
+			var book BookAuthorInfo
+			var author AuthorInfo
+			if err := rows.Scan(&book.BookTitle, &book.AuthorID, &book.BookPhoto, &book.IsBorrowed, &book.BookID, &book.BookDetails, &author.Lastname, &author.Firstname, &book.ISBN, &book.Publisher, &book.Format, &book.BorrowCount); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			author.ID = book.AuthorID
+			book.Authors = []AuthorInfo{author}
+
+			books = append(books, book)
+		}
+
+		if err := rows.Err(); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if len(books) == 0 {
+			http.Error(w, "Book not found", http.StatusNotFound)
+			return
+		}
+
+		books[0].Tags, err = getBookTags(r.Context(), db, intBookID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if err := loadBookGenres(r.Context(), db, books[:1]); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if err := loadBookAuthors(r.Context(), db, books[:1]); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		// The average is NULL, and left out, while the book has no reviews
+		var reviewCount int
+		var averageRating sql.NullFloat64
+		err = db.QueryRowContext(r.Context(), "SELECT COUNT(*), AVG(rating) FROM reviews WHERE book_id = ?", intBookID).Scan(&reviewCount, &averageRating)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		books[0].ReviewCount = &reviewCount
+		if averageRating.Valid {
+			books[0].AverageRating = &averageRating.Float64
+		}
+
+		RespondWithETag(w, r, http.StatusOK, books[0])
+	}
+}
+
+// BorrowHistoryEntry is one loan of a book. DueDate is unset for loans made before due dates were recorded
+// and ReturnDate while the book is still out. The subscriber is unset for the loans recorded when a book is
+// marked borrowed by hand.
+type BorrowHistoryEntry struct {
+	SubscriberID        *int       `json:"subscriber_id"`
+	SubscriberFirstname string     `json:"subscriber_firstname"`
+	SubscriberLastname  string     `json:"subscriber_lastname"`
+	SubscriberEmail     string     `json:"subscriber_email"`
+	DateOfBorrow        *time.Time `json:"date_of_borrow"`
+	DueDate             *time.Time `json:"due_date"`
+	ReturnDate          *time.Time `json:"return_date"`
+}
+
+// GetBookBorrowHistory returns a handler that lists every loan of a book, the most recent first.
+func GetBookBorrowHistory(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		bookID, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			http.Error(w, "Invalid book ID", http.StatusBadRequest)
+			return
+		}
+
+		var exists bool
+		err = db.QueryRowContext(r.Context(), "SELECT EXISTS(SELECT 1 FROM books WHERE id = ?)", bookID).Scan(&exists)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !exists {
+			http.Error(w, "Book not found", http.StatusNotFound)
+			return
+		}
+
+		rows, err := db.QueryContext(r.Context(), `
+			SELECT subscribers.id, COALESCE(subscribers.Firstname, ''), COALESCE(subscribers.Lastname, ''),
+				COALESCE(subscribers.Email, ''),
+				borrowed_books.date_of_borrow, borrowed_books.due_date, borrowed_books.return_date
+			FROM borrowed_books
+			LEFT JOIN subscribers ON borrowed_books.subscriber_id = subscribers.id
+			WHERE borrowed_books.book_id = ?
+			ORDER BY borrowed_books.date_of_borrow DESC
+		`, bookID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer rows.Close()
+
+		history := []BorrowHistoryEntry{}
+		for rows.Next() {
+			var entry BorrowHistoryEntry
+			if err := rows.Scan(&entry.SubscriberID, &entry.SubscriberFirstname, &entry.SubscriberLastname, &entry.SubscriberEmail,
+				&entry.DateOfBorrow, &entry.DueDate, &entry.ReturnDate); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			history = append(history, entry)
+		}
+		if err := rows.Err(); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		RespondWithJSON(w, http.StatusOK, history)
+	}
+}
+
+// ActiveBorrowInfo is a book a subscriber currently has checked out
+type ActiveBorrowInfo struct {
+	BookID          int        `json:"book_id"`
+	BookTitle       string     `json:"book_title"`
+	AuthorFirstname string     `json:"author_firstname"`
+	AuthorLastname  string     `json:"author_lastname"`
+	DateOfBorrow    *time.Time `json:"date_of_borrow"`
+	DueDate         *time.Time `json:"due_date"`
+}
+
+// GetSubscriberActiveBorrows returns a handler that lists the books a subscriber has not returned yet, oldest loan first.
+func GetSubscriberActiveBorrows(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		subscriberID, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			http.Error(w, "Invalid subscriber ID", http.StatusBadRequest)
+			return
+		}
+
+		var exists bool
+		err = db.QueryRowContext(r.Context(), "SELECT EXISTS(SELECT 1 FROM subscribers WHERE id = ?)", subscriberID).Scan(&exists)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !exists {
+			http.Error(w, "Subscriber not found", http.StatusNotFound)
+			return
+		}
+
+		rows, err := db.QueryContext(r.Context(), `
+			SELECT books.id, books.title, authors.Firstname, authors.Lastname,
+				borrowed_books.date_of_borrow, borrowed_books.due_date
+			FROM borrowed_books
+			JOIN books ON borrowed_books.book_id = books.id
+			JOIN authors ON books.author_id = authors.id
+			WHERE borrowed_books.subscriber_id = ? AND borrowed_books.return_date IS NULL
+			ORDER BY borrowed_books.date_of_borrow
+		`, subscriberID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer rows.Close()
+
+		borrows := []ActiveBorrowInfo{}
+		for rows.Next() {
+			var borrow ActiveBorrowInfo
+			if err := rows.Scan(&borrow.BookID, &borrow.BookTitle, &borrow.AuthorFirstname, &borrow.AuthorLastname, &borrow.DateOfBorrow, &borrow.DueDate); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			borrows = append(borrows, borrow)
+		}
+		if err := rows.Err(); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		RespondWithJSON(w, http.StatusOK, borrows)
+	}
+}
+
+func GetSubscribersByBookID(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Extract the book ID from the URL path using Gorilla Mux
+		bookID := mux.Vars(r)["id"]
+		if bookID == "" {
+			http.Error(w, "Missing book ID parameter", http.StatusBadRequest)
+			return
+		}
+
+		query := `
+			SELECT s.id, s.Lastname, s.Firstname, s.Email, COALESCE(s.phone, ''), s.membership_expiry
+			FROM subscribers s
+			JOIN borrowed_books bb ON s.id = bb.subscriber_id
+			WHERE bb.book_id = ?
+		`
+
+		rows, err := db.QueryContext(r.Context(), query, bookID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer rows.Close()
+
+		subscribers := []Subscriber{}
+
+		// Iterate over the query result set and populate the subscribers slice
+		for rows.Next() {
+			var subscriber Subscriber
+			if err := rows.Scan(&subscriber.ID, &subscriber.Lastname, &subscriber.Firstname, &subscriber.Email, &subscriber.Phone, &subscriber.MembershipExpiry); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			subscribers = append(subscribers, subscriber)
+		}
+
+		if err := rows.Err(); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		RespondWithJSON(w, http.StatusOK, subscribers)
+	}
+}
+
+// GetAllSubscribers returns a handler that gets all the subscribers in the database.
+func GetAllSubscribers(db *sql.DB) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        limit, offset, err := ParsePagination(r)
+        if err != nil {
+            http.Error(w, err.Error(), http.StatusBadRequest)
+            return
+        }
+
+        var total int
+        if err := db.QueryRowContext(r.Context(), "SELECT COUNT(*) FROM subscribers").Scan(&total); err != nil {
+            http.Error(w, err.Error(), http.StatusInternalServerError)
+            return
+        }
+
+        query, args := paginate("SELECT id, lastname, firstname, email, COALESCE(phone, ''), membership_expiry FROM subscribers ORDER BY id", nil, limit, offset)
+        rows, err := db.QueryContext(r.Context(), query, args...)
+        if err != nil {
+            http.Error(w, err.Error(), http.StatusInternalServerError)
+            return
+        }
+        defer rows.Close()
+
+        var subscribers []Subscriber
+        for rows.Next() {
+            var subscriber Subscriber
+            if err := rows.Scan(&subscriber.ID, &subscriber.Lastname, &subscriber.Firstname, &subscriber.Email, &subscriber.Phone, &subscriber.MembershipExpiry); err != nil {
+                http.Error(w, err.Error(), http.StatusInternalServerError)
+                return
+            }
+            subscribers = append(subscribers, subscriber)
+        }
+        if err := rows.Err(); err != nil {
+            http.Error(w, err.Error(), http.StatusInternalServerError)
+            return
+        }
+
+        WriteListResponse(w, http.StatusOK, subscribers, total)
+    }
+}
+
+// SearchSubscribers returns a handler that finds the subscribers whose name or email contains the query parameter.
+func SearchSubscribers(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := strings.TrimSpace(r.URL.Query().Get("query"))
+		if query == "" {
+			http.Error(w, "Query parameter is missing", http.StatusBadRequest)
+			return
+		}
+
+		limit, offset, err := ParsePagination(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		where := "WHERE lastname LIKE ? OR firstname LIKE ? OR email LIKE ?"
+		pattern := "%" + query + "%"
+		filterArgs := []interface{}{pattern, pattern, pattern}
+
+		var total int
+		if err := db.QueryRowContext(r.Context(), "SELECT COUNT(*) FROM subscribers "+where, filterArgs...).Scan(&total); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		sqlQuery, args := paginate("SELECT id, lastname, firstname, email, COALESCE(phone, ''), membership_expiry FROM subscribers "+where+" ORDER BY id", filterArgs, limit, offset)
+		rows, err := db.QueryContext(r.Context(), sqlQuery, args...)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer rows.Close()
+
+		subscribers := []Subscriber{}
+		for rows.Next() {
+			var subscriber Subscriber
+			if err := rows.Scan(&subscriber.ID, &subscriber.Lastname, &subscriber.Firstname, &subscriber.Email, &subscriber.Phone, &subscriber.MembershipExpiry); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			subscribers = append(subscribers, subscriber)
+		}
+		if err := rows.Err(); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		WriteListResponse(w, http.StatusOK, subscribers, total)
+	}
+}
+
+// GetSubscriberByID returns a handler that gets a single subscriber by ID.
+func GetSubscriberByID(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Extract the subscriber ID from the URL path
+		subscriberID, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			http.Error(w, "Invalid subscriber ID", http.StatusBadRequest)
+			return
+		}
+
+		query := "SELECT id, lastname, firstname, email, COALESCE(phone, ''), membership_expiry FROM subscribers WHERE id = ?"
+
+		var subscriber Subscriber
+		err = db.QueryRowContext(r.Context(), query, subscriberID).Scan(&subscriber.ID, &subscriber.Lastname, &subscriber.Firstname, &subscriber.Email, &subscriber.Phone, &subscriber.MembershipExpiry)
+		if err == sql.ErrNoRows {
+			http.Error(w, "Subscriber not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		RespondWithJSON(w, http.StatusOK, subscriber)
+	}
+}
+
+// AddAuthor adds a new author to the database. The author is read from a JSON body, or from the
+// firstname and lastname fields of a multipart/form-data body with an optional "photo" file.
+func AddAuthor(db *sql.DB, photoConfig PhotoConfig) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        if r.Method != http.MethodPost {
+            http.Error(w, "Only POST method is supported", http.StatusMethodNotAllowed)
+            return
+        }
+
+        // We parse the JSON or form data received from the request
+        var author Author
+        multipartForm := isMultipart(r)
+        if multipartForm {
+            if !parsePhotoForm(photoConfig, w, r) {
+                return
+            }
+            defer r.MultipartForm.RemoveAll()
+            author.Firstname = r.FormValue("firstname")
+            author.Lastname = r.FormValue("lastname")
+        } else {
+            err := StrictJSONDecoder(r.Body, &author)
+            if err != nil {
+                http.Error(w, err.Error(), http.StatusBadRequest)
+                return
+            }
+            defer r.Body.Close()
+        }
+
+        author.Firstname = strings.TrimSpace(author.Firstname)
+        author.Lastname = strings.TrimSpace(author.Lastname)
+
+        // We check if all required fields are filled, a multipart request brings its photo as a file
+        if author.Firstname == "" || author.Lastname == "" || (!multipartForm && author.Photo == "") {
+            http.Error(w, "Firstname and Lastname are required fields", http.StatusBadRequest)
+            return
+        }
+
+        // We refuse to create the same author twice unless explicitly allowed.
+        // The comparison relies on the case-insensitive collation of the name columns.
+        if r.URL.Query().Get("allow_duplicate") != "true" {
+            var existingID int
+            err := db.QueryRowContext(r.Context(), "SELECT id FROM authors WHERE Lastname = ? AND Firstname = ? LIMIT 1", author.Lastname, author.Firstname).Scan(&existingID)
+            if err == nil {
+                RespondWithJSON(w, http.StatusConflict, map[string]interface{}{
+                    "error":       "author already exists",
+                    "existing_id": existingID,
+                })
+                return
+            }
+            if err != sql.ErrNoRows {
+                http.Error(w, fmt.Sprintf("Failed to check for existing author: %v", err), http.StatusInternalServerError)
+                return
+            }
+        }
+
+        // Query to add author with photo path
+        query := `
+            INSERT INTO authors (lastname, firstname, photo) 
+            VALUES (?, ?, ?)
+        `
+
+        // We run the query
+        result, err := db.ExecContext(r.Context(), query, author.Lastname, author.Firstname, author.Photo)
+        if err != nil {
+            http.Error(w, fmt.Sprintf("Failed to insert author: %v", err), http.StatusInternalServerError)
+            return
+        }
+
+        // We get the inserted author ID
+        id, err := result.LastInsertId()
+        if err != nil {
+            http.Error(w, "Failed to get last insert ID", http.StatusInternalServerError)
+            return
+        }
+
+        audit(r.Context(), db, "create", "author", int(id), author)
+
+        // We return the response with the author ID inserted
+        response := map[string]interface{}{"id": int(id)}
+        if multipartForm {
+            attachFormPhoto(db, photoConfig, r, "authors", photoConfig.AuthorDir(int(id)), int(id), response)
+        }
+        RespondWithJSON(w, http.StatusCreated, response)
+    }
+}
+
+
+// AddBook adds a new book to the database. The book is read from a JSON body, or from the fields of a
+// multipart/form-data body (tag_names may be repeated) with an optional "photo" file.
+func AddBook(db *sql.DB, photoConfig PhotoConfig) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        // Check the HTTP method
+        if r.Method != http.MethodPost {
+            http.Error(w, "Only POST method is supported", http.StatusMethodNotAllowed)
+            return
+        }
+
+        // Parse the JSON or form data received from the request
+        var book NewBook
+        multipartForm := isMultipart(r)
+        if multipartForm {
+            if !parsePhotoForm(photoConfig, w, r) {
+                return
+            }
+            defer r.MultipartForm.RemoveAll()
+            var err error
+            book, err = newBookFromForm(r)
+            if err != nil {
+                http.Error(w, err.Error(), http.StatusBadRequest)
+                return
+            }
+        } else {
+            err := StrictJSONDecoder(r.Body, &book)
+            if err != nil {
+                slog.Debug("decoding book failed", "error", err)
+                http.Error(w, err.Error(), http.StatusBadRequest)
+                return
+            }
+            defer r.Body.Close()
+        }
+
+        // Log the received book data for debugging
+        slog.Debug("received book", "book", book)
+
+        // author_ids lists all authors of the book, the first one becomes its main author_id.
+        // A single author_id is still accepted on its own.
+        if len(book.AuthorIDs) == 0 && book.AuthorID != 0 {
+            book.AuthorIDs = []int{book.AuthorID}
+        }
+
+        // Check if all required fields are filled
+        if book.Title == "" || len(book.AuthorIDs) == 0 {
+            http.Error(w, "Book title and author IDs are required fields", http.StatusBadRequest)
+            return
+        }
+        book.AuthorID = book.AuthorIDs[0]
+
+        book.Publisher = strings.TrimSpace(book.Publisher)
+        if err := validateOptionalField("publisher", book.Publisher, maxNameLength); err != nil {
+            http.Error(w, err.Error(), http.StatusBadRequest)
+            return
+        }
+        book.Format = strings.TrimSpace(book.Format)
+        if err := ValidateBookFormat(book.Format); err != nil {
+            http.Error(w, err.Error(), http.StatusBadRequest)
+            return
+        }
+
+        if book.ISBN != "" {
+            var err error
+            book.ISBN, err = NormalizeISBN(book.ISBN)
+            if err != nil {
+                http.Error(w, err.Error(), http.StatusBadRequest)
+                return
+            }
+        }
+
+        tx, err := db.BeginTx(r.Context(), nil)
+        if err != nil {
+            http.Error(w, fmt.Sprintf("Failed to start transaction: %v", err), http.StatusInternalServerError)
+            return
+        }
+        defer tx.Rollback()
+
+        // Refuse a second copy of a book with the same ISBN. The row lock keeps a concurrent
+        // insert of the same ISBN from slipping in between the check and the insert.
+        if book.ISBN != "" {
+            var existingID int
+            err = tx.QueryRowContext(r.Context(), "SELECT id FROM books WHERE isbn = ? LIMIT 1 FOR UPDATE", book.ISBN).Scan(&existingID)
+            if err == nil {
+                RespondWithJSON(w, http.StatusConflict, map[string]interface{}{
+                    "error":       "book already exists",
+                    "existing_id": existingID,
+                })
+                return
+            }
+            if err != sql.ErrNoRows {
+                http.Error(w, fmt.Sprintf("Failed to check for existing book: %v", err), http.StatusInternalServerError)
+                return
+            }
+        }
+
+        // Query to add book
+        query := `
+            INSERT INTO books (title, author_id, photo, is_borrowed, details, isbn, publisher, format) 
+            VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+        `
+
+        // Execute the query
+        result, err := tx.ExecContext(r.Context(), query, book.Title, book.AuthorID, book.Photo, book.IsBorrowed, book.Details, nullIfEmpty(book.ISBN), book.Publisher, book.Format)
+        if isDuplicateEntry(err) {
+            RespondWithJSON(w, http.StatusConflict, map[string]string{"error": "book already exists"})
+            return
+        }
+        if err != nil {
+            http.Error(w, fmt.Sprintf("Failed to insert book: %v", err), http.StatusInternalServerError)
+            return
+        }
+
+        // Get the inserted book ID
+        id, err := result.LastInsertId()
+        if err != nil {
+            http.Error(w, "Failed to get last insert ID", http.StatusInternalServerError)
+            return
+        }
+
+        // Link the book to all of its authors, the first one is also books.author_id
+        if err := setBookAuthors(r.Context(), tx, id, book.AuthorIDs); err != nil {
+            if errors.Is(err, errUnknownAuthor) {
+                http.Error(w, err.Error(), http.StatusBadRequest)
+                return
+            }
+            http.Error(w, fmt.Sprintf("Failed to save authors: %v", err), http.StatusInternalServerError)
+            return
+        }
+
+        // Attach the tags, creating the ones that don't exist yet
+        if err := setBookTags(r.Context(), tx, id, book.TagNames); err != nil {
+            http.Error(w, fmt.Sprintf("Failed to save tags: %v", err), http.StatusInternalServerError)
+            return
+        }
+
+        if err := setBookGenres(r.Context(), tx, id, book.Genres); err != nil {
+            if errors.Is(err, errUnknownGenre) {
+                http.Error(w, err.Error(), http.StatusBadRequest)
+                return
+            }
+            http.Error(w, fmt.Sprintf("Failed to save genres: %v", err), http.StatusInternalServerError)
+            return
+        }
+
+        if err := tx.Commit(); err != nil {
+            http.Error(w, fmt.Sprintf("Failed to commit transaction: %v", err), http.StatusInternalServerError)
+            return
+        }
+
+        audit(r.Context(), db, "create", "book", int(id), book)
+
+        // Return the response with the book ID inserted
+        response := map[string]interface{}{"id": int(id)}
+        if multipartForm {
+            attachFormPhoto(db, photoConfig, r, "books", photoConfig.BookDir(int(id)), int(id), response)
+        }
+        RespondWithJSON(w, http.StatusCreated, response)
+    }
+}
+
+// newBookFromForm reads the fields of a book from a parsed multipart form
+func newBookFromForm(r *http.Request) (NewBook, error) {
+	book := NewBook{
+		Title:     r.FormValue("title"),
+		Details:   r.FormValue("details"),
+		ISBN:      r.FormValue("isbn"),
+		Publisher: r.FormValue("publisher"),
+		Format:    r.FormValue("format"),
+		TagNames:  r.MultipartForm.Value["tag_names"],
+	}
+	for _, genre := range r.MultipartForm.Value["genres"] {
+		book.Genres = append(book.Genres, parseGenreRef(genre))
+	}
+	if authorID := r.FormValue("author_id"); authorID != "" {
+		id, err := strconv.Atoi(authorID)
+		if err != nil {
+			return NewBook{}, fmt.Errorf("invalid author_id")
+		}
+		book.AuthorID = id
+	}
+	for _, authorID := range r.MultipartForm.Value["author_ids"] {
+		id, err := strconv.Atoi(authorID)
+		if err != nil {
+			return NewBook{}, fmt.Errorf("invalid author_ids")
+		}
+		book.AuthorIDs = append(book.AuthorIDs, id)
+	}
+	if isBorrowed := r.FormValue("is_borrowed"); isBorrowed != "" {
+		borrowed, err := strconv.ParseBool(isBorrowed)
+		if err != nil {
+			return NewBook{}, fmt.Errorf("invalid is_borrowed")
+		}
+		book.IsBorrowed = borrowed
+	}
+	return book, nil
+}
+
+// AddSubscriber adds a new subscriber to the database
+func AddSubscriber(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Check the HTTP method
+		if r.Method != http.MethodPost {
+			http.Error(w, "Only POST method is supported", http.StatusMethodNotAllowed)
+			return
+		}
+
+		// Parse the JSON data received from the request, membership_expiry is a date without a time
+		var body struct {
+			Subscriber
+			MembershipExpiry string `json:"membership_expiry"`
+		}
+		err := json.NewDecoder(r.Body).Decode(&body)
+		if err != nil {
+			http.Error(w, "Invalid JSON data", http.StatusBadRequest)
+			return
+		}
+		defer r.Body.Close()
+
+		subscriber := body.Subscriber
+		subscriber.MembershipExpiry, err = parseMembershipExpiry(body.MembershipExpiry)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		// Check if all required fields are filled and valid
+		if err := ValidateSubscriberData(&subscriber); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		// Emails are unique regardless of case
+		subscriber.Email = strings.ToLower(subscriber.Email)
+
+		// Query to add subscriber
+		query := `
+			INSERT INTO subscribers (lastname, firstname, email, phone, membership_expiry) 
+			VALUES (?, ?, ?, ?, ?)
+		`
+
+		// Execute the query
+		result, err := db.ExecContext(r.Context(), query, subscriber.Lastname, subscriber.Firstname, subscriber.Email, nullIfEmpty(subscriber.Phone), subscriber.MembershipExpiry)
+		if isDuplicateEntry(err) {
+			RespondWithJSON(w, http.StatusConflict, map[string]string{"error": subscriberConflictMessage(err)})
+			return
+		}
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to insert subscriber: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		// Get the ID of the inserted subscriber
+		id, err := result.LastInsertId()
+		if err != nil {
+			http.Error(w, "Failed to get last insert ID", http.StatusInternalServerError)
+			return
+		}
+
+		audit(r.Context(), db, "create", "subscriber", int(id), subscriber)
+
+		// Return the response with the subscriber ID inserted
+		response := map[string]int{"id": int(id)}
+		RespondWithJSON(w, http.StatusCreated, response)
+	}
+}
+
+// maxBulkSubscribers is the largest number of subscribers accepted by one bulk request
+const maxBulkSubscribers = 1000
+
+// BulkSubscriberResult is the outcome for one subscriber of a bulk request, in the order they were sent
+type BulkSubscriberResult struct {
+	Index int    `json:"index"`
+	ID    int    `json:"id,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// AddSubscribersBulk returns a handler that creates many subscribers in one transaction. An invalid or
+// duplicate subscriber is reported in its result and doesn't stop the others from being created.
+func AddSubscribersBulk(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// As in AddSubscriber, membership_expiry is a date checked with the other fields of its subscriber
+		var subscribers []struct {
+			Subscriber
+			MembershipExpiry string `json:"membership_expiry"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&subscribers); err != nil {
+			http.Error(w, "Invalid JSON data", http.StatusBadRequest)
+			return
+		}
+		defer r.Body.Close()
+		if len(subscribers) > maxBulkSubscribers {
+			http.Error(w, fmt.Sprintf("at most %d subscribers can be created at once", maxBulkSubscribers), http.StatusRequestEntityTooLarge)
+			return
+		}
+
+		tx, err := db.BeginTx(r.Context(), nil)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to start transaction: %v", err), http.StatusInternalServerError)
+			return
+		}
+		defer tx.Rollback()
+
+		// A duplicate key only fails its own statement, so the transaction goes on with the next subscriber.
+		// Duplicates inside the batch hit the unique keys the same way as existing rows.
+		results := make([]BulkSubscriberResult, len(subscribers))
+		created := 0
+		for i := range subscribers {
+			subscriber := &subscribers[i].Subscriber
+			results[i].Index = i
+
+			subscriber.MembershipExpiry, err = parseMembershipExpiry(subscribers[i].MembershipExpiry)
+			if err != nil {
+				results[i].Error = err.Error()
+				continue
+			}
+			if err := ValidateSubscriberData(subscriber); err != nil {
+				results[i].Error = err.Error()
+				continue
+			}
+			subscriber.Email = strings.ToLower(subscriber.Email)
+
+			result, err := tx.ExecContext(r.Context(), `
+				INSERT INTO subscribers (lastname, firstname, email, phone, membership_expiry)
+				VALUES (?, ?, ?, ?, ?)
+			`, subscriber.Lastname, subscriber.Firstname, subscriber.Email, nullIfEmpty(subscriber.Phone), subscriber.MembershipExpiry)
+			if isDuplicateEntry(err) {
+				results[i].Error = subscriberConflictMessage(err)
+				continue
+			}
+			if err != nil {
+				http.Error(w, fmt.Sprintf("Failed to insert subscriber: %v", err), http.StatusInternalServerError)
+				return
+			}
+			id, err := result.LastInsertId()
+			if err != nil {
+				http.Error(w, "Failed to get last insert ID", http.StatusInternalServerError)
+				return
+			}
+			results[i].ID = int(id)
+			created++
+		}
+
+		if err := tx.Commit(); err != nil {
+			http.Error(w, fmt.Sprintf("Failed to commit transaction: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		RespondWithJSON(w, http.StatusOK, map[string]interface{}{
+			"created": created,
+			"failed":  len(subscribers) - created,
+			"results": results,
+		})
+	}
+}
+
+
+// BorrowBook handles borrowing a book by a subscriber
+func BorrowBook(db *sql.DB, webhooks *WebhookDispatcher) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var requestBody struct {
+			SubscriberID int `json:"subscriber_id"`
+			BookID       int `json:"book_id"`
+		}
+		err := json.NewDecoder(r.Body).Decode(&requestBody)
+		if err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		// A subscriber whose membership has expired can't borrow anything
+		ctx, span := startDBSpan(r.Context(), "check membership", "SELECT membership_expiry FROM subscribers")
+		expiredAt, err := expiredMembership(ctx, db, requestBody.SubscriberID)
+		endSpan(span, err)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if expiredAt != nil {
+			RespondWithJSON(w, http.StatusForbidden, map[string]string{"error": "membership expired", "expired_at": expiredAt.Format(membershipDateLayout)})
+			return
+		}
+
+		// The check and the loan happen in one transaction, the row lock keeps two requests from borrowing
+		// the same book
+		tx, err := db.BeginTx(r.Context(), nil)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to start transaction: %v", err), http.StatusInternalServerError)
+			return
+		}
+		defer tx.Rollback()
+
+		// Check if the book is already borrowed
+		var isBorrowed bool
+		query := "SELECT is_borrowed FROM books WHERE id = ? FOR UPDATE"
+		ctx, span = startDBSpan(r.Context(), "check book availability", query)
+		err = tx.QueryRowContext(ctx, query, requestBody.BookID).Scan(&isBorrowed)
+		endSpan(span, err)
+		if err == sql.ErrNoRows {
+			http.Error(w, "Book not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if isBorrowed {
+			http.Error(w, "Book is already borrowed", http.StatusConflict)
+			return
+		}
+
+		// Insert a new record in the borrowed_books table
+		query = "INSERT INTO borrowed_books (subscriber_id, book_id, date_of_borrow, due_date) VALUES (?, ?, NOW(), NOW() + INTERVAL ? DAY)"
+		ctx, span = startDBSpan(r.Context(), "record loan", query)
+		_, err = tx.ExecContext(ctx, query, requestBody.SubscriberID, requestBody.BookID, loanPeriodDays)
+		endSpan(span, err)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		// Update the is_borrowed status of the book
+		query = "UPDATE books SET is_borrowed = TRUE WHERE id = ?"
+		ctx, span = startDBSpan(r.Context(), "mark book borrowed", query)
+		_, err = tx.ExecContext(ctx, query, requestBody.BookID)
+		endSpan(span, err)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if err := tx.Commit(); err != nil {
+			http.Error(w, fmt.Sprintf("Failed to commit transaction: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		ctx, span = startDBSpan(r.Context(), "record audit entry", "INSERT INTO audit_log")
+		audit(ctx, db, "borrow", "book", requestBody.BookID, requestBody)
+		span.End()
+		webhooks.Dispatch(webhookEventBorrowed, requestBody.BookID, requestBody.SubscriberID)
+
+		RespondWithJSON(w, http.StatusCreated, map[string]string{"message": "Book borrowed successfully"})
+	}
+}
+
+// TransferBorrow hands a borrowed book over from one subscriber to another without returning it
+func TransferBorrow(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var requestBody struct {
+			FromSubscriberID int `json:"from_subscriber_id"`
+			ToSubscriberID   int `json:"to_subscriber_id"`
+			BookID           int `json:"book_id"`
+		}
+		err := json.NewDecoder(r.Body).Decode(&requestBody)
+		if err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		if requestBody.FromSubscriberID == 0 || requestBody.ToSubscriberID == 0 || requestBody.BookID == 0 {
+			http.Error(w, "from_subscriber_id, to_subscriber_id and book_id are required fields", http.StatusBadRequest)
+			return
+		}
+		if requestBody.FromSubscriberID == requestBody.ToSubscriberID {
+			http.Error(w, "Cannot transfer a book to the same subscriber", http.StatusBadRequest)
+			return
+		}
+
+		// A subscriber whose membership has expired can't be handed a book either
+		expiredAt, err := expiredMembership(r.Context(), db, requestBody.ToSubscriberID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if expiredAt != nil {
+			RespondWithJSON(w, http.StatusForbidden, map[string]string{"error": "membership expired", "expired_at": expiredAt.Format(membershipDateLayout)})
+			return
+		}
+
+		tx, err := db.BeginTx(r.Context(), nil)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer tx.Rollback()
+
+		// Check if the book is currently borrowed by the source subscriber
+		var activeBorrows int
+		err = tx.QueryRowContext(r.Context(), "SELECT COUNT(*) FROM borrowed_books WHERE subscriber_id = ? AND book_id = ? AND return_date IS NULL FOR UPDATE", requestBody.FromSubscriberID, requestBody.BookID).Scan(&activeBorrows)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if activeBorrows == 0 {
+			http.Error(w, "Book is not borrowed by the source subscriber", http.StatusConflict)
+			return
+		}
+
+		// Check if the target subscriber exists
+		var exists bool
+		err = tx.QueryRowContext(r.Context(), "SELECT EXISTS(SELECT 1 FROM subscribers WHERE id = ?)", requestBody.ToSubscriberID).Scan(&exists)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !exists {
+			http.Error(w, "Target subscriber not found", http.StatusNotFound)
+			return
+		}
+
+		// Check if the target subscriber has reached the borrow limit
+		var targetBorrows int
+		err = tx.QueryRowContext(r.Context(), "SELECT COUNT(*) FROM borrowed_books WHERE subscriber_id = ? AND return_date IS NULL", requestBody.ToSubscriberID).Scan(&targetBorrows)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if targetBorrows >= maxActiveBorrows {
+			http.Error(w, "Target subscriber has reached the borrow limit", http.StatusUnprocessableEntity)
+			return
+		}
+
+		// Move the active borrow record to the target subscriber, the book stays borrowed
+		_, err = tx.ExecContext(r.Context(), "UPDATE borrowed_books SET subscriber_id = ? WHERE subscriber_id = ? AND book_id = ? AND return_date IS NULL", requestBody.ToSubscriberID, requestBody.FromSubscriberID, requestBody.BookID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if err := tx.Commit(); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		RespondWithJSON(w, http.StatusOK, map[string]string{"message": "Book transferred successfully"})
+	}
+}
+
+// ReturnBorrowedBook handles returning a borrowed book by a subscriber
+func ReturnBorrowedBook(db *sql.DB, webhooks *WebhookDispatcher) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		// Parse the request body to get subscriber ID and book ID
+		var requestBody struct {
+			SubscriberID int `json:"subscriber_id"`
+			BookID       int `json:"book_id"`
+		}
+		err := json.NewDecoder(r.Body).Decode(&requestBody)
+		if err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		tx, err := db.BeginTx(r.Context(), nil)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to start transaction: %v", err), http.StatusInternalServerError)
+			return
+		}
+		defer tx.Rollback()
+
+		// Check if the book is actually borrowed, the row stays locked until the return is committed
+		var isBorrowed bool
+		err = tx.QueryRowContext(r.Context(), "SELECT is_borrowed FROM books WHERE id = ? FOR UPDATE", requestBody.BookID).Scan(&isBorrowed)
+		if err != nil && err != sql.ErrNoRows {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if err == sql.ErrNoRows || !isBorrowed {
+			http.Error(w, "Book is not borrowed", http.StatusNotFound)
+			return
+		}
+
+		// Close the open loan of the subscriber, the earlier loans of the book keep their return date
+		result, err := tx.ExecContext(r.Context(), "UPDATE borrowed_books SET return_date = NOW() WHERE subscriber_id = ? AND book_id = ? AND return_date IS NULL", requestBody.SubscriberID, requestBody.BookID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+			http.Error(w, "Book is not borrowed by this subscriber", http.StatusConflict)
+			return
+		}
+
+		// Update books table to mark book as not borrowed
+		_, err = tx.ExecContext(r.Context(), "UPDATE books SET is_borrowed = FALSE WHERE id = ?", requestBody.BookID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if err := tx.Commit(); err != nil {
+			http.Error(w, fmt.Sprintf("Failed to commit transaction: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		audit(r.Context(), db, "return", "book", requestBody.BookID, requestBody)
+		webhooks.Dispatch(webhookEventReturned, requestBody.BookID, requestBody.SubscriberID)
+
+		RespondWithJSON(w, http.StatusOK, map[string]string{"message": "Book returned successfully"})
+	}
+}
+
+// SetBookBorrowStatus returns a handler that overrides whether a book is borrowed, for example when a lost
+// book is found. Marking a book available closes its latest open loan; marking it borrowed without an open
+// loan records one with no subscriber, so the loan history stays consistent with is_borrowed. That loan has
+// no due date since there is nobody to remind or to hold overdue, and only marking the book available
+// again closes it, ReturnBorrowedBook needs the subscriber of the loan.
+func SetBookBorrowStatus(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		bookID, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			http.Error(w, "Invalid book ID", http.StatusBadRequest)
+			return
+		}
+
+		var requestBody struct {
+			IsBorrowed *bool `json:"is_borrowed"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&requestBody); err != nil {
+			http.Error(w, "Invalid JSON data", http.StatusBadRequest)
+			return
+		}
+		defer r.Body.Close()
+		if requestBody.IsBorrowed == nil {
+			http.Error(w, "is_borrowed is a required field", http.StatusBadRequest)
+			return
+		}
+
+		tx, err := db.BeginTx(r.Context(), nil)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to start transaction: %v", err), http.StatusInternalServerError)
+			return
+		}
+		defer tx.Rollback()
+
+		var id int
+		err = tx.QueryRowContext(r.Context(), "SELECT id FROM books WHERE id = ? FOR UPDATE", bookID).Scan(&id)
+		if err == sql.ErrNoRows {
+			http.Error(w, "Book not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if *requestBody.IsBorrowed {
+			var openLoan bool
+			err = tx.QueryRowContext(r.Context(), "SELECT EXISTS(SELECT 1 FROM borrowed_books WHERE book_id = ? AND return_date IS NULL)", bookID).Scan(&openLoan)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			if !openLoan {
+				_, err = tx.ExecContext(r.Context(), "INSERT INTO borrowed_books (subscriber_id, book_id, date_of_borrow) VALUES (NULL, ?, NOW())", bookID)
+				if err != nil {
+					http.Error(w, fmt.Sprintf("Failed to record loan: %v", err), http.StatusInternalServerError)
+					return
+				}
+			}
+		} else {
+			_, err = tx.ExecContext(r.Context(), `
+				UPDATE borrowed_books SET return_date = NOW()
+				WHERE book_id = ? AND return_date IS NULL
+				ORDER BY date_of_borrow DESC
+				LIMIT 1
+			`, bookID)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("Failed to close loan: %v", err), http.StatusInternalServerError)
+				return
+			}
+		}
+
+		_, err = tx.ExecContext(r.Context(), "UPDATE books SET is_borrowed = ? WHERE id = ?", *requestBody.IsBorrowed, bookID)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to update book: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		if err := tx.Commit(); err != nil {
+			http.Error(w, fmt.Sprintf("Failed to commit transaction: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		RespondWithJSON(w, http.StatusOK, map[string]interface{}{"id": bookID, "is_borrowed": *requestBody.IsBorrowed})
+	}
+}
+
+
+func UpdateAuthor(db *sql.DB) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        if r.Method != http.MethodPut && r.Method != http.MethodPost {
+            http.Error(w, "Only PUT or POST methods are supported", http.StatusMethodNotAllowed)
+            return
+        }
+
+        vars := mux.Vars(r)
+        authorID, err := strconv.Atoi(vars["id"])
+        if err != nil {
+            http.Error(w, "Invalid author ID", http.StatusBadRequest)
+            return
+        }
+
+        var author Author
+        err = StrictJSONDecoder(r.Body, &author)
+        if err != nil {
+            http.Error(w, err.Error(), http.StatusBadRequest)
+            return
+        }
+        defer r.Body.Close()
+
+        if author.Firstname == "" || author.Lastname == "" {
+            http.Error(w, "Firstname and Lastname are required fields", http.StatusBadRequest)
+            return
+        }
+
+        query := `
+            UPDATE authors 
+            SET lastname = ?, firstname = ?, photo = ? 
+            WHERE id = ?
+        `
+
+        result, err := db.ExecContext(r.Context(), query, author.Lastname, author.Firstname, author.Photo, authorID)
+        if err != nil {
+            http.Error(w, fmt.Sprintf("Failed to update author: %v", err), http.StatusInternalServerError)
+            return
+        }
+
+        rowsAffected, _ := result.RowsAffected()
+        if rowsAffected == 0 {
+            http.Error(w, "Author not found", http.StatusNotFound)
+            return
+        }
+
+        audit(r.Context(), db, "update", "author", authorID, author)
+
+        RespondWithJSON(w, http.StatusOK, map[string]string{"message": "Author updated successfully"})
+    }
+}
+
+
+
+// UpdateBook updates an existing book in the database
+func UpdateBook(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Check the HTTP method
+		if r.Method != http.MethodPut && r.Method != http.MethodPost {
+			http.Error(w, "Only PUT or POST methods are supported", http.StatusMethodNotAllowed)
+			return
+		}
+
+		// Extract the book ID from the URL path
+		vars := mux.Vars(r)
+		bookID, err := strconv.Atoi(vars["id"])
+		if err != nil {
+			http.Error(w, "Invalid book ID", http.StatusBadRequest)
+			return
+		}
+
+		// Parse the JSON data received from the request
+		var book struct {
+			Title      string `json:"title"`
+			AuthorID   int    `json:"author_id"`
+			AuthorIDs  []int  `json:"author_ids"`
+			Photo      string `json:"photo"`
+			Details    string   `json:"details"`
+			IsBorrowed bool     `json:"is_borrowed"`
+			ISBN       string   `json:"isbn"`
+			Publisher  string   `json:"publisher"`
+			Format     string   `json:"format"`
+			TagNames   []string `json:"tag_names"`
+			Genres     []GenreRef `json:"genres"`
+		}
+		err = StrictJSONDecoder(r.Body, &book)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		defer r.Body.Close()
+
+		// Log the book ID and received data for update
+		slog.Debug("updating book", "book_id", bookID, "book", book)
+
+		// As in AddBook, author_ids takes precedence over a single author_id. Without author_ids only the
+		// main author changes, and the co-authors stay linked.
+		replaceAuthors := len(book.AuthorIDs) > 0
+		if !replaceAuthors && book.AuthorID != 0 {
+			book.AuthorIDs = []int{book.AuthorID}
+		}
+
+		// Check if all required fields are filled
+		if book.Title == "" || len(book.AuthorIDs) == 0 {
+			http.Error(w, "Title and AuthorID are required fields", http.StatusBadRequest)
+			return
+		}
+		book.AuthorID = book.AuthorIDs[0]
+
+		book.Publisher = strings.TrimSpace(book.Publisher)
+		if err := validateOptionalField("publisher", book.Publisher, maxNameLength); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		book.Format = strings.TrimSpace(book.Format)
+		if err := ValidateBookFormat(book.Format); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if book.ISBN != "" {
+			book.ISBN, err = NormalizeISBN(book.ISBN)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+
+		// Query to update the book
+		query := `
+			UPDATE books 
+			SET title = ?, author_id = ?, photo = ?, details = ?, is_borrowed = ?, isbn = ?, publisher = ?, format = ? 
+			WHERE id = ?
+		`
+
+		tx, err := db.BeginTx(r.Context(), nil)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to start transaction: %v", err), http.StatusInternalServerError)
+			return
+		}
+		defer tx.Rollback()
+
+		// The link of the previous main author is moved to the new one
+		var previousAuthorID int
+		if !replaceAuthors {
+			err := tx.QueryRowContext(r.Context(), "SELECT author_id FROM books WHERE id = ? FOR UPDATE", bookID).Scan(&previousAuthorID)
+			if err == sql.ErrNoRows {
+				http.Error(w, "Book not found", http.StatusNotFound)
+				return
+			}
+			if err != nil {
+				http.Error(w, fmt.Sprintf("Failed to update book: %v", err), http.StatusInternalServerError)
+				return
+			}
+		}
+
+		// Execute the query
+		result, err := tx.ExecContext(r.Context(), query, book.Title, book.AuthorID, book.Photo, book.Details, book.IsBorrowed, nullIfEmpty(book.ISBN), book.Publisher, book.Format, bookID)
+		if isDuplicateEntry(err) {
+			http.Error(w, "isbn already registered", http.StatusConflict)
+			return
+		}
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to update book: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		// Check if any row was actually updated
+		rowsAffected, _ := result.RowsAffected()
+		if rowsAffected == 0 {
+			http.Error(w, "Book not found", http.StatusNotFound)
+			return
+		}
+
+		// author_ids replaces the author links as well
+		if replaceAuthors {
+			err = setBookAuthors(r.Context(), tx, int64(bookID), book.AuthorIDs)
+		} else {
+			err = setBookMainAuthor(r.Context(), tx, int64(bookID), previousAuthorID, book.AuthorID)
+		}
+		if err != nil {
+			if errors.Is(err, errUnknownAuthor) {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			http.Error(w, fmt.Sprintf("Failed to save authors: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		// Replace the tags only when tag_names is part of the request
+		if book.TagNames != nil {
+			if err := setBookTags(r.Context(), tx, int64(bookID), book.TagNames); err != nil {
+				http.Error(w, fmt.Sprintf("Failed to save tags: %v", err), http.StatusInternalServerError)
+				return
+			}
+		}
+
+		// Likewise for the genres
+		if book.Genres != nil {
+			if err := setBookGenres(r.Context(), tx, int64(bookID), book.Genres); err != nil {
+				if errors.Is(err, errUnknownGenre) {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+				http.Error(w, fmt.Sprintf("Failed to save genres: %v", err), http.StatusInternalServerError)
+				return
+			}
+		}
+
+		if err := tx.Commit(); err != nil {
+			http.Error(w, fmt.Sprintf("Failed to commit transaction: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		audit(r.Context(), db, "update", "book", bookID, book)
+
+		// Return the success response
+		RespondWithJSON(w, http.StatusOK, map[string]string{"message": "Book updated successfully"})
+	}
+}
+
+
+// UpdateSubscriber updates an existing subscriber in the database
+func UpdateSubscriber(db *sql.DB) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        // Check the HTTP method
+        if r.Method != http.MethodPut && r.Method != http.MethodPost {
+            http.Error(w, "Only PUT or POST methods are supported", http.StatusMethodNotAllowed)
+            return
+        }
+
+        // Extract the subscriber ID from the URL path
+        vars := mux.Vars(r)
+        subscriberID, err := strconv.Atoi(vars["id"])
+        if err != nil {
+            http.Error(w, "Invalid subscriber ID", http.StatusBadRequest)
+            return
+        }
+
+        // Parse the JSON data received from the request
+        var subscriber Subscriber
+        err = StrictJSONDecoder(r.Body, &subscriber)
+        if err != nil {
+            http.Error(w, err.Error(), http.StatusBadRequest)
+            return
+        }
+        defer r.Body.Close()
+
+        // Log the subscriber ID and received data for update
+        slog.Debug("updating subscriber", "subscriber_id", subscriberID, "subscriber", subscriber)
+
+        // Check if all required fields are filled and valid
+        if err := ValidateSubscriberData(&subscriber); err != nil {
+            http.Error(w, err.Error(), http.StatusBadRequest)
+            return
+        }
+
+        // Emails are unique regardless of case
+        subscriber.Email = strings.ToLower(subscriber.Email)
+
+        // Query to update the subscriber
+        query := `
+            UPDATE subscribers 
+            SET lastname = ?, firstname = ?, email = ?, phone = ?, membership_expiry = ? 
+            WHERE id = ?
+        `
+
+        // Execute the query, a subscriber sent without membership_expiry no longer has an expiry
+        result, err := db.ExecContext(r.Context(), query, subscriber.Lastname, subscriber.Firstname, subscriber.Email, nullIfEmpty(subscriber.Phone), subscriber.MembershipExpiry, subscriberID)
+        if isDuplicateEntry(err) {
+            RespondWithJSON(w, http.StatusConflict, map[string]string{"error": subscriberConflictMessage(err)})
+            return
+        }
+        if err != nil {
+            http.Error(w, fmt.Sprintf("Failed to update subscriber: %v", err), http.StatusInternalServerError)
+            return
+        }
+
+        // Check if any row was actually updated
+        rowsAffected, _ := result.RowsAffected()
+        if rowsAffected == 0 {
+            http.Error(w, "Subscriber not found", http.StatusNotFound)
+            return
+        }
 
-		rows, err := db.Query(query, intBookID)
+        audit(r.Context(), db, "update", "subscriber", subscriberID, subscriber)
+
+        // Return the success response
+        RespondWithJSON(w, http.StatusOK, map[string]string{"message": "Subscriber updated successfully"})
+    }
+}
+
+// PatchSubscriber updates only the fields of a subscriber present in the request
+func PatchSubscriber(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		subscriberID, err := strconv.Atoi(mux.Vars(r)["id"])
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			http.Error(w, "Invalid subscriber ID", http.StatusBadRequest)
 			return
 		}
-		defer rows.Close()
 
-		var books []BookAuthorInfo
-		for rows.Next() {
-			var book BookAuthorInfo
-			if err := rows.Scan(&book.BookTitle, &book.AuthorID, &book.BookPhoto, &book.IsBorrowed, &book.BookID, &book.BookDetails, &book.AuthorLastname, &book.AuthorFirstname); err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
-				return
-			}
+		// Absent fields stay nil and are left untouched
+		var patch struct {
+			Firstname *string `json:"firstname"`
+			Lastname  *string `json:"lastname"`
+			Email     *string `json:"email"`
+			Phone     *string `json:"phone"`
 
-			books = append(books, book)
+			MembershipExpiry *string `json:"membership_expiry"`
 		}
-
-		if err := rows.Err(); err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+		if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+			http.Error(w, "Invalid JSON data", http.StatusBadRequest)
 			return
 		}
+		defer r.Body.Close()
 
-		if len(books) == 0 {
-			http.Error(w, "Book not found", http.StatusNotFound)
-			return
+		var setClauses []string
+		var args []interface{}
+		if patch.Firstname != nil {
+			firstname := strings.TrimSpace(*patch.Firstname)
+			if err := validateRequiredField("firstname", firstname, maxNameLength); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			setClauses = append(setClauses, "firstname = ?")
+			args = append(args, firstname)
+		}
+		if patch.Lastname != nil {
+			lastname := strings.TrimSpace(*patch.Lastname)
+			if err := validateRequiredField("lastname", lastname, maxNameLength); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			setClauses = append(setClauses, "lastname = ?")
+			args = append(args, lastname)
+		}
+		if patch.Email != nil {
+			email := strings.ToLower(strings.TrimSpace(*patch.Email))
+			if err := ValidateEmail(email); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			setClauses = append(setClauses, "email = ?")
+			args = append(args, email)
+		}
+		if patch.Phone != nil {
+			// An empty phone clears the number
+			phone := strings.TrimSpace(*patch.Phone)
+			if phone != "" {
+				if err := ValidatePhone(phone); err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+			}
+			setClauses = append(setClauses, "phone = ?")
+			args = append(args, nullIfEmpty(phone))
+		}
+		if patch.MembershipExpiry != nil {
+			// An empty date removes the expiry
+			expiry, err := parseMembershipExpiry(strings.TrimSpace(*patch.MembershipExpiry))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			setClauses = append(setClauses, "membership_expiry = ?")
+			args = append(args, expiry)
 		}
 
-		json.NewEncoder(w).Encode(books[0])
-	}
-}
-
-func GetSubscribersByBookID(db *sql.DB) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		// Extract the book ID from the URL path using Gorilla Mux
-		bookID := mux.Vars(r)["id"]
-		if bookID == "" {
-			http.Error(w, "Missing book ID parameter", http.StatusBadRequest)
+		if len(setClauses) == 0 {
+			http.Error(w, "No fields to update", http.StatusBadRequest)
 			return
 		}
 
-		query := `
-			SELECT s.id, s.Lastname, s.Firstname, s.Email
-			FROM subscribers s
-			JOIN borrowed_books bb ON s.id = bb.subscriber_id
-			WHERE bb.book_id = ?
-		`
+		query := "UPDATE subscribers SET " + strings.Join(setClauses, ", ") + " WHERE id = ?"
+		args = append(args, subscriberID)
 
-		rows, err := db.Query(query, bookID)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+		_, err = db.ExecContext(r.Context(), query, args...)
+		if isDuplicateEntry(err) {
+			RespondWithJSON(w, http.StatusConflict, map[string]string{"error": subscriberConflictMessage(err)})
 			return
 		}
-		defer rows.Close()
-
-		var subscribers []Subscriber
-		
-		// Iterate over the query result set and populate the subscribers slice
-		for rows.Next() {
-			var subscriber Subscriber
-			if err := rows.Scan(&subscriber.Lastname, &subscriber.Firstname, &subscriber.Email); err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
-				return
-			}
-			subscribers = append(subscribers, subscriber)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to update subscriber: %v", err), http.StatusInternalServerError)
+			return
 		}
 
-		if err := rows.Err(); err != nil {
+		// RowsAffected is 0 when nothing changed, so look the subscriber up to tell 404 apart
+		var exists bool
+		err = db.QueryRowContext(r.Context(), "SELECT EXISTS(SELECT 1 FROM subscribers WHERE id = ?)", subscriberID).Scan(&exists)
+		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
+		if !exists {
+			http.Error(w, "Subscriber not found", http.StatusNotFound)
+			return
+		}
 
-		json.NewEncoder(w).Encode(subscribers)
+		audit(r.Context(), db, "update", "subscriber", subscriberID, patch)
+
+		RespondWithJSON(w, http.StatusOK, map[string]string{"message": "Subscriber updated successfully"})
 	}
 }
 
-// GetAllSubscribers returns a handler that gets all the subscribers in the database.
-func GetAllSubscribers(db *sql.DB) http.HandlerFunc {
+// DeleteAuthor deletes an existing author from the database
+func DeleteAuthor(db *sql.DB, photoConfig PhotoConfig) http.HandlerFunc {
     return func(w http.ResponseWriter, r *http.Request) {
-        query := "SELECT id, lastname, firstname, email FROM subscribers"
-        rows, err := db.Query(query)
-        if err != nil {
-            http.Error(w, err.Error(), http.StatusInternalServerError)
+        // Check the HTTP method
+        if r.Method != http.MethodDelete {
+            http.Error(w, "Only DELETE method is supported", http.StatusMethodNotAllowed)
             return
         }
-        defer rows.Close()
 
-        var subscribers []Subscriber
-        for rows.Next() {
-            var subscriber Subscriber
-            if err := rows.Scan(&subscriber.Lastname, &subscriber.Firstname, &subscriber.Email); err != nil {
-                http.Error(w, err.Error(), http.StatusInternalServerError)
-                return
-            }
-            subscribers = append(subscribers, subscriber)
-        }
-        if err := rows.Err(); err != nil {
-            http.Error(w, err.Error(), http.StatusInternalServerError)
+        // Extract the author ID from the URL path
+        vars := mux.Vars(r)
+        authorID, err := strconv.Atoi(vars["id"])
+        if err != nil {
+            http.Error(w, "Invalid author ID", http.StatusBadRequest)
             return
         }
 
-        json.NewEncoder(w).Encode(subscribers)
-    }
-}
-// AddAuthor adds a new author to the database
-func AddAuthor(db *sql.DB) http.HandlerFunc {
-    return func(w http.ResponseWriter, r *http.Request) {
-        if r.Method != http.MethodPost {
-            http.Error(w, "Only POST method is supported", http.StatusMethodNotAllowed)
+        // With ?cascade=true the author's books are removed as well
+        if r.URL.Query().Get("cascade") == "true" {
+            deleteAuthorCascade(db, photoConfig, w, r, authorID, r.URL.Query().Get("force") == "true")
             return
         }
 
-        // We parse the JSON data received from the request
-        var author Author
-        err := json.NewDecoder(r.Body).Decode(&author)
+        // Query to check if the author has books, as main author or co-author
+        booksQuery := `
+            SELECT (SELECT COUNT(*) FROM books WHERE author_id = ?)
+                + (SELECT COUNT(*) FROM authors_books WHERE author_id = ?)
+        `
+
+        // Execute the query
+        var numBooks int
+        err = db.QueryRowContext(r.Context(), booksQuery, authorID, authorID).Scan(&numBooks)
         if err != nil {
-            http.Error(w, "Invalid JSON data", http.StatusBadRequest)
+            http.Error(w, fmt.Sprintf("Failed to check for books: %v", err), http.StatusInternalServerError)
             return
         }
-        defer r.Body.Close()
 
-        // We check if all required fields are filled
-        if author.Firstname == "" || author.Lastname == "" || author.Photo == "" {
-            http.Error(w, "Firstname and Lastname are required fields", http.StatusBadRequest)
+        // If author has books, respond with a bad request
+        if numBooks > 0 {
+            http.Error(w, "Author has associated books, delete books first", http.StatusBadRequest)
             return
         }
 
-        // Query to add author with photo path
-        query := `
-            INSERT INTO authors (lastname, firstname, photo) 
-            VALUES (?, ?, ?)
+        // Query to delete the author
+        deleteQuery := `
+            DELETE FROM authors
+            WHERE id = ?
         `
 
-        // We run the query
-        result, err := db.Exec(query, author.Lastname, author.Firstname, author.Photo)
+        // Execute the query to delete the author
+        result, err := db.ExecContext(r.Context(), deleteQuery, authorID)
         if err != nil {
-            http.Error(w, fmt.Sprintf("Failed to insert author: %v", err), http.StatusInternalServerError)
+            http.Error(w, fmt.Sprintf("Failed to delete author: %v", err), http.StatusInternalServerError)
             return
         }
 
-        // We get the inserted author ID
-        id, err := result.LastInsertId()
-        if err != nil {
-            http.Error(w, "Failed to get last insert ID", http.StatusInternalServerError)
+        // Check if any row was actually deleted
+        rowsAffected, _ := result.RowsAffected()
+        if rowsAffected == 0 {
+            http.Error(w, "Author not found", http.StatusNotFound)
             return
         }
 
-        w.Header().Set("Content-Type", "application/json")
-        w.WriteHeader(http.StatusCreated)
-        // We return the response with the author ID inserted
-        response := map[string]int{"id": int(id)}
-        json.NewEncoder(w).Encode(response)
+        photoConfig.removeUploadDir(r.Context(), photoConfig.AuthorDir(authorID))
+
+        audit(r.Context(), db, "delete", "author", authorID, nil)
+
+        // Return the success response
+        RespondWithJSON(w, http.StatusOK, map[string]string{"message": "Author deleted successfully"})
     }
 }
 
+// deleteAuthorCascade deletes an author together with all of their books inside a transaction.
+// It refuses to delete anything while one of the author's books is borrowed, unless force is set.
+func deleteAuthorCascade(db *sql.DB, photoConfig PhotoConfig, w http.ResponseWriter, r *http.Request, authorID int, force bool) {
+	tx, err := db.BeginTx(r.Context(), nil)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to start transaction: %v", err), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	// Check if any of the author's books is currently borrowed
+	var numBorrowed int
+	err = tx.QueryRowContext(r.Context(), `
+		SELECT COUNT(*)
+		FROM borrowed_books bb
+		JOIN books b ON bb.book_id = b.id
+		WHERE b.author_id = ? AND bb.return_date IS NULL
+	`, authorID).Scan(&numBorrowed)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to check for borrowed books: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if numBorrowed > 0 && !force {
+		http.Error(w, "Book is currently borrowed", http.StatusConflict)
+		return
+	}
 
-// AddBook adds a new book to the database
-func AddBook(db *sql.DB) http.HandlerFunc {
-    return func(w http.ResponseWriter, r *http.Request) {
-        // Check the HTTP method
-        if r.Method != http.MethodPost {
-            http.Error(w, "Only POST method is supported", http.StatusMethodNotAllowed)
-            return
-        }
+	// Remember the author's books to clean up their upload directories afterwards
+	var bookIDs []int
+	idRows, err := tx.QueryContext(r.Context(), "SELECT id FROM books WHERE author_id = ?", authorID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to list books: %v", err), http.StatusInternalServerError)
+		return
+	}
+	for idRows.Next() {
+		var bookID int
+		if err := idRows.Scan(&bookID); err != nil {
+			idRows.Close()
+			http.Error(w, fmt.Sprintf("Failed to list books: %v", err), http.StatusInternalServerError)
+			return
+		}
+		bookIDs = append(bookIDs, bookID)
+	}
+	idRows.Close()
+	if err := idRows.Err(); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to list books: %v", err), http.StatusInternalServerError)
+		return
+	}
 
-        // Parse the JSON data received from the request
-        var book NewBook
-        err := json.NewDecoder(r.Body).Decode(&book)
-        if err != nil {
-            log.Printf("Error decoding JSON: %v", err)
-            http.Error(w, "Invalid JSON data", http.StatusBadRequest)
-            return
-        }
-        defer r.Body.Close()
+	// Remove the borrow history of the author's books so the books can be deleted
+	_, err = tx.ExecContext(r.Context(), "DELETE FROM borrowed_books WHERE book_id IN (SELECT id FROM books WHERE author_id = ?)", authorID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to delete borrow history: %v", err), http.StatusInternalServerError)
+		return
+	}
 
-        // Log the received book data for debugging
-        log.Printf("Received book data: %+v", book)
+	_, err = tx.ExecContext(r.Context(), "DELETE FROM book_tags WHERE book_id IN (SELECT id FROM books WHERE author_id = ?)", authorID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to delete book tags: %v", err), http.StatusInternalServerError)
+		return
+	}
 
-        // Check if all required fields are filled
-        if book.Title == "" || book.AuthorID == 0 {
-            http.Error(w, "Book title and author ID are required fields", http.StatusBadRequest)
-            return
-        }
+	_, err = tx.ExecContext(r.Context(), "DELETE FROM book_genres WHERE book_id IN (SELECT id FROM books WHERE author_id = ?)", authorID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to delete book genres: %v", err), http.StatusInternalServerError)
+		return
+	}
 
-        // Query to add book
-        query := `
-            INSERT INTO books (title, author_id, photo, is_borrowed, details) 
-            VALUES (?, ?, ?, ?, ?)
-        `
+	_, err = tx.ExecContext(r.Context(), "DELETE FROM reviews WHERE book_id IN (SELECT id FROM books WHERE author_id = ?)", authorID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to delete book reviews: %v", err), http.StatusInternalServerError)
+		return
+	}
 
-        // Execute the query
-        result, err := db.Exec(query, book.Title, book.AuthorID, book.Photo, book.IsBorrowed, book.Details)  // Changed here
-        if err != nil {
-            http.Error(w, fmt.Sprintf("Failed to insert book: %v", err), http.StatusInternalServerError)
-            return
-        }
+	// The links of the author, and those of the co-authors of the deleted books
+	_, err = tx.ExecContext(r.Context(), "DELETE FROM authors_books WHERE author_id = ? OR book_id IN (SELECT id FROM books WHERE author_id = ?)", authorID, authorID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to delete author links: %v", err), http.StatusInternalServerError)
+		return
+	}
 
-        // Get the inserted book ID
-        id, err := result.LastInsertId()
-        if err != nil {
-            http.Error(w, "Failed to get last insert ID", http.StatusInternalServerError)
-            return
-        }
+	result, err := tx.ExecContext(r.Context(), "DELETE FROM books WHERE author_id = ?", authorID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to delete books: %v", err), http.StatusInternalServerError)
+		return
+	}
+	booksDeleted, _ := result.RowsAffected()
 
-        // Return the response with the book ID inserted
-        response := map[string]int{"id": int(id)}
-        w.Header().Set("Content-Type", "application/json")
-        json.NewEncoder(w).Encode(response)
-    }
+	result, err = tx.ExecContext(r.Context(), "DELETE FROM authors WHERE id = ?", authorID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to delete author: %v", err), http.StatusInternalServerError)
+		return
+	}
+	rowsAffected, _ := result.RowsAffected()
+	if rowsAffected == 0 {
+		http.Error(w, "Author not found", http.StatusNotFound)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to commit transaction: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	for _, bookID := range bookIDs {
+		photoConfig.removeUploadDir(r.Context(), photoConfig.BookDir(bookID))
+	}
+	photoConfig.removeUploadDir(r.Context(), photoConfig.AuthorDir(authorID))
+
+	audit(r.Context(), db, "delete", "author", authorID, map[string]int64{"books_deleted": booksDeleted})
+
+	response := map[string]interface{}{
+		"message":       "Author deleted successfully",
+		"books_deleted": booksDeleted,
+	}
+	RespondWithJSON(w, http.StatusOK, response)
 }
 
-// AddSubscriber adds a new subscriber to the database
-func AddSubscriber(db *sql.DB) http.HandlerFunc {
+// MergeAuthors merges the author given in the body into the author in the URL:
+// the source author's books and links are moved to the target and the source is deleted.
+func MergeAuthors(db *sql.DB, photoConfig PhotoConfig) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		// Check the HTTP method
-		if r.Method != http.MethodPost {
-			http.Error(w, "Only POST method is supported", http.StatusMethodNotAllowed)
+		targetID, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			http.Error(w, "Invalid author ID", http.StatusBadRequest)
 			return
 		}
 
-		// Parse the JSON data received from the request
-		var subscriber Subscriber
-		err := json.NewDecoder(r.Body).Decode(&subscriber)
-		if err != nil {
+		var requestBody struct {
+			SourceID int `json:"source_id"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&requestBody); err != nil {
 			http.Error(w, "Invalid JSON data", http.StatusBadRequest)
 			return
 		}
 		defer r.Body.Close()
 
-		// Check if all required fields are filled
-		if subscriber.Firstname == "" || subscriber.Lastname == "" || subscriber.Email == "" {
-			http.Error(w, "Firstname, Lastname, and Email are required fields", http.StatusBadRequest)
+		if requestBody.SourceID == 0 {
+			http.Error(w, "source_id is a required field", http.StatusBadRequest)
+			return
+		}
+		if requestBody.SourceID == targetID {
+			http.Error(w, "Cannot merge an author into itself", http.StatusBadRequest)
 			return
 		}
 
-		// Query to add subscriber
-		query := `
-			INSERT INTO subscribers (lastname, firstname, email) 
-			VALUES (?, ?, ?)
-		`
-
-		// Execute the query
-		result, err := db.Exec(query, subscriber.Lastname, subscriber.Firstname, subscriber.Email)
+		tx, err := db.BeginTx(r.Context(), nil)
 		if err != nil {
-			http.Error(w, fmt.Sprintf("Failed to insert subscriber: %v", err), http.StatusInternalServerError)
+			http.Error(w, fmt.Sprintf("Failed to start transaction: %v", err), http.StatusInternalServerError)
 			return
 		}
+		defer tx.Rollback()
 
-		// Get the ID of the inserted subscriber
-		id, err := result.LastInsertId()
+		// Check that both authors exist
+		var numAuthors int
+		err = tx.QueryRowContext(r.Context(), "SELECT COUNT(*) FROM authors WHERE id IN (?, ?)", targetID, requestBody.SourceID).Scan(&numAuthors)
 		if err != nil {
-			http.Error(w, "Failed to get last insert ID", http.StatusInternalServerError)
+			http.Error(w, fmt.Sprintf("Failed to check authors: %v", err), http.StatusInternalServerError)
 			return
 		}
-
-		// Return the response with the subscriber ID inserted
-		response := map[string]int{"id": int(id)}
-		json.NewEncoder(w).Encode(response)
-	}
-}
-
-
-// BorrowBook handles borrowing a book by a subscriber
-func BorrowBook(db *sql.DB) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		if r.Method != http.MethodPost {
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		if numAuthors != 2 {
+			http.Error(w, "Author not found", http.StatusNotFound)
 			return
 		}
 
-		var requestBody struct {
-			SubscriberID int `json:"subscriber_id"`
-			BookID       int `json:"book_id"`
-		}
-		err := json.NewDecoder(r.Body).Decode(&requestBody)
+		// Move the books to the target author
+		result, err := tx.ExecContext(r.Context(), "UPDATE books SET author_id = ? WHERE author_id = ?", targetID, requestBody.SourceID)
 		if err != nil {
-			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			http.Error(w, fmt.Sprintf("Failed to move books: %v", err), http.StatusInternalServerError)
 			return
 		}
-
-		// Check if the book is already borrowed
-		var isBorrowed bool
-		err = db.QueryRow("SELECT is_borrowed FROM books WHERE id = ?", requestBody.BookID).Scan(&isBorrowed)
+		booksMoved, _ := result.RowsAffected()
+
+		// Drop the source links the target already has, then move the rest
+		_, err = tx.ExecContext(r.Context(), `
+			DELETE src FROM authors_books src
+			JOIN authors_books dst ON src.book_id = dst.book_id
+			WHERE src.author_id = ? AND dst.author_id = ?
+		`, requestBody.SourceID, targetID)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			http.Error(w, fmt.Sprintf("Failed to delete duplicate author links: %v", err), http.StatusInternalServerError)
 			return
 		}
-		if isBorrowed {
-			http.Error(w, "Book is already borrowed", http.StatusConflict)
+		_, err = tx.ExecContext(r.Context(), "UPDATE authors_books SET author_id = ? WHERE author_id = ?", targetID, requestBody.SourceID)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to move author links: %v", err), http.StatusInternalServerError)
 			return
 		}
 
-		// Insert a new record in the borrowed_books table
-		_, err = db.Exec("INSERT INTO borrowed_books (subscriber_id, book_id, date_of_borrow) VALUES (?, ?, NOW())", requestBody.SubscriberID, requestBody.BookID)
+		_, err = tx.ExecContext(r.Context(), "DELETE FROM authors WHERE id = ?", requestBody.SourceID)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			http.Error(w, fmt.Sprintf("Failed to delete author: %v", err), http.StatusInternalServerError)
 			return
 		}
 
-		// Update the is_borrowed status of the book
-		_, err = db.Exec("UPDATE books SET is_borrowed = TRUE WHERE id = ?", requestBody.BookID)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+		if err := tx.Commit(); err != nil {
+			http.Error(w, fmt.Sprintf("Failed to commit transaction: %v", err), http.StatusInternalServerError)
 			return
 		}
 
-		w.WriteHeader(http.StatusCreated)
-		fmt.Fprintf(w, "Book borrowed successfully")
+		// The source author's photos are no longer referenced
+		photoConfig.removeUploadDir(r.Context(), photoConfig.AuthorDir(requestBody.SourceID))
+
+		RespondWithJSON(w, http.StatusOK, map[string]interface{}{
+			"message":     "Authors merged successfully",
+			"books_moved": booksMoved,
+		})
 	}
 }
 
-// ReturnBorrowedBook handles returning a borrowed book by a subscriber
-func ReturnBorrowedBook(db *sql.DB) http.HandlerFunc {
+// LinkBookToAuthor returns a handler that adds an author to an existing book. Linking twice is harmless,
+// 201 tells a new link from 200 for one that already existed.
+func LinkBookToAuthor(db *sql.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		if r.Method != http.MethodPost {
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		authorID, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			http.Error(w, "Invalid author ID", http.StatusBadRequest)
 			return
 		}
 
-		// Parse the request body to get subscriber ID and book ID
 		var requestBody struct {
-			SubscriberID int `json:"subscriber_id"`
-			BookID       int `json:"book_id"`
+			BookID int `json:"book_id"`
 		}
-		err := json.NewDecoder(r.Body).Decode(&requestBody)
-		if err != nil {
-			http.Error(w, "Invalid request body", http.StatusBadRequest)
+		if err := json.NewDecoder(r.Body).Decode(&requestBody); err != nil {
+			http.Error(w, "Invalid JSON data", http.StatusBadRequest)
 			return
 		}
-
-		// Check if the book is actually borrowed by the subscriber
-		var isBorrowed bool
-		err = db.QueryRow("SELECT is_borrowed FROM books WHERE id = ? AND is_borrowed = TRUE", requestBody.BookID).Scan(&isBorrowed)
-		if err != nil {
-			http.Error(w, "Book is not borrowed", http.StatusNotFound)
+		defer r.Body.Close()
+		if requestBody.BookID <= 0 {
+			http.Error(w, "book_id is a required field", http.StatusBadRequest)
 			return
 		}
 
-		// Update borrowed_books table to mark book as returned
-		_, err = db.Exec("UPDATE borrowed_books SET return_date = NOW() WHERE subscriber_id = ? AND book_id = ?", requestBody.SubscriberID, requestBody.BookID)
+		var authorExists, bookExists bool
+		err = db.QueryRowContext(r.Context(), `
+			SELECT EXISTS(SELECT 1 FROM authors WHERE id = ?), EXISTS(SELECT 1 FROM books WHERE id = ?)
+		`, authorID, requestBody.BookID).Scan(&authorExists, &bookExists)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
+		if !authorExists {
+			http.Error(w, "Author not found", http.StatusNotFound)
+			return
+		}
+		if !bookExists {
+			http.Error(w, "Book not found", http.StatusNotFound)
+			return
+		}
 
-		// Update books table to mark book as not borrowed
-		_, err = db.Exec("UPDATE books SET is_borrowed = FALSE WHERE id = ?", requestBody.BookID)
+		result, err := db.ExecContext(r.Context(), "INSERT IGNORE INTO authors_books (author_id, book_id) VALUES (?, ?)", authorID, requestBody.BookID)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			http.Error(w, fmt.Sprintf("Failed to link book: %v", err), http.StatusInternalServerError)
 			return
 		}
 
-		w.WriteHeader(http.StatusOK)
-		fmt.Fprintf(w, "Book returned successfully")
+		if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+			RespondWithJSON(w, http.StatusOK, map[string]string{"message": "Book already linked to the author"})
+			return
+		}
+		RespondWithJSON(w, http.StatusCreated, map[string]string{"message": "Book linked to the author successfully"})
 	}
 }
 
-
-func UpdateAuthor(db *sql.DB) http.HandlerFunc {
-    return func(w http.ResponseWriter, r *http.Request) {
-        if r.Method != http.MethodPut && r.Method != http.MethodPost {
-            http.Error(w, "Only PUT or POST methods are supported", http.StatusMethodNotAllowed)
-            return
-        }
-
-        vars := mux.Vars(r)
-        authorID, err := strconv.Atoi(vars["id"])
-        if err != nil {
-            http.Error(w, "Invalid author ID", http.StatusBadRequest)
-            return
-        }
-
-        var author Author
-        err = json.NewDecoder(r.Body).Decode(&author)
-        if err != nil {
-            http.Error(w, "Invalid JSON data", http.StatusBadRequest)
-            return
-        }
-        defer r.Body.Close()
-
-        if author.Firstname == "" || author.Lastname == "" {
-            http.Error(w, "Firstname and Lastname are required fields", http.StatusBadRequest)
-            return
-        }
-
-        query := `
-            UPDATE authors 
-            SET lastname = ?, firstname = ?, photo = ? 
-            WHERE id = ?
-        `
-
-        result, err := db.Exec(query, author.Lastname, author.Firstname, author.Photo, authorID)
-        if err != nil {
-            http.Error(w, fmt.Sprintf("Failed to update author: %v", err), http.StatusInternalServerError)
-            return
-        }
-
-        rowsAffected, _ := result.RowsAffected()
-        if rowsAffected == 0 {
-            http.Error(w, "Author not found", http.StatusNotFound)
-            return
-        }
-
-        fmt.Fprintf(w, "Author updated successfully")
-    }
-}
-
-
-
-// UpdateBook updates an existing book in the database
-func UpdateBook(db *sql.DB) http.HandlerFunc {
+// UnlinkBookFromAuthor returns a handler that removes an author from a book. The main author of a book
+// can't be removed this way, the book would still point to them.
+func UnlinkBookFromAuthor(db *sql.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		// Check the HTTP method
-		if r.Method != http.MethodPut && r.Method != http.MethodPost {
-			http.Error(w, "Only PUT or POST methods are supported", http.StatusMethodNotAllowed)
+		vars := mux.Vars(r)
+		authorID, err := strconv.Atoi(vars["id"])
+		if err != nil {
+			http.Error(w, "Invalid author ID", http.StatusBadRequest)
 			return
 		}
-
-		// Extract the book ID from the URL path
-		vars := mux.Vars(r)
-		bookID, err := strconv.Atoi(vars["id"])
+		bookID, err := strconv.Atoi(vars["book_id"])
 		if err != nil {
 			http.Error(w, "Invalid book ID", http.StatusBadRequest)
 			return
 		}
 
-		// Parse the JSON data received from the request
-		var book struct {
-			Title      string `json:"title"`
-			AuthorID   int    `json:"author_id"`
-			Photo      string `json:"photo"`
-			Details    string `json:"details"`
-			IsBorrowed bool   `json:"is_borrowed"`
+		var mainAuthorID int
+		err = db.QueryRowContext(r.Context(), "SELECT author_id FROM books WHERE id = ?", bookID).Scan(&mainAuthorID)
+		if err == sql.ErrNoRows {
+			http.Error(w, "Book not found", http.StatusNotFound)
+			return
 		}
-		err = json.NewDecoder(r.Body).Decode(&book)
 		if err != nil {
-			http.Error(w, "Invalid JSON data", http.StatusBadRequest)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
-		defer r.Body.Close()
-
-		// Log the book ID and received data for update
-		log.Printf("Updating book with ID: %d", bookID)
-		log.Printf("Received data: %+v", book)
-
-		// Check if all required fields are filled
-		if book.Title == "" || book.AuthorID == 0 {
-			http.Error(w, "Title and AuthorID are required fields", http.StatusBadRequest)
+		if mainAuthorID == authorID {
+			http.Error(w, "Can't unlink the main author of a book", http.StatusConflict)
 			return
 		}
 
-		// Query to update the book
-		query := `
-			UPDATE books 
-			SET title = ?, author_id = ?, photo = ?, details = ?, is_borrowed = ? 
-			WHERE id = ?
-		`
-
-		// Execute the query
-		result, err := db.Exec(query, book.Title, book.AuthorID, book.Photo, book.Details, book.IsBorrowed, bookID)
+		result, err := db.ExecContext(r.Context(), "DELETE FROM authors_books WHERE author_id = ? AND book_id = ?", authorID, bookID)
 		if err != nil {
-			http.Error(w, fmt.Sprintf("Failed to update book: %v", err), http.StatusInternalServerError)
+			http.Error(w, fmt.Sprintf("Failed to unlink book: %v", err), http.StatusInternalServerError)
 			return
 		}
-
-		// Check if any row was actually updated
-		rowsAffected, _ := result.RowsAffected()
-		if rowsAffected == 0 {
-			http.Error(w, "Book not found", http.StatusNotFound)
+		if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+			http.Error(w, "Book is not linked to the author", http.StatusNotFound)
 			return
 		}
 
-		// Return the success response
-		fmt.Fprintf(w, "Book updated successfully")
+		RespondWithJSON(w, http.StatusOK, map[string]string{"message": "Book unlinked from the author successfully"})
 	}
 }
 
-
-// UpdateSubscriber updates an existing subscriber in the database
-func UpdateSubscriber(db *sql.DB) http.HandlerFunc {
+// DeleteBook deletes an existing book from the database
+func DeleteBook(db *sql.DB, photoConfig PhotoConfig) http.HandlerFunc {
     return func(w http.ResponseWriter, r *http.Request) {
         // Check the HTTP method
-        if r.Method != http.MethodPut && r.Method != http.MethodPost {
-            http.Error(w, "Only PUT or POST methods are supported", http.StatusMethodNotAllowed)
+        if r.Method != http.MethodDelete {
+            http.Error(w, "Only DELETE method is supported", http.StatusMethodNotAllowed)
             return
         }
 
-        // Extract the subscriber ID from the URL path
+        // Extract the book ID from the URL path
         vars := mux.Vars(r)
-        subscriberID, err := strconv.Atoi(vars["id"])
+        bookID, err := strconv.Atoi(vars["id"])
         if err != nil {
-            http.Error(w, "Invalid subscriber ID", http.StatusBadRequest)
+            http.Error(w, "Invalid book ID", http.StatusBadRequest)
             return
         }
 
-        // Parse the JSON data received from the request
-        var subscriber Subscriber
-        err = json.NewDecoder(r.Body).Decode(&subscriber)
+        tx, err := db.BeginTx(r.Context(), nil)
         if err != nil {
-            http.Error(w, "Invalid JSON data", http.StatusBadRequest)
-            return
-        }
-        defer r.Body.Close()
-
-        // Log the subscriber ID and received data for update
-        log.Printf("Updating subscriber with ID: %d", subscriberID)
-        log.Printf("Received data: %+v", subscriber)
-
-        // Check if all required fields are filled
-        if subscriber.Firstname == "" || subscriber.Lastname == "" || subscriber.Email == "" {
-            http.Error(w, "Firstname, Lastname, and Email are required fields", http.StatusBadRequest)
+            http.Error(w, fmt.Sprintf("Failed to start transaction: %v", err), http.StatusInternalServerError)
             return
         }
+        defer tx.Rollback()
 
-        // Query to update the subscriber
-        query := `
-            UPDATE subscribers 
-            SET lastname = ?, firstname = ?, email = ? 
+        // Query to get the author ID of the book
+        authorIDQuery := `
+            SELECT author_id
+            FROM books
             WHERE id = ?
         `
 
         // Execute the query
-        result, err := db.Exec(query, subscriber.Lastname, subscriber.Firstname, subscriber.Email, subscriberID)
-        if err != nil {
-            http.Error(w, fmt.Sprintf("Failed to update subscriber: %v", err), http.StatusInternalServerError)
-            return
-        }
-
-        // Check if any row was actually updated
-        rowsAffected, _ := result.RowsAffected()
-        if rowsAffected == 0 {
-            http.Error(w, "Subscriber not found", http.StatusNotFound)
-            return
-        }
-
-        // Return the success response
-        fmt.Fprintf(w, "Subscriber updated successfully")
-    }
-}
-
-// DeleteAuthor deletes an existing author from the database
-func DeleteAuthor(db *sql.DB) http.HandlerFunc {
-    return func(w http.ResponseWriter, r *http.Request) {
-        // Check the HTTP method
-        if r.Method != http.MethodDelete {
-            http.Error(w, "Only DELETE method is supported", http.StatusMethodNotAllowed)
+        var authorID int
+        err = tx.QueryRowContext(r.Context(), authorIDQuery, bookID).Scan(&authorID)
+        if err == sql.ErrNoRows {
+            http.Error(w, "Book not found", http.StatusNotFound)
             return
         }
-
-        // Extract the author ID from the URL path
-        vars := mux.Vars(r)
-        authorID, err := strconv.Atoi(vars["id"])
         if err != nil {
-            http.Error(w, "Invalid author ID", http.StatusBadRequest)
+            http.Error(w, fmt.Sprintf("Failed to retrieve author ID: %v", err), http.StatusInternalServerError)
             return
         }
 
-        // Query to check if the author has books
-        booksQuery := `
-            SELECT COUNT(*)
-            FROM books
-            WHERE author_id = ?
-        `
-
-        // Execute the query
-        var numBooks int
-        err = db.QueryRow(booksQuery, authorID).Scan(&numBooks)
+        // Check if the book is currently borrowed
+        var activeBorrows int
+        err = tx.QueryRowContext(r.Context(), "SELECT COUNT(*) FROM borrowed_books WHERE book_id = ? AND return_date IS NULL", bookID).Scan(&activeBorrows)
         if err != nil {
-            http.Error(w, fmt.Sprintf("Failed to check for books: %v", err), http.StatusInternalServerError)
+            http.Error(w, fmt.Sprintf("Failed to check for active borrows: %v", err), http.StatusInternalServerError)
             return
         }
-
-        // If author has books, respond with a bad request
-        if numBooks > 0 {
-            http.Error(w, "Author has associated books, delete books first", http.StatusBadRequest)
+        if activeBorrows > 0 && r.URL.Query().Get("force") != "true" {
+            http.Error(w, "Book is currently borrowed", http.StatusConflict)
             return
         }
 
-        // Query to delete the author
-        deleteQuery := `
-            DELETE FROM authors
-            WHERE id = ?
-        `
-
-        // Execute the query to delete the author
-        result, err := db.Exec(deleteQuery, authorID)
+        // The borrow history references the book, open borrows included, so it goes with it
+        _, err = tx.ExecContext(r.Context(), "DELETE FROM borrowed_books WHERE book_id = ?", bookID)
         if err != nil {
-            http.Error(w, fmt.Sprintf("Failed to delete author: %v", err), http.StatusInternalServerError)
+            http.Error(w, fmt.Sprintf("Failed to delete borrow history: %v", err), http.StatusInternalServerError)
             return
         }
 
-        // Check if any row was actually deleted
-        rowsAffected, _ := result.RowsAffected()
-        if rowsAffected == 0 {
-            http.Error(w, "Author not found", http.StatusNotFound)
+        _, err = tx.ExecContext(r.Context(), "DELETE FROM book_tags WHERE book_id = ?", bookID)
+        if err != nil {
+            http.Error(w, fmt.Sprintf("Failed to delete book tags: %v", err), http.StatusInternalServerError)
             return
         }
 
-        // Return the success response
-        fmt.Fprintf(w, "Author deleted successfully")
-    }
-}
-
-// DeleteBook deletes an existing book from the database
-func DeleteBook(db *sql.DB) http.HandlerFunc {
-    return func(w http.ResponseWriter, r *http.Request) {
-        // Check the HTTP method
-        if r.Method != http.MethodDelete {
-            http.Error(w, "Only DELETE method is supported", http.StatusMethodNotAllowed)
+        _, err = tx.ExecContext(r.Context(), "DELETE FROM book_genres WHERE book_id = ?", bookID)
+        if err != nil {
+            http.Error(w, fmt.Sprintf("Failed to delete book genres: %v", err), http.StatusInternalServerError)
             return
         }
 
-        // Extract the book ID from the URL path
-        vars := mux.Vars(r)
-        bookID, err := strconv.Atoi(vars["id"])
+        _, err = tx.ExecContext(r.Context(), "DELETE FROM reviews WHERE book_id = ?", bookID)
         if err != nil {
-            http.Error(w, "Invalid book ID", http.StatusBadRequest)
+            http.Error(w, fmt.Sprintf("Failed to delete book reviews: %v", err), http.StatusInternalServerError)
             return
         }
 
-        // Query to get the author ID of the book
-        authorIDQuery := `
-            SELECT author_id
-            FROM books
-            WHERE id = ?
-        `
-
-        // Execute the query
-        var authorID int
-        err = db.QueryRow(authorIDQuery, bookID).Scan(&authorID)
+        _, err = tx.ExecContext(r.Context(), "DELETE FROM authors_books WHERE book_id = ?", bookID)
         if err != nil {
-            http.Error(w, fmt.Sprintf("Failed to retrieve author ID: %v", err), http.StatusInternalServerError)
+            http.Error(w, fmt.Sprintf("Failed to delete author links: %v", err), http.StatusInternalServerError)
             return
         }
 
-        // Query to check if the author has any other books
+        // Query to check if the author has any other books, as main author or co-author. The links of
+        // this book are already deleted.
         otherBooksQuery := `
-            SELECT COUNT(*)
-            FROM books
-            WHERE author_id = ? AND id != ?
+            SELECT (SELECT COUNT(*) FROM books WHERE author_id = ? AND id != ?)
+                + (SELECT COUNT(*) FROM authors_books WHERE author_id = ?)
         `
 
         // Execute the query
         var numOtherBooks int
-        err = db.QueryRow(otherBooksQuery, authorID, bookID).Scan(&numOtherBooks)
+        err = tx.QueryRowContext(r.Context(), otherBooksQuery, authorID, bookID, authorID).Scan(&numOtherBooks)
         if err != nil {
             http.Error(w, fmt.Sprintf("Failed to check for other books: %v", err), http.StatusInternalServerError)
             return
@@ -1052,7 +3469,7 @@ func DeleteBook(db *sql.DB) http.HandlerFunc {
         `
 
         // Execute the query to delete the book
-        result, err := db.Exec(deleteBookQuery, bookID)
+        result, err := tx.ExecContext(r.Context(), deleteBookQuery, bookID)
         if err != nil {
             http.Error(w, fmt.Sprintf("Failed to delete book: %v", err), http.StatusInternalServerError)
             return
@@ -1073,14 +3490,26 @@ func DeleteBook(db *sql.DB) http.HandlerFunc {
             `
 
             // Execute the query to delete the author
-            _, err = db.Exec(deleteAuthorQuery, authorID)
+            _, err = tx.ExecContext(r.Context(), deleteAuthorQuery, authorID)
             if err != nil {
                 http.Error(w, fmt.Sprintf("Failed to delete author: %v", err), http.StatusInternalServerError)
                 return
             }
         }
-        
-        fmt.Fprintf(w, "Book deleted successfully")
+
+        if err := tx.Commit(); err != nil {
+            http.Error(w, fmt.Sprintf("Failed to commit transaction: %v", err), http.StatusInternalServerError)
+            return
+        }
+
+        photoConfig.removeUploadDir(r.Context(), photoConfig.BookDir(bookID))
+        if numOtherBooks == 0 {
+            photoConfig.removeUploadDir(r.Context(), photoConfig.AuthorDir(authorID))
+        }
+
+        audit(r.Context(), db, "delete", "book", bookID, nil)
+
+        RespondWithJSON(w, http.StatusOK, map[string]string{"message": "Book deleted successfully"})
     }
 }
 
@@ -1102,6 +3531,57 @@ func DeleteSubscriber(db *sql.DB) http.HandlerFunc {
             return
         }
 
+        tx, err := db.BeginTx(r.Context(), nil)
+        if err != nil {
+            http.Error(w, fmt.Sprintf("Failed to start transaction: %v", err), http.StatusInternalServerError)
+            return
+        }
+        defer tx.Rollback()
+
+        // Check if the subscriber still has books checked out
+        var activeBorrows int
+        err = tx.QueryRowContext(r.Context(), "SELECT COUNT(*) FROM borrowed_books WHERE subscriber_id = ? AND return_date IS NULL", subscriberID).Scan(&activeBorrows)
+        if err != nil {
+            http.Error(w, fmt.Sprintf("Failed to check for active borrows: %v", err), http.StatusInternalServerError)
+            return
+        }
+
+        if activeBorrows > 0 {
+            if r.URL.Query().Get("force") != "true" {
+                http.Error(w, fmt.Sprintf("Subscriber has %d borrowed book(s), return them first or use force=true", activeBorrows), http.StatusConflict)
+                return
+            }
+
+            // Free the books and mark the borrows as returned
+            _, err = tx.ExecContext(r.Context(), `
+                UPDATE books
+                SET is_borrowed = FALSE
+                WHERE id IN (SELECT book_id FROM borrowed_books WHERE subscriber_id = ? AND return_date IS NULL)
+            `, subscriberID)
+            if err != nil {
+                http.Error(w, fmt.Sprintf("Failed to free borrowed books: %v", err), http.StatusInternalServerError)
+                return
+            }
+            _, err = tx.ExecContext(r.Context(), "UPDATE borrowed_books SET return_date = NOW() WHERE subscriber_id = ? AND return_date IS NULL", subscriberID)
+            if err != nil {
+                http.Error(w, fmt.Sprintf("Failed to close active borrows: %v", err), http.StatusInternalServerError)
+                return
+            }
+        }
+
+        // Keep the borrow history for the statistics, without the subscriber. The reviews go with them.
+        _, err = tx.ExecContext(r.Context(), "UPDATE borrowed_books SET subscriber_id = NULL WHERE subscriber_id = ?", subscriberID)
+        if err != nil {
+            http.Error(w, fmt.Sprintf("Failed to detach borrow history: %v", err), http.StatusInternalServerError)
+            return
+        }
+
+        _, err = tx.ExecContext(r.Context(), "DELETE FROM reviews WHERE subscriber_id = ?", subscriberID)
+        if err != nil {
+            http.Error(w, fmt.Sprintf("Failed to delete reviews: %v", err), http.StatusInternalServerError)
+            return
+        }
+
         // Query to delete the subscriber
         deleteQuery := `
             DELETE FROM subscribers
@@ -1109,7 +3589,7 @@ func DeleteSubscriber(db *sql.DB) http.HandlerFunc {
         `
 
         // Execute the query to delete the subscriber
-        result, err := db.Exec(deleteQuery, subscriberID)
+        result, err := tx.ExecContext(r.Context(), deleteQuery, subscriberID)
         if err != nil {
             http.Error(w, fmt.Sprintf("Failed to delete subscriber: %v", err), http.StatusInternalServerError)
             return
@@ -1122,7 +3602,14 @@ func DeleteSubscriber(db *sql.DB) http.HandlerFunc {
             return
         }
 
+        if err := tx.Commit(); err != nil {
+            http.Error(w, fmt.Sprintf("Failed to commit transaction: %v", err), http.StatusInternalServerError)
+            return
+        }
+
+        audit(r.Context(), db, "delete", "subscriber", subscriberID, nil)
+
         // Return the success response
-        fmt.Fprintf(w, "Subscriber deleted successfully")
+        RespondWithJSON(w, http.StatusOK, map[string]string{"message": "Subscriber deleted successfully"})
     }
 }
@@ -0,0 +1,1800 @@
+package main
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-sql-driver/mysql"
+	"github.com/gorilla/mux"
+)
+
+// newMockDB returns a database backed by sqlmock, whose expectations are checked when the test ends
+func newMockDB(t *testing.T) (*sql.DB, sqlmock.Sqlmock) {
+	t.Helper()
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+		db.Close()
+	})
+	return db, mock
+}
+
+// sqlPattern matches a query containing fragment
+func sqlPattern(fragment string) string {
+	return regexp.QuoteMeta(fragment)
+}
+
+// serveRoute serves a request for target with handler registered at pattern, so that the path variables
+// are set as they are by the router. A handler built with a nil *sql.DB checks that the request is rejected
+// before any query: reaching the database would panic.
+func serveRoute(handler http.Handler, method, pattern, target string, body io.Reader) *httptest.ResponseRecorder {
+	r := mux.NewRouter()
+	r.Handle(pattern, handler).Methods(method)
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(method, target, body))
+	return rec
+}
+
+// decodeJSON decodes the body of a response into v
+func decodeJSON(t *testing.T, rec *httptest.ResponseRecorder, v interface{}) {
+	t.Helper()
+	if err := json.NewDecoder(rec.Body).Decode(v); err != nil {
+		t.Fatalf("decoding %q: %v", rec.Body.String(), err)
+	}
+}
+
+// expectAudit expects the audit entry of action on an entity
+func expectAudit(mock sqlmock.Sqlmock, action, entityType string, entityID int) {
+	mock.ExpectExec(sqlPattern("INSERT INTO audit_log")).
+		WithArgs(action, entityType, entityID, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+}
+
+// expectMessage checks that a response is the JSON {"message": want}
+func expectMessage(t *testing.T, rec *httptest.ResponseRecorder, want string) {
+	t.Helper()
+	if got := rec.Header().Get("Content-Type"); got != "application/json" {
+		t.Errorf("Content-Type %q, want application/json", got)
+	}
+	var response map[string]string
+	decodeJSON(t, rec, &response)
+	if response["message"] != want {
+		t.Errorf("got %v, want message %q", response, want)
+	}
+}
+
+func TestGetSubscriberByID(t *testing.T) {
+	columns := []string{"id", "lastname", "firstname", "email", "phone", "membership_expiry"}
+
+	t.Run("found", func(t *testing.T) {
+		db, mock := newMockDB(t)
+		mock.ExpectQuery(sqlPattern("FROM subscribers WHERE id = ?")).WithArgs(7).
+			WillReturnRows(sqlmock.NewRows(columns).AddRow(7, "Johnson", "Emma", "emma@example.com", "+40700000001", nil))
+
+		rec := serveRoute(GetSubscriberByID(db), http.MethodGet, "/subscribers/{id}", "/subscribers/7", nil)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status %d, want 200: %s", rec.Code, rec.Body)
+		}
+		var subscriber Subscriber
+		decodeJSON(t, rec, &subscriber)
+		want := Subscriber{ID: 7, Lastname: "Johnson", Firstname: "Emma", Email: "emma@example.com", Phone: "+40700000001"}
+		if subscriber != want {
+			t.Errorf("got %+v, want %+v", subscriber, want)
+		}
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		db, mock := newMockDB(t)
+		mock.ExpectQuery(sqlPattern("FROM subscribers WHERE id = ?")).WithArgs(8).WillReturnRows(sqlmock.NewRows(columns))
+
+		rec := serveRoute(GetSubscriberByID(db), http.MethodGet, "/subscribers/{id}", "/subscribers/8", nil)
+		if rec.Code != http.StatusNotFound {
+			t.Errorf("status %d, want 404", rec.Code)
+		}
+	})
+
+	t.Run("invalid id", func(t *testing.T) {
+		db, _ := newMockDB(t)
+
+		rec := serveRoute(GetSubscriberByID(db), http.MethodGet, "/subscribers/{id}", "/subscribers/abc", nil)
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("status %d, want 400", rec.Code)
+		}
+	})
+}
+
+func TestSubscriberDuplicateEmail(t *testing.T) {
+	duplicate := &mysql.MySQLError{Number: mysqlErrDuplicateEntry, Message: "Duplicate entry 'emma@example.com' for key 'uq_subscribers_email'"}
+	body := `{"firstname":"Emma","lastname":"Johnson","email":" Emma@Example.com "}`
+	tests := []struct {
+		name    string
+		handler func(*sql.DB) http.HandlerFunc
+		method  string
+		pattern string
+		target  string
+		query   string
+		args    []driver.Value
+	}{
+		{name: "create", handler: AddSubscriber, method: http.MethodPost, pattern: "/subscribers/new", target: "/subscribers/new",
+			query: "INSERT INTO subscribers", args: []driver.Value{"Johnson", "Emma", "emma@example.com", nil, nil}},
+		{name: "update", handler: UpdateSubscriber, method: http.MethodPut, pattern: "/subscribers/{id}", target: "/subscribers/3",
+			query: "UPDATE subscribers", args: []driver.Value{"Johnson", "Emma", "emma@example.com", nil, nil, 3}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock := newMockDB(t)
+			// The email is trimmed and lower-cased, the unique index finds the collision
+			mock.ExpectExec(sqlPattern(tt.query)).WithArgs(tt.args...).WillReturnError(duplicate)
+
+			rec := serveRoute(tt.handler(db), tt.method, tt.pattern, tt.target, strings.NewReader(body))
+			if rec.Code != http.StatusConflict {
+				t.Fatalf("status %d, want 409: %s", rec.Code, rec.Body)
+			}
+			var response map[string]string
+			decodeJSON(t, rec, &response)
+			if response["error"] != "email already registered" {
+				t.Errorf("got %v", response)
+			}
+		})
+	}
+}
+
+func TestAddDuplicateAuthor(t *testing.T) {
+	body := `{"firstname":" John ","lastname":"Doe ","photo":"doe.jpg"}`
+
+	t.Run("conflict", func(t *testing.T) {
+		db, mock := newMockDB(t)
+		mock.ExpectQuery(sqlPattern("SELECT id FROM authors WHERE Lastname = ? AND Firstname = ?")).
+			WithArgs("Doe", "John").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(4))
+
+		rec := serveRoute(AddAuthor(db, newTestPhotoConfig(t)), http.MethodPost, "/authors/new", "/authors/new", strings.NewReader(body))
+		if rec.Code != http.StatusConflict {
+			t.Fatalf("status %d, want 409: %s", rec.Code, rec.Body)
+		}
+		var response map[string]interface{}
+		decodeJSON(t, rec, &response)
+		if response["error"] != "author already exists" || response["existing_id"] != 4.0 {
+			t.Errorf("got %v", response)
+		}
+	})
+
+	t.Run("allowed", func(t *testing.T) {
+		db, mock := newMockDB(t)
+		mock.ExpectExec(sqlPattern("INSERT INTO authors")).WithArgs("Doe", "John", "doe.jpg").WillReturnResult(sqlmock.NewResult(8, 1))
+		expectAudit(mock, "create", "author", 8)
+
+		rec := serveRoute(AddAuthor(db, newTestPhotoConfig(t)), http.MethodPost, "/authors/new", "/authors/new?allow_duplicate=true", strings.NewReader(body))
+		if rec.Code != http.StatusCreated {
+			t.Errorf("status %d, want 201: %s", rec.Code, rec.Body)
+		}
+	})
+}
+
+// newTestPhotoConfig stores the photos in a temporary directory
+func newTestPhotoConfig(t *testing.T) PhotoConfig {
+	return PhotoConfig{Storage: &LocalStorage{Dir: t.TempDir(), BaseURL: "/upload"}, MaxSize: 1 << 20, MaxMemory: 1 << 20}
+}
+
+func TestDeleteAuthorWithBooks(t *testing.T) {
+	db, mock := newMockDB(t)
+	mock.ExpectQuery(sqlPattern("FROM books")).WithArgs(3, 3).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(2))
+
+	rec := serveRoute(DeleteAuthor(db, newTestPhotoConfig(t)), http.MethodDelete, "/authors/{id}", "/authors/3", nil)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("status %d, want 400", rec.Code)
+	}
+}
+
+func TestDeleteAuthorCascade(t *testing.T) {
+	t.Run("refuses while a book is borrowed", func(t *testing.T) {
+		db, mock := newMockDB(t)
+		mock.ExpectBegin()
+		mock.ExpectQuery(sqlPattern("bb.return_date IS NULL")).WithArgs(3).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+		mock.ExpectRollback()
+
+		rec := serveRoute(DeleteAuthor(db, newTestPhotoConfig(t)), http.MethodDelete, "/authors/{id}", "/authors/3?cascade=true", nil)
+		if rec.Code != http.StatusConflict {
+			t.Errorf("status %d, want 409", rec.Code)
+		}
+	})
+
+	t.Run("deletes the books", func(t *testing.T) {
+		db, mock := newMockDB(t)
+		mock.ExpectBegin()
+		mock.ExpectQuery(sqlPattern("bb.return_date IS NULL")).WithArgs(3).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+		mock.ExpectQuery(sqlPattern("SELECT id FROM books WHERE author_id = ?")).WithArgs(3).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(10).AddRow(11))
+		for _, table := range []string{"borrowed_books", "book_tags", "book_genres", "reviews"} {
+			mock.ExpectExec(sqlPattern("DELETE FROM " + table + " WHERE book_id IN")).WithArgs(3).WillReturnResult(sqlmock.NewResult(0, 1))
+		}
+		mock.ExpectExec(sqlPattern("DELETE FROM authors_books")).WithArgs(3, 3).WillReturnResult(sqlmock.NewResult(0, 3))
+		mock.ExpectExec(sqlPattern("DELETE FROM books WHERE author_id = ?")).WithArgs(3).WillReturnResult(sqlmock.NewResult(0, 2))
+		mock.ExpectExec(sqlPattern("DELETE FROM authors WHERE id = ?")).WithArgs(3).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+		expectAudit(mock, "delete", "author", 3)
+
+		rec := serveRoute(DeleteAuthor(db, newTestPhotoConfig(t)), http.MethodDelete, "/authors/{id}", "/authors/3?cascade=true", nil)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status %d, want 200: %s", rec.Code, rec.Body)
+		}
+		var response struct {
+			BooksDeleted int `json:"books_deleted"`
+		}
+		decodeJSON(t, rec, &response)
+		if response.BooksDeleted != 2 {
+			t.Errorf("books_deleted %d, want 2", response.BooksDeleted)
+		}
+	})
+
+	t.Run("unknown author", func(t *testing.T) {
+		db, mock := newMockDB(t)
+		mock.ExpectBegin()
+		mock.ExpectQuery(sqlPattern("bb.return_date IS NULL")).WithArgs(4).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+		mock.ExpectQuery(sqlPattern("SELECT id FROM books WHERE author_id = ?")).WithArgs(4).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+		for _, table := range []string{"borrowed_books", "book_tags", "book_genres", "reviews"} {
+			mock.ExpectExec(sqlPattern("DELETE FROM " + table + " WHERE book_id IN")).WithArgs(4).WillReturnResult(sqlmock.NewResult(0, 0))
+		}
+		mock.ExpectExec(sqlPattern("DELETE FROM authors_books")).WithArgs(4, 4).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec(sqlPattern("DELETE FROM books WHERE author_id = ?")).WithArgs(4).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec(sqlPattern("DELETE FROM authors WHERE id = ?")).WithArgs(4).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectRollback()
+
+		rec := serveRoute(DeleteAuthor(db, newTestPhotoConfig(t)), http.MethodDelete, "/authors/{id}", "/authors/4?cascade=true", nil)
+		if rec.Code != http.StatusNotFound {
+			t.Errorf("status %d, want 404", rec.Code)
+		}
+	})
+}
+
+func TestDeleteBorrowedBook(t *testing.T) {
+	t.Run("conflict", func(t *testing.T) {
+		db, mock := newMockDB(t)
+		mock.ExpectBegin()
+		mock.ExpectQuery(sqlPattern("SELECT author_id")).WithArgs(5).WillReturnRows(sqlmock.NewRows([]string{"author_id"}).AddRow(2))
+		mock.ExpectQuery(sqlPattern("FROM borrowed_books WHERE book_id = ? AND return_date IS NULL")).WithArgs(5).
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+		mock.ExpectRollback()
+
+		rec := serveRoute(DeleteBook(db, newTestPhotoConfig(t)), http.MethodDelete, "/books/{id}", "/books/5", nil)
+		if rec.Code != http.StatusConflict {
+			t.Errorf("status %d, want 409", rec.Code)
+		}
+		if !strings.Contains(rec.Body.String(), "Book is currently borrowed") {
+			t.Errorf("body %q", rec.Body)
+		}
+	})
+
+	t.Run("forced", func(t *testing.T) {
+		db, mock := newMockDB(t)
+		mock.ExpectBegin()
+		mock.ExpectQuery(sqlPattern("SELECT author_id")).WithArgs(5).WillReturnRows(sqlmock.NewRows([]string{"author_id"}).AddRow(2))
+		mock.ExpectQuery(sqlPattern("FROM borrowed_books WHERE book_id = ? AND return_date IS NULL")).WithArgs(5).
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+		for _, table := range []string{"borrowed_books", "book_tags", "book_genres", "reviews", "authors_books"} {
+			mock.ExpectExec(sqlPattern("DELETE FROM " + table + " WHERE book_id = ?")).WithArgs(5).WillReturnResult(sqlmock.NewResult(0, 1))
+		}
+		mock.ExpectQuery(sqlPattern("WHERE author_id = ? AND id != ?")).WithArgs(2, 5, 2).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+		mock.ExpectExec(sqlPattern("DELETE FROM books")).WithArgs(5).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+		expectAudit(mock, "delete", "book", 5)
+
+		rec := serveRoute(DeleteBook(db, newTestPhotoConfig(t)), http.MethodDelete, "/books/{id}", "/books/5?force=true", nil)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status %d, want 200: %s", rec.Code, rec.Body)
+		}
+		expectMessage(t, rec, "Book deleted successfully")
+	})
+}
+
+func TestDeleteRemovesAuthorLinks(t *testing.T) {
+	t.Run("co-author", func(t *testing.T) {
+		// An author linked to a book only as co-author still has books, so the link isn't dropped
+		db, mock := newMockDB(t)
+		mock.ExpectQuery(sqlPattern("(SELECT COUNT(*) FROM authors_books WHERE author_id = ?)")).WithArgs(6, 6).
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+
+		rec := serveRoute(DeleteAuthor(db, newTestPhotoConfig(t)), http.MethodDelete, "/authors/{id}", "/authors/6", nil)
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("status %d, want 400: %s", rec.Code, rec.Body)
+		}
+	})
+
+	t.Run("last book of its author", func(t *testing.T) {
+		db, mock := newMockDB(t)
+		mock.ExpectBegin()
+		mock.ExpectQuery(sqlPattern("SELECT author_id")).WithArgs(5).WillReturnRows(sqlmock.NewRows([]string{"author_id"}).AddRow(2))
+		mock.ExpectQuery(sqlPattern("FROM borrowed_books WHERE book_id = ? AND return_date IS NULL")).WithArgs(5).
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+		for _, table := range []string{"borrowed_books", "book_tags", "book_genres", "reviews", "authors_books"} {
+			mock.ExpectExec(sqlPattern("DELETE FROM " + table + " WHERE book_id = ?")).WithArgs(5).WillReturnResult(sqlmock.NewResult(0, 1))
+		}
+		mock.ExpectQuery(sqlPattern("WHERE author_id = ? AND id != ?")).WithArgs(2, 5, 2).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+		mock.ExpectExec(sqlPattern("DELETE FROM books")).WithArgs(5).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(sqlPattern("DELETE FROM authors")).WithArgs(2).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+		expectAudit(mock, "delete", "book", 5)
+
+		rec := serveRoute(DeleteBook(db, newTestPhotoConfig(t)), http.MethodDelete, "/books/{id}", "/books/5", nil)
+		if rec.Code != http.StatusOK {
+			t.Errorf("status %d, want 200: %s", rec.Code, rec.Body)
+		}
+	})
+
+	t.Run("last main-authored book of a co-author", func(t *testing.T) {
+		db, mock := newMockDB(t)
+		mock.ExpectBegin()
+		mock.ExpectQuery(sqlPattern("SELECT author_id")).WithArgs(5).WillReturnRows(sqlmock.NewRows([]string{"author_id"}).AddRow(2))
+		mock.ExpectQuery(sqlPattern("FROM borrowed_books WHERE book_id = ? AND return_date IS NULL")).WithArgs(5).
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+		for _, table := range []string{"borrowed_books", "book_tags", "book_genres", "reviews", "authors_books"} {
+			mock.ExpectExec(sqlPattern("DELETE FROM " + table + " WHERE book_id = ?")).WithArgs(5).WillReturnResult(sqlmock.NewResult(0, 1))
+		}
+		// The author still co-authors a book, so neither they nor their links are deleted
+		mock.ExpectQuery(sqlPattern("(SELECT COUNT(*) FROM authors_books WHERE author_id = ?)")).WithArgs(2, 5, 2).
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+		mock.ExpectExec(sqlPattern("DELETE FROM books")).WithArgs(5).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+		expectAudit(mock, "delete", "book", 5)
+
+		rec := serveRoute(DeleteBook(db, newTestPhotoConfig(t)), http.MethodDelete, "/books/{id}", "/books/5", nil)
+		if rec.Code != http.StatusOK {
+			t.Errorf("status %d, want 200: %s", rec.Code, rec.Body)
+		}
+	})
+}
+
+func TestDeleteAuthorCascadeForced(t *testing.T) {
+	db, mock := newMockDB(t)
+	mock.ExpectBegin()
+	mock.ExpectQuery(sqlPattern("bb.return_date IS NULL")).WithArgs(3).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	mock.ExpectQuery(sqlPattern("SELECT id FROM books WHERE author_id = ?")).WithArgs(3).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(10))
+	for _, table := range []string{"borrowed_books", "book_tags", "book_genres", "reviews"} {
+		mock.ExpectExec(sqlPattern("DELETE FROM " + table + " WHERE book_id IN")).WithArgs(3).WillReturnResult(sqlmock.NewResult(0, 1))
+	}
+	mock.ExpectExec(sqlPattern("DELETE FROM authors_books")).WithArgs(3, 3).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(sqlPattern("DELETE FROM books WHERE author_id = ?")).WithArgs(3).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(sqlPattern("DELETE FROM authors WHERE id = ?")).WithArgs(3).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	expectAudit(mock, "delete", "author", 3)
+
+	rec := serveRoute(DeleteAuthor(db, newTestPhotoConfig(t)), http.MethodDelete, "/authors/{id}", "/authors/3?cascade=true&force=true", nil)
+	if rec.Code != http.StatusOK {
+		t.Errorf("status %d, want 200: %s", rec.Code, rec.Body)
+	}
+}
+
+func TestDeleteSubscriberWithActiveBorrows(t *testing.T) {
+	t.Run("blocked", func(t *testing.T) {
+		db, mock := newMockDB(t)
+		mock.ExpectBegin()
+		mock.ExpectQuery(sqlPattern("WHERE subscriber_id = ? AND return_date IS NULL")).WithArgs(4).
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(2))
+		mock.ExpectRollback()
+
+		rec := serveRoute(DeleteSubscriber(db), http.MethodDelete, "/subscribers/{id}", "/subscribers/4", nil)
+		if rec.Code != http.StatusConflict {
+			t.Errorf("status %d, want 409", rec.Code)
+		}
+		if !strings.Contains(rec.Body.String(), "2 borrowed book(s)") {
+			t.Errorf("body %q doesn't give the number of books", rec.Body)
+		}
+	})
+
+	t.Run("override", func(t *testing.T) {
+		db, mock := newMockDB(t)
+		mock.ExpectBegin()
+		mock.ExpectQuery(sqlPattern("WHERE subscriber_id = ? AND return_date IS NULL")).WithArgs(4).
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(2))
+		mock.ExpectExec(sqlPattern("SET is_borrowed = FALSE")).WithArgs(4).WillReturnResult(sqlmock.NewResult(0, 2))
+		mock.ExpectExec(sqlPattern("UPDATE borrowed_books SET return_date = NOW()")).WithArgs(4).WillReturnResult(sqlmock.NewResult(0, 2))
+		mock.ExpectExec(sqlPattern("UPDATE borrowed_books SET subscriber_id = NULL WHERE subscriber_id = ?")).WithArgs(4).
+			WillReturnResult(sqlmock.NewResult(0, 3))
+		mock.ExpectExec(sqlPattern("DELETE FROM reviews WHERE subscriber_id = ?")).WithArgs(4).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec(sqlPattern("DELETE FROM subscribers")).WithArgs(4).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+		expectAudit(mock, "delete", "subscriber", 4)
+
+		rec := serveRoute(DeleteSubscriber(db), http.MethodDelete, "/subscribers/{id}", "/subscribers/4?force=true", nil)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status %d, want 200: %s", rec.Code, rec.Body)
+		}
+		expectMessage(t, rec, "Subscriber deleted successfully")
+	})
+}
+
+func TestDeleteSubscriberKeepsBorrowHistory(t *testing.T) {
+	db, mock := newMockDB(t)
+	mock.ExpectBegin()
+	mock.ExpectQuery(sqlPattern("WHERE subscriber_id = ? AND return_date IS NULL")).WithArgs(4).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	mock.ExpectExec(sqlPattern("UPDATE borrowed_books SET subscriber_id = NULL WHERE subscriber_id = ?")).WithArgs(4).
+		WillReturnResult(sqlmock.NewResult(0, 5))
+	mock.ExpectExec(sqlPattern("DELETE FROM reviews WHERE subscriber_id = ?")).WithArgs(4).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(sqlPattern("DELETE FROM subscribers")).WithArgs(4).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	expectAudit(mock, "delete", "subscriber", 4)
+
+	rec := serveRoute(DeleteSubscriber(db), http.MethodDelete, "/subscribers/{id}", "/subscribers/4", nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d, want 200: %s", rec.Code, rec.Body)
+	}
+	expectMessage(t, rec, "Subscriber deleted successfully")
+}
+
+func TestWriteListResponse(t *testing.T) {
+	rec := httptest.NewRecorder()
+	WriteListResponse(rec, http.StatusOK, []int{1, 2}, 42)
+
+	if got := rec.Header().Get("X-Total-Count"); got != "42" {
+		t.Errorf("X-Total-Count %q, want 42", got)
+	}
+	if rec.Code != http.StatusOK || strings.TrimSpace(rec.Body.String()) != "[1,2]" {
+		t.Errorf("got %d %q", rec.Code, rec.Body)
+	}
+}
+
+func TestGetAllSubscribersTotalCount(t *testing.T) {
+	db, mock := newMockDB(t)
+	Fixture{Page: []driver.Value{1, 1}, Total: 3}.Subscribers(mock, []Subscriber{
+		{ID: 2, Lastname: "Brown", Firstname: "Sophia", Email: "sophia@example.com"},
+	})
+
+	rec := serveRoute(GetAllSubscribers(db), http.MethodGet, "/subscribers", "/subscribers?page=2&page_size=1", nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d, want 200: %s", rec.Code, rec.Body)
+	}
+	if got := rec.Header().Get("X-Total-Count"); got != "3" {
+		t.Errorf("X-Total-Count %q, want 3", got)
+	}
+	var subscribers []Subscriber
+	decodeJSON(t, rec, &subscribers)
+	if len(subscribers) != 1 || subscribers[0].ID != 2 {
+		t.Errorf("got %+v", subscribers)
+	}
+}
+
+func TestGetMostBorrowedBooks(t *testing.T) {
+	t.Run("success", func(t *testing.T) {
+		db, mock := newMockDB(t)
+		mock.ExpectQuery(sqlPattern("ORDER BY borrow_count DESC")).WithArgs(10).
+			WillReturnRows(sqlmock.NewRows(bookColumns).
+				AddRow(3, "Nineteen Eighty-Four", 2, "", true, "", "Orwell", "George", "9780451524935", "Signet Classics", "ebook", 5).
+				AddRow(1, "Emma", 1, "", false, "", "Austen", "Jane", "", "", "", 2))
+		mock.ExpectQuery(sqlPattern("FROM book_genres")).WithArgs(3, 1).
+			WillReturnRows(sqlmock.NewRows([]string{"book_id", "id", "name"}).AddRow(3, 4, "Dystopia"))
+		mock.ExpectQuery(sqlPattern("FROM authors_books")).WithArgs(3, 1).
+			WillReturnRows(sqlmock.NewRows([]string{"book_id", "id", "firstname", "lastname"}).
+				AddRow(3, 2, "George", "Orwell").AddRow(3, 5, "Erich", "Fromm"))
+
+		rec := serveRoute(GetMostBorrowedBooks(db), http.MethodGet, "/books/popular", "/books/popular", nil)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status %d, want 200: %s", rec.Code, rec.Body)
+		}
+		var books []BookAuthorInfo
+		decodeJSON(t, rec, &books)
+		if len(books) != 2 || books[0].BookID != 3 || books[0].BorrowCount != 5 || books[1].BorrowCount != 2 {
+			t.Fatalf("got %+v", books)
+		}
+		// The books are as complete as in GET /books
+		if books[0].Publisher != "Signet Classics" || books[0].Format != "ebook" || len(books[0].Genres) != 1 {
+			t.Errorf("got %+v", books[0])
+		}
+		if len(books[0].Authors) != 2 || books[0].Authors[1].Lastname != "Fromm" {
+			t.Errorf("authors %+v", books[0].Authors)
+		}
+		if len(books[1].Authors) != 1 || books[1].Authors[0].Lastname != "Austen" {
+			t.Errorf("authors %+v", books[1].Authors)
+		}
+	})
+
+	t.Run("limit clamped", func(t *testing.T) {
+		for target, limit := range map[string]int{"/books/popular?limit=500": 50, "/books/popular?limit=0": 1} {
+			db, mock := newMockDB(t)
+			mock.ExpectQuery(sqlPattern("ORDER BY borrow_count DESC")).WithArgs(limit).WillReturnRows(sqlmock.NewRows(bookColumns))
+
+			rec := serveRoute(GetMostBorrowedBooks(db), http.MethodGet, "/books/popular", target, nil)
+			if rec.Code != http.StatusOK || strings.TrimSpace(rec.Body.String()) != "[]" {
+				t.Errorf("%s: got %d %q", target, rec.Code, rec.Body)
+			}
+		}
+	})
+
+	t.Run("database error", func(t *testing.T) {
+		db, mock := newMockDB(t)
+		mock.ExpectQuery(sqlPattern("ORDER BY borrow_count DESC")).WithArgs(10).WillReturnError(sql.ErrConnDone)
+
+		rec := serveRoute(GetMostBorrowedBooks(db), http.MethodGet, "/books/popular", "/books/popular", nil)
+		if rec.Code != http.StatusInternalServerError {
+			t.Errorf("status %d, want 500", rec.Code)
+		}
+	})
+}
+
+func TestGetAuthorByID(t *testing.T) {
+	columns := []string{"id", "lastname", "firstname", "photo"}
+
+	t.Run("found", func(t *testing.T) {
+		db, mock := newMockDB(t)
+		mock.ExpectQuery(sqlPattern("FROM authors WHERE id = ?")).WithArgs(2).
+			WillReturnRows(sqlmock.NewRows(columns).AddRow(2, "Orwell", "George", ""))
+
+		rec := serveRoute(GetAuthorByID(db, "http://library.test"), http.MethodGet, "/authors/{id}/profile", "/authors/2/profile", nil)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status %d, want 200: %s", rec.Code, rec.Body)
+		}
+		var author AuthorProfile
+		decodeJSON(t, rec, &author)
+		if want := (AuthorProfile{ID: 2, Lastname: "Orwell", Firstname: "George"}); author != want {
+			t.Errorf("got %+v, want %+v", author, want)
+		}
+	})
+
+	t.Run("with a photo", func(t *testing.T) {
+		db, mock := newMockDB(t)
+		mock.ExpectQuery(sqlPattern("FROM authors WHERE id = ?")).WithArgs(3).
+			WillReturnRows(sqlmock.NewRows(columns).AddRow(3, "Austen", "Jane", "./upload/3/fullsize.jpg"))
+
+		const baseURL = "https://library.example.com"
+		rec := serveRoute(GetAuthorByID(db, baseURL), http.MethodGet, "/authors/{id}/profile", "/authors/3/profile", nil)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status %d, want 200: %s", rec.Code, rec.Body)
+		}
+		if strings.Contains(rec.Body.String(), "./upload") {
+			t.Errorf("the response exposes the stored photo path: %s", rec.Body)
+		}
+		var author map[string]interface{}
+		decodeJSON(t, rec, &author)
+		if _, ok := author["photo"]; ok {
+			t.Errorf("the response has a photo field: %v", author)
+		}
+		photoURL, _ := author["photo_url"].(string)
+		if !strings.HasPrefix(photoURL, baseURL+"/") || photoURL != authorPhotoURL(baseURL, 3) {
+			t.Errorf("photo_url = %q, want %q", photoURL, authorPhotoURL(baseURL, 3))
+		}
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		db, mock := newMockDB(t)
+		mock.ExpectQuery(sqlPattern("FROM authors WHERE id = ?")).WithArgs(9).WillReturnRows(sqlmock.NewRows(columns))
+
+		rec := serveRoute(GetAuthorByID(db, ""), http.MethodGet, "/authors/{id}/profile", "/authors/9/profile", nil)
+		if rec.Code != http.StatusNotFound {
+			t.Errorf("status %d, want 404", rec.Code)
+		}
+	})
+
+	t.Run("invalid id", func(t *testing.T) {
+		db, _ := newMockDB(t)
+
+		rec := serveRoute(GetAuthorByID(db, ""), http.MethodGet, "/authors/{id}/profile", "/authors/x/profile", nil)
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("status %d, want 400", rec.Code)
+		}
+	})
+}
+
+func TestGetAuthorStats(t *testing.T) {
+	columns := []string{"id", "total_books", "borrowed_books", "available_books", "total_times_borrowed", "average_borrow_duration_days"}
+	tests := []struct {
+		name string
+		row  []driver.Value
+		want AuthorStats
+	}{
+		{name: "no books", row: []driver.Value{1, 0, 0, 0, 0, 0.0}, want: AuthorStats{AuthorID: 1}},
+		{name: "active borrows", row: []driver.Value{1, 3, 2, 1, 4, 5.5},
+			want: AuthorStats{AuthorID: 1, TotalBooks: 3, BorrowedBooks: 2, AvailableBooks: 1, TotalTimesBorrowed: 4, AverageBorrowDurationDays: 5.5}},
+		{name: "all returned", row: []driver.Value{1, 2, 0, 2, 6, 9.25},
+			want: AuthorStats{AuthorID: 1, TotalBooks: 2, AvailableBooks: 2, TotalTimesBorrowed: 6, AverageBorrowDurationDays: 9.25}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock := newMockDB(t)
+			mock.ExpectQuery(sqlPattern("GROUP BY a.id")).WithArgs(1).WillReturnRows(sqlmock.NewRows(columns).AddRow(tt.row...))
+
+			rec := serveRoute(GetAuthorStats(db), http.MethodGet, "/authors/{id}/stats", "/authors/1/stats", nil)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status %d, want 200: %s", rec.Code, rec.Body)
+			}
+			var stats AuthorStats
+			decodeJSON(t, rec, &stats)
+			if stats != tt.want {
+				t.Errorf("got %+v, want %+v", stats, tt.want)
+			}
+		})
+	}
+
+	t.Run("unknown author", func(t *testing.T) {
+		db, mock := newMockDB(t)
+		mock.ExpectQuery(sqlPattern("GROUP BY a.id")).WithArgs(9).WillReturnRows(sqlmock.NewRows(columns))
+
+		rec := serveRoute(GetAuthorStats(db), http.MethodGet, "/authors/{id}/stats", "/authors/9/stats", nil)
+		if rec.Code != http.StatusNotFound {
+			t.Errorf("status %d, want 404", rec.Code)
+		}
+	})
+}
+
+func TestGetSimilarBooks(t *testing.T) {
+	t.Run("excludes the source book", func(t *testing.T) {
+		db, mock := newMockDB(t)
+		mock.ExpectQuery(sqlPattern("SELECT author_id FROM books WHERE id = ?")).WithArgs(7).
+			WillReturnRows(sqlmock.NewRows([]string{"author_id"}).AddRow(2))
+		// The source book is excluded by id, and the other books of its author or sharing a tag are kept
+		mock.ExpectQuery(sqlPattern("WHERE books.id != ? AND (books.author_id = ? OR shared.shared_tags > 0)")).WithArgs(7, 7, 2, 5).
+			WillReturnRows(sqlmock.NewRows(bookColumns).AddRow(4, "Animal Farm", 2, "", false, "", "Orwell", "George", "", "", "", 0))
+
+		rec := serveRoute(GetSimilarBooks(db), http.MethodGet, "/books/{id}/similar", "/books/7/similar", nil)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status %d, want 200: %s", rec.Code, rec.Body)
+		}
+		var books []BookAuthorInfo
+		decodeJSON(t, rec, &books)
+		if len(books) != 1 || books[0].BookID != 4 {
+			t.Errorf("got %+v", books)
+		}
+	})
+
+	t.Run("limit capped", func(t *testing.T) {
+		db, mock := newMockDB(t)
+		mock.ExpectQuery(sqlPattern("SELECT author_id FROM books WHERE id = ?")).WithArgs(7).
+			WillReturnRows(sqlmock.NewRows([]string{"author_id"}).AddRow(2))
+		mock.ExpectQuery(sqlPattern("LIMIT ?")).WithArgs(7, 7, 2, 50).WillReturnRows(sqlmock.NewRows(bookColumns))
+
+		rec := serveRoute(GetSimilarBooks(db), http.MethodGet, "/books/{id}/similar", "/books/7/similar?limit=1000", nil)
+		if rec.Code != http.StatusOK || strings.TrimSpace(rec.Body.String()) != "[]" {
+			t.Errorf("got %d %q, want 200 []", rec.Code, rec.Body)
+		}
+	})
+
+	t.Run("unknown book", func(t *testing.T) {
+		db, mock := newMockDB(t)
+		mock.ExpectQuery(sqlPattern("SELECT author_id FROM books WHERE id = ?")).WithArgs(8).WillReturnRows(sqlmock.NewRows([]string{"author_id"}))
+
+		rec := serveRoute(GetSimilarBooks(db), http.MethodGet, "/books/{id}/similar", "/books/8/similar", nil)
+		if rec.Code != http.StatusNotFound {
+			t.Errorf("status %d, want 404", rec.Code)
+		}
+	})
+}
+
+func TestGetBookByISBN(t *testing.T) {
+	t.Run("found with hyphens", func(t *testing.T) {
+		db, mock := newMockDB(t)
+		mock.ExpectQuery(sqlPattern("WHERE books.isbn = ?")).WithArgs("9780451524935").
+			WillReturnRows(sqlmock.NewRows(bookColumns).AddRow(3, "Nineteen Eighty-Four", 2, "", false, "", "Orwell", "George", "9780451524935", "", "", 1))
+		mock.ExpectQuery(sqlPattern("FROM book_tags")).WithArgs(3).WillReturnRows(sqlmock.NewRows([]string{"name"}))
+
+		rec := serveRoute(GetBookByISBN(db), http.MethodGet, "/books/isbn/{isbn}", "/books/isbn/978-0-451-52493-5", nil)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status %d, want 200: %s", rec.Code, rec.Body)
+		}
+		var book BookAuthorInfo
+		decodeJSON(t, rec, &book)
+		if book.BorrowCount != 1 {
+			t.Errorf("borrow_count %d, want 1", book.BorrowCount)
+		}
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		db, mock := newMockDB(t)
+		mock.ExpectQuery(sqlPattern("WHERE books.isbn = ?")).WithArgs("9780306406157").WillReturnRows(sqlmock.NewRows(bookColumns))
+
+		rec := serveRoute(GetBookByISBN(db), http.MethodGet, "/books/isbn/{isbn}", "/books/isbn/9780306406157", nil)
+		if rec.Code != http.StatusNotFound {
+			t.Errorf("status %d, want 404", rec.Code)
+		}
+	})
+
+	t.Run("invalid checksum", func(t *testing.T) {
+		db, _ := newMockDB(t)
+
+		rec := serveRoute(GetBookByISBN(db), http.MethodGet, "/books/isbn/{isbn}", "/books/isbn/9780306406158", nil)
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("status %d, want 400", rec.Code)
+		}
+	})
+}
+
+// newTestWebhooks returns a dispatcher without webhooks. Its deliveries happen in the background, so its
+// database isn't checked.
+func newTestWebhooks(t *testing.T) *WebhookDispatcher {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock: %v", err)
+	}
+	mock.ExpectQuery(sqlPattern("FROM webhooks")).WillReturnRows(sqlmock.NewRows([]string{"id", "url", "secret"}))
+	t.Cleanup(func() { db.Close() })
+	return NewWebhookDispatcher(db)
+}
+
+func TestGetBookBorrowHistory(t *testing.T) {
+	columns := []string{"id", "firstname", "lastname", "email", "date_of_borrow", "due_date", "return_date"}
+	borrowed := time.Date(2024, 5, 2, 10, 0, 0, 0, time.UTC)
+	due := borrowed.AddDate(0, 0, loanPeriodDays)
+	returned := borrowed.AddDate(0, 0, 5)
+
+	tests := []struct {
+		name string
+		rows *sqlmock.Rows
+		want int
+	}{
+		{name: "no history", rows: sqlmock.NewRows(columns), want: 0},
+		{name: "current borrow", rows: sqlmock.NewRows(columns).AddRow(1, "Emma", "Johnson", "emma@example.com", borrowed, due, nil), want: 1},
+		{name: "past borrows", rows: sqlmock.NewRows(columns).
+			AddRow(2, "Sophia", "Brown", "sophia@example.com", returned, nil, returned).
+			AddRow(nil, "", "", "", borrowed, nil, returned).
+			AddRow(1, "Emma", "Johnson", "emma@example.com", borrowed.AddDate(0, -1, 0), due.AddDate(0, -1, 0), borrowed), want: 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock := newMockDB(t)
+			mock.ExpectQuery(sqlPattern("SELECT EXISTS(SELECT 1 FROM books WHERE id = ?)")).WithArgs(2).
+				WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+			mock.ExpectQuery(sqlPattern("LEFT JOIN subscribers")).WithArgs(2).WillReturnRows(tt.rows)
+
+			rec := serveRoute(GetBookBorrowHistory(db), http.MethodGet, "/books/{id}/borrow-history", "/books/2/borrow-history", nil)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status %d, want 200: %s", rec.Code, rec.Body)
+			}
+			var history []BorrowHistoryEntry
+			decodeJSON(t, rec, &history)
+			if len(history) != tt.want {
+				t.Fatalf("got %d entries, want %d", len(history), tt.want)
+			}
+			for _, entry := range history {
+				if entry.DateOfBorrow == nil {
+					t.Errorf("entry without date_of_borrow: %+v", entry)
+				}
+			}
+		})
+	}
+
+	t.Run("current borrow has no return date", func(t *testing.T) {
+		db, mock := newMockDB(t)
+		mock.ExpectQuery(sqlPattern("SELECT EXISTS")).WithArgs(2).WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+		mock.ExpectQuery(sqlPattern("LEFT JOIN subscribers")).WithArgs(2).
+			WillReturnRows(sqlmock.NewRows(columns).AddRow(1, "Emma", "Johnson", "emma@example.com", borrowed, due, nil))
+
+		rec := serveRoute(GetBookBorrowHistory(db), http.MethodGet, "/books/{id}/borrow-history", "/books/2/borrow-history", nil)
+		var history []map[string]interface{}
+		decodeJSON(t, rec, &history)
+		if history[0]["return_date"] != nil || history[0]["due_date"] == nil || history[0]["subscriber_id"] != float64(1) {
+			t.Errorf("got %v", history[0])
+		}
+	})
+
+	t.Run("loan without subscriber", func(t *testing.T) {
+		db, mock := newMockDB(t)
+		mock.ExpectQuery(sqlPattern("SELECT EXISTS")).WithArgs(2).WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+		mock.ExpectQuery(sqlPattern("LEFT JOIN subscribers")).WithArgs(2).
+			WillReturnRows(sqlmock.NewRows(columns).AddRow(nil, "", "", "", borrowed, nil, nil))
+
+		rec := serveRoute(GetBookBorrowHistory(db), http.MethodGet, "/books/{id}/borrow-history", "/books/2/borrow-history", nil)
+		var history []map[string]interface{}
+		decodeJSON(t, rec, &history)
+		if len(history) != 1 || history[0]["subscriber_id"] != nil {
+			t.Errorf("got %v", history)
+		}
+	})
+
+	t.Run("unknown book", func(t *testing.T) {
+		db, mock := newMockDB(t)
+		mock.ExpectQuery(sqlPattern("SELECT EXISTS")).WithArgs(9).WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+
+		rec := serveRoute(GetBookBorrowHistory(db), http.MethodGet, "/books/{id}/borrow-history", "/books/9/borrow-history", nil)
+		if rec.Code != http.StatusNotFound {
+			t.Errorf("status %d, want 404", rec.Code)
+		}
+	})
+}
+
+func TestReturnBorrowedBook(t *testing.T) {
+	body := `{"subscriber_id": 1, "book_id": 2}`
+
+	t.Run("returned", func(t *testing.T) {
+		db, mock := newMockDB(t)
+		mock.ExpectBegin()
+		mock.ExpectQuery(sqlPattern("SELECT is_borrowed FROM books WHERE id = ? FOR UPDATE")).WithArgs(2).
+			WillReturnRows(sqlmock.NewRows([]string{"is_borrowed"}).AddRow(true))
+		mock.ExpectExec(sqlPattern("AND return_date IS NULL")).WithArgs(1, 2).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(sqlPattern("UPDATE books SET is_borrowed = FALSE")).WithArgs(2).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+		expectAudit(mock, "return", "book", 2)
+
+		rec := serveRoute(ReturnBorrowedBook(db, newTestWebhooks(t)), http.MethodPost, "/book/return", "/book/return", strings.NewReader(body))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status %d, want 200: %s", rec.Code, rec.Body)
+		}
+		expectMessage(t, rec, "Book returned successfully")
+	})
+
+	t.Run("book not borrowed", func(t *testing.T) {
+		db, mock := newMockDB(t)
+		mock.ExpectBegin()
+		mock.ExpectQuery(sqlPattern("SELECT is_borrowed FROM books")).WithArgs(2).
+			WillReturnRows(sqlmock.NewRows([]string{"is_borrowed"}).AddRow(false))
+		mock.ExpectRollback()
+
+		rec := serveRoute(ReturnBorrowedBook(db, newTestWebhooks(t)), http.MethodPost, "/book/return", "/book/return", strings.NewReader(body))
+		if rec.Code != http.StatusNotFound {
+			t.Errorf("status %d, want 404", rec.Code)
+		}
+	})
+
+	t.Run("borrowed by another subscriber", func(t *testing.T) {
+		db, mock := newMockDB(t)
+		mock.ExpectBegin()
+		mock.ExpectQuery(sqlPattern("SELECT is_borrowed FROM books")).WithArgs(2).
+			WillReturnRows(sqlmock.NewRows([]string{"is_borrowed"}).AddRow(true))
+		mock.ExpectExec(sqlPattern("AND return_date IS NULL")).WithArgs(1, 2).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectRollback()
+
+		rec := serveRoute(ReturnBorrowedBook(db, newTestWebhooks(t)), http.MethodPost, "/book/return", "/book/return", strings.NewReader(body))
+		if rec.Code != http.StatusConflict {
+			t.Errorf("status %d, want 409", rec.Code)
+		}
+	})
+}
+
+func TestSetBookBorrowStatus(t *testing.T) {
+	expectBook := func(mock sqlmock.Sqlmock, rows *sqlmock.Rows) {
+		mock.ExpectBegin()
+		mock.ExpectQuery(sqlPattern("SELECT id FROM books WHERE id = ? FOR UPDATE")).WithArgs(2).WillReturnRows(rows)
+	}
+	book := func() *sqlmock.Rows { return sqlmock.NewRows([]string{"id"}).AddRow(2) }
+
+	t.Run("borrowed without a loan", func(t *testing.T) {
+		db, mock := newMockDB(t)
+		expectBook(mock, book())
+		mock.ExpectQuery(sqlPattern("SELECT EXISTS(SELECT 1 FROM borrowed_books")).WithArgs(2).WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+		mock.ExpectExec(sqlPattern("VALUES (NULL, ?, NOW())")).WithArgs(2).WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec(sqlPattern("UPDATE books SET is_borrowed = ?")).WithArgs(true, 2).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		rec := serveRoute(SetBookBorrowStatus(db), http.MethodPatch, "/books/{id}/borrow-status", "/books/2/borrow-status", strings.NewReader(`{"is_borrowed":true}`))
+		if rec.Code != http.StatusOK {
+			t.Errorf("status %d, want 200: %s", rec.Code, rec.Body)
+		}
+	})
+
+	t.Run("borrowed with an open loan", func(t *testing.T) {
+		db, mock := newMockDB(t)
+		expectBook(mock, book())
+		mock.ExpectQuery(sqlPattern("SELECT EXISTS(SELECT 1 FROM borrowed_books")).WithArgs(2).WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+		mock.ExpectExec(sqlPattern("UPDATE books SET is_borrowed = ?")).WithArgs(true, 2).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectCommit()
+
+		rec := serveRoute(SetBookBorrowStatus(db), http.MethodPatch, "/books/{id}/borrow-status", "/books/2/borrow-status", strings.NewReader(`{"is_borrowed":true}`))
+		if rec.Code != http.StatusOK {
+			t.Errorf("status %d, want 200: %s", rec.Code, rec.Body)
+		}
+	})
+
+	t.Run("available", func(t *testing.T) {
+		db, mock := newMockDB(t)
+		expectBook(mock, book())
+		mock.ExpectExec(sqlPattern("ORDER BY date_of_borrow DESC")).WithArgs(2).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(sqlPattern("UPDATE books SET is_borrowed = ?")).WithArgs(false, 2).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		rec := serveRoute(SetBookBorrowStatus(db), http.MethodPatch, "/books/{id}/borrow-status", "/books/2/borrow-status", strings.NewReader(`{"is_borrowed":false}`))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status %d, want 200: %s", rec.Code, rec.Body)
+		}
+		var response map[string]interface{}
+		decodeJSON(t, rec, &response)
+		if response["id"] != 2.0 || response["is_borrowed"] != false {
+			t.Errorf("got %v", response)
+		}
+	})
+
+	t.Run("unknown book", func(t *testing.T) {
+		db, mock := newMockDB(t)
+		expectBook(mock, sqlmock.NewRows([]string{"id"}))
+		mock.ExpectRollback()
+
+		rec := serveRoute(SetBookBorrowStatus(db), http.MethodPatch, "/books/{id}/borrow-status", "/books/2/borrow-status", strings.NewReader(`{"is_borrowed":false}`))
+		if rec.Code != http.StatusNotFound {
+			t.Errorf("status %d, want 404", rec.Code)
+		}
+	})
+
+	t.Run("missing is_borrowed", func(t *testing.T) {
+		db, _ := newMockDB(t)
+
+		rec := serveRoute(SetBookBorrowStatus(db), http.MethodPatch, "/books/{id}/borrow-status", "/books/2/borrow-status", strings.NewReader(`{}`))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("status %d, want 400", rec.Code)
+		}
+	})
+}
+
+func TestGetSubscriberActiveBorrows(t *testing.T) {
+	columns := []string{"id", "title", "firstname", "lastname", "date_of_borrow", "due_date"}
+	borrowed := time.Date(2024, 5, 2, 10, 0, 0, 0, time.UTC)
+
+	t.Run("unknown subscriber", func(t *testing.T) {
+		db, mock := newMockDB(t)
+		mock.ExpectQuery(sqlPattern("SELECT EXISTS(SELECT 1 FROM subscribers WHERE id = ?)")).WithArgs(9).
+			WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+
+		rec := serveRoute(GetSubscriberActiveBorrows(db), http.MethodGet, "/subscribers/{id}/active-borrows", "/subscribers/9/active-borrows", nil)
+		if rec.Code != http.StatusNotFound {
+			t.Errorf("status %d, want 404", rec.Code)
+		}
+	})
+
+	tests := []struct {
+		name string
+		rows *sqlmock.Rows
+		want []int
+	}{
+		{name: "no active borrows", rows: sqlmock.NewRows(columns), want: []int{}},
+		{name: "several books", rows: sqlmock.NewRows(columns).
+			AddRow(2, "Emma", "Jane", "Austen", borrowed, borrowed.AddDate(0, 0, loanPeriodDays)).
+			AddRow(3, "Nineteen Eighty-Four", "George", "Orwell", borrowed.AddDate(0, 0, 1), nil), want: []int{2, 3}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock := newMockDB(t)
+			mock.ExpectQuery(sqlPattern("SELECT EXISTS(SELECT 1 FROM subscribers WHERE id = ?)")).WithArgs(1).
+				WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+			mock.ExpectQuery(sqlPattern("borrowed_books.return_date IS NULL")).WithArgs(1).WillReturnRows(tt.rows)
+
+			rec := serveRoute(GetSubscriberActiveBorrows(db), http.MethodGet, "/subscribers/{id}/active-borrows", "/subscribers/1/active-borrows", nil)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status %d, want 200: %s", rec.Code, rec.Body)
+			}
+			if len(tt.want) == 0 && strings.TrimSpace(rec.Body.String()) != "[]" {
+				t.Errorf("body %q, want an empty array", rec.Body)
+			}
+			var borrows []ActiveBorrowInfo
+			decodeJSON(t, rec, &borrows)
+			ids := []int{}
+			for _, borrow := range borrows {
+				ids = append(ids, borrow.BookID)
+			}
+			if !reflect.DeepEqual(ids, tt.want) {
+				t.Errorf("got books %v, want %v", ids, tt.want)
+			}
+		})
+	}
+}
+
+func TestLinkBookToAuthor(t *testing.T) {
+	tests := []struct {
+		name         string
+		body         string
+		authorExists bool
+		bookExists   bool
+		inserted     int64
+		want         int
+	}{
+		{name: "new link", body: `{"book_id": 5}`, authorExists: true, bookExists: true, inserted: 1, want: http.StatusCreated},
+		{name: "existing link", body: `{"book_id": 5}`, authorExists: true, bookExists: true, inserted: 0, want: http.StatusOK},
+		{name: "unknown author", body: `{"book_id": 5}`, bookExists: true, want: http.StatusNotFound},
+		{name: "unknown book", body: `{"book_id": 5}`, authorExists: true, want: http.StatusNotFound},
+		{name: "unknown author and book", body: `{"book_id": 5}`, want: http.StatusNotFound},
+		{name: "missing book_id", body: `{}`, want: http.StatusBadRequest},
+		{name: "invalid JSON", body: `{"book_id":`, want: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock := newMockDB(t)
+			if tt.want != http.StatusBadRequest {
+				mock.ExpectQuery(sqlPattern("SELECT EXISTS(SELECT 1 FROM authors WHERE id = ?), EXISTS(SELECT 1 FROM books WHERE id = ?)")).
+					WithArgs(1, 5).WillReturnRows(sqlmock.NewRows([]string{"author", "book"}).AddRow(tt.authorExists, tt.bookExists))
+			}
+			if tt.authorExists && tt.bookExists {
+				mock.ExpectExec(sqlPattern("INSERT IGNORE INTO authors_books (author_id, book_id) VALUES (?, ?)")).
+					WithArgs(1, 5).WillReturnResult(sqlmock.NewResult(0, tt.inserted))
+			}
+
+			rec := serveRoute(LinkBookToAuthor(db), http.MethodPost, "/authors/{id}/books", "/authors/1/books", strings.NewReader(tt.body))
+			if rec.Code != tt.want {
+				t.Errorf("status %d, want %d: %s", rec.Code, tt.want, rec.Body)
+			}
+		})
+	}
+}
+
+func TestUnlinkBookFromAuthor(t *testing.T) {
+	tests := []struct {
+		name         string
+		path         string
+		mainAuthorID int // 0 when the book doesn't exist
+		deleted      int64
+		want         int
+	}{
+		{name: "co-author", path: "/authors/2/books/5", mainAuthorID: 1, deleted: 1, want: http.StatusOK},
+		{name: "not linked", path: "/authors/2/books/5", mainAuthorID: 1, deleted: 0, want: http.StatusNotFound},
+		{name: "main author", path: "/authors/1/books/5", mainAuthorID: 1, want: http.StatusConflict},
+		{name: "unknown book", path: "/authors/2/books/5", want: http.StatusNotFound},
+		{name: "invalid book ID", path: "/authors/2/books/abc", want: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock := newMockDB(t)
+			if tt.want != http.StatusBadRequest {
+				rows := sqlmock.NewRows([]string{"author_id"})
+				if tt.mainAuthorID != 0 {
+					rows.AddRow(tt.mainAuthorID)
+				}
+				mock.ExpectQuery(sqlPattern("SELECT author_id FROM books WHERE id = ?")).WithArgs(5).WillReturnRows(rows)
+			}
+			if tt.mainAuthorID != 0 && tt.want != http.StatusConflict {
+				mock.ExpectExec(sqlPattern("DELETE FROM authors_books WHERE author_id = ? AND book_id = ?")).
+					WithArgs(2, 5).WillReturnResult(sqlmock.NewResult(0, tt.deleted))
+			}
+
+			rec := serveRoute(UnlinkBookFromAuthor(db), http.MethodDelete, "/authors/{id}/books/{book_id}", tt.path, nil)
+			if rec.Code != tt.want {
+				t.Errorf("status %d, want %d: %s", rec.Code, tt.want, rec.Body)
+			}
+		})
+	}
+}
+
+func TestUpdateBookAuthors(t *testing.T) {
+	expectUpdate := func(mock sqlmock.Sqlmock, authorID int) {
+		mock.ExpectExec(sqlPattern("UPDATE books SET title = ?, author_id = ?")).
+			WithArgs("Good Omens", authorID, "", "", false, nil, "", "", 5).WillReturnResult(sqlmock.NewResult(0, 1))
+	}
+
+	t.Run("author_id keeps the co-authors", func(t *testing.T) {
+		db, mock := newMockDB(t)
+		mock.ExpectBegin()
+		mock.ExpectQuery(sqlPattern("SELECT author_id FROM books WHERE id = ? FOR UPDATE")).WithArgs(5).
+			WillReturnRows(sqlmock.NewRows([]string{"author_id"}).AddRow(1))
+		expectUpdate(mock, 2)
+		mock.ExpectQuery(sqlPattern("SELECT EXISTS(SELECT 1 FROM authors WHERE id = ?)")).WithArgs(2).
+			WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+		mock.ExpectExec(sqlPattern("DELETE FROM authors_books WHERE author_id = ? AND book_id = ?")).WithArgs(1, 5).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(sqlPattern("INSERT IGNORE INTO authors_books (author_id, book_id) VALUES (?, ?)")).WithArgs(2, 5).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+		expectAudit(mock, "update", "book", 5)
+
+		// The co-author links of the book aren't deleted: an unexpected DELETE fails the mock
+		rec := serveRoute(UpdateBook(db), http.MethodPut, "/books/{id}", "/books/5", strings.NewReader(`{"title":"Good Omens","author_id":2}`))
+		if rec.Code != http.StatusOK {
+			t.Errorf("status %d, want 200: %s", rec.Code, rec.Body)
+		}
+	})
+
+	t.Run("same author_id", func(t *testing.T) {
+		db, mock := newMockDB(t)
+		mock.ExpectBegin()
+		mock.ExpectQuery(sqlPattern("SELECT author_id FROM books WHERE id = ? FOR UPDATE")).WithArgs(5).
+			WillReturnRows(sqlmock.NewRows([]string{"author_id"}).AddRow(2))
+		expectUpdate(mock, 2)
+		mock.ExpectCommit()
+		expectAudit(mock, "update", "book", 5)
+
+		rec := serveRoute(UpdateBook(db), http.MethodPut, "/books/{id}", "/books/5", strings.NewReader(`{"title":"Good Omens","author_id":2}`))
+		if rec.Code != http.StatusOK {
+			t.Errorf("status %d, want 200: %s", rec.Code, rec.Body)
+		}
+	})
+
+	t.Run("unknown book", func(t *testing.T) {
+		db, mock := newMockDB(t)
+		mock.ExpectBegin()
+		mock.ExpectQuery(sqlPattern("SELECT author_id FROM books WHERE id = ? FOR UPDATE")).WithArgs(5).
+			WillReturnRows(sqlmock.NewRows([]string{"author_id"}))
+		mock.ExpectRollback()
+
+		rec := serveRoute(UpdateBook(db), http.MethodPut, "/books/{id}", "/books/5", strings.NewReader(`{"title":"Good Omens","author_id":2}`))
+		if rec.Code != http.StatusNotFound {
+			t.Errorf("status %d, want 404: %s", rec.Code, rec.Body)
+		}
+	})
+
+	t.Run("author_ids replaces the links", func(t *testing.T) {
+		db, mock := newMockDB(t)
+		mock.ExpectBegin()
+		expectUpdate(mock, 2)
+		mock.ExpectExec(sqlPattern("DELETE FROM authors_books WHERE book_id = ?")).WithArgs(5).WillReturnResult(sqlmock.NewResult(0, 2))
+		for _, authorID := range []int{2, 3} {
+			mock.ExpectQuery(sqlPattern("SELECT EXISTS(SELECT 1 FROM authors WHERE id = ?)")).WithArgs(authorID).
+				WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+			mock.ExpectExec(sqlPattern("INSERT INTO authors_books (author_id, book_id)")).WithArgs(authorID, 5).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+		}
+		mock.ExpectCommit()
+		expectAudit(mock, "update", "book", 5)
+
+		rec := serveRoute(UpdateBook(db), http.MethodPut, "/books/{id}", "/books/5", strings.NewReader(`{"title":"Good Omens","author_ids":[2,3]}`))
+		if rec.Code != http.StatusOK {
+			t.Errorf("status %d, want 200: %s", rec.Code, rec.Body)
+		}
+	})
+}
+
+func TestGetAllBooksByAuthor(t *testing.T) {
+	db, mock := newMockDB(t)
+	// The books of an author include the ones they co-author
+	Fixture{
+		Where: "WHERE (books.author_id = ? OR books.id IN (SELECT authors_books.book_id FROM authors_books WHERE authors_books.author_id = ?))",
+		Args:  []driver.Value{3, 3},
+	}.Books(mock, []BookAuthorInfo{
+		{BookID: 5, BookTitle: "Good Omens", AuthorID: 2, Authors: []AuthorInfo{{ID: 2, Firstname: "Terry", Lastname: "Pratchett"}}},
+	})
+
+	rec := serveRoute(GetAllBooks(db), http.MethodGet, "/books", "/books?author_id=3", nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d, want 200: %s", rec.Code, rec.Body)
+	}
+	var books []BookAuthorInfo
+	decodeJSON(t, rec, &books)
+	if len(books) != 1 || books[0].BookID != 5 {
+		t.Errorf("got %+v, want the co-authored book", books)
+	}
+}
+
+func TestParseSort(t *testing.T) {
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"", "ORDER BY books.id ASC"},
+		{"sort=id&order=desc", "ORDER BY books.id DESC"},
+		{"sort=title", "ORDER BY books.title ASC, books.id"},
+		{"sort=title&order=asc", "ORDER BY books.title ASC, books.id"},
+		{"sort=title&order=DESC", "ORDER BY books.title DESC, books.id"},
+		{"sort=author&order=desc", "ORDER BY authors.Lastname DESC, books.id"},
+		{"sort=borrow_count&order=desc", "ORDER BY " + allowedBookSortColumns["borrow_count"] + " DESC, books.id"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/books?"+tt.query, nil)
+		got, err := ParseSort(r, allowedBookSortColumns, "id", "books.id")
+		if err != nil || got != tt.want {
+			t.Errorf("%q: got %q, %v, want %q", tt.query, got, err, tt.want)
+		}
+	}
+
+	for _, query := range []string{"sort=hack_column", "sort=title;DROP TABLE books", "sort=title&order=sideways"} {
+		r := httptest.NewRequest(http.MethodGet, "/books?"+url.PathEscape(query), nil)
+		if got, err := ParseSort(r, allowedBookSortColumns, "id", "books.id"); err == nil {
+			t.Errorf("%q: got %q, want an error", query, got)
+		}
+	}
+}
+
+func TestGetAuthorsSort(t *testing.T) {
+	for sort, column := range allowedAuthorSortColumns {
+		for _, order := range []string{"asc", "desc"} {
+			t.Run(sort+" "+order, func(t *testing.T) {
+				db, mock := newMockDB(t)
+				mock.ExpectQuery(sqlPattern("SELECT COUNT(*) FROM authors")).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+				mock.ExpectQuery(sqlPattern("ORDER BY " + column + " " + strings.ToUpper(order))).
+					WillReturnRows(sqlmock.NewRows(authorColumns))
+
+				rec := serveRoute(GetAuthors(db), http.MethodGet, "/authors", "/authors?sort="+sort+"&order="+order, nil)
+				if rec.Code != http.StatusOK {
+					t.Errorf("status %d, want 200: %s", rec.Code, rec.Body)
+				}
+			})
+		}
+	}
+}
+
+func TestGetAuthorsAndBooks(t *testing.T) {
+	columns := []string{"author_firstname", "author_lastname", "book_title", "book_photo"}
+	tests := []struct {
+		name    string
+		query   string
+		where   string
+		orderBy string
+		args    []driver.Value
+	}{
+		{"unsorted", "", "WHERE 1 = 1", "ORDER BY ab.id ASC", nil},
+		{"by book", "?sort=book&order=desc", "WHERE 1 = 1", "ORDER BY b.title DESC, ab.id", nil},
+		{"books of an author", "?author_id=2&sort=book", "WHERE 1 = 1 AND ab.author_id = ?", "ORDER BY b.title ASC, ab.id", []driver.Value{2}},
+		{"authors of a book", "?book_id=3&sort=author", "WHERE 1 = 1 AND ab.book_id = ?", "ORDER BY a.Lastname ASC, ab.id", []driver.Value{3}},
+		{"both", "?author_id=2&book_id=3", "WHERE 1 = 1 AND ab.author_id = ? AND ab.book_id = ?", "ORDER BY ab.id ASC", []driver.Value{2, 3}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock := newMockDB(t)
+			mock.ExpectQuery(sqlPattern(tt.where) + `\s+` + sqlPattern(tt.orderBy) + `\s*$`).WithArgs(tt.args...).
+				WillReturnRows(sqlmock.NewRows(columns).AddRow("George", "Orwell", "Nineteen Eighty-Four", "")).
+				RowsWillBeClosed()
+
+			rec := serveRoute(GetAuthorsAndBooks(db), http.MethodGet, "/authorsbooks", "/authorsbooks"+tt.query, nil)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status %d, want 200: %s", rec.Code, rec.Body)
+			}
+			var pairs []AuthorBook
+			decodeJSON(t, rec, &pairs)
+			if len(pairs) != 1 || pairs[0].BookTitle != "Nineteen Eighty-Four" {
+				t.Errorf("got %+v", pairs)
+			}
+		})
+	}
+
+	// An empty result is an empty JSON array, with the headers a cached response has
+	t.Run("empty", func(t *testing.T) {
+		db, mock := newMockDB(t)
+		mock.ExpectQuery(sqlPattern("FROM authors_books ab")).WillReturnRows(sqlmock.NewRows(columns))
+
+		rec := serveRoute(GetAuthorsAndBooks(db), http.MethodGet, "/authorsbooks", "/authorsbooks", nil)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status %d, want 200: %s", rec.Code, rec.Body)
+		}
+		if got := rec.Header().Get("Content-Type"); got != "application/json" {
+			t.Errorf("Content-Type %q, want application/json", got)
+		}
+		if rec.Header().Get("ETag") == "" {
+			t.Error("no ETag")
+		}
+		if got := strings.TrimSpace(rec.Body.String()); got != "[]" {
+			t.Errorf("body %q, want an empty array", got)
+		}
+	})
+
+	// Reading the rows to the end closes them, returning early on a scan error relies on the deferred Close
+	t.Run("rows closed on a scan error", func(t *testing.T) {
+		db, mock := newMockDB(t)
+		mock.ExpectQuery(sqlPattern("FROM authors_books ab")).
+			WillReturnRows(sqlmock.NewRows(columns).AddRow("George", "Orwell", "Nineteen Eighty-Four", nil).
+				AddRow("Jane", "Austen", "Emma", "")).
+			RowsWillBeClosed()
+
+		rec := serveRoute(GetAuthorsAndBooks(db), http.MethodGet, "/authorsbooks", "/authorsbooks", nil)
+		if rec.Code != http.StatusInternalServerError {
+			t.Errorf("status %d, want 500", rec.Code)
+		}
+	})
+
+	for _, query := range []string{"?sort=title", "?order=up", "?author_id=two", "?book_id=3x"} {
+		t.Run("invalid "+query, func(t *testing.T) {
+			rec := serveRoute(GetAuthorsAndBooks(nil), http.MethodGet, "/authorsbooks", "/authorsbooks"+query, nil)
+			if rec.Code != http.StatusBadRequest {
+				t.Errorf("status %d, want 400", rec.Code)
+			}
+		})
+	}
+}
+
+func TestGetAuthorsBookCount(t *testing.T) {
+	db, mock := newMockDB(t)
+	want := []AuthorWithCount{
+		{Author: Author{ID: 1, Lastname: "Orwell", Firstname: "George"}, BookCount: 3},
+		{Author: Author{ID: 2, Lastname: "Austen", Firstname: "Jane"}, BookCount: 0},
+	}
+	FixtureAuthors(mock, want)
+
+	rec := serveRoute(GetAuthors(db), http.MethodGet, "/authors", "/authors", nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d, want 200: %s", rec.Code, rec.Body)
+	}
+	var authors []AuthorWithCount
+	decodeJSON(t, rec, &authors)
+	if !reflect.DeepEqual(authors, want) {
+		t.Errorf("got %+v, want %+v", authors, want)
+	}
+}
+
+func TestGetAuthorsHasBooks(t *testing.T) {
+	tests := []struct {
+		query string
+		where string
+	}{
+		{query: "has_books=true", where: "WHERE " + authorHasBooks},
+		{query: "has_books=false", where: "WHERE NOT " + authorHasBooks},
+		{query: "no_books=true", where: "WHERE NOT " + authorHasBooks},
+		{query: "no_books=true&has_books=false", where: "WHERE NOT " + authorHasBooks},
+		{query: "no_books=false", where: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			db, mock := newMockDB(t)
+			Fixture{Where: tt.where}.Authors(mock, nil)
+
+			rec := serveRoute(GetAuthors(db), http.MethodGet, "/authors", "/authors?"+tt.query, nil)
+			if rec.Code != http.StatusOK {
+				t.Errorf("status %d, want 200: %s", rec.Code, rec.Body)
+			}
+		})
+	}
+
+	// The authors without books are selected by the list query as well, not only counted
+	t.Run("no_books list", func(t *testing.T) {
+		db, mock := newMockDB(t)
+		mock.ExpectQuery(sqlPattern("SELECT COUNT(*) FROM authors WHERE NOT " + authorHasBooks)).
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+		mock.ExpectQuery(sqlPattern(") written ON written.author_id = authors.id") + `\s+` + sqlPattern("WHERE NOT "+authorHasBooks)).
+			WillReturnRows(sqlmock.NewRows(authorColumns).AddRow(4, "Bronte", "Anne", "", 0))
+
+		rec := serveRoute(GetAuthors(db), http.MethodGet, "/authors", "/authors?no_books=true", nil)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status %d, want 200: %s", rec.Code, rec.Body)
+		}
+		var authors []AuthorWithCount
+		decodeJSON(t, rec, &authors)
+		if len(authors) != 1 || authors[0].ID != 4 || authors[0].BookCount != 0 {
+			t.Errorf("got %+v", authors)
+		}
+	})
+
+	t.Run("no_books without orphans", func(t *testing.T) {
+		db, mock := newMockDB(t)
+		Fixture{Where: "WHERE NOT " + authorHasBooks}.Authors(mock, nil)
+
+		rec := serveRoute(GetAuthors(db), http.MethodGet, "/authors", "/authors?no_books=true", nil)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status %d, want 200: %s", rec.Code, rec.Body)
+		}
+		if got := strings.TrimSpace(rec.Body.String()); got != "[]" {
+			t.Errorf("body %q, want an empty array", got)
+		}
+	})
+
+	for _, query := range []string{"has_books=maybe", "no_books=maybe", "no_books=true&has_books=true"} {
+		t.Run("invalid "+query, func(t *testing.T) {
+			db, _ := newMockDB(t)
+
+			rec := serveRoute(GetAuthors(db), http.MethodGet, "/authors", "/authors?"+query, nil)
+			if rec.Code != http.StatusBadRequest {
+				t.Errorf("status %d, want 400", rec.Code)
+			}
+		})
+	}
+}
+
+func TestInvalidSortColumn(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		pattern string
+		target  string
+	}{
+		{"books", GetAllBooks(nil), "/books", "/books?sort=publication_year"},
+		{"authors", GetAuthors(nil), "/authors", "/authors?sort=title"},
+		{"order", GetAuthors(nil), "/authors", "/authors?sort=lastname&order=up"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serveRoute(tt.handler, http.MethodGet, tt.pattern, tt.target, nil)
+			if rec.Code != http.StatusBadRequest {
+				t.Errorf("status %d, want 400", rec.Code)
+			}
+		})
+	}
+}
+
+func TestSearchSubscribers(t *testing.T) {
+	columns := []string{"id", "lastname", "firstname", "email", "phone", "membership_expiry"}
+	pattern := "%john%"
+
+	t.Run("missing query", func(t *testing.T) {
+		rec := serveRoute(SearchSubscribers(nil), http.MethodGet, "/subscribers/search", "/subscribers/search?query=%20", nil)
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("status %d, want 400", rec.Code)
+		}
+	})
+
+	t.Run("database error", func(t *testing.T) {
+		db, mock := newMockDB(t)
+		mock.ExpectQuery(sqlPattern("SELECT COUNT(*) FROM subscribers WHERE lastname LIKE ? OR firstname LIKE ? OR email LIKE ?")).
+			WithArgs(pattern, pattern, pattern).WillReturnError(errors.New("connection refused"))
+
+		rec := serveRoute(SearchSubscribers(db), http.MethodGet, "/subscribers/search", "/subscribers/search?query=john", nil)
+		if rec.Code != http.StatusInternalServerError {
+			t.Errorf("status %d, want 500", rec.Code)
+		}
+	})
+
+	t.Run("scan error", func(t *testing.T) {
+		db, mock := newMockDB(t)
+		mock.ExpectQuery(sqlPattern("SELECT COUNT(*) FROM subscribers")).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+		mock.ExpectQuery(sqlPattern("FROM subscribers WHERE lastname LIKE ?")).WithArgs(pattern, pattern, pattern).
+			WillReturnRows(sqlmock.NewRows(columns).AddRow("not a number", "Johnson", "Emma", "emma@example.com", "", nil))
+
+		rec := serveRoute(SearchSubscribers(db), http.MethodGet, "/subscribers/search", "/subscribers/search?query=john", nil)
+		if rec.Code != http.StatusInternalServerError {
+			t.Errorf("status %d, want 500", rec.Code)
+		}
+	})
+
+	t.Run("found", func(t *testing.T) {
+		db, mock := newMockDB(t)
+		mock.ExpectQuery(sqlPattern("SELECT COUNT(*) FROM subscribers")).WithArgs(pattern, pattern, pattern).
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(2))
+		mock.ExpectQuery(sqlPattern("FROM subscribers WHERE lastname LIKE ? OR firstname LIKE ? OR email LIKE ? ORDER BY id")).
+			WithArgs(pattern, pattern, pattern).
+			WillReturnRows(sqlmock.NewRows(columns).
+				AddRow(1, "Johnson", "Emma", "emma@example.com", "+40700000001", nil).
+				AddRow(4, "Smith", "John", "john.smith@example.com", "", "2025-01-31"))
+
+		rec := serveRoute(SearchSubscribers(db), http.MethodGet, "/subscribers/search", "/subscribers/search?query=john", nil)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status %d, want 200: %s", rec.Code, rec.Body)
+		}
+		if got := rec.Header().Get("X-Total-Count"); got != "2" {
+			t.Errorf("X-Total-Count %q, want 2", got)
+		}
+		var subscribers []Subscriber
+		decodeJSON(t, rec, &subscribers)
+		if len(subscribers) != 2 || subscribers[1].Firstname != "John" || subscribers[1].MembershipExpiry == nil {
+			t.Errorf("got %+v", subscribers)
+		}
+	})
+
+	for _, form := range routeForms {
+		t.Run("routed before the subscriber ID, "+form.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			newTestRouter(t, nil).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, form.prefix+"/subscribers/search", nil))
+			if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "Query parameter is missing") {
+				t.Errorf("got %d %q, want the missing query error of the search", rec.Code, rec.Body)
+			}
+		})
+	}
+}
+
+func TestGetBookAvailability(t *testing.T) {
+	tests := []struct {
+		name string
+		rows *sqlmock.Rows
+		want int
+		body map[string]interface{}
+	}{
+		{name: "available", rows: sqlmock.NewRows([]string{"is_borrowed"}).AddRow(false), want: http.StatusOK,
+			body: map[string]interface{}{"book_id": float64(5), "is_borrowed": false, "available": true}},
+		{name: "borrowed", rows: sqlmock.NewRows([]string{"is_borrowed"}).AddRow(true), want: http.StatusOK,
+			body: map[string]interface{}{"book_id": float64(5), "is_borrowed": true, "available": false}},
+		{name: "not found", rows: sqlmock.NewRows([]string{"is_borrowed"}), want: http.StatusNotFound,
+			body: map[string]interface{}{"available": false, "reason": "not found"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock := newMockDB(t)
+			mock.ExpectQuery("^" + sqlPattern("SELECT is_borrowed FROM books WHERE id = ?") + "$").WithArgs(5).WillReturnRows(tt.rows)
+
+			rec := serveRoute(GetBookAvailability(db), http.MethodGet, "/books/{id}/availability", "/books/5/availability", nil)
+			if rec.Code != tt.want {
+				t.Fatalf("status %d, want %d: %s", rec.Code, tt.want, rec.Body)
+			}
+			var body map[string]interface{}
+			decodeJSON(t, rec, &body)
+			if !reflect.DeepEqual(body, tt.body) {
+				t.Errorf("got %v, want %v", body, tt.body)
+			}
+			if tt.want == http.StatusOK && rec.Header().Get("Cache-Control") != "no-store" {
+				t.Errorf("Cache-Control %q, want no-store", rec.Header().Get("Cache-Control"))
+			}
+		})
+	}
+}
+
+func TestGetAvailableBooks(t *testing.T) {
+	tests := []struct {
+		name     string
+		query    string
+		total    int
+		ids      []int
+		pageArgs []driver.Value
+	}{
+		{name: "empty", total: 0, ids: nil},
+		{name: "single page", query: "?page=1&page_size=20", total: 3, ids: []int{1, 4, 5}, pageArgs: []driver.Value{20, 0}},
+		{name: "second page", query: "?page=2&page_size=2&sort=title&order=desc", total: 3, ids: []int{1}, pageArgs: []driver.Value{2, 2}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock := newMockDB(t)
+			var books []BookAuthorInfo
+			for _, id := range tt.ids {
+				books = append(books, BookAuthorInfo{BookID: id, BookTitle: fmt.Sprintf("Book %d", id), AuthorID: 1})
+			}
+			Fixture{Where: "WHERE books.is_borrowed = ?", Args: []driver.Value{false}, Page: tt.pageArgs, Total: tt.total}.Books(mock, books)
+
+			rec := serveRoute(GetAvailableBooks(db), http.MethodGet, "/books/available", "/books/available"+tt.query, nil)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status %d, want 200: %s", rec.Code, rec.Body)
+			}
+			if got := rec.Header().Get("X-Total-Count"); got != strconv.Itoa(tt.total) {
+				t.Errorf("X-Total-Count %q, want %d", got, tt.total)
+			}
+			if len(tt.ids) == 0 && strings.TrimSpace(rec.Body.String()) != "[]" {
+				t.Errorf("body %q, want an empty array", rec.Body)
+			}
+			var listed []BookAuthorInfo
+			decodeJSON(t, rec, &listed)
+			if len(listed) != len(tt.ids) {
+				t.Errorf("got %d books, want %d", len(listed), len(tt.ids))
+			}
+		})
+	}
+}
+
+func TestAvailableBooksRoutedBeforeBookID(t *testing.T) {
+	for _, form := range routeForms {
+		t.Run(form.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			newTestRouter(t, nil).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, form.prefix+"/books/available?page=0", nil))
+			if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "invalid page parameter") {
+				t.Errorf("got %d %q, want the pagination error of the available books", rec.Code, rec.Body)
+			}
+		})
+	}
+}
+
+func TestGetBooksByAuthorName(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		args  []driver.Value
+	}{
+		{name: "both names", query: "firstname=George&lastname=Orwell", args: []driver.Value{"%George%", "%Orwell%"}},
+		{name: "firstname only", query: "firstname=George", args: []driver.Value{"%George%", "%%"}},
+		{name: "lastname only", query: "lastname=%20Orwell%20", args: []driver.Value{"%%", "%Orwell%"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock := newMockDB(t)
+			mock.ExpectQuery(sqlPattern("WHERE authors.Firstname LIKE ? AND authors.Lastname LIKE ?")).WithArgs(tt.args...).
+				WillReturnRows(bookRows(1, 2))
+
+			rec := serveRoute(GetBooksByAuthorName(db), http.MethodGet, "/books/by-author", "/books/by-author?"+tt.query, nil)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status %d, want 200: %s", rec.Code, rec.Body)
+			}
+			var books []BookAuthorInfo
+			decodeJSON(t, rec, &books)
+			if len(books) != 2 {
+				t.Errorf("got %d books, want 2", len(books))
+			}
+		})
+	}
+
+	t.Run("no names", func(t *testing.T) {
+		db, _ := newMockDB(t)
+
+		rec := serveRoute(GetBooksByAuthorName(db), http.MethodGet, "/books/by-author", "/books/by-author?firstname=", nil)
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("status %d, want 400", rec.Code)
+		}
+	})
+}
+
+func TestBookAuthors(t *testing.T) {
+	t.Run("add with several authors", func(t *testing.T) {
+		db, mock := newMockDB(t)
+		mock.ExpectBegin()
+		// The first author stays the main author_id of the book
+		mock.ExpectExec(sqlPattern("INSERT INTO books")).WithArgs("Good Omens", 5, "", false, "", nil, "", "").WillReturnResult(sqlmock.NewResult(9, 1))
+		expectBookLinks(mock, 9, 5, 6)
+		mock.ExpectCommit()
+		expectAudit(mock, "create", "book", 9)
+
+		rec := serveRoute(AddBook(db, newTestPhotoConfig(t)), http.MethodPost, "/books/new", "/books/new",
+			strings.NewReader(`{"title":"Good Omens","author_ids":[5,6,5]}`))
+		if rec.Code != http.StatusCreated {
+			t.Errorf("status %d, want 201: %s", rec.Code, rec.Body)
+		}
+	})
+
+	t.Run("unknown author", func(t *testing.T) {
+		db, mock := newMockDB(t)
+		mock.ExpectBegin()
+		mock.ExpectExec(sqlPattern("INSERT INTO books")).WillReturnResult(sqlmock.NewResult(9, 1))
+		mock.ExpectExec(sqlPattern("DELETE FROM authors_books")).WithArgs(9).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery(sqlPattern("SELECT EXISTS")).WithArgs(5).WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+		mock.ExpectRollback()
+
+		rec := serveRoute(AddBook(db, newTestPhotoConfig(t)), http.MethodPost, "/books/new", "/books/new",
+			strings.NewReader(`{"title":"Good Omens","author_ids":[5]}`))
+		if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "unknown author: 5") {
+			t.Errorf("got %d %q, want 400 about author 5", rec.Code, rec.Body)
+		}
+	})
+
+	t.Run("no authors", func(t *testing.T) {
+		db, _ := newMockDB(t)
+
+		rec := serveRoute(AddBook(db, newTestPhotoConfig(t)), http.MethodPost, "/books/new", "/books/new",
+			strings.NewReader(`{"title":"Good Omens","author_ids":[]}`))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("status %d, want 400", rec.Code)
+		}
+	})
+
+	t.Run("listed with all of them", func(t *testing.T) {
+		db, mock := newMockDB(t)
+		mock.ExpectQuery(sqlPattern("SELECT COUNT(*) FROM books")).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(2))
+		mock.ExpectQuery(sqlPattern("AS borrow_count")).WillReturnRows(bookRows(1, 2))
+		mock.ExpectQuery(sqlPattern("FROM book_genres")).WillReturnRows(sqlmock.NewRows([]string{"book_id", "id", "name"}))
+		mock.ExpectQuery(sqlPattern("FROM authors_books")).WithArgs(1, 2).WillReturnRows(sqlmock.NewRows([]string{"book_id", "id", "firstname", "lastname"}).
+			AddRow(1, 5, "Terry", "Pratchett").AddRow(1, 6, "Neil", "Gaiman"))
+
+		rec := serveRoute(GetAllBooks(db), http.MethodGet, "/books", "/books", nil)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status %d, want 200: %s", rec.Code, rec.Body)
+		}
+		var books []BookAuthorInfo
+		decodeJSON(t, rec, &books)
+		want := [][]AuthorInfo{
+			{{ID: 5, Firstname: "Terry", Lastname: "Pratchett"}, {ID: 6, Firstname: "Neil", Lastname: "Gaiman"}},
+			// A book without links keeps its main author
+			{{ID: 1, Firstname: "Jane", Lastname: "Austen"}},
+		}
+		if len(books) != 2 || !reflect.DeepEqual(books[0].Authors, want[0]) || !reflect.DeepEqual(books[1].Authors, want[1]) {
+			t.Errorf("got %+v", books)
+		}
+	})
+}
+
+func TestBorrowCount(t *testing.T) {
+	t.Run("book", func(t *testing.T) {
+		db, mock := newMockDB(t)
+		mock.ExpectQuery(sqlPattern("AS borrow_count")).WithArgs(3).
+			WillReturnRows(sqlmock.NewRows([]string{"book_title", "author_id", "book_photo", "is_borrowed", "book_id", "book_details",
+				"author_lastname", "author_firstname", "isbn", "publisher", "format", "borrow_count"}).
+				AddRow("Nineteen Eighty-Four", 2, "", true, 3, "", "Orwell", "George", "", "", "ebook", 7))
+		mock.ExpectQuery(sqlPattern("FROM book_tags")).WithArgs(3).WillReturnRows(sqlmock.NewRows([]string{"name"}))
+		mock.ExpectQuery(sqlPattern("FROM book_genres")).WillReturnRows(sqlmock.NewRows([]string{"book_id", "id", "name"}))
+		mock.ExpectQuery(sqlPattern("FROM authors_books")).WillReturnRows(sqlmock.NewRows([]string{"book_id", "id", "firstname", "lastname"}))
+		mock.ExpectQuery(sqlPattern("FROM reviews WHERE book_id = ?")).WithArgs(3).
+			WillReturnRows(sqlmock.NewRows([]string{"count", "avg"}).AddRow(0, nil))
+
+		rec := serveRoute(GetBookByID(db), http.MethodGet, "/books/{id}", "/books/3", nil)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status %d, want 200: %s", rec.Code, rec.Body)
+		}
+		var book map[string]interface{}
+		decodeJSON(t, rec, &book)
+		if book["borrow_count"] != float64(7) {
+			t.Errorf("borrow_count %v, want 7", book["borrow_count"])
+		}
+	})
+
+	t.Run("book list", func(t *testing.T) {
+		db, mock := newMockDB(t)
+		FixtureBooks(mock, []BookAuthorInfo{
+			{BookID: 1, BookTitle: "Pride and Prejudice", AuthorID: 1, Authors: []AuthorInfo{{ID: 1, Firstname: "Jane", Lastname: "Austen"}}},
+			{BookID: 3, BookTitle: "Nineteen Eighty-Four", AuthorID: 2, IsBorrowed: true, BorrowCount: 7,
+				Authors: []AuthorInfo{{ID: 2, Firstname: "George", Lastname: "Orwell"}}},
+		})
+
+		rec := serveRoute(GetAllBooks(db), http.MethodGet, "/books", "/books", nil)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status %d, want 200: %s", rec.Code, rec.Body)
+		}
+		// A book never borrowed still has its count of 0
+		var books []map[string]interface{}
+		decodeJSON(t, rec, &books)
+		if len(books) != 2 || books[0]["borrow_count"] != float64(0) || books[1]["borrow_count"] != float64(7) {
+			t.Errorf("got %v", books)
+		}
+	})
+}
+
+func TestBuildServer(t *testing.T) {
+	handler := http.NewServeMux()
+	server := buildServer(":8443", handler)
+
+	if server.Addr != ":8443" {
+		t.Errorf("Addr %q, want :8443", server.Addr)
+	}
+	if server.Handler != handler {
+		t.Error("the handler isn't set")
+	}
+	if server.ReadHeaderTimeout <= 0 || server.ReadTimeout <= 0 || server.IdleTimeout <= 0 {
+		t.Errorf("timeouts %v, %v, %v, want them all set", server.ReadHeaderTimeout, server.ReadTimeout, server.IdleTimeout)
+	}
+}
+
+func TestRespondWithJSON(t *testing.T) {
+	rec := httptest.NewRecorder()
+	RespondWithJSON(rec, http.StatusCreated, map[string]int{"id": 4})
+
+	if rec.Code != http.StatusCreated {
+		t.Errorf("status %d, want 201", rec.Code)
+	}
+	if got := rec.Header().Get("Content-Type"); got != "application/json" {
+		t.Errorf("Content-Type %q, want application/json", got)
+	}
+	if got := rec.Body.String(); got != "{\"id\":4}\n" {
+		t.Errorf("body %q", got)
+	}
+}
+
+func TestGetSubscribersByBookID(t *testing.T) {
+	columns := []string{"id", "lastname", "firstname", "email", "phone", "membership_expiry"}
+	tests := []struct {
+		name string
+		rows *sqlmock.Rows
+		want string
+	}{
+		{name: "never borrowed", rows: sqlmock.NewRows(columns), want: "[]"},
+		{name: "borrowed", rows: sqlmock.NewRows(columns).AddRow(1, "Johnson", "Emma", "emma@example.com", "", nil),
+			want: `[{"id":1,"lastname":"Johnson","firstname":"Emma","email":"emma@example.com"}]`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock := newMockDB(t)
+			mock.ExpectQuery(sqlPattern("WHERE bb.book_id = ?")).WithArgs("3").WillReturnRows(tt.rows)
+
+			rec := serveRoute(GetSubscribersByBookID(db), http.MethodGet, "/books/{id}/subscribers", "/books/3/subscribers", nil)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status %d, want 200: %s", rec.Code, rec.Body)
+			}
+			if got := rec.Header().Get("Content-Type"); got != "application/json" {
+				t.Errorf("Content-Type %q, want application/json", got)
+			}
+			if got := strings.TrimSpace(rec.Body.String()); got != tt.want {
+				t.Errorf("body %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+// expectBookLinks expects a new or updated book to be linked to authorIDs, without tags or genres
+func expectBookLinks(mock sqlmock.Sqlmock, bookID int, authorIDs ...int) {
+	mock.ExpectExec(sqlPattern("DELETE FROM authors_books WHERE book_id = ?")).WithArgs(bookID).WillReturnResult(sqlmock.NewResult(0, 0))
+	for _, authorID := range authorIDs {
+		mock.ExpectQuery(sqlPattern("SELECT EXISTS(SELECT 1 FROM authors WHERE id = ?)")).WithArgs(authorID).
+			WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+		mock.ExpectExec(sqlPattern("INSERT INTO authors_books (author_id, book_id)")).WithArgs(authorID, bookID).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+	}
+	mock.ExpectExec(sqlPattern("DELETE FROM book_tags WHERE book_id = ?")).WithArgs(bookID).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(sqlPattern("DELETE FROM book_genres WHERE book_id = ?")).WithArgs(bookID).WillReturnResult(sqlmock.NewResult(0, 0))
+}
+
+func TestCreateHandlersRespondCreated(t *testing.T) {
+	t.Run("author", func(t *testing.T) {
+		db, mock := newMockDB(t)
+		mock.ExpectQuery(sqlPattern("SELECT id FROM authors WHERE Lastname = ? AND Firstname = ?")).
+			WithArgs("Orwell", "George").WillReturnRows(sqlmock.NewRows([]string{"id"}))
+		mock.ExpectExec(sqlPattern("INSERT INTO authors")).WithArgs("Orwell", "George", "orwell.jpg").WillReturnResult(sqlmock.NewResult(5, 1))
+		expectAudit(mock, "create", "author", 5)
+
+		rec := serveRoute(AddAuthor(db, newTestPhotoConfig(t)), http.MethodPost, "/authors/new", "/authors/new",
+			strings.NewReader(`{"firstname":"George","lastname":"Orwell","photo":"orwell.jpg"}`))
+		if rec.Code != http.StatusCreated {
+			t.Fatalf("status %d, want 201: %s", rec.Code, rec.Body)
+		}
+		var response map[string]int
+		decodeJSON(t, rec, &response)
+		if response["id"] != 5 {
+			t.Errorf("got %v, want id 5", response)
+		}
+	})
+
+	t.Run("book", func(t *testing.T) {
+		db, mock := newMockDB(t)
+		mock.ExpectBegin()
+		mock.ExpectExec(sqlPattern("INSERT INTO books")).WillReturnResult(sqlmock.NewResult(9, 1))
+		expectBookLinks(mock, 9, 5)
+		mock.ExpectCommit()
+		expectAudit(mock, "create", "book", 9)
+
+		rec := serveRoute(AddBook(db, newTestPhotoConfig(t)), http.MethodPost, "/books/new", "/books/new",
+			strings.NewReader(`{"title":"1984","author_id":5}`))
+		if rec.Code != http.StatusCreated {
+			t.Fatalf("status %d, want 201: %s", rec.Code, rec.Body)
+		}
+	})
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// TopBook is a book in the ranking of the dashboard
+type TopBook struct {
+	BookID      int    `json:"book_id"`
+	BookTitle   string `json:"book_title"`
+	BorrowCount int    `json:"borrow_count"`
+}
+
+// statsCounts are the single figures of the dashboard and the queries computing them
+var statsCounts = map[string]string{
+	"total_books":              "SELECT COUNT(*) FROM books",
+	"total_authors":            "SELECT COUNT(*) FROM authors",
+	"total_subscribers":        "SELECT COUNT(*) FROM subscribers",
+	"books_currently_borrowed": "SELECT COUNT(*) FROM borrowed_books WHERE return_date IS NULL",
+	"borrows_last_30_days":     "SELECT COUNT(*) FROM borrowed_books WHERE date_of_borrow >= NOW() - INTERVAL 30 DAY",
+}
+
+// topBooks returns the most borrowed books
+func topBooks(ctx context.Context, db *sql.DB, limit int) ([]TopBook, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT books.id, books.title, COUNT(*) AS borrow_count
+		FROM borrowed_books
+		JOIN books ON borrowed_books.book_id = books.id
+		GROUP BY books.id, books.title
+		ORDER BY borrow_count DESC, books.id
+		LIMIT ?
+	`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	books := []TopBook{}
+	for rows.Next() {
+		var book TopBook
+		if err := rows.Scan(&book.BookID, &book.BookTitle, &book.BorrowCount); err != nil {
+			return nil, err
+		}
+		books = append(books, book)
+	}
+	return books, rows.Err()
+}
+
+// GetStats returns a handler with the figures of the admin dashboard. The queries run concurrently;
+// a figure whose query fails is logged and left out of the response instead of failing the request.
+func GetStats(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var mu sync.Mutex
+		stats := make(map[string]interface{})
+		set := func(name string, value interface{}, err error) {
+			if err != nil {
+				slog.Warn("computing statistic failed", "statistic", name, "error", err)
+				return
+			}
+			mu.Lock()
+			stats[name] = value
+			mu.Unlock()
+		}
+
+		var g errgroup.Group
+		for name, query := range statsCounts {
+			name, query := name, query
+			g.Go(func() error {
+				var count int
+				err := db.QueryRowContext(r.Context(), query).Scan(&count)
+				set(name, count, err)
+				return nil
+			})
+		}
+		g.Go(func() error {
+			books, err := topBooks(r.Context(), db, 5)
+			set("top_books", books, err)
+			return nil
+		})
+		g.Wait()
+
+		RespondWithJSON(w, http.StatusOK, stats)
+	}
+}
+
+const (
+	// monthLayout is the YYYY-MM format of months in the monthly statistics
+	monthLayout = "2006-01"
+	// maxMonthlyBorrowsRange is the largest number of months of a monthly borrows request
+	maxMonthlyBorrowsRange = 24
+)
+
+// MonthlyBorrow is the number of books borrowed in a month
+type MonthlyBorrow struct {
+	Month string `json:"month"`
+	Count int    `json:"count"`
+}
+
+// parseMonthRange reads the required from and to months, both inclusive
+func parseMonthRange(r *http.Request) (time.Time, time.Time, error) {
+	from, err := time.Parse(monthLayout, r.URL.Query().Get("from"))
+	if err != nil {
+		return time.Time{}, time.Time{}, errors.New("from must be a month in the YYYY-MM format")
+	}
+	to, err := time.Parse(monthLayout, r.URL.Query().Get("to"))
+	if err != nil {
+		return time.Time{}, time.Time{}, errors.New("to must be a month in the YYYY-MM format")
+	}
+	if from.After(to) {
+		return time.Time{}, time.Time{}, errors.New("from must not be after to")
+	}
+	if from.AddDate(0, maxMonthlyBorrowsRange, 0).Before(to.AddDate(0, 1, 0)) {
+		return time.Time{}, time.Time{}, errors.New("the range can't be longer than 24 months")
+	}
+	return from, to, nil
+}
+
+// GetMonthlyBorrows returns a handler with the number of borrows of every month between from and to.
+// Months without borrows are included with a count of 0.
+func GetMonthlyBorrows(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		from, to, err := parseMonthRange(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		rows, err := db.QueryContext(r.Context(), `
+			SELECT DATE_FORMAT(date_of_borrow, '%Y-%m') AS month, COUNT(*) AS borrows
+			FROM borrowed_books
+			WHERE date_of_borrow >= ? AND date_of_borrow < ?
+			GROUP BY month
+			ORDER BY month
+		`, from.Format(reportDateLayout), to.AddDate(0, 1, 0).Format(reportDateLayout))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer rows.Close()
+
+		counts := make(map[string]int)
+		for rows.Next() {
+			var month string
+			var count int
+			if err := rows.Scan(&month, &count); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			counts[month] = count
+		}
+		if err := rows.Err(); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		borrows := []MonthlyBorrow{}
+		for month := from; !month.After(to); month = month.AddDate(0, 1, 0) {
+			name := month.Format(monthLayout)
+			borrows = append(borrows, MonthlyBorrow{Month: name, Count: counts[name]})
+		}
+
+		RespondWithJSON(w, http.StatusOK, borrows)
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// expectStats expects the queries of GetStats, in any order since they run concurrently. The query of
+// failing fails.
+func expectStats(mock sqlmock.Sqlmock, failing string) {
+	mock.MatchExpectationsInOrder(false)
+	counts := map[string]int{
+		"total_books":              6,
+		"total_authors":            4,
+		"total_subscribers":        3,
+		"books_currently_borrowed": 2,
+		"borrows_last_30_days":     1,
+	}
+	for name, count := range counts {
+		expectation := mock.ExpectQuery("^" + sqlPattern(statsCounts[name]) + "$")
+		if name == failing {
+			expectation.WillReturnError(errors.New("lock wait timeout"))
+		} else {
+			expectation.WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(count))
+		}
+	}
+	mock.ExpectQuery(sqlPattern("JOIN books ON borrowed_books.book_id = books.id")).WithArgs(5).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "title", "borrow_count"}).AddRow(3, "Nineteen Eighty-Four", 4).AddRow(2, "Emma", 2))
+}
+
+func TestGetStats(t *testing.T) {
+	db, mock := newMockDB(t)
+	expectStats(mock, "")
+
+	rec := serveRoute(GetStats(db), http.MethodGet, "/stats", "/stats", nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d, want 200: %s", rec.Code, rec.Body)
+	}
+	var stats struct {
+		TotalBooks             *int      `json:"total_books"`
+		TotalAuthors           *int      `json:"total_authors"`
+		TotalSubscribers       *int      `json:"total_subscribers"`
+		BooksCurrentlyBorrowed *int      `json:"books_currently_borrowed"`
+		BorrowsLast30Days      *int      `json:"borrows_last_30_days"`
+		TopBooks               []TopBook `json:"top_books"`
+	}
+	decodeJSON(t, rec, &stats)
+	for name, got := range map[string]*int{"total_books": stats.TotalBooks, "total_authors": stats.TotalAuthors,
+		"total_subscribers": stats.TotalSubscribers, "books_currently_borrowed": stats.BooksCurrentlyBorrowed,
+		"borrows_last_30_days": stats.BorrowsLast30Days} {
+		if got == nil {
+			t.Errorf("%s is missing", name)
+		}
+	}
+	if stats.TotalBooks != nil && *stats.TotalBooks != 6 {
+		t.Errorf("total_books %d, want 6", *stats.TotalBooks)
+	}
+	if len(stats.TopBooks) != 2 || stats.TopBooks[0] != (TopBook{BookID: 3, BookTitle: "Nineteen Eighty-Four", BorrowCount: 4}) {
+		t.Errorf("top_books %+v", stats.TopBooks)
+	}
+}
+
+func TestGetStatsPartialFailure(t *testing.T) {
+	db, mock := newMockDB(t)
+	expectStats(mock, "total_authors")
+
+	rec := serveRoute(GetStats(db), http.MethodGet, "/stats", "/stats", nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d, want 200: %s", rec.Code, rec.Body)
+	}
+	var stats map[string]interface{}
+	decodeJSON(t, rec, &stats)
+	if _, ok := stats["total_authors"]; ok {
+		t.Error("the failed figure is in the response")
+	}
+	if stats["total_books"] != float64(6) || stats["top_books"] == nil {
+		t.Errorf("the other figures are missing: %v", stats)
+	}
+}
+
+func TestGetMonthlyBorrowsInvalidRange(t *testing.T) {
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"to=2024-06", "from must be a month"},
+		{"from=2024-1&to=2024-06", "from must be a month"},
+		{"from=2024-01&to=June", "to must be a month"},
+		{"from=2024-06&to=2024-01", "from must not be after to"},
+		{"from=2022-01&to=2024-01", "can't be longer than 24 months"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			rec := serveRoute(GetMonthlyBorrows(nil), http.MethodGet, "/stats/monthly-borrows", "/stats/monthly-borrows?"+tt.query, nil)
+			if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), tt.want) {
+				t.Errorf("got %d %q, want 400 %q", rec.Code, rec.Body, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetMonthlyBorrows(t *testing.T) {
+	db, mock := newMockDB(t)
+	mock.ExpectQuery(sqlPattern("FROM borrowed_books")).WithArgs("2023-12-01", "2024-04-01").
+		WillReturnRows(sqlmock.NewRows([]string{"month", "borrows"}).AddRow("2023-12", 4).AddRow("2024-02", 7))
+
+	rec := serveRoute(GetMonthlyBorrows(db), http.MethodGet, "/stats/monthly-borrows", "/stats/monthly-borrows?from=2023-12&to=2024-03", nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d, want 200: %s", rec.Code, rec.Body)
+	}
+	var borrows []MonthlyBorrow
+	decodeJSON(t, rec, &borrows)
+	want := []MonthlyBorrow{{"2023-12", 4}, {"2024-01", 0}, {"2024-02", 7}, {"2024-03", 0}}
+	if !reflect.DeepEqual(borrows, want) {
+		t.Errorf("got %v, want %v", borrows, want)
+	}
+}
+
+func TestGetMonthlyBorrowsLongestRange(t *testing.T) {
+	db, mock := newMockDB(t)
+	mock.ExpectQuery(sqlPattern("FROM borrowed_books")).WithArgs("2023-01-01", "2025-01-01").
+		WillReturnRows(sqlmock.NewRows([]string{"month", "borrows"}))
+
+	rec := serveRoute(GetMonthlyBorrows(db), http.MethodGet, "/stats/monthly-borrows", "/stats/monthly-borrows?from=2023-01&to=2024-12", nil)
+	var borrows []MonthlyBorrow
+	decodeJSON(t, rec, &borrows)
+	if len(borrows) != maxMonthlyBorrowsRange {
+		t.Errorf("got %d months, want %d", len(borrows), maxMonthlyBorrowsRange)
+	}
+}
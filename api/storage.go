@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Storage stores uploaded photos. Keys are slash separated paths such as "books/3/fullsize-<version>.jpg".
+// A key is never overwritten with different content, so the stored objects can be cached indefinitely.
+type Storage interface {
+	// Save stores the content of r under key and returns the URL or path it can be read from
+	Save(ctx context.Context, key string, r io.Reader) (string, error)
+	// URL returns the URL or path the object stored under key can be read from
+	URL(key string) string
+	// Delete removes the object stored under key, or every object below it when key is a directory.
+	// Deleting a key that doesn't exist is not an error.
+	Delete(ctx context.Context, key string) error
+}
+
+// newStorage builds the storage selected by STORAGE_BACKEND: "local" (default) or "s3"
+func newStorage(cfg Config) (Storage, error) {
+	switch backend := cfg.StorageBackend; backend {
+	case "local":
+		return NewLocalStorage(cfg.UploadDir, "/upload")
+	case "s3":
+		return NewS3StorageFromEnv()
+	default:
+		return nil, fmt.Errorf("unknown STORAGE_BACKEND %q", backend)
+	}
+}
+
+// immutableCacheControl lets clients cache a stored photo for a year without revalidating it
+const immutableCacheControl = "public, max-age=31536000, immutable"
+
+// LocalStorage keeps the photos in a directory on the local disk and serves them below BaseURL
+type LocalStorage struct {
+	Dir     string
+	BaseURL string
+}
+
+// NewLocalStorage creates dir if needed and checks that files can be written to it.
+func NewLocalStorage(dir, baseURL string) (*LocalStorage, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create upload directory %s: %w", dir, err)
+	}
+	probe, err := os.CreateTemp(dir, ".write-check-*")
+	if err != nil {
+		return nil, fmt.Errorf("upload directory %s is not writable: %w", dir, err)
+	}
+	probe.Close()
+	if err := os.Remove(probe.Name()); err != nil {
+		return nil, err
+	}
+	return &LocalStorage{Dir: dir, BaseURL: baseURL}, nil
+}
+
+// path is the location of key on disk
+func (s *LocalStorage) path(key string) string {
+	return s.Dir + "/" + key
+}
+
+// Save writes r to the file of key and returns its URL. A partially written file is removed.
+func (s *LocalStorage) Save(ctx context.Context, key string, r io.Reader) (string, error) {
+	path := s.path(key)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return "", fmt.Errorf("failed to create upload directory: %w", err)
+	}
+
+	out, err := os.Create(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to create %s: %w", path, err)
+	}
+
+	_, err = io.Copy(out, r)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path)
+		return "", fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return s.URL(key), nil
+}
+
+// URL returns the path Handler serves key at
+func (s *LocalStorage) URL(key string) string {
+	return s.BaseURL + "/" + key
+}
+
+// Delete removes the file or directory of key
+func (s *LocalStorage) Delete(ctx context.Context, key string) error {
+	return os.RemoveAll(s.path(key))
+}
+
+// Handler serves the stored files below BaseURL with a long-lived Cache-Control header
+func (s *LocalStorage) Handler() http.Handler {
+	files := http.StripPrefix(s.BaseURL+"/", http.FileServer(http.Dir(s.Dir)))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", immutableCacheControl)
+		files.ServeHTTP(w, r)
+	})
+}
+
+// File returns the location on disk of key. Keys that resolve outside of Dir, such as "../secret", are rejected.
+func (s *LocalStorage) File(key string) (string, error) {
+	file := filepath.Join(s.Dir, filepath.FromSlash(key))
+	rel, err := filepath.Rel(s.Dir, file)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%s is outside of the upload directory", key)
+	}
+	return file, nil
+}
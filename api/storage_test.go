@@ -0,0 +1,279 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"image"
+	"image/png"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gorilla/mux"
+)
+
+// memStorage is a Storage keeping the objects in memory
+type memStorage struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+}
+
+func newMemStorage() *memStorage {
+	return &memStorage{objects: map[string][]byte{}}
+}
+
+func (s *memStorage) Save(ctx context.Context, key string, r io.Reader) (string, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return "", err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.objects[key] = data
+	return s.URL(key), nil
+}
+
+func (s *memStorage) URL(key string) string {
+	return "/mem/" + key
+}
+
+func (s *memStorage) Delete(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for stored := range s.objects {
+		if stored == key || strings.HasPrefix(stored, key+"/") {
+			delete(s.objects, stored)
+		}
+	}
+	return nil
+}
+
+// keys returns the stored keys, sorted
+func (s *memStorage) keys() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var keys []string
+	for key := range s.objects {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// pngPhoto encodes a small PNG image
+func pngPhoto(t *testing.T) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 400, 200))); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// photoUploadRequest builds a multipart request with photo as its "file" field
+func photoUploadRequest(t *testing.T, target string, photo []byte) *http.Request {
+	t.Helper()
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, err := form.CreateFormFile("file", "photo.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	part.Write(photo)
+	form.Close()
+	req := httptest.NewRequest(http.MethodPost, target, &body)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	return req
+}
+
+func TestAddAuthorPhotoUsesStorage(t *testing.T) {
+	storage := newMemStorage()
+	storage.objects["5/fullsize-old.png"] = []byte("old")
+	storage.objects["5/medium-old.jpg"] = []byte("old")
+	storage.objects["5/thumbnail-old.jpg"] = []byte("old")
+	config := PhotoConfig{Storage: storage, MaxSize: 1 << 20, MaxMemory: 1 << 20}
+
+	db, mock := newMockDB(t)
+	mock.ExpectQuery(sqlPattern("SELECT EXISTS(SELECT 1 FROM authors WHERE id = ?)")).WithArgs(5).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+	mock.ExpectQuery(sqlPattern("SELECT photo FROM authors WHERE id = ?")).WithArgs(5).
+		WillReturnRows(sqlmock.NewRows([]string{"photo"}).AddRow("/mem/5/fullsize-old.png"))
+	mock.ExpectExec(sqlPattern("UPDATE authors SET photo = ? WHERE id = ?")).WithArgs(sqlmock.AnyArg(), 5).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(sqlPattern("INSERT INTO photo_uploads")).WillReturnResult(sqlmock.NewResult(1, 1))
+
+	router := mux.NewRouter()
+	router.Handle("/author/photo/{id}", AddAuthorPhoto(db, config))
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, photoUploadRequest(t, "/author/photo/5", pngPhoto(t)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d, want 200: %s", rec.Code, rec.Body)
+	}
+	var response struct {
+		Photo    string        `json:"photo"`
+		Variants PhotoVariants `json:"variants"`
+	}
+	decodeJSON(t, rec, &response)
+	if !strings.HasPrefix(response.Photo, "/mem/5/fullsize-") || !strings.HasSuffix(response.Photo, ".png") {
+		t.Errorf("photo %q isn't in the storage", response.Photo)
+	}
+
+	// The new photo and its variants are stored, the replaced ones removed
+	keys := storage.keys()
+	if len(keys) != 3 {
+		t.Fatalf("stored %v, want the photo and its two variants", keys)
+	}
+	for _, key := range keys {
+		if strings.Contains(key, "-old.") {
+			t.Errorf("the replaced photo %s is still stored", key)
+		}
+	}
+	if "/mem/"+keys[0] != response.Photo || "/mem/"+keys[1] != response.Variants.Medium || "/mem/"+keys[2] != response.Variants.Thumbnail {
+		t.Errorf("stored %v, response %+v", keys, response)
+	}
+}
+
+func TestAddAuthorPhotoUpdateFailure(t *testing.T) {
+	storage := newMemStorage()
+	storage.objects["5/fullsize-old.png"] = []byte("old")
+	config := PhotoConfig{Storage: storage, MaxSize: 1 << 20, MaxMemory: 1 << 20}
+
+	db, mock := newMockDB(t)
+	mock.ExpectQuery(sqlPattern("SELECT EXISTS(SELECT 1 FROM authors WHERE id = ?)")).WithArgs(5).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+	mock.ExpectQuery(sqlPattern("SELECT photo FROM authors WHERE id = ?")).WithArgs(5).
+		WillReturnRows(sqlmock.NewRows([]string{"photo"}).AddRow("/mem/5/fullsize-old.png"))
+	mock.ExpectExec(sqlPattern("UPDATE authors SET photo = ? WHERE id = ?")).WithArgs(sqlmock.AnyArg(), 5).
+		WillReturnError(errors.New("connection lost"))
+
+	router := mux.NewRouter()
+	router.Handle("/author/photo/{id}", AddAuthorPhoto(db, config))
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, photoUploadRequest(t, "/author/photo/5", pngPhoto(t)))
+	if rec.Code != http.StatusInternalServerError {
+		t.Fatalf("status %d, want 500: %s", rec.Code, rec.Body)
+	}
+	// The new photo and its variants are removed, the current photo is kept
+	if keys := storage.keys(); len(keys) != 1 || keys[0] != "5/fullsize-old.png" {
+		t.Errorf("stored %v, want only the current photo", keys)
+	}
+}
+
+func TestDeleteBookPhotoUsesStorage(t *testing.T) {
+	storage := newMemStorage()
+	storage.objects["books/3/fullsize-abc.jpg"] = []byte("photo")
+	storage.objects["books/3/medium-abc.jpg"] = []byte("photo")
+	storage.objects["books/30/fullsize-def.jpg"] = []byte("other book")
+	config := PhotoConfig{Storage: storage}
+
+	db, mock := newMockDB(t)
+	mock.ExpectQuery(sqlPattern("SELECT photo FROM books WHERE id = ?")).WithArgs(3).
+		WillReturnRows(sqlmock.NewRows([]string{"photo"}).AddRow("/mem/books/3/fullsize-abc.jpg"))
+	mock.ExpectExec(sqlPattern("UPDATE books SET photo = '' WHERE id = ?")).WithArgs(3).WillReturnResult(sqlmock.NewResult(0, 1))
+
+	rec := serveRoute(DeleteBookPhoto(db, config), http.MethodDelete, "/books/photo/{id}", "/books/photo/3", nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d, want 200: %s", rec.Code, rec.Body)
+	}
+	if keys := storage.keys(); len(keys) != 1 || keys[0] != "books/30/fullsize-def.jpg" {
+		t.Errorf("stored %v, want only the photo of the other book", keys)
+	}
+}
+
+func TestLocalStorage(t *testing.T) {
+	storage, err := NewLocalStorage(t.TempDir(), "/upload")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	url, err := storage.Save(ctx, "books/1/fullsize-abc.jpg", strings.NewReader("photo"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if url != "/upload/books/1/fullsize-abc.jpg" {
+		t.Errorf("url %q", url)
+	}
+	file, err := storage.File("books/1/fullsize-abc.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, err := os.ReadFile(file); err != nil || string(data) != "photo" {
+		t.Errorf("read %q, %v", data, err)
+	}
+
+	if err := storage.Delete(ctx, "books/1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(file); !os.IsNotExist(err) {
+		t.Errorf("%s still exists after deleting its directory", file)
+	}
+	if _, err := storage.File("../secret"); err == nil {
+		t.Error("a key outside of the directory was accepted")
+	}
+}
+
+func TestSetPhotoURL(t *testing.T) {
+	const cdnURL = "https://cdn.example.com/authors/3.jpg"
+	tests := []struct {
+		name     string
+		handler  func(*sql.DB, PhotoConfig) http.HandlerFunc
+		table    string
+		pattern  string
+		body     string
+		previous interface{} // nil when the record doesn't exist
+		want     int
+	}{
+		{name: "author", handler: SetAuthorPhotoURL, table: "authors", pattern: "/author/photo-url/{id}",
+			body: `{"photo_url":"` + cdnURL + `"}`, previous: "/mem/3/photo.png", want: http.StatusOK},
+		{name: "book", handler: SetBookPhotoURL, table: "books", pattern: "/books/photo-url/{id}",
+			body: `{"photo_url":"` + cdnURL + `"}`, previous: "", want: http.StatusOK},
+		{name: "http URL", handler: SetAuthorPhotoURL, pattern: "/author/photo-url/{id}",
+			body: `{"photo_url":"http://cdn.example.com/authors/3.jpg"}`, want: http.StatusBadRequest},
+		{name: "unparseable URL", handler: SetAuthorPhotoURL, pattern: "/author/photo-url/{id}",
+			body: `{"photo_url":"cdn.example.com/authors/3.jpg"}`, want: http.StatusBadRequest},
+		{name: "URL without host", handler: SetBookPhotoURL, pattern: "/books/photo-url/{id}",
+			body: `{"photo_url":"https://"}`, want: http.StatusBadRequest},
+		{name: "unknown author", handler: SetAuthorPhotoURL, table: "authors", pattern: "/author/photo-url/{id}",
+			body: `{"photo_url":"` + cdnURL + `"}`, want: http.StatusNotFound},
+		{name: "unknown book", handler: SetBookPhotoURL, table: "books", pattern: "/books/photo-url/{id}",
+			body: `{"photo_url":"` + cdnURL + `"}`, want: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock := newMockDB(t)
+			storage := newMemStorage()
+			storage.objects["3/photo.png"] = []byte("old photo")
+			config := PhotoConfig{Storage: storage, MaxSize: 1 << 20, MaxMemory: 1 << 20}
+			if tt.table != "" {
+				rows := sqlmock.NewRows([]string{"photo"})
+				if tt.previous != nil {
+					rows.AddRow(tt.previous)
+				}
+				mock.ExpectQuery(sqlPattern("SELECT photo FROM " + tt.table + " WHERE id = ?")).WithArgs(3).WillReturnRows(rows)
+			}
+			if tt.want == http.StatusOK {
+				mock.ExpectExec(sqlPattern("UPDATE "+tt.table+" SET photo = ? WHERE id = ?")).WithArgs(cdnURL, 3).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+			}
+
+			target := strings.Replace(tt.pattern, "{id}", "3", 1)
+			rec := serveRoute(tt.handler(db, config), http.MethodPatch, tt.pattern, target, strings.NewReader(tt.body))
+			if rec.Code != tt.want {
+				t.Fatalf("status %d, want %d: %s", rec.Code, tt.want, rec.Body)
+			}
+			if tt.name == "author" && len(storage.keys()) != 0 {
+				t.Errorf("the uploaded photo wasn't removed: %v", storage.keys())
+			}
+		})
+	}
+}
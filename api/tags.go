@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// normalizeTagNames trims and lower-cases tag names, dropping empty and duplicate entries.
+func normalizeTagNames(tagNames []string) []string {
+	seen := make(map[string]bool)
+	var names []string
+	for _, name := range tagNames {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" || seen[name] {
+			continue
+		}
+		seen[name] = true
+		names = append(names, name)
+	}
+	return names
+}
+
+// setBookTags replaces the tags of a book, creating the tags that don't exist yet.
+func setBookTags(ctx context.Context, tx *sql.Tx, bookID int64, tagNames []string) error {
+	if _, err := tx.ExecContext(ctx, "DELETE FROM book_tags WHERE book_id = ?", bookID); err != nil {
+		return err
+	}
+
+	for _, name := range normalizeTagNames(tagNames) {
+		if _, err := tx.ExecContext(ctx, "INSERT IGNORE INTO tags (name) VALUES (?)", name); err != nil {
+			return err
+		}
+		_, err := tx.ExecContext(ctx, "INSERT IGNORE INTO book_tags (book_id, tag_id) SELECT ?, id FROM tags WHERE name = ?", bookID, name)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// getBookTags returns the tag names of a book in alphabetical order.
+func getBookTags(ctx context.Context, db *sql.DB, bookID int) ([]string, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT tags.name
+		FROM book_tags
+		JOIN tags ON book_tags.tag_id = tags.id
+		WHERE book_tags.book_id = ?
+		ORDER BY tags.name
+	`, bookID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tags := []string{}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		tags = append(tags, name)
+	}
+	return tags, rows.Err()
+}
+
+// RemoveBookTag returns a handler that removes a single tag from a book, leaving its other tags in place.
+func RemoveBookTag(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		bookID, err := strconv.Atoi(vars["id"])
+		if err != nil {
+			http.Error(w, "Invalid book ID", http.StatusBadRequest)
+			return
+		}
+		tagName := strings.ToLower(strings.TrimSpace(vars["tag_name"]))
+
+		var bookExists bool
+		var tagID sql.NullInt64
+		err = db.QueryRowContext(r.Context(), `
+			SELECT EXISTS(SELECT 1 FROM books WHERE id = ?), (SELECT id FROM tags WHERE name = ?)
+		`, bookID, tagName).Scan(&bookExists, &tagID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !bookExists {
+			http.Error(w, "Book not found", http.StatusNotFound)
+			return
+		}
+		if !tagID.Valid {
+			http.Error(w, "Tag not found", http.StatusNotFound)
+			return
+		}
+
+		result, err := db.ExecContext(r.Context(), "DELETE FROM book_tags WHERE book_id = ? AND tag_id = ?", bookID, tagID.Int64)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to remove tag: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+			http.Error(w, "Book doesn't have this tag", http.StatusNotFound)
+			return
+		}
+
+		RespondWithJSON(w, http.StatusOK, map[string]string{"message": "Tag removed successfully"})
+	}
+}
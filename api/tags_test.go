@@ -0,0 +1,117 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestRemoveBookTag(t *testing.T) {
+	tests := []struct {
+		name       string
+		path       string
+		bookExists bool
+		tagID      interface{}
+		queryErr   error
+		deleted    int64
+		want       int
+		wantBody   string
+	}{
+		{name: "removed", path: "/books/1/tags/Classic", bookExists: true, tagID: 3, deleted: 1, want: http.StatusOK},
+		{name: "unknown book", path: "/books/1/tags/classic", tagID: 3, want: http.StatusNotFound, wantBody: "Book not found"},
+		{name: "unknown tag", path: "/books/1/tags/classic", bookExists: true, want: http.StatusNotFound, wantBody: "Tag not found"},
+		{name: "tag not on the book", path: "/books/1/tags/classic", bookExists: true, tagID: 3, deleted: 0, want: http.StatusNotFound, wantBody: "Book doesn't have this tag"},
+		{name: "database error", path: "/books/1/tags/classic", queryErr: errors.New("connection refused"), want: http.StatusInternalServerError},
+		{name: "invalid book ID", path: "/books/abc/tags/classic", want: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock := newMockDB(t)
+			if tt.want != http.StatusBadRequest {
+				query := mock.ExpectQuery(sqlPattern("SELECT EXISTS(SELECT 1 FROM books WHERE id = ?), (SELECT id FROM tags WHERE name = ?)")).
+					WithArgs(1, "classic")
+				if tt.queryErr != nil {
+					query.WillReturnError(tt.queryErr)
+				} else {
+					query.WillReturnRows(sqlmock.NewRows([]string{"book", "tag"}).AddRow(tt.bookExists, tt.tagID))
+				}
+			}
+			if tt.bookExists && tt.tagID != nil {
+				mock.ExpectExec(sqlPattern("DELETE FROM book_tags WHERE book_id = ? AND tag_id = ?")).WithArgs(1, 3).
+					WillReturnResult(sqlmock.NewResult(0, tt.deleted))
+			}
+
+			rec := serveRoute(RemoveBookTag(db), http.MethodDelete, "/books/{id}/tags/{tag_name}", tt.path, nil)
+			if rec.Code != tt.want {
+				t.Fatalf("status %d, want %d: %s", rec.Code, tt.want, rec.Body)
+			}
+			if tt.wantBody != "" && !strings.Contains(rec.Body.String(), tt.wantBody) {
+				t.Errorf("body %q, want %q", rec.Body, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestNormalizeTagNames(t *testing.T) {
+	got := normalizeTagNames([]string{" Space-Opera", "space-opera", "", "Nobel-Prize-Winner ", "  "})
+	want := []string{"space-opera", "nobel-prize-winner"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestAddBookTags(t *testing.T) {
+	db, mock := newMockDB(t)
+	mock.ExpectBegin()
+	mock.ExpectExec(sqlPattern("INSERT INTO books")).WillReturnResult(sqlmock.NewResult(9, 1))
+	mock.ExpectExec(sqlPattern("DELETE FROM authors_books")).WithArgs(9).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(sqlPattern("SELECT EXISTS")).WithArgs(5).WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+	mock.ExpectExec(sqlPattern("INSERT INTO authors_books")).WithArgs(5, 9).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(sqlPattern("DELETE FROM book_tags")).WithArgs(9).WillReturnResult(sqlmock.NewResult(0, 0))
+	// The repeated tag is only created and linked once
+	for _, name := range []string{"space-opera", "classic"} {
+		mock.ExpectExec(sqlPattern("INSERT IGNORE INTO tags (name)")).WithArgs(name).WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec(sqlPattern("INSERT IGNORE INTO book_tags")).WithArgs(9, name).WillReturnResult(sqlmock.NewResult(0, 1))
+	}
+	mock.ExpectExec(sqlPattern("DELETE FROM book_genres")).WithArgs(9).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+	expectAudit(mock, "create", "book", 9)
+
+	body := `{"title":"Dune","author_id":5,"tag_names":["Space-Opera","classic","space-opera "]}`
+	rec := serveRoute(AddBook(db, newTestPhotoConfig(t)), http.MethodPost, "/books/new", "/books/new", strings.NewReader(body))
+	if rec.Code != http.StatusCreated {
+		t.Errorf("status %d, want 201: %s", rec.Code, rec.Body)
+	}
+}
+
+func TestSearchBooksByTags(t *testing.T) {
+	t.Run("found", func(t *testing.T) {
+		db, mock := newMockDB(t)
+		mock.ExpectQuery(sqlPattern("SELECT COUNT(*) FROM books")).WithArgs("space-opera", "classic").
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+		mock.ExpectQuery(sqlPattern("WHERE tags.name IN (?, ?)")).WithArgs("space-opera", "classic").WillReturnRows(bookRows(3))
+
+		rec := serveRoute(SearchBooks(db), http.MethodGet, "/books/search", "/books/search?tags=Space-Opera,,classic", nil)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status %d, want 200: %s", rec.Code, rec.Body)
+		}
+		var books []BookAuthorInfo
+		decodeJSON(t, rec, &books)
+		if len(books) != 1 || books[0].BookID != 3 {
+			t.Errorf("got %+v, want book 3", books)
+		}
+	})
+
+	t.Run("no tag names", func(t *testing.T) {
+		db, _ := newMockDB(t)
+
+		rec := serveRoute(SearchBooks(db), http.MethodGet, "/books/search", "/books/search?tags=,", nil)
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("status %d, want 400", rec.Code)
+		}
+	})
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+var (
+	spanRecorderOnce sync.Once
+	spanRecorder     *tracetest.InMemoryExporter
+)
+
+// recordSpans installs an in-memory exporter as the tracer provider and returns it empty. The global
+// tracer only delegates to the first provider installed, so it is installed once for all the tests.
+func recordSpans(t *testing.T) *tracetest.InMemoryExporter {
+	spanRecorderOnce.Do(func() {
+		spanRecorder = tracetest.NewInMemoryExporter()
+		otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(spanRecorder)))
+	})
+	spanRecorder.Reset()
+	t.Cleanup(spanRecorder.Reset)
+	return spanRecorder
+}
+
+// expectBorrowChecks expects the membership check of subscriber 1 and the locked read of book 2
+func expectBorrowChecks(mock sqlmock.Sqlmock, isBorrowed bool) {
+	mock.ExpectQuery(sqlPattern("SELECT membership_expiry")).WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"membership_expiry", "expired"}).AddRow(nil, false))
+	mock.ExpectBegin()
+	mock.ExpectQuery(sqlPattern("SELECT is_borrowed FROM books WHERE id = ? FOR UPDATE")).WithArgs(2).
+		WillReturnRows(sqlmock.NewRows([]string{"is_borrowed"}).AddRow(isBorrowed))
+}
+
+func borrowRequest() *http.Request {
+	return httptest.NewRequest(http.MethodPost, "/book/borrow", strings.NewReader(`{"subscriber_id": 1, "book_id": 2}`))
+}
+
+func TestBorrowBookSpans(t *testing.T) {
+	spans := recordSpans(t)
+	db, mock := newMockDB(t)
+	expectBorrowChecks(mock, false)
+	mock.ExpectExec(sqlPattern("INSERT INTO borrowed_books")).WithArgs(1, 2, loanPeriodDays).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(sqlPattern("UPDATE books SET is_borrowed = TRUE WHERE id = ?")).WithArgs(2).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	expectAudit(mock, "borrow", "book", 2)
+
+	rec := serveWithMiddleware(TracingMiddleware(), BorrowBook(db, newTestWebhooks(t)), "/book/borrow", borrowRequest())
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("status %d, want 201: %s", rec.Code, rec.Body)
+	}
+
+	var names []string
+	var request tracetest.SpanStub
+	for _, span := range spans.GetSpans() {
+		names = append(names, span.Name)
+		if span.Name == "POST /book/borrow" {
+			request = span
+		}
+	}
+	want := []string{"check membership", "check book availability", "record loan", "mark book borrowed", "record audit entry", "POST /book/borrow"}
+	if strings.Join(names, ", ") != strings.Join(want, ", ") {
+		t.Fatalf("got spans %v, want %v", names, want)
+	}
+	for _, span := range spans.GetSpans()[:len(want)-1] {
+		if span.Parent.SpanID() != request.SpanContext.SpanID() {
+			t.Errorf("span %q isn't a child of the request span", span.Name)
+		}
+	}
+}
+
+func TestBorrowBookSpanRecordsError(t *testing.T) {
+	spans := recordSpans(t)
+	db, mock := newMockDB(t)
+	expectBorrowChecks(mock, false)
+	mock.ExpectExec(sqlPattern("INSERT INTO borrowed_books")).WillReturnError(errors.New("lock wait timeout"))
+	mock.ExpectRollback()
+
+	rec := serveWithMiddleware(TracingMiddleware(), BorrowBook(db, newTestWebhooks(t)), "/book/borrow", borrowRequest())
+	if rec.Code != http.StatusInternalServerError {
+		t.Fatalf("status %d, want 500", rec.Code)
+	}
+	for _, span := range spans.GetSpans() {
+		if span.Name == "record loan" && span.Status.Description != "lock wait timeout" {
+			t.Errorf("record loan status %+v, want the error", span.Status)
+		}
+	}
+}
+
+func TestBorrowBookRejected(t *testing.T) {
+	t.Run("already borrowed", func(t *testing.T) {
+		db, mock := newMockDB(t)
+		expectBorrowChecks(mock, true)
+		mock.ExpectRollback()
+
+		rec := serveRoute(BorrowBook(db, newTestWebhooks(t)), http.MethodPost, "/book/borrow", "/book/borrow", strings.NewReader(`{"subscriber_id": 1, "book_id": 2}`))
+		if rec.Code != http.StatusConflict {
+			t.Errorf("status %d, want 409", rec.Code)
+		}
+	})
+
+	t.Run("unknown book", func(t *testing.T) {
+		db, mock := newMockDB(t)
+		mock.ExpectQuery(sqlPattern("SELECT membership_expiry")).WithArgs(1).
+			WillReturnRows(sqlmock.NewRows([]string{"membership_expiry", "expired"}).AddRow(nil, false))
+		mock.ExpectBegin()
+		mock.ExpectQuery(sqlPattern("FOR UPDATE")).WithArgs(2).WillReturnRows(sqlmock.NewRows([]string{"is_borrowed"}))
+		mock.ExpectRollback()
+
+		rec := serveRoute(BorrowBook(db, newTestWebhooks(t)), http.MethodPost, "/book/borrow", "/book/borrow", strings.NewReader(`{"subscriber_id": 1, "book_id": 2}`))
+		if rec.Code != http.StatusNotFound {
+			t.Errorf("status %d, want 404", rec.Code)
+		}
+	})
+}
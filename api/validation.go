@@ -0,0 +1,203 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// Column sizes of the subscribers and reviews tables, see schema.sql
+const (
+	maxNameLength  = 255
+	maxEmailLength = 255
+	maxPhoneLength = 20
+
+	maxCommentLength = 1000
+
+	// photo column of the authors and books tables
+	maxPhotoURLLength = 255
+)
+
+// bookFormats are the formats a book can have, besides the empty unspecified one
+var bookFormats = []string{"hardcover", "paperback", "ebook", "audiobook"}
+
+// emailPattern is a pragmatic check for something@domain.tld
+var emailPattern = regexp.MustCompile(`^[a-zA-Z0-9._%+\-]+@[a-zA-Z0-9.\-]+\.[a-zA-Z]{2,}$`)
+
+// phonePattern allows an optional leading +, digits, spaces, hyphens and parentheses
+var phonePattern = regexp.MustCompile(`^\+?[0-9 ()\-]+$`)
+
+// ValidatePhone checks that phone looks like a phone number with 7 to 15 digits.
+func ValidatePhone(phone string) error {
+	if !phonePattern.MatchString(phone) {
+		return errors.New("phone may only contain digits, spaces, hyphens, parentheses and a leading +")
+	}
+
+	digits := 0
+	for _, c := range phone {
+		if c >= '0' && c <= '9' {
+			digits++
+		}
+	}
+	if digits < 7 || digits > 15 {
+		return errors.New("phone must contain between 7 and 15 digits")
+	}
+	return nil
+}
+
+// ValidateEmail checks that email is a well-formed address that fits in the email column.
+func ValidateEmail(email string) error {
+	if utf8.RuneCountInString(email) > maxEmailLength {
+		return fmt.Errorf("email must be at most %d characters", maxEmailLength)
+	}
+	if !emailPattern.MatchString(email) {
+		return errors.New("email is not a valid email address")
+	}
+	return nil
+}
+
+// validateRequiredField checks that a trimmed text field is present and fits in its column.
+func validateRequiredField(name, value string, maxLength int) error {
+	if value == "" {
+		return fmt.Errorf("%s is a required field", name)
+	}
+	if utf8.RuneCountInString(value) > maxLength {
+		return fmt.Errorf("%s must be at most %d characters", name, maxLength)
+	}
+	return nil
+}
+
+// validateOptionalField checks that a trimmed text field that may be empty fits in its column.
+func validateOptionalField(name, value string, maxLength int) error {
+	if utf8.RuneCountInString(value) > maxLength {
+		return fmt.Errorf("%s must be at most %d characters", name, maxLength)
+	}
+	return nil
+}
+
+// ValidateBookFormat checks that format is one of bookFormats or empty when the format is unspecified.
+func ValidateBookFormat(format string) error {
+	if format == "" {
+		return nil
+	}
+	for _, known := range bookFormats {
+		if format == known {
+			return nil
+		}
+	}
+	return fmt.Errorf("format must be one of %s", strings.Join(bookFormats, ", "))
+}
+
+// ValidateSubscriberData trims the fields of a subscriber and checks them before it is written to the database.
+func ValidateSubscriberData(subscriber *Subscriber) error {
+	subscriber.Firstname = strings.TrimSpace(subscriber.Firstname)
+	subscriber.Lastname = strings.TrimSpace(subscriber.Lastname)
+	subscriber.Email = strings.TrimSpace(subscriber.Email)
+	subscriber.Phone = strings.TrimSpace(subscriber.Phone)
+
+	if err := validateRequiredField("firstname", subscriber.Firstname, maxNameLength); err != nil {
+		return err
+	}
+	if err := validateRequiredField("lastname", subscriber.Lastname, maxNameLength); err != nil {
+		return err
+	}
+	if err := validateRequiredField("email", subscriber.Email, maxEmailLength); err != nil {
+		return err
+	}
+	if err := ValidateEmail(subscriber.Email); err != nil {
+		return err
+	}
+	if subscriber.Phone != "" {
+		if utf8.RuneCountInString(subscriber.Phone) > maxPhoneLength {
+			return fmt.Errorf("phone must be at most %d characters", maxPhoneLength)
+		}
+		if err := ValidatePhone(subscriber.Phone); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ValidateReview checks the rating and comment of a review
+func ValidateReview(review Review) error {
+	if review.SubscriberID <= 0 {
+		return errors.New("subscriber_id is required")
+	}
+	if review.Rating < 1 || review.Rating > 5 {
+		return errors.New("rating must be between 1 and 5")
+	}
+	if utf8.RuneCountInString(review.Comment) > maxCommentLength {
+		return fmt.Errorf("comment must be at most %d characters", maxCommentLength)
+	}
+	return nil
+}
+
+// NormalizeISBN removes the hyphens and spaces of an ISBN-10 or ISBN-13 and checks its check digit.
+// It returns the bare digits (with an upper-case X check digit for ISBN-10).
+func NormalizeISBN(isbn string) (string, error) {
+	normalized := strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(isbn))
+
+	switch len(normalized) {
+	case 10:
+		sum := 0
+		for i, c := range normalized {
+			var digit int
+			switch {
+			case c >= '0' && c <= '9':
+				digit = int(c - '0')
+			case c == 'X' && i == 9:
+				digit = 10
+			default:
+				return "", errors.New("invalid ISBN: unexpected character")
+			}
+			sum += (10 - i) * digit
+		}
+		if sum%11 != 0 {
+			return "", errors.New("invalid ISBN: wrong check digit")
+		}
+	case 13:
+		sum := 0
+		for i, c := range normalized {
+			if c < '0' || c > '9' {
+				return "", errors.New("invalid ISBN: unexpected character")
+			}
+			weight := 1
+			if i%2 == 1 {
+				weight = 3
+			}
+			sum += weight * int(c-'0')
+		}
+		if sum%10 != 0 {
+			return "", errors.New("invalid ISBN: wrong check digit")
+		}
+	default:
+		return "", errors.New("invalid ISBN: must have 10 or 13 digits")
+	}
+	return normalized, nil
+}
+
+// errInvalidJSON is returned for a request body that isn't valid JSON for its target
+var errInvalidJSON = errors.New("Invalid JSON data")
+
+// StrictJSONDecoder decodes a JSON body into target and rejects the keys target has no field for, so a
+// misspelled key fails instead of being silently dropped. The error names the unknown field.
+func StrictJSONDecoder[T any](body io.Reader, target *T) error {
+	decoder := json.NewDecoder(body)
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(target)
+	if err == nil {
+		return nil
+	}
+	// encoding/json has no error type for unknown fields, only this message
+	if strings.HasPrefix(err.Error(), "json: unknown field ") {
+		return fmt.Errorf("unknown field %s", strings.TrimPrefix(err.Error(), "json: unknown field "))
+	}
+	if errors.Is(err, errInvalidMembershipExpiry) {
+		return err
+	}
+	return errInvalidJSON
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestValidateSubscriberData(t *testing.T) {
+	valid := Subscriber{Firstname: "Emma", Lastname: "Johnson", Email: "emma.johnson@example.com", Phone: "+40 700 000 001"}
+
+	tests := []struct {
+		name    string
+		modify  func(*Subscriber)
+		wantErr string
+	}{
+		{name: "valid", modify: func(s *Subscriber) {}},
+		{name: "without phone", modify: func(s *Subscriber) { s.Phone = "" }},
+		{name: "blank firstname", modify: func(s *Subscriber) { s.Firstname = "   " }, wantErr: "firstname is a required field"},
+		{name: "blank lastname", modify: func(s *Subscriber) { s.Lastname = "\t" }, wantErr: "lastname is a required field"},
+		{name: "missing email", modify: func(s *Subscriber) { s.Email = "" }, wantErr: "email is a required field"},
+		{name: "invalid email", modify: func(s *Subscriber) { s.Email = "notanemail" }, wantErr: "email is not a valid email address"},
+		{name: "email without tld", modify: func(s *Subscriber) { s.Email = "emma@example" }, wantErr: "email is not a valid email address"},
+		{name: "long firstname", modify: func(s *Subscriber) { s.Firstname = strings.Repeat("a", maxNameLength+1) }, wantErr: "firstname must be at most 255 characters"},
+		{name: "long email", modify: func(s *Subscriber) { s.Email = strings.Repeat("a", maxEmailLength) + "@example.com" }, wantErr: "email must be at most 255 characters"},
+		{name: "long phone", modify: func(s *Subscriber) { s.Phone = strings.Repeat("1", maxPhoneLength+1) }, wantErr: "phone must be at most 20 characters"},
+		{name: "phone with letters", modify: func(s *Subscriber) { s.Phone = "0700 abc 001" }, wantErr: "phone may only contain"},
+		{name: "short phone", modify: func(s *Subscriber) { s.Phone = "12345" }, wantErr: "phone must contain between 7 and 15 digits"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			subscriber := valid
+			tt.modify(&subscriber)
+			err := ValidateSubscriberData(&subscriber)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("error %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateSubscriberDataTrims(t *testing.T) {
+	subscriber := Subscriber{Firstname: "  Emma ", Lastname: " Johnson", Email: " emma@example.com ", Phone: " 0700000001 "}
+	if err := ValidateSubscriberData(&subscriber); err != nil {
+		t.Fatal(err)
+	}
+	want := Subscriber{Firstname: "Emma", Lastname: "Johnson", Email: "emma@example.com", Phone: "0700000001"}
+	if subscriber != want {
+		t.Errorf("got %+v, want %+v", subscriber, want)
+	}
+}
+
+func TestNormalizeISBN(t *testing.T) {
+	valid := map[string]string{
+		"0-306-40615-2":     "0306406152",
+		"080442957X":        "080442957X",
+		"0 8044 2957 x":     "080442957X",
+		"978-0-306-40615-7": "9780306406157",
+		"9780141439518":     "9780141439518",
+		"978 0451 524935":   "9780451524935",
+	}
+	for isbn, want := range valid {
+		got, err := NormalizeISBN(isbn)
+		if err != nil || got != want {
+			t.Errorf("NormalizeISBN(%q) = %q, %v, want %q", isbn, got, err, want)
+		}
+	}
+
+	invalid := map[string]string{
+		"0-306-40615-3":     "wrong check digit",
+		"978-0-306-40615-8": "wrong check digit",
+		"9780141439519":     "wrong check digit",
+		"0X06406152":        "unexpected character",
+		"978030640615X":     "unexpected character",
+		"12345":             "must have 10 or 13 digits",
+		"":                  "must have 10 or 13 digits",
+	}
+	for isbn, wantErr := range invalid {
+		if _, err := NormalizeISBN(isbn); err == nil || !strings.Contains(err.Error(), wantErr) {
+			t.Errorf("NormalizeISBN(%q) error %v, want %q", isbn, err, wantErr)
+		}
+	}
+}
+
+func TestStrictJSONDecoder(t *testing.T) {
+	var author Author
+	err := StrictJSONDecoder(strings.NewReader(`{"firstname":"Jane","lastname":"Austen","hack_field":"y"}`), &author)
+	if err == nil || err.Error() != `unknown field "hack_field"` {
+		t.Errorf("got %v, want the unknown field", err)
+	}
+	if err := StrictJSONDecoder(strings.NewReader(`{"firstname":`), &author); err != errInvalidJSON {
+		t.Errorf("got %v, want errInvalidJSON", err)
+	}
+	if err := StrictJSONDecoder(strings.NewReader(`{"firstname":"Jane","lastname":"Austen"}`), &author); err != nil || author.Lastname != "Austen" {
+		t.Errorf("got %v, %+v", err, author)
+	}
+}
+
+func TestHandlersRejectUnknownFields(t *testing.T) {
+	photoConfig := newTestPhotoConfig(t)
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		method  string
+		pattern string
+		target  string
+		body    string
+	}{
+		{"AddBook", AddBook(nil, photoConfig), http.MethodPost, "/books/new", "/books/new",
+			`{"title":"x","author_id":1,"hack_field":"y"}`},
+		{"AddAuthor", AddAuthor(nil, photoConfig), http.MethodPost, "/authors/new", "/authors/new",
+			`{"firstname":"Jane","lastname":"Austen","photo":"a.jpg","hack_field":"y"}`},
+		{"UpdateBook", UpdateBook(nil), http.MethodPut, "/books/{id}", "/books/1",
+			`{"title":"x","author_id":1,"hack_field":"y"}`},
+		{"UpdateAuthor", UpdateAuthor(nil), http.MethodPut, "/authors/{id}", "/authors/1",
+			`{"firstname":"Jane","lastname":"Austen","hack_field":"y"}`},
+		{"UpdateSubscriber", UpdateSubscriber(nil), http.MethodPut, "/subscribers/{id}", "/subscribers/1",
+			`{"firstname":"Emma","lastname":"Johnson","email":"emma@example.com","hack_field":"y"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serveRoute(tt.handler, tt.method, tt.pattern, tt.target, strings.NewReader(tt.body))
+			if rec.Code != http.StatusBadRequest {
+				t.Fatalf("status %d, want 400: %s", rec.Code, rec.Body)
+			}
+			if !strings.Contains(rec.Body.String(), `unknown field "hack_field"`) {
+				t.Errorf("body %q doesn't name the unknown field", rec.Body)
+			}
+		})
+	}
+}
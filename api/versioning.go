@@ -0,0 +1,93 @@
+package main
+
+import (
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// apiV1Prefix is the path prefix of the routes of version 1 of the API
+const apiV1Prefix = "/api/v1"
+
+// apiVersions are the path prefixes of the versions of the API, listed by Home
+var apiVersions = []string{apiV1Prefix}
+
+// legacyRoutes are the path templates of the routes of version 1 that were served without a prefix before
+// the API was versioned. The routes added since are only served under the prefix and are not listed here.
+var legacyRoutes = map[string]bool{
+	"/info": true, "/search_books": true, "/stats": true, "/stats/monthly-borrows": true, "/audit": true,
+	"/admin/loglevel": true, "/reports/top-books": true, "/publishers": true,
+	"/genres": true, "/genres/new": true, "/genres/{id}": true,
+	"/authors": true, "/authorsbooks": true, "/authors/new": true, "/authors/{id}": true, "/authors/{id}/profile": true,
+	"/authors/{id}/stats": true, "/authors/{id}/merge": true, "/authors/{id}/books": true, "/authors/{id}/books/{book_id}": true,
+	"/books": true, "/books/new": true, "/books/available": true, "/books/popular": true, "/books/by-author": true,
+	"/books/isbn/{isbn}": true, "/books/lookup": true, "/books/export": true, "/books/import": true,
+	"/books/{id:[0-9]+}": true, "/books/{id}/subscribers": true, "/books/{id}/borrow-history": true,
+	"/books/{id}/availability": true, "/books/{id}/similar": true, "/books/{id}/reviews": true,
+	"/books/{id}/reviews/{reviewID}": true, "/books/{id}/tags/{tag_name}": true, "/books/{id}/borrow-status": true,
+	"/book/borrow": true, "/book/return": true, "/book/transfer": true,
+	"/subscribers": true, "/subscribers/new": true, "/subscribers/bulk": true, "/subscribers/export": true,
+	"/subscribers/search": true, "/subscribers/{id}": true, "/subscribers/{id}/active-borrows": true,
+	"/subscribers/{id}/export": true, "/subscribers/{id}/anonymize": true,
+	"/author/photo/{id}": true, "/author/photo-url/{id}": true, "/books/photo/{id}": true, "/books/photo-url/{id}": true,
+}
+
+// legacyPathMatcher matches the unprefixed paths of the legacyRoutes of api, served under prefix. A request
+// the routes of api answer with another route than a legacy one, such as /subscribers/expired-memberships
+// next to /subscribers/{id}, doesn't match. A request with a method a legacy path doesn't support does, so
+// that api answers it with 405. It is built once every route of api is registered.
+func legacyPathMatcher(prefix string, api *mux.Router) mux.MatcherFunc {
+	var paths []*regexp.Regexp
+	api.Walk(func(route *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+		template, err := route.GetPathTemplate()
+		if err != nil || !legacyRoutes[strings.TrimPrefix(template, prefix)] {
+			return nil
+		}
+		if path, err := route.GetPathRegexp(); err == nil {
+			paths = append(paths, regexp.MustCompile(path))
+		}
+		return nil
+	})
+
+	return func(r *http.Request, _ *mux.RouteMatch) bool {
+		if strings.HasPrefix(r.URL.Path, "/api/") {
+			return false
+		}
+		versioned := versionedRequest(prefix, r)
+		var match mux.RouteMatch
+		if api.Match(versioned, &match) && match.MatchErr == nil {
+			template, err := match.Route.GetPathTemplate()
+			return err == nil && legacyRoutes[strings.TrimPrefix(template, prefix)]
+		}
+		if match.MatchErr != mux.ErrMethodMismatch {
+			return false
+		}
+		for _, path := range paths {
+			if path.MatchString(versioned.URL.Path) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// versionedRequest returns a copy of r for the path of r under prefix
+func versionedRequest(prefix string, r *http.Request) *http.Request {
+	versioned := r.Clone(r.Context())
+	versioned.URL.Path = prefix + r.URL.Path
+	versioned.URL.RawPath = ""
+	return versioned
+}
+
+// LegacyAlias serves a request to an unprefixed path with the route of api under prefix. The response is
+// marked with a Deprecation header and links to the path it moved to.
+func LegacyAlias(prefix string, api *mux.Router) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		versioned := versionedRequest(prefix, r)
+		w.Header().Set("Deprecation", "true")
+		w.Header().Set("Link", "<"+versioned.URL.Path+`>; rel="successor-version"`)
+		api.ServeHTTP(w, versioned)
+	})
+}
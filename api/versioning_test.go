@@ -0,0 +1,103 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// routeForms are the path prefixes a route of the API is served under, the versioned one and the legacy alias
+var routeForms = []struct {
+	name       string
+	prefix     string
+	deprecated bool
+}{
+	{name: "versioned", prefix: apiV1Prefix},
+	{name: "legacy", prefix: "", deprecated: true},
+}
+
+func TestHome(t *testing.T) {
+	rec := httptest.NewRecorder()
+	newTestRouter(t, nil).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec.Code != http.StatusOK || rec.Header().Get("Deprecation") != "" {
+		t.Fatalf("status %d with Deprecation %q, want 200 without", rec.Code, rec.Header().Get("Deprecation"))
+	}
+	var home struct {
+		Message  string   `json:"message"`
+		Versions []string `json:"versions"`
+	}
+	decodeJSON(t, rec, &home)
+	if home.Message != "Homepage" || !reflect.DeepEqual(home.Versions, []string{"/api/v1"}) {
+		t.Errorf("got %+v, want the versions of the API", home)
+	}
+}
+
+func TestVersionedAndLegacyRoutes(t *testing.T) {
+	for _, form := range routeForms {
+		t.Run(form.name, func(t *testing.T) {
+			db, mock := newMockDB(t)
+			mock.ExpectQuery(sqlPattern("FROM subscribers WHERE id = ?")).WithArgs(7).
+				WillReturnRows(sqlmock.NewRows([]string{"id", "lastname", "firstname", "email", "phone", "membership_expiry"}).
+					AddRow(7, "Johnson", "Emma", "emma@example.com", "", nil))
+
+			rec := httptest.NewRecorder()
+			newTestRouter(t, db).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, form.prefix+"/subscribers/7", nil))
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status %d, want 200: %s", rec.Code, rec.Body)
+			}
+			var subscriber Subscriber
+			decodeJSON(t, rec, &subscriber)
+			if subscriber.ID != 7 {
+				t.Errorf("got %+v", subscriber)
+			}
+
+			deprecation, link := rec.Header().Get("Deprecation"), rec.Header().Get("Link")
+			if form.deprecated && (deprecation != "true" || link != `</api/v1/subscribers/7>; rel="successor-version"`) {
+				t.Errorf("Deprecation %q and Link %q, want the legacy path marked deprecated", deprecation, link)
+			}
+			if !form.deprecated && (deprecation != "" || link != "") {
+				t.Errorf("Deprecation %q and Link %q on the versioned path", deprecation, link)
+			}
+		})
+	}
+}
+
+func TestLegacyAliasesOnlyForRoutesBeforeVersioning(t *testing.T) {
+	tests := []struct {
+		method, target string
+		want           int
+	}{
+		// Added after the API was versioned
+		{http.MethodGet, "/webhooks", http.StatusNotFound},
+		{http.MethodDelete, "/webhooks/1", http.StatusNotFound},
+		{http.MethodGet, "/books/formats", http.StatusNotFound},
+		// The legacy /subscribers/{id} takes the other methods on that path
+		{http.MethodGet, "/subscribers/expired-memberships", http.StatusMethodNotAllowed},
+		// A legacy path with a method it doesn't support
+		{http.MethodDelete, "/stats", http.StatusMethodNotAllowed},
+		{http.MethodGet, "/book/borrow", http.StatusMethodNotAllowed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.target, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			newTestRouter(t, nil).ServeHTTP(rec, httptest.NewRequest(tt.method, tt.target, nil))
+			if rec.Code != tt.want {
+				t.Errorf("status %d, want %d: %s", rec.Code, tt.want, rec.Body)
+			}
+		})
+	}
+
+	t.Run("versioned", func(t *testing.T) {
+		db, mock := newMockDB(t)
+		mock.ExpectQuery(sqlPattern("FROM webhooks")).WillReturnRows(sqlmock.NewRows([]string{"id", "url", "events", "created_at"}))
+
+		rec := httptest.NewRecorder()
+		newTestRouter(t, db).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, apiV1Prefix+"/webhooks", nil))
+		if rec.Code != http.StatusOK {
+			t.Errorf("status %d, want 200: %s", rec.Code, rec.Body)
+		}
+	})
+}
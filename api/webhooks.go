@@ -0,0 +1,423 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Events the webhooks can subscribe to
+const (
+	webhookEventBorrowed = "book.borrowed"
+	webhookEventReturned = "book.returned"
+)
+
+// webhookEvents are the events a webhook can subscribe to
+var webhookEvents = []string{webhookEventBorrowed, webhookEventReturned}
+
+// Column sizes of the webhooks and webhook_deliveries tables
+const (
+	maxWebhookURLLength    = 2048
+	maxWebhookSecretLength = 255
+	maxWebhookErrorLength  = 1000
+)
+
+// Webhook is a URL notified of the events it subscribed to. The secret signs the deliveries, it is only
+// returned when the webhook is created.
+type Webhook struct {
+	ID        int       `json:"id"`
+	URL       string    `json:"url"`
+	Secret    string    `json:"secret,omitempty"`
+	Events    []string  `json:"events"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// WebhookDelivery is an attempt to deliver an event to a webhook. StatusCode is 0 when no response was received.
+type WebhookDelivery struct {
+	ID         int       `json:"id"`
+	Event      string    `json:"event"`
+	Attempt    int       `json:"attempt"`
+	StatusCode int       `json:"status_code,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// WebhookPayload is the JSON body posted to the webhooks
+type WebhookPayload struct {
+	Event string `json:"event"`
+	Book  struct {
+		ID    int    `json:"id"`
+		Title string `json:"title"`
+	} `json:"book"`
+	Subscriber Subscriber `json:"subscriber"`
+	Timestamp  time.Time  `json:"timestamp"`
+}
+
+// WebhookDispatcher delivers the events to the webhooks in the background, so a request never waits for
+// them. A failed delivery is attempted again up to Attempts times, waiting Backoff and then twice as long
+// before every new attempt. Every attempt is recorded in webhook_deliveries.
+type WebhookDispatcher struct {
+	db         *sql.DB
+	HTTPClient *http.Client
+	Attempts   int
+	Backoff    time.Duration
+}
+
+// NewWebhookDispatcher returns a dispatcher for the webhooks stored in db
+func NewWebhookDispatcher(db *sql.DB) *WebhookDispatcher {
+	return &WebhookDispatcher{
+		db:         db,
+		HTTPClient: &http.Client{Timeout: 5 * time.Second},
+		Attempts:   3,
+		Backoff:    time.Second,
+	}
+}
+
+// Dispatch notifies the webhooks subscribed to event about a book and the subscriber involved. It returns
+// immediately, the deliveries happen in the background.
+func (d *WebhookDispatcher) Dispatch(event string, bookID, subscriberID int) {
+	timestamp := time.Now().UTC()
+	go d.dispatch(context.Background(), event, bookID, subscriberID, timestamp)
+}
+
+func (d *WebhookDispatcher) dispatch(ctx context.Context, event string, bookID, subscriberID int, timestamp time.Time) {
+	webhooks, err := d.subscribedWebhooks(ctx, event)
+	if err != nil {
+		slog.Error("loading the webhooks failed", "event", event, "error", err)
+		return
+	}
+	if len(webhooks) == 0 {
+		return
+	}
+
+	payload := WebhookPayload{Event: event, Timestamp: timestamp}
+	payload.Book.ID = bookID
+	payload.Subscriber.ID = subscriberID
+	// The book or the subscriber may have been deleted since, the ids are sent anyway
+	err = d.db.QueryRowContext(ctx, "SELECT title FROM books WHERE id = ?", bookID).Scan(&payload.Book.Title)
+	if err != nil && err != sql.ErrNoRows {
+		slog.Error("loading the webhook payload failed", "event", event, "book_id", bookID, "error", err)
+	}
+	err = d.db.QueryRowContext(ctx, "SELECT lastname, firstname, email, COALESCE(phone, '') FROM subscribers WHERE id = ?", subscriberID).
+		Scan(&payload.Subscriber.Lastname, &payload.Subscriber.Firstname, &payload.Subscriber.Email, &payload.Subscriber.Phone)
+	if err != nil && err != sql.ErrNoRows {
+		slog.Error("loading the webhook payload failed", "event", event, "subscriber_id", subscriberID, "error", err)
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		slog.Error("encoding the webhook payload failed", "event", event, "error", err)
+		return
+	}
+	for _, webhook := range webhooks {
+		go d.deliver(ctx, webhook, event, body)
+	}
+}
+
+// subscribedWebhooks returns the webhooks subscribed to event, with their secret
+func (d *WebhookDispatcher) subscribedWebhooks(ctx context.Context, event string) ([]Webhook, error) {
+	rows, err := d.db.QueryContext(ctx, "SELECT id, url, secret FROM webhooks WHERE FIND_IN_SET(?, events)", event)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var webhooks []Webhook
+	for rows.Next() {
+		var webhook Webhook
+		if err := rows.Scan(&webhook.ID, &webhook.URL, &webhook.Secret); err != nil {
+			return nil, err
+		}
+		webhooks = append(webhooks, webhook)
+	}
+	return webhooks, rows.Err()
+}
+
+// deliver posts body to webhook until it succeeds or the attempts run out, and records every attempt
+func (d *WebhookDispatcher) deliver(ctx context.Context, webhook Webhook, event string, body []byte) {
+	backoff := d.Backoff
+	for attempt := 1; attempt <= d.Attempts; attempt++ {
+		statusCode, err := d.send(ctx, webhook, event, body)
+		d.recordDelivery(ctx, webhook.ID, event, attempt, statusCode, err)
+		if err == nil {
+			return
+		}
+		if attempt < d.Attempts {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+	}
+	slog.Warn("webhook delivery failed", "webhook_id", webhook.ID, "event", event, "attempts", d.Attempts)
+}
+
+// send posts body to webhook once. Any response other than 2xx is a failure.
+func (d *WebhookDispatcher) send(ctx context.Context, webhook Webhook, event string, body []byte) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Webhook-Event", event)
+	req.Header.Set("X-Webhook-Signature", "sha256="+signWebhookPayload(webhook.Secret, body))
+
+	resp, err := d.HTTPClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	// Reading the body lets the connection be reused
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// recordDelivery stores the outcome of an attempt, a failure to store it is only logged
+func (d *WebhookDispatcher) recordDelivery(ctx context.Context, webhookID int, event string, attempt, statusCode int, deliveryErr error) {
+	var status, message interface{}
+	if statusCode != 0 {
+		status = statusCode
+	}
+	if deliveryErr != nil {
+		text := deliveryErr.Error()
+		if len(text) > maxWebhookErrorLength {
+			text = strings.ToValidUTF8(text[:maxWebhookErrorLength], "")
+		}
+		message = text
+	}
+	_, err := d.db.ExecContext(ctx, `
+		INSERT INTO webhook_deliveries (webhook_id, event, attempt, status_code, error)
+		VALUES (?, ?, ?, ?, ?)
+	`, webhookID, event, attempt, status, message)
+	if err != nil {
+		slog.Error("recording webhook delivery failed", "webhook_id", webhookID, "event", event, "error", err)
+	}
+}
+
+// signWebhookPayload is the hex HMAC-SHA256 of body with secret, sent in X-Webhook-Signature so that the
+// receivers can check a delivery comes from the library
+func signWebhookPayload(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// validateWebhook trims the fields of a new webhook and checks them
+func validateWebhook(webhook *Webhook) error {
+	webhook.URL = strings.TrimSpace(webhook.URL)
+	if err := validateRequiredField("url", webhook.URL, maxWebhookURLLength); err != nil {
+		return err
+	}
+	parsed, err := url.ParseRequestURI(webhook.URL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return fmt.Errorf("url must be an absolute http or https URL")
+	}
+	if err := validateOptionalField("secret", webhook.Secret, maxWebhookSecretLength); err != nil {
+		return err
+	}
+
+	if len(webhook.Events) == 0 {
+		return fmt.Errorf("events must list at least one of %s", strings.Join(webhookEvents, ", "))
+	}
+	seen := make(map[string]bool)
+	var events []string
+	for _, event := range webhook.Events {
+		if !containsString(webhookEvents, event) {
+			return fmt.Errorf("unknown event %q, events must be among %s", event, strings.Join(webhookEvents, ", "))
+		}
+		if !seen[event] {
+			seen[event] = true
+			events = append(events, event)
+		}
+	}
+	webhook.Events = events
+	return nil
+}
+
+// containsString reports whether values holds value
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// GetWebhooks returns a handler that lists the webhooks, without their secrets
+func GetWebhooks(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rows, err := db.QueryContext(r.Context(), "SELECT id, url, events, created_at FROM webhooks ORDER BY id")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer rows.Close()
+
+		webhooks := []Webhook{}
+		for rows.Next() {
+			var webhook Webhook
+			var events string
+			if err := rows.Scan(&webhook.ID, &webhook.URL, &events, &webhook.CreatedAt); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			webhook.Events = strings.Split(events, ",")
+			webhooks = append(webhooks, webhook)
+		}
+		if err := rows.Err(); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		RespondWithJSON(w, http.StatusOK, webhooks)
+	}
+}
+
+// AddWebhook returns a handler that registers a webhook. Without a secret one is generated; the secret is
+// only returned in this response.
+func AddWebhook(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var webhook Webhook
+		if err := StrictJSONDecoder(r.Body, &webhook); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		defer r.Body.Close()
+
+		if err := validateWebhook(&webhook); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if webhook.Secret == "" {
+			secret := make([]byte, 32)
+			if _, err := rand.Read(secret); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			webhook.Secret = hex.EncodeToString(secret)
+		}
+
+		result, err := db.ExecContext(r.Context(), "INSERT INTO webhooks (url, secret, events) VALUES (?, ?, ?)",
+			webhook.URL, webhook.Secret, strings.Join(webhook.Events, ","))
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to insert webhook: %v", err), http.StatusInternalServerError)
+			return
+		}
+		id, err := result.LastInsertId()
+		if err != nil {
+			http.Error(w, "Failed to get last insert ID", http.StatusInternalServerError)
+			return
+		}
+
+		RespondWithJSON(w, http.StatusCreated, map[string]interface{}{"id": id, "secret": webhook.Secret})
+	}
+}
+
+// DeleteWebhook returns a handler that deletes a webhook together with its deliveries
+func DeleteWebhook(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		webhookID, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			http.Error(w, "Invalid webhook ID", http.StatusBadRequest)
+			return
+		}
+
+		tx, err := db.BeginTx(r.Context(), nil)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to start transaction: %v", err), http.StatusInternalServerError)
+			return
+		}
+		defer tx.Rollback()
+
+		if _, err := tx.ExecContext(r.Context(), "DELETE FROM webhook_deliveries WHERE webhook_id = ?", webhookID); err != nil {
+			http.Error(w, fmt.Sprintf("Failed to delete the deliveries: %v", err), http.StatusInternalServerError)
+			return
+		}
+		result, err := tx.ExecContext(r.Context(), "DELETE FROM webhooks WHERE id = ?", webhookID)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to delete webhook: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+			http.Error(w, "Webhook not found", http.StatusNotFound)
+			return
+		}
+
+		if err := tx.Commit(); err != nil {
+			http.Error(w, fmt.Sprintf("Failed to commit transaction: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		RespondWithJSON(w, http.StatusOK, map[string]string{"message": "Webhook deleted successfully"})
+	}
+}
+
+// GetWebhookDeliveries returns a handler that lists the delivery attempts of a webhook, the most recent
+// first, paginated like the other lists
+func GetWebhookDeliveries(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		webhookID, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			http.Error(w, "Invalid webhook ID", http.StatusBadRequest)
+			return
+		}
+		limit, offset, err := ParsePagination(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		var total int
+		if err := db.QueryRowContext(r.Context(), "SELECT COUNT(*) FROM webhook_deliveries WHERE webhook_id = ?", webhookID).Scan(&total); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		query, args := paginate(`
+			SELECT id, event, attempt, COALESCE(status_code, 0), COALESCE(error, ''), created_at
+			FROM webhook_deliveries
+			WHERE webhook_id = ?
+			ORDER BY id DESC
+		`, []interface{}{webhookID}, limit, offset)
+		rows, err := db.QueryContext(r.Context(), query, args...)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer rows.Close()
+
+		deliveries := []WebhookDelivery{}
+		for rows.Next() {
+			var delivery WebhookDelivery
+			if err := rows.Scan(&delivery.ID, &delivery.Event, &delivery.Attempt, &delivery.StatusCode, &delivery.Error, &delivery.CreatedAt); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			deliveries = append(deliveries, delivery)
+		}
+		if err := rows.Err(); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		WriteListResponse(w, http.StatusOK, deliveries, total)
+	}
+}
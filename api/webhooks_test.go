@@ -0,0 +1,296 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// receivedWebhook is a request received by a webhook receiver
+type receivedWebhook struct {
+	header http.Header
+	body   []byte
+}
+
+// newWebhookReceiver starts a receiver answering with the statuses in turn, the last one once they run out,
+// and sending the requests it receives to the returned channel
+func newWebhookReceiver(t *testing.T, statuses ...int) (*httptest.Server, chan receivedWebhook) {
+	t.Helper()
+	received := make(chan receivedWebhook, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received <- receivedWebhook{header: r.Header, body: body}
+		status := statuses[0]
+		if len(statuses) > 1 {
+			statuses = statuses[1:]
+		}
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(server.Close)
+	return server, received
+}
+
+// newTestDispatcher returns a dispatcher on a sqlmock database that retries without waiting
+func newTestDispatcher(t *testing.T) (*WebhookDispatcher, sqlmock.Sqlmock) {
+	db, mock := newMockDB(t)
+	dispatcher := NewWebhookDispatcher(db)
+	dispatcher.Backoff = time.Millisecond
+	return dispatcher, mock
+}
+
+// expectWebhookPayload expects the webhooks subscribed to event to be loaded, then the book and the subscriber
+func expectWebhookPayload(mock sqlmock.Sqlmock, event, url string) {
+	mock.ExpectQuery(sqlPattern("SELECT id, url, secret FROM webhooks WHERE FIND_IN_SET(?, events)")).WithArgs(event).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "url", "secret"}).AddRow(4, url, "s3cret"))
+	mock.ExpectQuery(sqlPattern("SELECT title FROM books WHERE id = ?")).WithArgs(3).
+		WillReturnRows(sqlmock.NewRows([]string{"title"}).AddRow("Nineteen Eighty-Four"))
+	mock.ExpectQuery(sqlPattern("FROM subscribers WHERE id = ?")).WithArgs(7).
+		WillReturnRows(sqlmock.NewRows([]string{"lastname", "firstname", "email", "phone"}).AddRow("Johnson", "Emma", "emma@example.com", ""))
+}
+
+// expectDelivery expects an attempt to be recorded
+func expectDelivery(mock sqlmock.Sqlmock, event string, attempt int, status, message interface{}) {
+	mock.ExpectExec(sqlPattern("INSERT INTO webhook_deliveries (webhook_id, event, attempt, status_code, error)")).
+		WithArgs(4, event, attempt, status, message).WillReturnResult(sqlmock.NewResult(int64(attempt), 1))
+}
+
+// waitForExpectations waits for the deliveries made in the background to meet the expectations of mock
+func waitForExpectations(t *testing.T, mock sqlmock.Sqlmock) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		err := mock.ExpectationsWereMet()
+		if err == nil {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal(err)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestWebhookDelivery(t *testing.T) {
+	dispatcher, mock := newTestDispatcher(t)
+	receiver, received := newWebhookReceiver(t, http.StatusNoContent)
+	expectWebhookPayload(mock, webhookEventBorrowed, receiver.URL)
+	expectDelivery(mock, webhookEventBorrowed, 1, http.StatusNoContent, nil)
+
+	timestamp := time.Date(2024, 5, 2, 10, 0, 0, 0, time.UTC)
+	dispatcher.dispatch(context.Background(), webhookEventBorrowed, 3, 7, timestamp)
+
+	var delivery receivedWebhook
+	select {
+	case delivery = <-received:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the webhook wasn't delivered")
+	}
+	waitForExpectations(t, mock)
+
+	if delivery.header.Get("Content-Type") != "application/json" || delivery.header.Get("X-Webhook-Event") != webhookEventBorrowed {
+		t.Errorf("headers %v", delivery.header)
+	}
+	// The receiver checks the signature with the secret it shares with the library
+	mac := hmac.New(sha256.New, []byte("s3cret"))
+	mac.Write(delivery.body)
+	if want := "sha256=" + hex.EncodeToString(mac.Sum(nil)); delivery.header.Get("X-Webhook-Signature") != want {
+		t.Errorf("signature %q, want %q", delivery.header.Get("X-Webhook-Signature"), want)
+	}
+
+	var payload WebhookPayload
+	if err := json.Unmarshal(delivery.body, &payload); err != nil {
+		t.Fatal(err)
+	}
+	if payload.Event != webhookEventBorrowed || payload.Book.ID != 3 || payload.Book.Title != "Nineteen Eighty-Four" ||
+		payload.Subscriber.ID != 7 || payload.Subscriber.Email != "emma@example.com" || !payload.Timestamp.Equal(timestamp) {
+		t.Errorf("got %+v", payload)
+	}
+}
+
+func TestWebhookRetries(t *testing.T) {
+	t.Run("succeeds on the third attempt", func(t *testing.T) {
+		dispatcher, mock := newTestDispatcher(t)
+		receiver, received := newWebhookReceiver(t, http.StatusInternalServerError, http.StatusBadGateway, http.StatusOK)
+		expectWebhookPayload(mock, webhookEventReturned, receiver.URL)
+		expectDelivery(mock, webhookEventReturned, 1, http.StatusInternalServerError, "unexpected status 500 Internal Server Error")
+		expectDelivery(mock, webhookEventReturned, 2, http.StatusBadGateway, "unexpected status 502 Bad Gateway")
+		expectDelivery(mock, webhookEventReturned, 3, http.StatusOK, nil)
+
+		dispatcher.dispatch(context.Background(), webhookEventReturned, 3, 7, time.Now())
+		waitForExpectations(t, mock)
+		if len(received) != 3 {
+			t.Errorf("%d requests received, want 3", len(received))
+		}
+	})
+
+	t.Run("gives up after the attempts", func(t *testing.T) {
+		dispatcher, mock := newTestDispatcher(t)
+		receiver, received := newWebhookReceiver(t, http.StatusServiceUnavailable)
+		expectWebhookPayload(mock, webhookEventReturned, receiver.URL)
+		for attempt := 1; attempt <= 3; attempt++ {
+			expectDelivery(mock, webhookEventReturned, attempt, http.StatusServiceUnavailable, "unexpected status 503 Service Unavailable")
+		}
+
+		discardLogs(t)
+		dispatcher.dispatch(context.Background(), webhookEventReturned, 3, 7, time.Now())
+		waitForExpectations(t, mock)
+		// No fourth attempt follows
+		time.Sleep(20 * time.Millisecond)
+		if len(received) != 3 {
+			t.Errorf("%d requests received, want 3", len(received))
+		}
+	})
+
+	t.Run("unreachable receiver", func(t *testing.T) {
+		dispatcher, mock := newTestDispatcher(t)
+		dispatcher.Attempts = 1
+		receiver, _ := newWebhookReceiver(t, http.StatusOK)
+		receiver.Close()
+		expectWebhookPayload(mock, webhookEventBorrowed, receiver.URL)
+		expectDelivery(mock, webhookEventBorrowed, 1, nil, sqlmock.AnyArg())
+
+		discardLogs(t)
+		dispatcher.dispatch(context.Background(), webhookEventBorrowed, 3, 7, time.Now())
+		waitForExpectations(t, mock)
+	})
+}
+
+func TestWebhookDispatchDoesNotBlock(t *testing.T) {
+	release := make(chan struct{})
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	t.Cleanup(receiver.Close)
+	t.Cleanup(func() { close(release) })
+
+	dispatcher, mock := newTestDispatcher(t)
+	expectWebhookPayload(mock, webhookEventBorrowed, receiver.URL)
+	expectDelivery(mock, webhookEventBorrowed, 1, http.StatusOK, nil)
+
+	start := time.Now()
+	dispatcher.Dispatch(webhookEventBorrowed, 3, 7)
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Errorf("Dispatch waited %s for the receiver", elapsed)
+	}
+	release <- struct{}{}
+	waitForExpectations(t, mock)
+}
+
+func TestValidateWebhook(t *testing.T) {
+	webhook := Webhook{URL: " https://hooks.example.com/library ", Events: []string{webhookEventBorrowed, webhookEventReturned, webhookEventBorrowed}}
+	if err := validateWebhook(&webhook); err != nil {
+		t.Fatal(err)
+	}
+	if webhook.URL != "https://hooks.example.com/library" || len(webhook.Events) != 2 {
+		t.Errorf("got %+v, want the URL trimmed and the events deduplicated", webhook)
+	}
+
+	tests := []struct {
+		name    string
+		webhook Webhook
+	}{
+		{"no URL", Webhook{Events: []string{webhookEventBorrowed}}},
+		{"relative URL", Webhook{URL: "/hooks", Events: []string{webhookEventBorrowed}}},
+		{"ftp URL", Webhook{URL: "ftp://hooks.example.com", Events: []string{webhookEventBorrowed}}},
+		{"no events", Webhook{URL: "https://hooks.example.com"}},
+		{"unknown event", Webhook{URL: "https://hooks.example.com", Events: []string{"book.deleted"}}},
+		{"secret too long", Webhook{URL: "https://hooks.example.com", Secret: strings.Repeat("s", maxWebhookSecretLength+1), Events: []string{webhookEventBorrowed}}},
+	}
+	for _, tt := range tests {
+		if err := validateWebhook(&tt.webhook); err == nil {
+			t.Errorf("%s: accepted", tt.name)
+		}
+	}
+}
+
+func TestAddWebhook(t *testing.T) {
+	t.Run("generated secret", func(t *testing.T) {
+		db, mock := newMockDB(t)
+		mock.ExpectExec(sqlPattern("INSERT INTO webhooks (url, secret, events)")).
+			WithArgs("https://hooks.example.com", sqlmock.AnyArg(), "book.borrowed,book.returned").WillReturnResult(sqlmock.NewResult(4, 1))
+
+		rec := serveRoute(AddWebhook(db), http.MethodPost, "/webhooks", "/webhooks",
+			strings.NewReader(`{"url":"https://hooks.example.com","events":["book.borrowed","book.returned"]}`))
+		if rec.Code != http.StatusCreated {
+			t.Fatalf("status %d, want 201: %s", rec.Code, rec.Body)
+		}
+		var response struct {
+			ID     int    `json:"id"`
+			Secret string `json:"secret"`
+		}
+		decodeJSON(t, rec, &response)
+		if response.ID != 4 || len(response.Secret) != 64 {
+			t.Errorf("got %+v, want the id and a generated secret", response)
+		}
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		rec := serveRoute(AddWebhook(nil), http.MethodPost, "/webhooks", "/webhooks", strings.NewReader(`{"url":"https://hooks.example.com","events":["book.lost"]}`))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("status %d, want 400", rec.Code)
+		}
+	})
+}
+
+func TestDeleteWebhook(t *testing.T) {
+	tests := []struct {
+		name     string
+		affected int64
+		want     int
+	}{
+		{"deleted", 1, http.StatusOK},
+		{"unknown", 0, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock := newMockDB(t)
+			mock.ExpectBegin()
+			mock.ExpectExec(sqlPattern("DELETE FROM webhook_deliveries WHERE webhook_id = ?")).WithArgs(4).WillReturnResult(sqlmock.NewResult(0, 2))
+			mock.ExpectExec(sqlPattern("DELETE FROM webhooks WHERE id = ?")).WithArgs(4).WillReturnResult(sqlmock.NewResult(0, tt.affected))
+			if tt.want == http.StatusOK {
+				mock.ExpectCommit()
+			} else {
+				mock.ExpectRollback()
+			}
+
+			rec := serveRoute(DeleteWebhook(db), http.MethodDelete, "/webhooks/{id}", "/webhooks/4", nil)
+			if rec.Code != tt.want {
+				t.Errorf("status %d, want %d: %s", rec.Code, tt.want, rec.Body)
+			}
+		})
+	}
+}
+
+func TestGetWebhookDeliveries(t *testing.T) {
+	db, mock := newMockDB(t)
+	created := time.Date(2024, 5, 2, 10, 0, 0, 0, time.UTC)
+	mock.ExpectQuery(sqlPattern("SELECT COUNT(*) FROM webhook_deliveries WHERE webhook_id = ?")).WithArgs(4).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(2))
+	mock.ExpectQuery(sqlPattern("FROM webhook_deliveries")).WithArgs(4).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "event", "attempt", "status_code", "error", "created_at"}).
+			AddRow(2, webhookEventBorrowed, 2, 200, "", created).
+			AddRow(1, webhookEventBorrowed, 1, 0, "connection refused", created))
+
+	rec := serveRoute(GetWebhookDeliveries(db), http.MethodGet, "/webhooks/{id}/deliveries", "/webhooks/4/deliveries", nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d, want 200: %s", rec.Code, rec.Body)
+	}
+	var deliveries []map[string]interface{}
+	decodeJSON(t, rec, &deliveries)
+	if len(deliveries) != 2 || deliveries[0]["status_code"] != float64(200) || deliveries[1]["error"] != "connection refused" {
+		t.Errorf("got %v", deliveries)
+	}
+	if _, ok := deliveries[1]["status_code"]; ok {
+		t.Errorf("the failed delivery has a status code: %v", deliveries[1])
+	}
+}
@@ -14,7 +14,7 @@ app.config['UPLOAD_FOLDER'] = 'static/uploads'
 if not os.path.exists(app.config['UPLOAD_FOLDER']):
     os.makedirs(app.config['UPLOAD_FOLDER'])
 
-API_URL = "http://localhost:8080"  
+API_URL = "http://localhost:8080/api/v1"  
 
 @app.route('/')
 def index():
@@ -206,7 +206,7 @@ def add_book():
             response = requests.post(f"{API_URL}/books/new", json=data, headers=headers)
             app.logger.debug(f"API Response: {response.status_code}, Content: {response.content}")
 
-            if response.status_code == 200:
+            if response.status_code == 201:
                 return redirect(url_for("index"))
             else:
                 error_message = response.json().get('error', 'Failed to add book')
@@ -245,7 +245,7 @@ def add_subscriber():
 
         try:
             response = requests.post(f"{API_URL}/subscribers/new", json=data)
-            if response.status_code == 200:
+            if response.status_code == 201:
                 return redirect(url_for("get_subscribers"))
             else:
                 error_message = response.json().get('error', 'Failed to add subscriber')